@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ---------- Accessibility Audit ----------
+
+// Each email's HTML is checked for the accessibility problems newsletters
+// most often repeat, and the results returned as its a11y block so the
+// content team can see (and fix in the template) what readers using screen
+// readers or low vision hit:
+//
+//   - images with no alt attribute (alt="" marks decorative images and is
+//     fine; tracking pixels and spacers are ignored);
+//   - heading structure: no h1 or more than one, skipped levels (an h2
+//     followed by an h4) and empty headings;
+//   - text whose color contrasts with its background less than WCAG AA
+//     asks: 4.5:1, or 3:1 for large text (24px, or 18.66px bold).
+//
+// Colors come from inline styles, <style> rules (inlined first, see css.go)
+// and the color and bgcolor attributes. Text over background images or
+// gradients, in colors we can't read, or hidden (such as preheaders) isn't
+// checked.
+
+const (
+	a11yMaxContrastWarnings = 20
+	a11ySnippetLen          = 60
+)
+
+// EmailA11y is an email's accessibility audit.
+type EmailA11y struct {
+	ImagesMissingAlt int            `json:"images_missing_alt"`
+	HeadingIssues    []string       `json:"heading_issues"`
+	LowContrast      []A11yContrast `json:"low_contrast"`
+}
+
+// A11yContrast is text that contrasts too little with its background, the
+// first of each combination of colors and text size.
+type A11yContrast struct {
+	Text       string  `json:"text"`
+	Color      string  `json:"color"`
+	Background string  `json:"background"`
+	Ratio      float64 `json:"ratio"`
+	Required   float64 `json:"required"`
+}
+
+// auditA11y audits an email's HTML.
+func auditA11y(src string) *EmailA11y {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(src))
+	if err != nil {
+		return nil
+	}
+	inlineStyles(doc)
+	a := &EmailA11y{HeadingIssues: []string{}, LowContrast: []A11yContrast{}}
+
+	doc.Find("img").Each(func(_ int, sel *goquery.Selection) {
+		width, height := imageDimension(sel.AttrOr("width", "")), imageDimension(sel.AttrOr("height", ""))
+		if (width > 0 && width <= 2) || (height > 0 && height <= 2) {
+			return
+		}
+		if _, ok := sel.Attr("alt"); !ok {
+			a.ImagesMissingAlt++
+		}
+	})
+
+	h1s, prev := 0, 0
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(_ int, sel *goquery.Selection) {
+		level := int(goquery.NodeName(sel)[1] - '0')
+		if level == 1 {
+			h1s++
+		}
+		if prev > 0 && level > prev+1 {
+			a.HeadingIssues = append(a.HeadingIssues, fmt.Sprintf("h%d follows h%d, skipping a level", level, prev))
+		}
+		if strings.TrimSpace(sel.Text()) == "" && sel.Find("img[alt]").Length() == 0 {
+			a.HeadingIssues = append(a.HeadingIssues, fmt.Sprintf("empty h%d", level))
+		}
+		prev = level
+	})
+	switch {
+	case h1s == 0 && prev > 0:
+		a.HeadingIssues = append([]string{"no h1"}, a.HeadingIssues...)
+	case h1s > 1:
+		a.HeadingIssues = append([]string{fmt.Sprintf("%d h1s; there should be one", h1s)}, a.HeadingIssues...)
+	}
+
+	seen := map[string]bool{}
+	walkContrast(doc.Nodes[0], a11yStyle{fg: themeBlack, bg: themeWhite, size: 16}, func(text string, st a11yStyle) {
+		if len(a.LowContrast) >= a11yMaxContrastWarnings {
+			return
+		}
+		required := 4.5
+		if st.size >= 24 || (st.size >= 18.66 && st.bold) {
+			required = 3
+		}
+		ratio := contrastRatio(st.fg, st.bg)
+		key := st.fg.hex() + st.bg.hex() + strconv.FormatFloat(required, 'f', 1, 64)
+		if ratio >= required || seen[key] {
+			return
+		}
+		seen[key] = true
+		if r := []rune(text); len(r) > a11ySnippetLen {
+			text = string(r[:a11ySnippetLen]) + "…"
+		}
+		a.LowContrast = append(a.LowContrast, A11yContrast{
+			Text:       text,
+			Color:      st.fg.hex(),
+			Background: st.bg.hex(),
+			Ratio:      math.Round(ratio*100) / 100,
+			Required:   required,
+		})
+	})
+	return a
+}
+
+// a11yStyle is the computed style that matters for contrast.
+type a11yStyle struct {
+	fg, bg               rgb
+	fgUnknown, bgUnknown bool // colors we can't read, or a background image
+	size                 float64
+	bold                 bool
+}
+
+// a11yHeadingSizes are browsers' default heading sizes, in ems.
+var a11yHeadingSizes = map[string]float64{"h1": 2, "h2": 1.5, "h3": 1.17, "h4": 1, "h5": 0.83, "h6": 0.67}
+
+// walkContrast calls fn with the text directly in each visible element
+// under n and its computed style.
+func walkContrast(n *html.Node, st a11yStyle, fn func(text string, st a11yStyle)) {
+	if n.Type == html.ElementNode {
+		switch n.Data {
+		case "head", "script", "style", "noscript", "template":
+			return
+		}
+		var ok bool
+		if st, ok = applyA11yStyle(n, st); !ok {
+			return // hidden
+		}
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			text.WriteString(c.Data)
+		}
+	}
+	if t := strings.Join(strings.Fields(text.String()), " "); t != "" && !st.fgUnknown && !st.bgUnknown {
+		fn(t, st)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			walkContrast(c, st, fn)
+		}
+	}
+}
+
+// applyA11yStyle is st as changed by element n, and false if n is hidden.
+func applyA11yStyle(n *html.Node, st a11yStyle) (a11yStyle, bool) {
+	if size, ok := a11yHeadingSizes[n.Data]; ok {
+		st.size *= size
+		st.bold = true
+	}
+	switch n.Data {
+	case "b", "strong", "th":
+		st.bold = true
+	}
+	for _, attr := range n.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "hidden":
+			return st, false
+		case "bgcolor":
+			st.bg, st.bgUnknown = setColor(st.bg, st.bgUnknown, attr.Val)
+		case "background":
+			st.bgUnknown = true
+		case "color":
+			if n.Data == "font" {
+				st.fg, st.fgUnknown = setColor(st.fg, st.fgUnknown, attr.Val)
+			}
+		}
+	}
+	for _, attr := range n.Attr {
+		if !strings.EqualFold(attr.Key, "style") {
+			continue
+		}
+		for _, d := range parseCSSDecls(attr.Val) {
+			v := strings.ToLower(d.value)
+			switch d.prop {
+			case "display":
+				if v == "none" {
+					return st, false
+				}
+			case "visibility":
+				if v == "hidden" {
+					return st, false
+				}
+			case "mso-hide":
+				if v == "all" {
+					return st, false
+				}
+			case "opacity", "max-height":
+				if f, ok := cssLength(v, 0); ok && f == 0 {
+					return st, false
+				}
+			case "font-size":
+				if f, ok := cssLength(v, st.size); ok {
+					if f == 0 {
+						return st, false
+					}
+					st.size = f
+				}
+			case "font-weight":
+				weight, _ := strconv.Atoi(v)
+				st.bold = v == "bold" || v == "bolder" || weight >= 600
+			case "color":
+				st.fg, st.fgUnknown = setColor(st.fg, st.fgUnknown, v)
+			case "background-color":
+				st.bg, st.bgUnknown = setColor(st.bg, st.bgUnknown, v)
+			case "background":
+				if strings.Contains(v, "url(") || strings.Contains(v, "gradient(") {
+					st.bgUnknown = true
+				} else {
+					st.bg, st.bgUnknown = setColor(st.bg, st.bgUnknown, v)
+				}
+			case "background-image":
+				if v != "none" {
+					st.bgUnknown = true
+				}
+			}
+		}
+	}
+	return st, true
+}
+
+// setColor is the color v sets and whether it's unknown: cur (and unknown)
+// are kept for transparent and inherit.
+func setColor(cur rgb, unknown bool, v string) (rgb, bool) {
+	v = strings.TrimSpace(strings.ToLower(v))
+	if v == "transparent" || v == "inherit" || v == "" {
+		return cur, unknown
+	}
+	if c, ok := parseColor(v); ok {
+		return c, false
+	}
+	return cur, true
+}
+
+// cssLength parses a px, pt, em, rem or % length (em and % relative to
+// parent) or a plain number.
+func cssLength(v string, parent float64) (float64, bool) {
+	v = strings.TrimSpace(v)
+	units := []struct {
+		suffix string
+		scale  float64
+	}{{"rem", 16}, {"px", 1}, {"pt", 4.0 / 3}, {"em", parent}, {"%", parent / 100}, {"", 1}}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(v, u.suffix); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			return f * u.scale, err == nil
+		}
+	}
+	return 0, false
+}
+
+// namedColors are the color keywords emails commonly use.
+var namedColors = map[string]rgb{
+	"black": {0, 0, 0}, "white": {255, 255, 255}, "gray": {128, 128, 128}, "grey": {128, 128, 128},
+	"silver": {192, 192, 192}, "lightgray": {211, 211, 211}, "lightgrey": {211, 211, 211},
+	"darkgray": {169, 169, 169}, "darkgrey": {169, 169, 169}, "red": {255, 0, 0}, "maroon": {128, 0, 0},
+	"orange": {255, 165, 0}, "yellow": {255, 255, 0}, "olive": {128, 128, 0}, "lime": {0, 255, 0},
+	"green": {0, 128, 0}, "aqua": {0, 255, 255}, "cyan": {0, 255, 255}, "teal": {0, 128, 128},
+	"blue": {0, 0, 255}, "navy": {0, 0, 128}, "fuchsia": {255, 0, 255}, "magenta": {255, 0, 255},
+	"purple": {128, 0, 128}, "pink": {255, 192, 203},
+}
+
+// parseColor reads a hex (see parseHexColor), opaque rgb() or named color.
+// Translucent rgba() colors aren't read, since their contrast depends on
+// what's under them.
+func parseColor(v string) (rgb, bool) {
+	if c, ok := namedColors[v]; ok {
+		return c, true
+	}
+	if strings.HasPrefix(v, "#") {
+		return parseHexColor(v)
+	}
+	args, ok := strings.CutPrefix(v, "rgb(")
+	if !ok {
+		if args, ok = strings.CutPrefix(v, "rgba("); !ok {
+			return rgb{}, false
+		}
+	}
+	parts := strings.FieldsFunc(strings.TrimSuffix(args, ")"), func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+	if len(parts) != 3 && len(parts) != 4 {
+		return rgb{}, false
+	}
+	if len(parts) == 4 {
+		if a, err := strconv.ParseFloat(parts[3], 64); err != nil || a < 1 {
+			return rgb{}, false
+		}
+	}
+	var channels [3]float64
+	for i := range 3 {
+		n, err := strconv.ParseFloat(strings.TrimSuffix(parts[i], "%"), 64)
+		if err != nil {
+			return rgb{}, false
+		}
+		if strings.HasSuffix(parts[i], "%") {
+			n *= 2.55
+		}
+		channels[i] = math.Max(0, math.Min(255, n))
+	}
+	return rgb{channels[0], channels[1], channels[2]}, true
+}
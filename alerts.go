@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------- Operational Alerts ----------
+
+// Alert is an operational signal meant for humans: a dependency going down,
+// a background job failing, an anomaly worth a look.
+type Alert struct {
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"` // info, warning, critical
+	Source   string    `json:"source"`   // e.g. health, recount, rollup
+	Title    string    `json:"title"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// AlertSink delivers alerts to one destination.
+type AlertSink interface {
+	Name() string
+	Send(ctx context.Context, a Alert) error
+}
+
+// Alerter fans alerts out to every configured sink from a background
+// goroutine. Identical alerts (same source and title) are suppressed for
+// alertRepeatInterval so a flapping dependency can't flood a channel. A nil
+// *Alerter discards everything, so callers needn't check configuration.
+type Alerter struct {
+	sinks  []AlertSink
+	queue  chan Alert
+	mu     sync.Mutex
+	recent map[string]time.Time
+}
+
+const alertRepeatInterval = 10 * time.Minute
+
+func NewAlerter(sinks []AlertSink) *Alerter {
+	if len(sinks) == 0 {
+		return nil
+	}
+	a := &Alerter{
+		sinks:  sinks,
+		queue:  make(chan Alert, 100),
+		recent: make(map[string]time.Time),
+	}
+	go a.run()
+	return a
+}
+
+// NewAlerterFromEnv builds sinks from ALERT_* variables.
+func NewAlerterFromEnv() *Alerter {
+	var sinks []AlertSink
+	if u := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); u != "" {
+		sinks = append(sinks, &SlackSink{WebhookURL: u})
+	}
+	if u := os.Getenv("ALERT_WEBHOOK_URL"); u != "" {
+		sinks = append(sinks, &WebhookSink{URL: u})
+	}
+	if key := os.Getenv("ALERT_LOOPS_API_KEY"); key != "" {
+		sinks = append(sinks, &LoopsEmailSink{
+			APIKey:          key,
+			TransactionalID: os.Getenv("ALERT_LOOPS_TRANSACTIONAL_ID"),
+			To:              strings.Split(os.Getenv("ALERT_EMAIL_TO"), ","),
+		})
+	}
+	for _, s := range sinks {
+		log.Printf("alerts: %s sink enabled", s.Name())
+	}
+	return NewAlerter(sinks)
+}
+
+// Notify queues an alert. It never blocks; if the queue is full the alert is
+// logged and dropped.
+func (a *Alerter) Notify(al Alert) {
+	if al.Time.IsZero() {
+		al.Time = time.Now().UTC()
+	}
+	if a == nil {
+		return
+	}
+
+	key := al.Source + "\x00" + al.Title
+	a.mu.Lock()
+	if last, ok := a.recent[key]; ok && al.Time.Sub(last) < alertRepeatInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.recent[key] = al.Time
+	a.mu.Unlock()
+
+	select {
+	case a.queue <- al:
+	default:
+		log.Printf("alerts: queue full, dropped %q", al.Title)
+	}
+}
+
+// HealthEvent adapts dependency transitions into alerts.
+func (a *Alerter) HealthEvent(ev HealthEvent) {
+	al := Alert{Time: ev.Time, Source: "health"}
+	switch ev.State {
+	case "degraded":
+		al.Severity = "critical"
+		al.Title = fmt.Sprintf("%s dependency degraded", ev.Dependency)
+		al.Message = ev.Error
+	case "recovered":
+		al.Severity = "info"
+		al.Title = fmt.Sprintf("%s dependency recovered", ev.Dependency)
+	default:
+		return
+	}
+	a.Notify(al)
+}
+
+func (a *Alerter) run() {
+	for al := range a.queue {
+		for _, s := range a.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := s.Send(ctx, al); err != nil {
+				log.Printf("alerts: %s sink: %v", s.Name(), err)
+			}
+			cancel()
+		}
+	}
+}
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(ctx context.Context, url string, body any, headers map[string]string) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackSink posts to a Slack incoming webhook.
+type SlackSink struct {
+	WebhookURL string
+}
+
+func (s *SlackSink) Name() string { return "slack" }
+
+func (s *SlackSink) Send(ctx context.Context, a Alert) error {
+	icon := map[string]string{"critical": ":rotating_light:", "warning": ":warning:"}[a.Severity]
+	if icon == "" {
+		icon = ":information_source:"
+	}
+	text := fmt.Sprintf("%s *%s* (%s)", icon, a.Title, a.Source)
+	if a.Message != "" {
+		text += "\n```" + a.Message + "```"
+	}
+	return postJSON(ctx, s.WebhookURL, map[string]string{"text": text}, nil)
+}
+
+// WebhookSink posts the Alert as JSON to an arbitrary URL.
+type WebhookSink struct {
+	URL string
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Send(ctx context.Context, a Alert) error {
+	return postJSON(ctx, s.URL, a, nil)
+}
+
+// LoopsEmailSink emails alerts through a Loops transactional template that
+// accepts severity, source, title, and message data variables.
+type LoopsEmailSink struct {
+	APIKey          string
+	TransactionalID string
+	To              []string
+}
+
+func (s *LoopsEmailSink) Name() string { return "loops-email" }
+
+func (s *LoopsEmailSink) Send(ctx context.Context, a Alert) error {
+	for _, to := range s.To {
+		to = strings.TrimSpace(to)
+		if to == "" {
+			continue
+		}
+		body := map[string]any{
+			"transactionalId": s.TransactionalID,
+			"email":           to,
+			"dataVariables": map[string]string{
+				"severity": a.Severity,
+				"source":   a.Source,
+				"title":    a.Title,
+				"message":  a.Message,
+			},
+		}
+		err := postJSON(ctx, "https://app.loops.so/api/v1/transactional", body,
+			map[string]string{"Authorization": "Bearer " + s.APIKey})
+		if err != nil {
+			return fmt.Errorf("%s: %w", to, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Publishing Approvals ----------
+
+// A sent campaign is only served once ai_publishable is set on it in the
+// warehouse. Editors review the rest here: /admin/pending lists sent
+// campaigns that aren't publishable, a single one previews exactly what the
+// API would serve, and approve/reject record a decision that overrides the
+// flag (see overrides.go), and counts as a change to the campaign so
+// /changes and the publish watcher pick it up. A NULL decision means not
+// reviewed yet and false means rejected. Decisions are attributed through
+// the admin audit log, where a reject's {"reason": "..."} body is kept too.
+
+// PendingEmail is a sent campaign awaiting (or denied) publication.
+type PendingEmail struct {
+	ID             string     `json:"id"`
+	Slug           string     `json:"slug"`
+	Subject        string     `json:"subject"`
+	Excerpt        *string    `json:"excerpt,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	MailingListRef ListRef    `json:"mailing_list"`
+	State          string     `json:"state"` // pending or rejected
+}
+
+// ListPendingEmails returns sent, unpublishable campaigns newest first,
+// optionally only those in state.
+func (s *Store) ListPendingEmails(ctx context.Context, state string, limit, offset int) ([]PendingEmail, *int, error) {
+	if s.pool == nil {
+		return nil, nil, errNoWarehouse
+	}
+	eo := s.emailOverrides()
+	overrides, args := eo.join([]any{state, limit, offset})
+	rows, err := s.content().Query(ctx, `
+		SELECT c.id, COALESCE(c.ai_publishable_slug, ''), COALESCE(c.ai_publishable_response_json->>'title', ''),
+		       c.ai_publishable_response_json->>'excerpt', c.sent_at,
+		       COALESCE(c.mailing_list_id, ''), COALESCE(ml.friendly_name, ''), COALESCE(ml.description, ''),
+		       COALESCE(ml.color_scheme, '#000000'),
+		       CASE WHEN `+campaignPublishable+` IS NULL THEN 'pending' ELSE 'rejected' END AS state,
+		       c.updated_at
+		FROM loops.campaigns c
+		LEFT JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		`+overrides+`
+		WHERE c.status = 'Sent' AND `+campaignPublishable+` IS DISTINCT FROM true
+		  AND ($1 = '' OR (`+campaignPublishable+` IS NULL) = ($1 = 'pending'))
+		ORDER BY c.sent_at DESC NULLS LAST, c.created_at DESC
+		LIMIT $2 OFFSET $3
+	`, args...)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	out := make([]PendingEmail, 0, limit)
+	for rows.Next() {
+		var p PendingEmail
+		var upstreamUpdatedAt *time.Time
+		if err := rows.Scan(&p.ID, &p.Slug, &p.Subject, &p.Excerpt, &p.SentAt,
+			&p.MailingListRef.ID, &p.MailingListRef.Name, &p.MailingListRef.Description, &p.MailingListRef.Color,
+			&p.State, &upstreamUpdatedAt); err != nil {
+			return nil, nil, err
+		}
+		if rev := eo.restoredRevision(p.ID, upstreamUpdatedAt); rev != nil {
+			p.Subject, p.Excerpt = rev.Subject, rev.Excerpt
+		}
+		p.Slug = emailSlug(p.Slug, p.Subject, p.ID)
+		p.MailingListRef.Slug = slugify(p.MailingListRef.Name)
+		p.MailingListRef.LogoURL = s.ListLogoURL(p.MailingListRef.ID)
+		out = append(out, p)
+	}
+	var next *int
+	if len(out) == limit {
+		n := offset + limit
+		next = &n
+	}
+	return out, next, rows.Err()
+}
+
+// SetEmailPublishable records a review decision for a sent campaign in
+// the metrics DB and reloads the overrides, so it applies here at once.
+func (s *Store) SetEmailPublishable(ctx context.Context, id string, publishable bool) error {
+	if s.pool == nil {
+		return errNoWarehouse
+	}
+	if s.metricsPool == nil {
+		return errNoOverrideStore
+	}
+	var hasList bool
+	err := s.pool.QueryRow(ctx, `
+		SELECT mailing_list_id IS NOT NULL FROM loops.campaigns WHERE id = $1 AND status = 'Sent'
+	`, id).Scan(&hasList)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errNotFound
+	}
+	if err := s.observe(depWarehouse, err); err != nil {
+		return err
+	}
+	if publishable && !hasList {
+		return &statusError{status: http.StatusConflict, code: codeConflict, message: "campaign has no mailing list, so it can't be published"}
+	}
+
+	_, err = s.metricsPool.Exec(ctx, `
+		INSERT INTO email_overrides (email_id, publishable) VALUES ($1, $2)
+		ON CONFLICT (email_id) DO UPDATE SET publishable = EXCLUDED.publishable, updated_at = NOW()
+		WHERE email_overrides.publishable IS DISTINCT FROM EXCLUDED.publishable
+	`, id, publishable)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	return s.LoadEmailOverrides(ctx)
+}
+
+func (s *Server) handleAdminPendingEmails(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parseLimitOffset(r, 50)
+	state := r.URL.Query().Get("state")
+	if state != "" && state != "pending" && state != "rejected" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "state must be pending or rejected")
+		return
+	}
+	items, next, err := s.store.ListPendingEmails(r.Context(), state, limit, offset)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, Paginated[PendingEmail]{Items: items, Next: next})
+}
+
+// handleAdminPendingEmail previews a campaign as it would be served once
+// approved, generated slug and excerpt included. Links aren't rewritten.
+func (s *Server) handleAdminPendingEmail(w http.ResponseWriter, r *http.Request) {
+	e, err := s.store.GetEmail(r.Context(), r, chi.URLParam(r, "id"), true)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, e)
+}
+
+func (s *Server) handleAdminApproveEmail(w http.ResponseWriter, r *http.Request) {
+	s.decideEmail(w, r, true)
+}
+
+func (s *Server) handleAdminRejectEmail(w http.ResponseWriter, r *http.Request) {
+	s.decideEmail(w, r, false)
+}
+
+// decideEmail records a decision and drops every cached response, since an
+// approved email joins lists, feeds, and counts everywhere.
+func (s *Server) decideEmail(w http.ResponseWriter, r *http.Request, publishable bool) {
+	id := chi.URLParam(r, "id")
+	if err := s.store.SetEmailPublishable(r.Context(), id, publishable); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	n := s.cache.Purge(func(string) bool { return true })
+	log.Printf("admin: %s set publishable=%t on %s, purged %d cache entries", adminKeyID(r), publishable, id, n)
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "publishable": publishable})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ---------- Admin Audit Log ----------
+
+// Several editors share the admin surface, each with their own key in
+// ADMIN_API_KEYS. Every call, reads included (old revisions and pending
+// drafts are worth knowing who looked at), is recorded in admin_audit in
+// the metrics DB with the key's ID, the query and body it was sent, and the
+// status it got, and can be reviewed at GET /admin/audit.
+
+// maxAuditBody caps the request body kept per entry. Admin bodies are small
+// JSON documents; anything bigger, or not JSON, is noted by content type
+// and size only.
+const maxAuditBody = 8 << 10
+
+// adminKeysFromEnv reads ADMIN_API_KEYS ("id:key,...", like API_KEYS) and
+// the older single ADMIN_API_KEY, which is audited as "admin".
+func adminKeysFromEnv() []apiKey {
+	keys, err := parseAPIKeys(os.Getenv("ADMIN_API_KEYS"))
+	if err != nil {
+		log.Fatalf("invalid ADMIN_API_KEYS: %v", err)
+	}
+	if key := os.Getenv("ADMIN_API_KEY"); key != "" {
+		keys = append(keys, apiKey{ID: "admin", Key: key})
+	}
+	return keys
+}
+
+type adminKeyCtxKey struct{}
+
+// adminKeyID is the ID of the admin key r was authorized with.
+func adminKeyID(r *http.Request) string {
+	id, _ := r.Context().Value(adminKeyCtxKey{}).(string)
+	return id
+}
+
+// AuditEntry is one recorded admin call.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	At        time.Time       `json:"at"`
+	KeyID     string          `json:"key_id"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Params    json.RawMessage `json:"params"` // {"query": {...}, "body": ...}
+	Status    int             `json:"status"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+// auditBody stands in for a request body that isn't recorded as is.
+type auditBody struct {
+	ContentType string `json:"content_type"`
+	Bytes       int64  `json:"bytes"`                // -1 if over the limit and sent without a length
+	OverLimit   bool   `json:"over_limit,omitempty"` // over maxAuditBody
+}
+
+// auditParams is what an entry records of r: its query, and its body (read
+// up to the limit) as JSON if it is JSON that Postgres can store, else an
+// auditBody.
+func auditParams(r *http.Request, body []byte, truncated bool) json.RawMessage {
+	params := map[string]any{}
+	if query := r.URL.Query(); len(query) > 0 {
+		clean := url.Values{}
+		for k, vs := range query {
+			for _, v := range vs {
+				clean.Add(auditText(k), auditText(v))
+			}
+		}
+		params["query"] = clean
+	}
+	switch {
+	case truncated:
+		params["body"] = auditBody{ContentType: r.Header.Get("Content-Type"), Bytes: r.ContentLength, OverLimit: true}
+	case len(bytes.TrimSpace(body)) == 0:
+	case storableJSON(body):
+		params["body"] = json.RawMessage(body)
+	default:
+		params["body"] = auditBody{ContentType: r.Header.Get("Content-Type"), Bytes: int64(len(body))}
+	}
+	b, _ := json.Marshal(params)
+	return b
+}
+
+// storableJSON reports whether body is JSON a jsonb column accepts, which
+// rules out invalid UTF-8 and NUL characters.
+func storableJSON(body []byte) bool {
+	return json.Valid(body) && utf8.Valid(body) && !bytes.Contains(body, []byte(`\u0000`))
+}
+
+// auditText makes s storable in a text or jsonb column, replacing NULs and
+// invalid UTF-8, which either rejects.
+func auditText(s string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", "\uFFFD"), "\uFFFD")
+}
+
+// auditAdmin records admin calls after they're handled.
+func (s *Server) auditAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Peek at the body and hand the handler an identical one.
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "unreadable body")
+			return
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		truncated := len(body) > maxAuditBody
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry := AuditEntry{
+			At:        time.Now().UTC(),
+			KeyID:     adminKeyID(r),
+			Method:    r.Method,
+			Path:      auditText(r.URL.Path),
+			Params:    auditParams(r, body, truncated),
+			Status:    status,
+			RequestID: middleware.GetReqID(r.Context()),
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+		defer cancel()
+		if err := s.store.InsertAuditEntry(ctx, entry); err != nil {
+			log.Printf("admin audit: %v; unrecorded: %s %s by %s -> %d %s", err, entry.Method, entry.Path, entry.KeyID, entry.Status, entry.Params)
+		}
+	})
+}
+
+var errNoAuditStore = errors.New("METRICS_DATABASE_URL not configured")
+
+func (s *Store) InsertAuditEntry(ctx context.Context, e AuditEntry) error {
+	if s.metricsPool == nil {
+		return errNoAuditStore
+	}
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO admin_audit (at, key_id, method, path, params, status, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+	`, e.At, e.KeyID, e.Method, e.Path, string(e.Params), e.Status, e.RequestID)
+	return s.observe(depMetrics, err)
+}
+
+// AuditFilter narrows ListAuditEntries; empty fields match everything.
+type AuditFilter struct {
+	KeyID      string
+	PathPrefix string
+}
+
+// ListAuditEntries returns entries newest first.
+func (s *Store) ListAuditEntries(ctx context.Context, f AuditFilter, limit, offset int) ([]AuditEntry, error) {
+	out := []AuditEntry{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT id, at, key_id, method, path, params, status, COALESCE(request_id, '')
+		FROM admin_audit
+		WHERE ($1 = '' OR key_id = $1)
+		  AND starts_with(path, $2)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4
+	`, f.KeyID, f.PathPrefix, limit, offset)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e AuditEntry
+		var params []byte
+		if err := rows.Scan(&e.ID, &e.At, &e.KeyID, &e.Method, &e.Path, &params, &e.Status, &e.RequestID); err != nil {
+			return nil, err
+		}
+		e.Params = params
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// handleAdminAudit lists audit entries newest first, filtered by ?key_id=
+// and ?path= (a prefix, e.g. /admin/cache).
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parseLimitOffset(r, 100)
+	f := AuditFilter{KeyID: r.URL.Query().Get("key_id"), PathPrefix: r.URL.Query().Get("path")}
+	// One extra row tells us whether there's another page.
+	items, err := s.store.ListAuditEntries(r.Context(), f, limit+1, offset)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	page := Paginated[AuditEntry]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		next := offset + limit
+		page.Next = &next
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, page)
+}
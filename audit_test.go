@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditParams(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		truncated   bool
+		want        string
+	}{
+		{"nothing", "/admin/jobs", "", "", false, `{}`},
+		{"query", "/admin/audit?key_id=alice&path=/admin/cache", "", "", false, `{"query":{"key_id":["alice"],"path":["/admin/cache"]}}`},
+		{"json body", "/admin/cache/purge", "application/json", `{"prefix":"/emails"}`, false, `{"body":{"prefix":"/emails"}}`},
+		{"whitespace body", "/admin/cache/purge", "application/json", " \n", false, `{}`},
+		{"form body", "/admin/cache/purge", "application/x-www-form-urlencoded", "prefix=%2Femails", false, `{"body":{"content_type":"application/x-www-form-urlencoded","bytes":16}}`},
+		{"binary body", "/admin/mailing_lists/x/logo", "image/png", "\x89PNG\r\n\x1a\n\x00\x00", false, `{"body":{"content_type":"image/png","bytes":10}}`},
+		{"json with NUL", "/admin/sender-names", "application/json", `{"name":"a\u0000b"}`, false, `{"body":{"content_type":"application/json","bytes":19}}`},
+		{"json with invalid UTF-8", "/admin/sender-names", "application/json", "{\"name\":\"\xff\"}", false, `{"body":{"content_type":"application/json","bytes":12}}`},
+		{"over the limit", "/admin/mailing_lists/x/logo", "image/svg+xml", "<svg", true, `{"body":{"content_type":"image/svg+xml","bytes":4,"over_limit":true}}`},
+		{"NUL in query", "/admin/audit?key_id=a%00b", "", "", false, `{"query":{"key_id":["a�b"]}}`},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := string(auditParams(r, []byte(tt.body), tt.truncated)); got != tt.want {
+			t.Errorf("%s: auditParams = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
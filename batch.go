@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ---------- Batch Tracking ----------
+
+// POST /track/batch takes the events a page would otherwise send one request
+// at a time (views, clicks, scroll depth, read time) from a client-side
+// queue, so busy pages make one request and offline-first frontends can
+// flush what they recorded while disconnected. Each event goes through the
+// same validation and deduplication as its single-event endpoint, and must
+// be for a published email. Clicks name the link by its index, which is
+// looked up in the email's link map (see links.go) for the URL recorded,
+// so a batch can't write arbitrary URLs into click analytics.
+
+const (
+	maxBatchEvents = 100
+	maxBatchBytes  = 64 << 10
+	// maxBatchEventAge bounds client timestamps; older events are rejected
+	// rather than backdated into closed aggregates.
+	maxBatchEventAge  = 24 * time.Hour
+	maxBatchClockSkew = time.Minute
+)
+
+// batchEvent is one queued event; fields beyond type and email_id depend on
+// the type.
+type batchEvent struct {
+	Type      string     `json:"type"` // view, click, scroll, or read
+	EmailID   string     `json:"email_id"`
+	At        *time.Time `json:"at"`  // when it happened; defaults to now
+	Ref       string     `json:"ref"` // view: document.referrer
+	LinkIndex *int       `json:"link_index"`
+	URL       string     `json:"url"` // click: destination, optional; must match the link map
+	Depth     *float64   `json:"depth"`
+	Seconds   *float64   `json:"seconds"`
+}
+
+type batchRejection struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func (s *Server) handleTrackBatch(w http.ResponseWriter, r *http.Request) {
+	// Like the other beacons, any content type: sendBeacon posts text/plain.
+	var body struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&body); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid batch")
+		return
+	}
+	if len(body.Events) > maxBatchEvents {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("at most %d events per batch", maxBatchEvents))
+		return
+	}
+
+	cookie := getOrCreateSession(w, r)
+	device := parseDevice(r.UserAgent())
+	now := time.Now()
+	accepted, rejected := 0, []batchRejection{}
+	seen, published := map[string]bool{}, map[string]error{}
+	for i, raw := range body.Events {
+		var ev batchEvent
+		err := errors.New("invalid event")
+		if json.Unmarshal(raw, &ev) == nil {
+			err = s.trackBatchEvent(r, &ev, cookie.Value, device, now, seen, published)
+		}
+		if err != nil {
+			rejected = append(rejected, batchRejection{Index: i, Error: err.Error()})
+			continue
+		}
+		accepted++
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"accepted": accepted, "rejected": rejected})
+}
+
+var (
+	errBatchDuplicate    = errors.New("duplicate event")
+	errBatchUnknownEmail = errors.New("unknown email_id")
+	errBatchUnknownLink  = errors.New("unknown link_index")
+)
+
+// trackBatchEvent validates ev and hands it to the metrics writer. seen
+// rejects exact repeats within the batch (a client queueing an event
+// twice); anything else is deduplicated on insert like single events.
+// published remembers which emails the batch has already looked up.
+func (s *Server) trackBatchEvent(r *http.Request, ev *batchEvent, sessionID string, device DeviceInfo, now time.Time, seen map[string]bool, published map[string]error) error {
+	if ev.EmailID == "" {
+		return errors.New("missing email_id")
+	}
+	err, ok := published[ev.EmailID]
+	if !ok {
+		err = s.store.RequireEmail(r.Context(), ev.EmailID)
+		published[ev.EmailID] = err
+	}
+	if errors.Is(err, errNotFound) {
+		return errBatchUnknownEmail
+	} else if err != nil {
+		return errors.New("couldn't check email_id")
+	}
+	at := now
+	if ev.At != nil {
+		if ev.At.Before(now.Add(-maxBatchEventAge)) || ev.At.After(now.Add(maxBatchClockSkew)) {
+			return errors.New("at is out of range")
+		}
+		at = *ev.At
+	}
+
+	var key string
+	var track func()
+	switch ev.Type {
+	case "view":
+		key = "view " + ev.EmailID
+		track = func() {
+			s.metricsWriter.TrackView(ViewEvent{
+				Time: at, SessionID: sessionID, EmailID: ev.EmailID,
+				Referrer: normalizeReferrer(ev.Ref), Device: device,
+			})
+		}
+	case "click":
+		if ev.LinkIndex == nil || *ev.LinkIndex < 0 {
+			return errors.New("invalid link_index")
+		}
+		link, err := s.store.EmailLink(r.Context(), ev.EmailID, *ev.LinkIndex)
+		if errors.Is(err, errNotFound) {
+			return errBatchUnknownLink
+		} else if err != nil {
+			return errors.New("couldn't check link_index")
+		}
+		if ev.URL != "" && unwrapTrackingURL(ev.URL) != link.URL {
+			return errors.New("url doesn't match link_index")
+		}
+		key = fmt.Sprintf("click %s %d", ev.EmailID, *ev.LinkIndex)
+		track = func() {
+			s.trackClick(r, ClickEvent{
+				Time: at, SessionID: sessionID, EmailID: ev.EmailID,
+				LinkURL: link.URL, LinkIndex: link.Index, Device: device,
+			})
+		}
+	case "scroll":
+		if ev.Depth == nil || *ev.Depth < 0 || *ev.Depth > 100 {
+			return errors.New("depth must be a percentage from 0 to 100")
+		}
+		key = fmt.Sprintf("scroll %s %d", ev.EmailID, int(*ev.Depth))
+		track = func() {
+			s.metricsWriter.TrackScroll(ScrollEvent{
+				Time: at, SessionID: sessionID, EmailID: ev.EmailID, Depth: int(*ev.Depth), Device: device,
+			})
+		}
+	case "read":
+		if ev.Seconds == nil || *ev.Seconds < 0 {
+			return errors.New("seconds must be a non-negative number")
+		}
+		bucket := readTimeBucket(*ev.Seconds)
+		key = fmt.Sprintf("read %s %d", ev.EmailID, bucket)
+		track = func() {
+			s.metricsWriter.TrackRead(ReadEvent{
+				Time: at, SessionID: sessionID, EmailID: ev.EmailID, Seconds: bucket, Device: device,
+			})
+		}
+	default:
+		return fmt.Errorf("unknown type %q", ev.Type)
+	}
+
+	key += " " + at.Format(time.RFC3339Nano)
+	if seen[key] {
+		return errBatchDuplicate
+	}
+	seen[key] = true
+	track()
+	return nil
+}
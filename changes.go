@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------- Change Feed ----------
+
+// /changes lets static sites and mirrors sync deletions as well as
+// additions: every email and mailing list whose publish state changed, in
+// order, with "delete" tombstones for emails pulled from the archive and
+// lists that stopped being public. Clients keep next_cursor and pass it back
+// on their next poll.
+
+const defaultChangesLimit = 100
+
+type Change struct {
+	Kind          string    `json:"kind"` // "email" or "mailing_list"
+	ID            string    `json:"id"`
+	Slug          string    `json:"slug"`
+	MailingListID string    `json:"mailing_list_id,omitempty"` // emails only
+	Action        string    `json:"action"`                    // "upsert" or "delete"
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+type ChangeFeed struct {
+	Items      []Change `json:"items"`
+	NextCursor string   `json:"next_cursor"`
+	HasMore    bool     `json:"has_more"`
+}
+
+// ListChanges returns the changes after cursor with the same slugs the
+// email and mailing list endpoints derive.
+func (s *Store) ListChanges(ctx context.Context, after ChangeCursor, limit int) ([]Change, error) {
+	src, err := s.source.ListChanges(ctx, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Change, 0, len(src))
+	for _, sc := range src {
+		c := Change{Kind: sc.Kind, ID: sc.ID, Action: "upsert", ChangedAt: sc.ChangedAt}
+		if !sc.Live {
+			c.Action = "delete"
+		}
+		if sc.Kind == changeEmail {
+			c.Slug = emailSlug(sc.Slug, sc.Name, sc.ID)
+			c.MailingListID = sc.MailingListID
+		} else {
+			c.Slug = slugify(sc.Name)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// encodeChangeCursor makes an opaque ?cursor= value.
+func encodeChangeCursor(c ChangeCursor) string {
+	raw := c.ChangedAt.UTC().Format(time.RFC3339Nano) + " " + c.Kind + " " + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeChangeCursor(s string) (ChangeCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ChangeCursor{}, badRequest("invalid cursor")
+	}
+	at, rest, _ := strings.Cut(string(raw), " ")
+	kind, id, ok := strings.Cut(rest, " ")
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if !ok || err != nil {
+		return ChangeCursor{}, badRequest("invalid cursor")
+	}
+	return ChangeCursor{ChangedAt: t, Kind: kind, ID: id}, nil
+}
+
+// parseChangeCursor reads ?cursor=, or starts at ?since= (RFC 3339,
+// inclusive), or at the beginning of the feed.
+func parseChangeCursor(r *http.Request) (ChangeCursor, error) {
+	q := r.URL.Query()
+	if v := q.Get("cursor"); v != "" {
+		return decodeChangeCursor(v)
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return ChangeCursor{}, badRequest("since must be an RFC 3339 timestamp")
+		}
+		// Just before anything at t.
+		return ChangeCursor{ChangedAt: t}, nil
+	}
+	return ChangeCursor{}, nil
+}
+
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	after, err := parseChangeCursor(r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	limit, _ := parseLimitOffset(r, defaultChangesLimit)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.ListChanges(r.Context(), after, limit)
+		if err != nil {
+			return nil, err
+		}
+		// With nothing new, the cursor stays put for the next poll.
+		next := after
+		if n := len(items); n > 0 {
+			last := items[n-1]
+			next = ChangeCursor{ChangedAt: last.ChangedAt, Kind: last.Kind, ID: last.ID}
+		}
+		return ChangeFeed{Items: items, NextCursor: encodeChangeCursor(next), HasMore: len(items) == limit}, nil
+	})
+}
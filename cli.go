@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ---------- CLI ----------
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: news <command> [flags]
+
+commands:
+  serve     run the API server (default)
+  migrate   apply metrics DB migrations and exit
+  export    write a static JSON snapshot of the archive from a running server
+  warm      request every list and email from a running server to fill its cache
+  seed      create the loops tables in a local DATABASE_URL and load demo data
+
+Run "news <command> -h" for a command's flags.
+`)
+}
+
+// loadEnv reads .env and then the config file (configPath, else
+// CONFIG_FILE); values already in the environment always win.
+func loadEnv(configPath string) {
+	_ = godotenv.Load()
+	if configPath == "" {
+		configPath = os.Getenv("CONFIG_FILE")
+	}
+	if configPath == "" {
+		return
+	}
+	_ = os.Setenv("CONFIG_FILE", configPath)
+	applied, err := loadConfigFile(configPath)
+	if err != nil {
+		log.Fatalf("config file: %v", err)
+	}
+	log.Printf("config file %s: applied %d settings not set in the environment", configPath, len(applied))
+}
+
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("config", "", "TOML config file (overrides CONFIG_FILE)")
+	_ = fs.Parse(args)
+	loadEnv(*configPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	store, closeStore := openStore(ctx)
+	defer closeStore()
+	if store.metricsPool == nil {
+		log.Fatal("METRICS_DATABASE_URL is required to migrate")
+	}
+	if err := store.RunMetricsMigrations(ctx); err != nil {
+		log.Fatalf("metrics migrations failed: %v", err)
+	}
+	log.Println("metrics migrations applied")
+}
+
+// crawlFlags are shared by export and warm, which talk to a running server
+// over HTTP rather than to the databases.
+func crawlFlags(fs *flag.FlagSet) (base, key *string, concurrency *int) {
+	base = fs.String("base", env("NEWS_BASE_URL", "http://127.0.0.1:"+env("PORT", "8080")), "server base URL (NEWS_BASE_URL)")
+	key = fs.String("key", os.Getenv("NEWS_API_KEY"), "API key, if the server requires one (NEWS_API_KEY)")
+	concurrency = fs.Int("concurrency", 4, "parallel email requests")
+	return
+}
+
+func runExport(args []string) {
+	loadEnv("") // flag defaults read the environment
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	base, key, concurrency := crawlFlags(fs)
+	out := fs.String("o", "export", "output directory")
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := os.MkdirAll(filepath.Join(*out, "emails"), 0o755); err != nil {
+		log.Fatal(err)
+	}
+	c := newCrawler(*base, *key)
+	n, err := c.walk(ctx, *concurrency, func(name string, body []byte) error {
+		return os.WriteFile(filepath.Join(*out, name), body, 0o644)
+	})
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	log.Printf("exported %d documents to %s", n, *out)
+}
+
+func runWarm(args []string) {
+	loadEnv("") // flag defaults read the environment
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	base, key, concurrency := crawlFlags(fs)
+	_ = fs.Parse(args)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	n, err := newCrawler(*base, *key).walk(ctx, *concurrency, func(string, []byte) error { return nil })
+	if err != nil {
+		log.Fatalf("warm: %v", err)
+	}
+	log.Printf("warmed %d documents in %s", n, time.Since(start).Round(time.Millisecond))
+}
+
+// startCacheWarming crawls this server like the warm command every
+// CACHE_WARM_INTERVAL (off by default; keep it under CACHE_TTL), so readers
+// rarely meet a cold cache. It reaches the server as the commands do, at
+// NEWS_BASE_URL with NEWS_API_KEY.
+func startCacheWarming(jobs *Jobs) {
+	interval := envDuration("CACHE_WARM_INTERVAL", 0)
+	if interval <= 0 {
+		return
+	}
+	c := newCrawler(env("NEWS_BASE_URL", "http://127.0.0.1:"+env("PORT", "8080")), os.Getenv("NEWS_API_KEY"))
+	jobs.Every("cache.warm", interval, func(ctx context.Context) error {
+		_, err := c.walk(ctx, 4, func(string, []byte) error { return nil })
+		return err
+	})
+}
+
+type crawler struct {
+	client *http.Client
+	base   string
+	key    string
+}
+
+func newCrawler(base, key string) *crawler {
+	return &crawler{client: &http.Client{Timeout: 60 * time.Second}, base: base, key: key}
+}
+
+func (c *crawler) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return body, nil
+}
+
+// pages fetches every page of a paginated endpoint and returns all items.
+func (c *crawler) pages(ctx context.Context, path string) ([]json.RawMessage, error) {
+	items := []json.RawMessage{}
+	offset := 0
+	for {
+		body, err := c.get(ctx, path+"?limit=200&offset="+strconv.Itoa(offset))
+		if err != nil {
+			return nil, err
+		}
+		var page Paginated[json.RawMessage]
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("GET %s: %w", path, err)
+		}
+		items = append(items, page.Items...)
+		if page.Next == nil || len(page.Items) == 0 {
+			return items, nil
+		}
+		offset = *page.Next
+	}
+}
+
+var exportIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// walk requests the list indexes, series, and every email, passing each
+// document to visit under its export file name. It returns the number of
+// documents visited.
+func (c *crawler) walk(ctx context.Context, concurrency int, visit func(name string, body []byte) error) (int, error) {
+	lists, err := c.pages(ctx, "/mailing_lists")
+	if err != nil {
+		return 0, err
+	}
+	emails, err := c.pages(ctx, "/emails")
+	if err != nil {
+		return 0, err
+	}
+	series, err := c.get(ctx, "/series")
+	if err != nil {
+		return 0, err
+	}
+	for name, v := range map[string]any{
+		"mailing_lists.json": map[string]any{"items": lists},
+		"emails.json":        map[string]any{"items": emails},
+		"series.json":        json.RawMessage(series),
+	} {
+		body, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		if err := visit(name, body); err != nil {
+			return 0, err
+		}
+	}
+
+	ids := make([]string, 0, len(emails))
+	for _, raw := range emails {
+		var e struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &e); err != nil || !exportIDRegex.MatchString(e.ID) {
+			return 0, fmt.Errorf("unexpected email id %q in /emails", e.ID)
+		}
+		ids = append(ids, e.ID)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+		n    atomic.Int64
+	)
+	sem := make(chan struct{}, max(concurrency, 1))
+	for _, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			body, err := c.get(ctx, "/emails/"+url.PathEscape(id))
+			if err == nil {
+				mu.Lock()
+				err = visit(filepath.Join("emails", id+".json"), body)
+				mu.Unlock()
+			}
+			if err != nil {
+				mu.Lock()
+				errs = errors.Join(errs, err)
+				mu.Unlock()
+				return
+			}
+			n.Add(1)
+		}()
+	}
+	wg.Wait()
+	return 3 + int(n.Load()), errs
+}
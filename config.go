@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Config File ----------
+
+// Settings can also come from a TOML file (CONFIG_FILE, see
+// config.example.toml). Every key maps onto the environment variable of the
+// same name, with tables as prefixes: rate_limit.public (or public under
+// [rate_limit]) is RATE_LIMIT_PUBLIC. Variables already set in the
+// environment win, so the file holds defaults a deployment can override.
+//
+// Only the flat subset of TOML we need is supported: tables, bare keys,
+// strings, numbers, booleans (true is "1", false "0"), and single-line
+// arrays, which become comma-separated lists.
+
+var configKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// loadConfigFile applies path to the environment and returns the variables
+// it set.
+func loadConfigFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	var order []string
+	prefix := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripConfigComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			table, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			table = strings.TrimSpace(table)
+			if !ok || !configKeyRegex.MatchString(table) {
+				return nil, fmt.Errorf("%s:%d: invalid table header", path, n)
+			}
+			prefix = table + "."
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !configKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		val, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(prefix + key))
+		if _, dup := values[name]; dup {
+			return nil, fmt.Errorf("%s:%d: %s set twice", path, n, name)
+		}
+		values[name] = val
+		order = append(order, name)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, name := range order {
+		if os.Getenv(name) != "" {
+			continue
+		}
+		if err := os.Setenv(name, values[name]); err != nil {
+			return nil, err
+		}
+		applied = append(applied, name)
+	}
+	return applied, nil
+}
+
+// stripConfigComment drops a trailing # comment, ignoring # inside quotes.
+func stripConfigComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case raw == "true":
+		return "1", nil
+	case raw == "false":
+		return "0", nil
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") || strings.Contains(raw[1:len(raw)-1], "'") {
+			return "", fmt.Errorf("unterminated literal string")
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "["):
+		inner, ok := strings.CutSuffix(raw[1:], "]")
+		if !ok {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range splitConfigArray(inner) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue // trailing comma
+			}
+			v, err := parseConfigValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+		}
+		return strings.Join(items, ","), nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("unsupported value %q (quote strings)", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// splitConfigArray splits on commas outside quotes.
+func splitConfigArray(s string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote && (quote == '\'' || i == 0 || s[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// envInt reads an integer setting, falling back to def when unset or invalid.
+func envInt(key string, def int) int {
+	n, err := strconv.Atoi(env(key, strconv.Itoa(def)))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// envDuration reads a duration setting such as "30s", falling back to def.
+func envDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(env(key, def.String()))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ---------- Content Sources ----------
+
+// ContentSource reads mailing lists and published emails from an email
+// provider's data. Implementations return content as the provider stores it,
+// save for review decisions and restored revisions made here (see
+// overrides.go); the Store layers on tracked stats, bylines, series,
+// images, and link rewriting, so every provider serves the same API
+// shapes. Selected by CONTENT_PROVIDER (default "loops").
+type ContentSource interface {
+	Name() string
+	// ListMailingLists returns lists with at least one published email,
+	// most recently sent first.
+	ListMailingLists(ctx context.Context, limit, offset int) ([]MailingList, *int, error)
+	// ListEmails returns published emails matching f, newest first.
+	ListEmails(ctx context.Context, f EmailFilter, limit, offset int) ([]SourceEmail, *int, error)
+	// EachEmail calls fn for every published email matching f from offset
+	// on, in ListEmails order, as rows arrive rather than buffering them.
+	EachEmail(ctx context.Context, f EmailFilter, offset int, fn func(*SourceEmail) error) error
+	// GetEmail returns one published email, or errNotFound. With
+	// includeUnpublished, drafts and unpublishable sends match too, and the
+	// mailing list may be missing.
+	GetEmail(ctx context.Context, id string, includeUnpublished bool) (*SourceEmail, error)
+	// ListChanges returns up to limit email and mailing list publish-state
+	// changes after the cursor, oldest first, including unpublished ones.
+	ListChanges(ctx context.Context, after ChangeCursor, limit int) ([]SourceChange, error)
+}
+
+// EmailFilter narrows ListEmails and EachEmail. Zero values match everything.
+type EmailFilter struct {
+	MailingListID string
+	UpdatedSince  *time.Time // changed (or, lacking that, sent) strictly after
+	Topic         string     // resolved into IDs by the Store; see topics.go
+	IDs           []string   // when non-nil, only these emails
+}
+
+// SourceEmail is an email as a ContentSource stores it.
+type SourceEmail struct {
+	ID          string
+	Subject     string
+	Slug        string // provider-assigned; derived from the subject when empty
+	Excerpt     *string
+	SentAt      *time.Time
+	UpdatedAt   *time.Time // last provider-side change; SentAt if unknown
+	MailingList ListRef    // Slug is derived by the Store
+	HTML        *string
+	Markdown    *string
+	Clicks      int64 // provider-tracked, added to our own counts
+	Opens       int64
+}
+
+// Kinds of SourceChange.
+const (
+	changeEmail       = "email"
+	changeMailingList = "mailing_list"
+)
+
+// SourceChange is an email or mailing list whose publish state may have
+// changed. Emails are live while published; lists while public.
+type SourceChange struct {
+	Kind          string
+	ID            string
+	Slug          string // provider-assigned email slug, if any
+	Name          string // email subject or list name, for deriving slugs
+	MailingListID string
+	Live          bool
+	ChangedAt     time.Time
+}
+
+// ChangeCursor is a position in the change feed, which is ordered by
+// (ChangedAt, Kind, ID). The zero cursor is the start.
+type ChangeCursor struct {
+	ChangedAt time.Time
+	Kind      string
+	ID        string
+}
+
+// Precedes reports whether the cursor is strictly before sc.
+func (c ChangeCursor) Precedes(sc *SourceChange) bool {
+	if !c.ChangedAt.Equal(sc.ChangedAt) {
+		return c.ChangedAt.Before(sc.ChangedAt)
+	}
+	if c.Kind != sc.Kind {
+		return c.Kind < sc.Kind
+	}
+	return c.ID < sc.ID
+}
+
+func newContentSource(store *Store) (ContentSource, error) {
+	switch provider := env("CONTENT_PROVIDER", "loops"); provider {
+	case "loops":
+		return &loopsSource{store: store}, nil
+	default:
+		return nil, fmt.Errorf("unknown CONTENT_PROVIDER %q", provider)
+	}
+}
+
+// loopsSource reads the Loops sync in the warehouse (loops.* tables).
+type loopsSource struct {
+	store *Store
+}
+
+func (ls *loopsSource) Name() string { return "loops" }
+
+func (ls *loopsSource) ListMailingLists(ctx context.Context, limit, offset int) ([]MailingList, *int, error) {
+	overrides, args := ls.store.emailOverrides().join(nil)
+	q := `
+WITH sent_counts AS (
+  SELECT c.mailing_list_id, COUNT(*) AS sent_email_count, MAX(c.sent_at) as last_sent_at
+  FROM loops.campaigns c
+  JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+  ` + overrides + `
+  ` + publishedEmailsWhere + `
+  GROUP BY c.mailing_list_id
+),
+sub_counts AS (
+  SELECT mailing_list_id, COUNT(*)::bigint AS subscriber_count
+  FROM loops.audience_mailing_lists
+  GROUP BY mailing_list_id
+)
+SELECT ml.id,
+       ml.friendly_name,
+       ml.description,
+       COALESCE(ml.is_public, false) AS is_public,
+       COALESCE(ml.color_scheme, '#000000') AS color_scheme,
+       ml.last_updated_at,
+       COALESCE(sc.subscriber_count, 0) AS subscriber_count,
+       COALESCE(se.sent_email_count, 0) AS sent_email_count,
+       se.last_sent_at
+FROM loops.mailing_lists ml
+LEFT JOIN sub_counts sc ON sc.mailing_list_id = ml.id
+LEFT JOIN sent_counts se ON se.mailing_list_id = ml.id
+WHERE COALESCE(se.sent_email_count, 0) > 0
+ORDER BY (se.last_sent_at IS NULL) ASC, se.last_sent_at DESC NULLS LAST, ml.friendly_name ASC
+LIMIT $4 OFFSET $5;
+`
+	rows, err := ls.store.content().Query(ctx, q, append(args, limit, offset)...)
+	if err := ls.store.observe(depWarehouse, err); err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	out := make([]MailingList, 0, limit)
+	for rows.Next() {
+		var ml MailingList
+		var name, desc, color string
+		var isPublic bool
+		var lastUpdated *time.Time
+		var lastSent *time.Time
+		var subCount, sentCount int64
+		var id string
+		if err := rows.Scan(&id, &name, &desc, &isPublic, &color, &lastUpdated, &subCount, &sentCount, &lastSent); err != nil {
+			return nil, nil, err
+		}
+		ml.ID = id
+		ml.Name = name
+		ml.Description = desc
+		ml.Color = color
+		ml.IsPublic = isPublic
+		ml.LastUpdatedAt = lastUpdated
+		ml.LastSentAt = lastSent
+		ml.SubscriberCount = subCount
+		ml.SentEmailCount = sentCount
+		out = append(out, ml)
+	}
+	var next *int
+	if len(out) == limit {
+		n := offset + limit
+		next = &n
+	}
+	return out, next, rows.Err()
+}
+
+// campaignPublishable is a campaign's review decision: the one made here
+// if there is one (o, the overrides join), else the warehouse's.
+const campaignPublishable = "COALESCE(o.publishable, c.ai_publishable)"
+
+// publishedEmailsWhere matches served emails: sent, approved, and on a
+// public list (ml), so making a list private pulls its emails too. The
+// query needs the overrides join.
+const publishedEmailsWhere = "WHERE c.status = 'Sent' AND c.mailing_list_id IS NOT NULL AND " + campaignPublishable + " = true AND COALESCE(ml.is_public, false)"
+
+func (ls *loopsSource) ListEmails(ctx context.Context, f EmailFilter, limit, offset int) ([]SourceEmail, *int, error) {
+	where, args := publishedEmailsFilter(f)
+	return ls.queryEmails(ctx, "JOIN", where, args, limit, offset)
+}
+
+func (ls *loopsSource) EachEmail(ctx context.Context, f EmailFilter, offset int, fn func(*SourceEmail) error) error {
+	where, args := publishedEmailsFilter(f)
+	return ls.scanEmails(ctx, "JOIN", where, args, 0, offset, fn)
+}
+
+// campaignUpdatedAt is when a campaign last changed, upstream or through
+// an override.
+const campaignUpdatedAt = "GREATEST(COALESCE(c.updated_at, c.sent_at), o.updated_at)"
+
+func publishedEmailsFilter(f EmailFilter) (string, []any) {
+	where, args := publishedEmailsWhere, []any{}
+	if f.MailingListID != "" {
+		args = append(args, f.MailingListID)
+		where += fmt.Sprintf(" AND c.mailing_list_id = $%d", len(args))
+	}
+	if f.UpdatedSince != nil {
+		args = append(args, *f.UpdatedSince)
+		where += fmt.Sprintf(" AND %s > $%d", campaignUpdatedAt, len(args))
+	}
+	if f.IDs != nil {
+		args = append(args, f.IDs)
+		where += fmt.Sprintf(" AND c.id = ANY($%d)", len(args))
+	}
+	return where, args
+}
+
+func (ls *loopsSource) GetEmail(ctx context.Context, id string, includeUnpublished bool) (*SourceEmail, error) {
+	join, where := "JOIN", publishedEmailsWhere+" AND c.id = $1"
+	if includeUnpublished {
+		join, where = "LEFT JOIN", "WHERE c.id = $1"
+	}
+	emails, _, err := ls.queryEmails(ctx, join, where, []any{id}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, errNotFound
+	}
+	return &emails[0], nil
+}
+
+// ListChanges reports every sent campaign and every list. Loops bumps
+// updated_at when ai_publishable flips, and a decision made here counts
+// from when it was made, so an unpublished email reappears here as not live; sent campaigns that were never publishable are included
+// too, which is harmless for consumers deleting pages. An email also changes
+// when its list does, so a list going private deletes its emails with it.
+func (ls *loopsSource) ListChanges(ctx context.Context, after ChangeCursor, limit int) ([]SourceChange, error) {
+	overrides, args := ls.store.emailOverrides().join([]any{after.ChangedAt, after.Kind, after.ID, limit})
+	q := `
+WITH changes AS (
+  SELECT 'email' AS kind,
+         c.id,
+         COALESCE(c.ai_publishable_slug, '') AS slug,
+         COALESCE(c.ai_publishable_response_json->>'title', '') AS name,
+         COALESCE(c.mailing_list_id, '') AS mailing_list_id,
+         (ml.id IS NOT NULL AND ` + campaignPublishable + ` = true AND COALESCE(ml.is_public, false)) AS live,
+         GREATEST(` + campaignUpdatedAt + `, ml.last_updated_at) AS changed_at
+  FROM loops.campaigns c
+  LEFT JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+  ` + overrides + `
+  WHERE c.status = 'Sent'
+  UNION ALL
+  SELECT 'mailing_list', ml.id, '', ml.friendly_name, ml.id, COALESCE(ml.is_public, false), ml.last_updated_at
+  FROM loops.mailing_lists ml
+)
+SELECT kind, id, slug, name, mailing_list_id, live, changed_at
+FROM changes
+WHERE changed_at IS NOT NULL AND (changed_at, kind, id) > ($1, $2, $3)
+ORDER BY changed_at, kind, id
+LIMIT $4;
+`
+	rows, err := ls.store.content().Query(ctx, q, args...)
+	if err := ls.store.observe(depWarehouse, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]SourceChange, 0, limit)
+	for rows.Next() {
+		var sc SourceChange
+		if err := rows.Scan(&sc.Kind, &sc.ID, &sc.Slug, &sc.Name, &sc.MailingListID, &sc.Live, &sc.ChangedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, rows.Err()
+}
+
+// queryEmails runs the shared campaign SELECT. join is "JOIN" or "LEFT JOIN"
+// for the mailing list; where is a trusted clause using $1..$len(args).
+func (ls *loopsSource) queryEmails(ctx context.Context, join, where string, args []any, limit, offset int) ([]SourceEmail, *int, error) {
+	out := make([]SourceEmail, 0, limit)
+	err := ls.scanEmails(ctx, join, where, args, limit, offset, func(e *SourceEmail) error {
+		out = append(out, *e)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var next *int
+	if len(out) == limit {
+		n := offset + limit
+		next = &n
+	}
+	return out, next, nil
+}
+
+// scanEmails streams the shared campaign SELECT into fn, with restored
+// revisions applied. limit <= 0 means no limit.
+func (ls *loopsSource) scanEmails(ctx context.Context, join, where string, args []any, limit, offset int, fn func(*SourceEmail) error) error {
+	eo := ls.store.emailOverrides()
+	overrides, args := eo.join(args)
+	limitSQL := "ALL"
+	if limit > 0 {
+		args = append(args, limit)
+		limitSQL = fmt.Sprintf("$%d", len(args))
+	}
+	args = append(args, offset)
+	q := fmt.Sprintf(`
+SELECT
+  c.id,
+  COALESCE(c.ai_publishable_response_json->>'title', ''),
+  c.sent_at,
+  `+campaignUpdatedAt+`,
+  COALESCE(c.mailing_list_id, ''),
+  COALESCE(ml.friendly_name, ''),
+  COALESCE(ml.description, ''),
+  COALESCE(ml.color_scheme, '#000000'),
+  COALESCE(c.clicks, 0)::bigint,
+  COALESCE(c.opens, 0)::bigint,
+  c.ai_publishable_content_html,
+  c.ai_publishable_content_markdown,
+  COALESCE(c.ai_publishable_slug, ''),
+  c.ai_publishable_response_json->>'excerpt',
+  c.updated_at
+FROM loops.campaigns c
+%s loops.mailing_lists ml ON ml.id = c.mailing_list_id
+%s
+%s
+ORDER BY c.sent_at DESC NULLS LAST, c.created_at DESC
+LIMIT %s OFFSET $%d;
+`, join, overrides, where, limitSQL, len(args))
+	rows, err := ls.store.content().Query(ctx, q, args...)
+	if err := ls.store.observe(depWarehouse, err); err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e SourceEmail
+		var upstreamUpdatedAt *time.Time
+		if err := rows.Scan(
+			&e.ID, &e.Subject, &e.SentAt, &e.UpdatedAt, &e.MailingList.ID,
+			&e.MailingList.Name, &e.MailingList.Description, &e.MailingList.Color,
+			&e.Clicks, &e.Opens,
+			&e.HTML, &e.Markdown, &e.Slug, &e.Excerpt, &upstreamUpdatedAt,
+		); err != nil {
+			return err
+		}
+		eo.restore(&e, upstreamUpdatedAt)
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
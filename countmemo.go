@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ---------- Count Memo ----------
+
+// Every SSE client re-reads an email's counts when it changes, and list
+// pages read them for every email shown, so a popular email's counts are
+// queried many times a second. countMemo keeps each per-email count for
+// METRICS_COUNT_TTL (default 5s, "0" disables it) and lets concurrent
+// readers of a missing count share one query. A change notification drops
+// the email's entries first, so streams still see new counts right away:
+// the dozens of clients woken by it then share a single recount.
+
+// countMemoSweepSize is the entry count past which inserts drop expired
+// entries.
+const countMemoSweepSize = 1024
+
+// countMemo memoizes counts keyed "<kind> <email ID>". A nil *countMemo
+// queries every time.
+type countMemo struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*memoCount
+}
+
+type memoCount struct {
+	done chan struct{} // closed once n and err are set
+	n    int64
+	err  error
+	at   time.Time
+}
+
+func newCountMemoFromEnv() *countMemo {
+	ttl := envDuration("METRICS_COUNT_TTL", 5*time.Second)
+	if ttl <= 0 {
+		return nil
+	}
+	return &countMemo{ttl: ttl, entries: map[string]*memoCount{}}
+}
+
+// get returns the memoized count under key, running count when there's
+// none. Errors aren't kept.
+func (m *countMemo) get(ctx context.Context, key string, count func(context.Context) (int64, error)) (int64, error) {
+	if m == nil {
+		return count(ctx)
+	}
+	m.mu.Lock()
+	e := m.entries[key]
+	if e != nil {
+		select {
+		case <-e.done:
+			if time.Since(e.at) >= m.ttl {
+				e = nil
+			}
+		default: // in flight
+		}
+	}
+	if e != nil {
+		m.mu.Unlock()
+		select {
+		case <-e.done:
+			return e.n, e.err
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	e = &memoCount{done: make(chan struct{})}
+	if len(m.entries) >= countMemoSweepSize {
+		m.sweep()
+	}
+	m.entries[key] = e
+	m.mu.Unlock()
+
+	e.n, e.err = count(ctx)
+	e.at = time.Now()
+	close(e.done)
+	if e.err != nil {
+		m.mu.Lock()
+		if m.entries[key] == e {
+			delete(m.entries, key)
+		}
+		m.mu.Unlock()
+	}
+	return e.n, e.err
+}
+
+// sweep drops expired entries. Callers hold m.mu.
+func (m *countMemo) sweep() {
+	for key, e := range m.entries {
+		select {
+		case <-e.done:
+			if time.Since(e.at) >= m.ttl {
+				delete(m.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// Forget drops emailID's counts, so the next read queries again. A query
+// already in flight completes for its waiters but isn't kept.
+func (m *countMemo) Forget(emailID string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, kind := range []string{"views", "clicks", "likes"} {
+		delete(m.entries, kind+" "+emailID)
+	}
+}
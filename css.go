@@ -0,0 +1,289 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// ---------- Style Inlining ----------
+
+// Campaign HTML styles itself with <style> blocks, which a strict CSP
+// (default-src 'none') and most frontend sanitizers strip, leaving the
+// email unstyled. ?css= on /emails/{id} moves them out of the markup:
+//
+//   - extract removes every <style> block and returns its CSS in the
+//     styles field, for the frontend to scope and load its own way.
+//   - inline applies each rule to the elements it matches as style
+//     attributes, in cascade order (importance, then specificity, then
+//     source order, with existing style attributes winning over rules).
+//     What can't be inlined, @media queries, @font-face and rules on
+//     states or pseudo-elements such as a:hover, is returned in styles.
+
+const (
+	cssInline  = "inline"
+	cssExtract = "extract"
+)
+
+var cssCommentRegex = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
+// cssDynamicRegex matches selectors for states and pseudo-elements, which
+// have nothing to inline onto.
+var cssDynamicRegex = regexp.MustCompile(`(?i)::|:(hover|focus|focus-within|focus-visible|active|visited|target|before|after|first-line|first-letter|placeholder|selection|marker)\b`)
+
+// cssRule is a rule set, or an at-rule kept verbatim in raw.
+type cssRule struct {
+	selectors string
+	decls     string
+	raw       string
+}
+
+// parseCSS splits a stylesheet into rule sets and at-rules.
+func parseCSS(css string) []cssRule {
+	css = cssCommentRegex.ReplaceAllString(css, "")
+	var rules []cssRule
+	for i := 0; i < len(css); {
+		for i < len(css) && strings.IndexByte(" \t\r\n\f;", css[i]) >= 0 {
+			i++
+		}
+		if i >= len(css) {
+			break
+		}
+		open := cssIndexOutsideStrings(css, i, "{;")
+		if css[i] == '@' && (open < 0 || css[open] == ';') {
+			// A statement at-rule: @import, @charset, @namespace.
+			end := len(css)
+			if open >= 0 {
+				end = open + 1
+			}
+			rules = append(rules, cssRule{raw: strings.TrimSpace(css[i:end])})
+			i = end
+			continue
+		}
+		if open < 0 {
+			break
+		}
+		if css[open] == ';' {
+			// Stray declaration outside any block; skip it.
+			i = open + 1
+			continue
+		}
+		end := cssMatchingBrace(css, open)
+		if css[i] == '@' {
+			rules = append(rules, cssRule{raw: strings.TrimSpace(css[i:end])})
+		} else {
+			rules = append(rules, cssRule{
+				selectors: strings.TrimSpace(css[i:open]),
+				decls:     strings.TrimSpace(css[open+1 : max(open+1, end-1)]),
+			})
+		}
+		i = end
+	}
+	return rules
+}
+
+// cssIndexOutsideStrings is the index of the first of chars at or after
+// from that isn't in a quoted string, or -1.
+func cssIndexOutsideStrings(css string, from int, chars string) int {
+	var quote byte
+	for i := from; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.IndexByte(chars, c) >= 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// cssMatchingBrace returns the index just past the brace closing the block
+// opened at css[open], or len(css) if it's unclosed.
+func cssMatchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; ; {
+		i = cssIndexOutsideStrings(css, i, "{}")
+		if i < 0 {
+			return len(css)
+		}
+		if css[i] == '{' {
+			depth++
+		} else if depth--; depth == 0 {
+			return i + 1
+		}
+		i++
+	}
+}
+
+// cssDecl is one declaration.
+type cssDecl struct {
+	prop, value string
+	important   bool
+}
+
+// parseCSSDecls splits a declaration block or style attribute.
+func parseCSSDecls(block string) []cssDecl {
+	var decls []cssDecl
+	for block != "" {
+		end := cssIndexOutsideStrings(block, 0, ";")
+		decl := block
+		if end < 0 {
+			block = ""
+		} else {
+			decl, block = block[:end], block[end+1:]
+		}
+		prop, value, ok := strings.Cut(decl, ":")
+		prop = strings.ToLower(strings.TrimSpace(prop))
+		value = strings.TrimSpace(value)
+		if !ok || prop == "" || value == "" {
+			continue
+		}
+		d := cssDecl{prop: prop, value: value}
+		if i := strings.LastIndex(value, "!"); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			d.value, d.important = strings.TrimSpace(value[:i]), true
+		}
+		decls = append(decls, d)
+	}
+	return decls
+}
+
+// cssApplied is a rule's declarations as applied to one element.
+type cssApplied struct {
+	specificity cascadia.Specificity
+	order       int
+	decls       []cssDecl
+}
+
+// takeStyles removes doc's <style> blocks and returns their CSS.
+func takeStyles(doc *goquery.Document) string {
+	var css []string
+	doc.Find("style").Each(func(_ int, sel *goquery.Selection) {
+		if t := strings.TrimSpace(sel.Text()); t != "" {
+			css = append(css, t)
+		}
+	}).Remove()
+	return strings.Join(css, "\n")
+}
+
+// inlineStyles applies doc's <style> rules to its elements and returns the
+// CSS that couldn't be inlined.
+func inlineStyles(doc *goquery.Document) string {
+	applied := map[*html.Node][]cssApplied{}
+	var rest []string
+	for order, rule := range parseCSS(takeStyles(doc)) {
+		if rule.raw != "" {
+			if !strings.HasPrefix(strings.ToLower(rule.raw), "@charset") {
+				rest = append(rest, rule.raw)
+			}
+			continue
+		}
+		decls := parseCSSDecls(rule.decls)
+		var kept []string
+		for _, selector := range strings.Split(rule.selectors, ",") {
+			selector = strings.TrimSpace(selector)
+			if selector == "" {
+				continue
+			}
+			sel, err := cascadia.Parse(selector)
+			if cssDynamicRegex.MatchString(selector) || err != nil {
+				kept = append(kept, selector)
+				continue
+			}
+			for _, n := range cascadia.QueryAll(doc.Nodes[0], sel) {
+				applied[n] = append(applied[n], cssApplied{specificity: sel.Specificity(), order: order, decls: decls})
+			}
+		}
+		if len(kept) > 0 {
+			rest = append(rest, strings.Join(kept, ", ")+" { "+rule.decls+" }")
+		}
+	}
+
+	for n, rules := range applied {
+		sort.SliceStable(rules, func(i, j int) bool {
+			if rules[i].specificity != rules[j].specificity {
+				return rules[i].specificity.Less(rules[j].specificity)
+			}
+			return rules[i].order < rules[j].order
+		})
+		// Later declarations win, so: rules, then the element's own style,
+		// then important rules.
+		var normal, important []cssDecl
+		for _, r := range rules {
+			for _, d := range r.decls {
+				if d.important {
+					important = append(important, d)
+				} else {
+					normal = append(normal, d)
+				}
+			}
+		}
+		sel := goquery.NewDocumentFromNode(n).Selection
+		own := parseCSSDecls(sel.AttrOr("style", ""))
+		for _, d := range own {
+			if d.important {
+				important = append(important, d)
+			}
+		}
+		sel.SetAttr("style", formatCSSDecls(append(append(normal, own...), important...)))
+	}
+	return strings.Join(rest, "\n")
+}
+
+// formatCSSDecls writes decls as a style attribute, keeping only the last
+// declaration of each property.
+func formatCSSDecls(decls []cssDecl) string {
+	last := map[string]int{}
+	for i, d := range decls {
+		last[d.prop] = i
+	}
+	var out []string
+	for i, d := range decls {
+		if last[d.prop] != i {
+			continue
+		}
+		s := d.prop + ": " + d.value
+		if d.important {
+			s += " !important"
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, "; ")
+}
+
+// transformCSS applies ?css=inline or extract to e.HTML, setting e.Styles
+// to the CSS left over.
+func (e *Email) transformCSS(mode string) error {
+	if e.HTML == nil || *e.HTML == "" {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(*e.HTML))
+	if err != nil {
+		return err
+	}
+	var styles string
+	if mode == cssInline {
+		styles = inlineStyles(doc)
+	} else {
+		styles = takeStyles(doc)
+	}
+	out, err := doc.Html()
+	if err != nil {
+		return err
+	}
+	e.HTML = &out
+	if styles != "" {
+		e.Styles = &styles
+	}
+	return nil
+}
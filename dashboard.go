@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+// ---------- Admin Dashboard ----------
+
+// /admin/dashboard is a minimal ops/editor view: live per-email counts from
+// the firehose, plus cache hit rates, rate-limited clicks, and recent
+// tracking activity polled from /admin/dashboard/data. It's behind the admin
+// key like every /admin route; browsers get a Basic auth prompt, where the
+// password is ADMIN_API_KEY, and reuse it for the data requests and for
+// /admin/stats/stream, the firehose served under /admin since EventSource
+// can't send the bearer token /stats/stream wants when API_KEYS is set.
+
+const dashboardRecentChanges = 50
+
+const dashboardScript = `
+const $ = id => document.getElementById(id);
+const counts = new Map();
+function render() {
+  const rows = [...counts.entries()].sort((a, b) => b[1].at - a[1].at).slice(0, 100);
+  $("live").replaceChildren(...rows.map(([id, s]) => {
+    const tr = document.createElement("tr");
+    for (const v of [id, s.views.toLocaleString(), s.clicks.toLocaleString(), new Date(s.at).toLocaleTimeString()]) {
+      const td = document.createElement("td");
+      td.textContent = v;
+      tr.append(td);
+    }
+    return tr;
+  }));
+}
+const es = new EventSource("/admin/stats/stream");
+es.onopen = () => { $("stream").textContent = "connected"; };
+es.onerror = () => { $("stream").textContent = "reconnecting…"; };
+es.onmessage = e => {
+  const s = JSON.parse(e.data);
+  counts.set(s.email_id, { views: s.views, clicks: s.clicks, at: Date.now() });
+  render();
+};
+async function poll() {
+  try {
+    const res = await fetch("/admin/dashboard/data", { cache: "no-store" });
+    const d = await res.json();
+    const c = d.cache;
+    $("cache").textContent = c.entries + "/" + c.max + " entries, " +
+      (c.hit_rate * 100).toFixed(1) + "% hits (" + c.hits + " hits, " + c.misses + " misses, " + c.stale + " stale), ttl " + c.ttl;
+    $("clicks").textContent = d.clicks.tracked + " tracked, " + d.clicks.rate_limited + " rate limited";
+    $("degraded").textContent = d.degraded.length ? d.degraded.join(", ") : "none";
+    $("recent").replaceChildren(...d.recent.map(r => {
+      const li = document.createElement("li");
+      li.textContent = new Date(r.at).toLocaleTimeString() + " " + r.email_id;
+      return li;
+    }));
+  } catch (err) {
+    $("cache").textContent = "error: " + err;
+  }
+}
+poll();
+setInterval(poll, 5000);
+`
+
+var dashboardScriptHash = func() string {
+	sum := sha256.Sum256([]byte(dashboardScript))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}()
+
+const dashboardHTML = `<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>news admin</title>
+<style>
+  body { margin: 24px; font: 14px/1.45 system-ui, -apple-system, "Segoe UI", sans-serif; color: #1f2d3d; }
+  h1 { font-size: 20px; } h2 { font-size: 15px; margin-top: 24px; }
+  table { border-collapse: collapse; } td, th { padding: 4px 12px 4px 0; text-align: left; }
+  td:nth-child(2), td:nth-child(3) { text-align: right; font-variant-numeric: tabular-nums; }
+  .meta { color: #8492a6; } ul { padding-left: 18px; font-family: ui-monospace, monospace; font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>news admin</h1>
+<p class="meta">Stream: <span id="stream">connecting…</span> · Degraded: <span id="degraded">…</span></p>
+<h2>Cache</h2>
+<p id="cache">…</p>
+<h2>Click tracking</h2>
+<p id="clicks">…</p>
+<h2>Live counts</h2>
+<table><thead><tr><th>Email</th><th>Views</th><th>Clicks</th><th>Updated</th></tr></thead><tbody id="live"></tbody></table>
+<h2>Recent tracking activity</h2>
+<ul id="recent"></ul>
+<script>` + dashboardScript + `</script>
+</body>
+</html>
+`
+
+func (s *Server) handleAdminDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; script-src "+dashboardScriptHash+
+		"; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none';")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(dashboardHTML))
+}
+
+type dashboardChange struct {
+	EmailID string    `json:"email_id"`
+	At      time.Time `json:"at"`
+}
+
+func (s *Server) handleAdminDashboardData(w http.ResponseWriter, r *http.Request) {
+	hits, misses, stale := s.cache.hits.Load(), s.cache.misses.Load(), s.cache.stale.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	recent := []dashboardChange{}
+	for _, c := range s.viewNotifier.Latest(dashboardRecentChanges) {
+		recent = append(recent, dashboardChange{EmailID: c.EmailID, At: c.At})
+	}
+	degraded := s.store.health.Degraded()
+	if degraded == nil {
+		degraded = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cache": map[string]any{
+			"entries":  s.cache.Len(),
+			"max":      s.cache.max,
+			"ttl":      s.cache.ttl.String(),
+			"hits":     hits,
+			"misses":   misses,
+			"stale":    stale,
+			"hit_rate": hitRate,
+		},
+		"clicks": map[string]any{
+			"tracked":      s.clicksTracked.Load(),
+			"rate_limited": s.clicksLimited.Load(),
+		},
+		"recent":   recent,
+		"degraded": degraded,
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Semantic Search ----------
+
+// Keyword search over a few hundred emails misses most of what readers
+// mean: "robots" doesn't find the email about a hardware grant. With
+// ENRICH_EMBEDDINGS_URL set to an OpenAI-compatible embeddings endpoint
+// (ENRICH_EMBEDDINGS_MODEL, default text-embedding-3-small; key
+// ENRICH_EMBEDDINGS_API_KEY, else ENRICH_LLM_API_KEY) and pgvector in the
+// metrics DB, each published email's subject and text are embedded into
+// email_embeddings, queued and claimed the same way enrichments are (see
+// enrich.go), every ENRICH_INTERVAL. Then:
+//
+//   - /search?mode=semantic embeds the query and returns the emails
+//     nearest to it;
+//   - /emails/{id}/similar returns the emails nearest to one, for "more
+//     like this".
+//
+// Nearness is cosine similarity. The corpus is small enough for exact
+// search without an approximate index, which also leaves the number of
+// dimensions up to the model. Only vectors from the current model are
+// compared; changing the model re-embeds every email.
+
+const defaultEmbeddingsModel = "text-embedding-3-small"
+
+// errNoEmbeddings is returned when semantic search isn't configured.
+var errNoEmbeddings = &statusError{status: http.StatusNotImplemented, code: codeNotImplemented, message: "semantic search needs ENRICH_EMBEDDINGS_URL and pgvector in the metrics DB"}
+
+// newEmbeddingsClientFromEnv returns the ENRICH_EMBEDDINGS_URL client, or
+// nil when unset.
+func newEmbeddingsClientFromEnv() *llmClient {
+	u := os.Getenv("ENRICH_EMBEDDINGS_URL")
+	if u == "" {
+		return nil
+	}
+	return &llmClient{
+		url:    u,
+		key:    env("ENRICH_EMBEDDINGS_API_KEY", os.Getenv("ENRICH_LLM_API_KEY")),
+		model:  env("ENRICH_EMBEDDINGS_MODEL", defaultEmbeddingsModel),
+		client: &http.Client{Timeout: enrichTimeout},
+	}
+}
+
+// embed returns the embedding of each of texts, in order.
+func (c *llmClient) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var reply struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := c.post(ctx, map[string]any{"model": c.model, "input": texts}, &reply); err != nil {
+		return nil, err
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range reply.Data {
+		if d.Index >= 0 && d.Index < len(out) {
+			out[d.Index] = d.Embedding
+		}
+	}
+	for _, v := range out {
+		if len(v) == 0 {
+			return nil, errors.New("llm: missing embedding in response")
+		}
+	}
+	return out, nil
+}
+
+// vectorLiteral formats v as pgvector input, "[1,2,3]".
+func vectorLiteral(v []float32) string {
+	parts := make([]string, len(v))
+	for i, x := range v {
+		parts[i] = strconv.FormatFloat(float64(x), 'g', -1, 32)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// embeddingInput is the text of src that's embedded.
+func embeddingInput(src *SourceEmail) string {
+	return src.Subject + "\n\n" + truncateUTF8(sourcePlaintext(src), enrichMaxInput)
+}
+
+// embeddingHash identifies the content and model an embedding is made from.
+func (s *Store) embeddingHash(src *SourceEmail) string {
+	sum := sha256.Sum256([]byte(s.embedder.model + "\x00" + embeddingInput(src)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// StartEmbeddings schedules the scan and handles embed.email jobs, if
+// the email_embeddings table exists (it needs pgvector).
+func (s *Store) StartEmbeddings(ctx context.Context) {
+	s.embedder = newEmbeddingsClientFromEnv()
+	interval := envDuration("ENRICH_INTERVAL", defaultEnrichInterval)
+	if s.embedder == nil || s.metricsPool == nil || interval <= 0 {
+		s.embedder = nil
+		return
+	}
+	var exists bool
+	err := s.metricsPool.QueryRow(ctx, `SELECT to_regclass('email_embeddings') IS NOT NULL`).Scan(&exists)
+	if err := s.observe(depMetrics, err); err != nil || !exists {
+		log.Printf("semantic search: off, email_embeddings is missing (the metrics DB needs the pgvector extension; see migrations/0030_email_embeddings.sql)")
+		s.embedder = nil
+		return
+	}
+	s.jobs.Handle("embed.email", 5, func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ID   string `json:"id"`
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return s.EmbedEmail(ctx, p.ID, p.Hash)
+	})
+	s.jobs.Every("embed.scan", interval, func(ctx context.Context) error {
+		n, err := s.queueChangedEmails(ctx, "email_embeddings", "embed.email", s.embeddingHash)
+		if n > 0 {
+			log.Printf("semantic search: queued %d emails to embed", n)
+		}
+		return err
+	})
+}
+
+// EmbedEmail embeds email id and saves it, unless its content (or the
+// model) no longer hashes to hash.
+func (s *Store) EmbedEmail(ctx context.Context, id, hash string) error {
+	if s.embedder == nil {
+		return errNoEmbeddings
+	}
+	src, err := s.source.GetEmail(ctx, id, false)
+	if errors.Is(err, errNotFound) {
+		return nil // unpublished since
+	}
+	if err != nil {
+		return err
+	}
+	if s.embeddingHash(src) != hash {
+		return nil
+	}
+	vecs, err := s.embedder.embed(ctx, []string{embeddingInput(src)})
+	if err != nil {
+		return err
+	}
+	_, err = s.metricsPool.Exec(ctx, `
+		UPDATE email_embeddings
+		SET content_hash = $2, model = $3, embedding = $4::vector, embedded_at = NOW()
+		WHERE email_id = $1 AND requested_hash = $2
+	`, id, hash, s.embedder.model, vectorLiteral(vecs[0]))
+	return s.observe(depMetrics, err)
+}
+
+// SemanticSearchEmails returns up to limit published emails nearest in
+// meaning to q, best first.
+func (s *Store) SemanticSearchEmails(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	if s.embedder == nil {
+		return nil, errNoEmbeddings
+	}
+	vecs, err := s.embedder.embed(ctx, []string{q})
+	if err != nil {
+		return nil, err
+	}
+	// Fetch extra, since some may have been unpublished since.
+	return s.nearestEmails(ctx, `
+		SELECT email_id, 1 - (embedding <=> $1::vector)
+		FROM email_embeddings
+		WHERE model = $2 AND embedding IS NOT NULL
+		ORDER BY embedding <=> $1::vector
+		LIMIT $3
+	`, limit, vectorLiteral(vecs[0]), s.embedder.model, limit*2)
+}
+
+// SimilarEmails returns up to limit published emails nearest in meaning
+// to email id, best first; none until it's been embedded.
+func (s *Store) SimilarEmails(ctx context.Context, id string, limit int) ([]SearchResult, error) {
+	if s.embedder == nil {
+		return nil, errNoEmbeddings
+	}
+	return s.nearestEmails(ctx, `
+		SELECT b.email_id, 1 - (a.embedding <=> b.embedding)
+		FROM email_embeddings a
+		JOIN email_embeddings b ON b.model = a.model AND b.email_id <> a.email_id AND b.embedding IS NOT NULL
+		WHERE a.email_id = $1 AND a.model = $2 AND a.embedding IS NOT NULL
+		ORDER BY a.embedding <=> b.embedding
+		LIMIT $3
+	`, limit, id, s.embedder.model, limit*2)
+}
+
+// nearestEmails runs query, which returns email IDs and similarities, and
+// looks up up to limit of them that are still published.
+func (s *Store) nearestEmails(ctx context.Context, query string, limit int, args ...any) ([]SearchResult, error) {
+	rows, err := s.metricsPool.Query(ctx, query, args...)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	scores := map[string]float64{}
+	for rows.Next() {
+		var id string
+		var score float64
+		if err := rows.Scan(&id, &score); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		scores[id] = math.Round(score*10000) / 10000
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []SearchResult{}, nil
+	}
+
+	srcs, _, err := s.source.ListEmails(ctx, EmailFilter{IDs: ids}, len(ids), 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SearchResult, 0, len(srcs))
+	for _, src := range srcs {
+		ref := src.MailingList
+		ref.Slug = slugify(ref.Name)
+		ref.LogoURL = s.ListLogoURL(ref.ID)
+		out = append(out, SearchResult{
+			ID:             src.ID,
+			Slug:           emailSlug(src.Slug, src.Subject, src.ID),
+			Subject:        src.Subject,
+			Excerpt:        src.Excerpt,
+			SentAt:         src.SentAt,
+			MailingListRef: ref,
+			Score:          scores[src.ID],
+			Match:          "semantic",
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *Server) handleEmailSimilar(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	limit, _ := parseLimitOffset(r, 5)
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.SimilarEmails(r.Context(), emailID, limit)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items}, nil
+	})
+}
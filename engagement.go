@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Engagement ----------
+
+// Views say an email was opened, not read. The archive page also beacons how
+// far each session scrolled and how long it spent reading, and the
+// aggregates are served beside the other per-email analytics. Both are
+// stored as per-session maxima: a beacon only adds a row when it beats the
+// session's previous report for the email.
+
+// ScrollEvent is the furthest a session has scrolled through an email, as a
+// percentage of the page.
+type ScrollEvent struct {
+	Time      time.Time
+	SessionID string
+	EmailID   string
+	Depth     int // 0-100
+	Device    DeviceInfo
+}
+
+// ReadEvent is how long a session has spent reading an email so far.
+type ReadEvent struct {
+	Time      time.Time
+	SessionID string
+	EmailID   string
+	Seconds   int // bucketed; see readTimeBucket
+	Device    DeviceInfo
+}
+
+// maxReadSeconds caps read time; longer means a tab left open.
+const maxReadSeconds = 1800
+
+// readTimeBucket rounds seconds down to 5s steps under a minute, 15s under
+// five minutes, and whole minutes after that, so medians are stable and
+// values can't fingerprint a reader.
+func readTimeBucket(seconds float64) int {
+	s := int(min(seconds, maxReadSeconds))
+	switch {
+	case s < 60:
+		return s - s%5
+	case s < 300:
+		return s - s%15
+	default:
+		return s - s%60
+	}
+}
+
+// sessionMax is a ScrollEvent or ReadEvent as stored.
+type sessionMax struct {
+	time        time.Time
+	sessionID   string
+	emailID     string
+	value       int
+	deviceClass string
+}
+
+// insertSessionMaxima writes events to table, dropping any that don't
+// exceed what the session already reported for the email in the last day
+// (in the DB or the batch). column holds the value.
+func (s *Store) insertSessionMaxima(ctx context.Context, table, column string, events []sessionMax) error {
+	if s.metricsPool == nil || len(events) == 0 {
+		return nil
+	}
+
+	n := len(events)
+	times := make([]time.Time, n)
+	sessions := make([]string, n)
+	emails := make([]string, n)
+	values := make([]int32, n)
+	devices := make([]string, n)
+	for i, ev := range events {
+		times[i] = ev.time
+		sessions[i] = s.hashSession(ev.sessionID, ev.time)
+		emails[i] = ev.emailID
+		values[i] = int32(ev.value)
+		devices[i] = ev.deviceClass
+	}
+
+	_, err := s.metricsPool.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %[1]s (region, session_hashed, time, session_id, email_id, %[2]s, device_class)
+		SELECT DISTINCT ON (e.session_id, e.email_id)
+		       NULLIF($6, ''), NULLIF($7::boolean, false), e.time, e.session_id, e.email_id, e.value, e.device_class
+		FROM unnest($1::timestamptz[], $2::text[], $3::text[], $4::int[], $5::text[])
+		     AS e(time, session_id, email_id, value, device_class)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM %[1]s d
+			WHERE d.session_id = e.session_id
+			  AND d.email_id = e.email_id
+			  AND d.%[2]s >= e.value
+			  AND d.time > e.time - INTERVAL '1 day'
+		)
+		ORDER BY e.session_id, e.email_id, e.value DESC
+	`, table, column), times, sessions, emails, values, devices, s.region, s.sessions != nil)
+	return err
+}
+
+func (s *Store) InsertScrollEvents(ctx context.Context, events []ScrollEvent) error {
+	rows := make([]sessionMax, len(events))
+	for i, ev := range events {
+		rows[i] = sessionMax{ev.Time, ev.SessionID, ev.EmailID, ev.Depth, ev.Device.Class}
+	}
+	return s.insertSessionMaxima(ctx, "email_scroll_depth", "depth", rows)
+}
+
+func (s *Store) InsertReadEvents(ctx context.Context, events []ReadEvent) error {
+	rows := make([]sessionMax, len(events))
+	for i, ev := range events {
+		rows[i] = sessionMax{ev.Time, ev.SessionID, ev.EmailID, ev.Seconds, ev.Device.Class}
+	}
+	return s.insertSessionMaxima(ctx, "email_read_time", "seconds", rows)
+}
+
+// GetMedianReadSeconds is the median of each session's longest reported
+// read time, or nil before anyone has reported one.
+func (s *Store) GetMedianReadSeconds(ctx context.Context, emailID string) (*int, error) {
+	if s.metricsPool == nil {
+		return nil, nil
+	}
+
+	var median *int
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT percentile_disc(0.5) WITHIN GROUP (ORDER BY seconds)
+		FROM (
+			SELECT MAX(seconds) AS seconds
+			FROM email_read_time
+			WHERE email_id = $1
+			GROUP BY session_id
+		) sessions
+	`, emailID).Scan(&median)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	return median, nil
+}
+
+// ScrollDepth summarizes how far sessions got through an email.
+type ScrollDepth struct {
+	EmailID     string          `json:"email_id"`
+	Sessions    int64           `json:"sessions"`
+	MedianDepth *float64        `json:"median_depth"`
+	Reached     []ScrollReached `json:"reached"`
+}
+
+// ScrollReached counts sessions that scrolled at least Depth percent.
+type ScrollReached struct {
+	Depth    int     `json:"depth"`
+	Sessions int64   `json:"sessions"`
+	Share    float64 `json:"share"` // of all sessions that reported a depth
+}
+
+// scrollDepthMarks are the depths reported in ScrollDepth.Reached, matching
+// the FILTER columns in GetScrollDepth.
+var scrollDepthMarks = [...]int{25, 50, 75, 100}
+
+func (s *Store) GetScrollDepth(ctx context.Context, emailID string) (ScrollDepth, error) {
+	out := ScrollDepth{EmailID: emailID, Reached: []ScrollReached{}}
+	var counts [len(scrollDepthMarks)]int64
+	if s.metricsPool != nil {
+		err := s.metricsPool.QueryRow(ctx, `
+			WITH sessions AS (
+				SELECT session_id, MAX(depth) AS depth
+				FROM email_scroll_depth
+				WHERE email_id = $1
+				GROUP BY session_id
+			)
+			SELECT COUNT(*),
+			       percentile_cont(0.5) WITHIN GROUP (ORDER BY depth),
+			       COUNT(*) FILTER (WHERE depth >= 25),
+			       COUNT(*) FILTER (WHERE depth >= 50),
+			       COUNT(*) FILTER (WHERE depth >= 75),
+			       COUNT(*) FILTER (WHERE depth >= 100)
+			FROM sessions
+		`, emailID).Scan(&out.Sessions, &out.MedianDepth, &counts[0], &counts[1], &counts[2], &counts[3])
+		if err != nil {
+			return out, err
+		}
+	}
+	for i, mark := range scrollDepthMarks {
+		r := ScrollReached{Depth: mark, Sessions: counts[i]}
+		if out.Sessions > 0 {
+			r.Share = float64(r.Sessions) / float64(out.Sessions)
+		}
+		out.Reached = append(out.Reached, r)
+	}
+	return out, nil
+}
+
+// handleEmailScroll takes {"depth": 0-100} from navigator.sendBeacon (so any
+// content type), or ?depth= for a bodiless fetch.
+func (s *Server) handleEmailScroll(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	var beacon struct {
+		Depth *float64 `json:"depth"`
+	}
+	if v := r.URL.Query().Get("depth"); v != "" {
+		d, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid depth")
+			return
+		}
+		beacon.Depth = &d
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&beacon); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid beacon")
+		return
+	}
+	if beacon.Depth == nil || *beacon.Depth < 0 || *beacon.Depth > 100 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "depth must be a percentage from 0 to 100")
+		return
+	}
+
+	cookie := getOrCreateSession(w, r)
+	s.metricsWriter.TrackScroll(ScrollEvent{
+		SessionID: cookie.Value,
+		EmailID:   emailID,
+		Depth:     int(*beacon.Depth),
+		Device:    parseDevice(r.UserAgent()),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEmailRead takes {"seconds": n}, the reader's active time on the
+// page so far (the archive page counts only while it's visible), as a
+// beacon or ?seconds=.
+func (s *Server) handleEmailRead(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	var beacon struct {
+		Seconds *float64 `json:"seconds"`
+	}
+	if v := r.URL.Query().Get("seconds"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid seconds")
+			return
+		}
+		beacon.Seconds = &n
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&beacon); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid beacon")
+		return
+	}
+	if beacon.Seconds == nil || *beacon.Seconds < 0 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "seconds must be a non-negative number")
+		return
+	}
+
+	cookie := getOrCreateSession(w, r)
+	s.metricsWriter.TrackRead(ReadEvent{
+		SessionID: cookie.Value,
+		EmailID:   emailID,
+		Seconds:   readTimeBucket(*beacon.Seconds),
+		Device:    parseDevice(r.UserAgent()),
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleEmailScrollDepth(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		return s.store.GetScrollDepth(r.Context(), emailID)
+	})
+}
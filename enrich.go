@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ---------- AI Enrichment ----------
+
+// Listing cards have only a subject and excerpt to go on. With
+// ENRICH_LLM_URL set to an OpenAI-compatible chat completions endpoint
+// (ENRICH_LLM_API_KEY, ENRICH_LLM_MODEL, default gpt-4o-mini), each
+// published email is sent to the model once, and the short summary and 3-5
+// key points it writes are kept in email_enrichments in the metrics DB and
+// returned as the email's summary and key_points.
+//
+// Every ENRICH_INTERVAL (default 10m, "0" disables it) the scan queues an
+// enrich.email job for each email that's new, or whose content changed
+// since it was enriched; claiming the email in email_enrichments first
+// means only one replica queues it. The jobs retry a failing endpoint
+// with backoff. Enrichments are loaded into memory every minute, and only
+// shown while they match the email's current content, so an edited email
+// shows none until it's been enriched again. An email whose job fails
+// for good (see /admin/jobs) is tried again once its content changes.
+
+const (
+	defaultEnrichInterval = 10 * time.Minute
+	defaultEnrichModel    = "gpt-4o-mini"
+	enrichMaxInput        = 12000 // bytes of plaintext sent to the model
+	enrichMaxKeyPoints    = 5
+	enrichMaxTopics       = 3
+	enrichTimeout         = 45 * time.Second
+
+	// enrichVersion is part of the content hash; bumping it when the
+	// prompt or what's asked for changes re-enriches every email.
+	enrichVersion = "2"
+)
+
+var enrichSystemPrompt = `You summarize newsletter emails from Hack Club, a nonprofit network of teen coders, for cards on the newsletter archive.
+Reply with a JSON object:
+{"summary": "...", "key_points": ["...", "..."], "topics": ["..."]}
+- summary: one or two plain sentences, under 300 characters, saying what the email is about. No greeting, no "This email...".
+- key_points: 3 to 5 short bullet points (under 120 characters each) with the most useful specifics: events, dates, deadlines, programs, calls to action.
+- topics: the 1 to 3 topics the email is mostly about, most relevant first, using only these slugs:
+` + topicPrompt() + `Use only what's in the email. Plain text only, no markdown.`
+
+// errNoEnrichment is returned when enrichment isn't configured.
+var errNoEnrichment = errors.New("enrichment needs ENRICH_LLM_URL and METRICS_DATABASE_URL")
+
+// enrichment is what the model wrote about an email's content.
+type enrichment struct {
+	Hash      string // enrichmentHash of the content it was written from
+	Summary   string
+	KeyPoints []string
+	Topics    []string // slugs in topicTaxonomy; see topics.go
+}
+
+// llmClient calls an OpenAI-compatible chat completions (or, for
+// embeddings.go, embeddings) endpoint.
+type llmClient struct {
+	url, key, model string
+	client          *http.Client
+}
+
+// newLLMClientFromEnv returns the ENRICH_LLM_URL client, or nil when unset.
+func newLLMClientFromEnv() *llmClient {
+	u := os.Getenv("ENRICH_LLM_URL")
+	if u == "" {
+		return nil
+	}
+	return &llmClient{
+		url:    u,
+		key:    os.Getenv("ENRICH_LLM_API_KEY"),
+		model:  env("ENRICH_LLM_MODEL", defaultEnrichModel),
+		client: &http.Client{Timeout: enrichTimeout},
+	}
+}
+
+// completeJSON asks the model to answer user, following system, with a
+// JSON object, and decodes it into out.
+func (c *llmClient) completeJSON(ctx context.Context, system, user string, out any) error {
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	err := c.post(ctx, map[string]any{
+		"model": c.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": system},
+			{"role": "user", "content": user},
+		},
+		"response_format": map[string]string{"type": "json_object"},
+		"temperature":     0.2,
+	}, &completion)
+	if err != nil {
+		return err
+	}
+	if len(completion.Choices) == 0 {
+		return errors.New("llm: no choices in response")
+	}
+	content := strings.TrimSpace(completion.Choices[0].Message.Content)
+	// Some models fence JSON even when asked for an object.
+	content = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(content, "```json"), "```"), "```")
+	if err := json.Unmarshal([]byte(content), out); err != nil {
+		return fmt.Errorf("llm: reply isn't the JSON asked for: %w", err)
+	}
+	return nil
+}
+
+// post sends body to the endpoint as JSON and decodes its reply into out.
+func (c *llmClient) post(ctx context.Context, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("llm: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+	return nil
+}
+
+// sourcePlaintext is src's text: its markdown body when there is one, else
+// its HTML's text.
+func sourcePlaintext(src *SourceEmail) string {
+	if src.Markdown != nil && *src.Markdown != "" {
+		return strings.TrimSpace(*src.Markdown)
+	}
+	if src.HTML != nil {
+		return stripTags(*src.HTML)
+	}
+	return ""
+}
+
+// truncateUTF8 cuts s to at most n bytes, at a character boundary.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// enrichmentHash identifies the content an enrichment is written from.
+func enrichmentHash(src *SourceEmail) string {
+	sum := sha256.Sum256([]byte(enrichVersion + "\x00" + src.Subject + "\x00" + sourcePlaintext(src)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// StartEnrichment schedules the scan and handles enrich.email jobs.
+func (s *Store) StartEnrichment() {
+	s.llm = newLLMClientFromEnv()
+	interval := envDuration("ENRICH_INTERVAL", defaultEnrichInterval)
+	if s.llm == nil || s.metricsPool == nil || interval <= 0 {
+		s.llm = nil
+		return
+	}
+	s.jobs.Handle("enrich.email", 5, func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			ID   string `json:"id"`
+			Hash string `json:"hash"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return s.EnrichEmail(ctx, p.ID, p.Hash)
+	})
+	s.jobs.Every("refresh.enrichments", time.Minute, s.LoadEnrichments)
+	s.jobs.Every("enrich.scan", interval, func(ctx context.Context) error {
+		n, err := s.queueChangedEmails(ctx, "email_enrichments", "enrich.email", enrichmentHash)
+		if n > 0 {
+			log.Printf("enrichment: queued %d emails", n)
+		}
+		return err
+	})
+}
+
+// queueChangedEmails queues a job of kind for each published email whose
+// row in table (email_enrichments or email_embeddings) is missing or was
+// requested for content with a different hash, and returns how many.
+func (s *Store) queueChangedEmails(ctx context.Context, table, kind string, hashOf func(*SourceEmail) string) (int, error) {
+	rows, err := s.metricsPool.Query(ctx, `SELECT email_id, requested_hash FROM `+table)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	requested := map[string]string{}
+	for rows.Next() {
+		var id, hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		requested[id] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	err = s.source.EachEmail(ctx, EmailFilter{}, 0, func(src *SourceEmail) error {
+		hash := hashOf(src)
+		if requested[src.ID] == hash {
+			return nil
+		}
+		// Claim it, so replicas scanning at the same time queue it once.
+		tag, err := s.metricsPool.Exec(ctx, `
+			INSERT INTO `+table+` (email_id, requested_hash) VALUES ($1, $2)
+			ON CONFLICT (email_id) DO UPDATE SET requested_hash = EXCLUDED.requested_hash
+			WHERE `+table+`.requested_hash <> EXCLUDED.requested_hash
+		`, src.ID, hash)
+		if err := s.observe(depMetrics, err); err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return nil
+		}
+		queued++
+		return s.jobs.Enqueue(ctx, kind, map[string]string{"id": src.ID, "hash": hash})
+	})
+	return queued, err
+}
+
+// EnrichEmail has the model enrich email id and saves the result, unless
+// its content no longer hashes to hash (a newer job is queued for it).
+func (s *Store) EnrichEmail(ctx context.Context, id, hash string) error {
+	if s.llm == nil {
+		return errNoEnrichment
+	}
+	src, err := s.source.GetEmail(ctx, id, false)
+	if errors.Is(err, errNotFound) {
+		return nil // unpublished since
+	}
+	if err != nil {
+		return err
+	}
+	if enrichmentHash(src) != hash {
+		return nil
+	}
+
+	var reply struct {
+		Summary   string   `json:"summary"`
+		KeyPoints []string `json:"key_points"`
+		Topics    []string `json:"topics"`
+	}
+	user := "Subject: " + src.Subject + "\n\n" + truncateUTF8(sourcePlaintext(src), enrichMaxInput)
+	if err := s.llm.completeJSON(ctx, enrichSystemPrompt, user, &reply); err != nil {
+		return err
+	}
+	en := enrichment{Hash: hash, Summary: strings.TrimSpace(reply.Summary), KeyPoints: []string{}, Topics: []string{}}
+	for _, p := range reply.KeyPoints {
+		if p = strings.TrimSpace(strings.TrimLeft(p, "-•* ")); p != "" && len(en.KeyPoints) < enrichMaxKeyPoints {
+			en.KeyPoints = append(en.KeyPoints, p)
+		}
+	}
+	for _, t := range reply.Topics {
+		// Models sometimes answer with a topic's name instead of its slug.
+		t = slugify(t)
+		if isTopic(t) && !slices.Contains(en.Topics, t) && len(en.Topics) < enrichMaxTopics {
+			en.Topics = append(en.Topics, t)
+		}
+	}
+	if en.Summary == "" {
+		return errors.New("llm: empty summary")
+	}
+
+	_, err = s.metricsPool.Exec(ctx, `
+		UPDATE email_enrichments
+		SET content_hash = $2, summary = $3, key_points = $4, topics = $5, model = $6, enriched_at = NOW()
+		WHERE email_id = $1 AND requested_hash = $2
+	`, id, hash, en.Summary, en.KeyPoints, en.Topics, s.llm.model)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	s.enrichments.Store(id, en)
+	return nil
+}
+
+// LoadEnrichments refreshes the in-memory enrichments from the metrics DB.
+func (s *Store) LoadEnrichments(ctx context.Context) error {
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT email_id, content_hash, summary, key_points, COALESCE(topics, '{}') FROM email_enrichments WHERE content_hash IS NOT NULL
+	`)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var en enrichment
+		if err := rows.Scan(&id, &en.Hash, &en.Summary, &en.KeyPoints, &en.Topics); err != nil {
+			return err
+		}
+		s.enrichments.Store(id, en)
+	}
+	return rows.Err()
+}
+
+// applyEnrichment sets e's enrichment fields from src's latest enrichment:
+// its topics, and its summary and key points if they match src's content.
+func (s *Store) applyEnrichment(e *Email, src *SourceEmail) {
+	v, ok := s.enrichments.Load(src.ID)
+	if !ok {
+		return
+	}
+	en := v.(enrichment)
+	e.Topics = en.Topics
+	if en.Hash == enrichmentHash(src) {
+		e.Summary = &en.Summary
+		e.KeyPoints = en.KeyPoints
+	}
+}
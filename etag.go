@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ---------- Content ETags ----------
+
+// Hashing a response's bytes ties its ETag to one cache fill: anything that
+// nudges the encoding (a degraded-dependency note, a fill racing a counter)
+// changes it, so replicas disagree and a CDN revalidating against another
+// instance rarely gets a 304. Responses built from emails, lists, and
+// changes are instead tagged from their items: emails and lists as served,
+// every field (their counters are ones every instance agrees on), changes
+// by ID and time, plus what of the envelope is content.
+
+// contentVersioned is implemented by response values that can describe
+// their content version. writeVersion returns false when it can't, and the
+// bytes are hashed instead.
+type contentVersioned interface {
+	writeVersion(w io.Writer) bool
+}
+
+// contentETag returns a weak ETag for v as served at r's cache key, or ""
+// if v isn't versioned. The build is part of it so a release that changes
+// response shapes doesn't revalidate old copies.
+func contentETag(r *http.Request, v any) string {
+	cv, ok := v.(contentVersioned)
+	if !ok {
+		return ""
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %s\n%s\n", version, commit, cacheKey(r))
+	if !cv.writeVersion(h) {
+		return ""
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// versionTime formats an optional timestamp for writeVersion.
+func versionTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Emails are hashed whole, as served: the body, enrichment, stats, image
+// sizes and the list's name and logo all change without updated_at, and a
+// field picked by hand is one a later change forgets to add.
+func (e *Email) writeVersion(w io.Writer) bool {
+	return writeJSONVersion(w, "email", e)
+}
+
+func (ml *MailingList) writeVersion(w io.Writer) bool {
+	return writeJSONVersion(w, "list", ml)
+}
+
+// writeJSONVersion writes v's JSON encoding, or returns false if it has
+// none.
+func writeJSONVersion(w io.Writer, kind string, v any) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	fmt.Fprintf(w, "%s %d\n%s\n", kind, len(b), b)
+	return true
+}
+
+func (c *Change) writeVersion(w io.Writer) bool {
+	fmt.Fprintf(w, "change %s %s %s %s\n", c.Kind, c.ID, c.Action, versionTime(&c.ChangedAt))
+	return true
+}
+
+func (p Paginated[T]) writeVersion(w io.Writer) bool {
+	for i := range p.Items {
+		cv, ok := any(&p.Items[i]).(contentVersioned)
+		if !ok || !cv.writeVersion(w) {
+			return false
+		}
+	}
+	if p.Next != nil {
+		fmt.Fprintf(w, "next %d\n", *p.Next)
+	}
+	if p.Count != nil {
+		fmt.Fprintf(w, "count %d\n", *p.Count)
+	}
+	if p.Meta != nil {
+		fmt.Fprintf(w, "degraded %q\n", p.Meta.Degraded)
+	}
+	return true
+}
+
+func (f ChangeFeed) writeVersion(w io.Writer) bool {
+	for i := range f.Items {
+		f.Items[i].writeVersion(w)
+	}
+	fmt.Fprintf(w, "next %s %t\n", f.NextCursor, f.HasMore)
+	return true
+}
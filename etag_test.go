@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmailETag(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/emails/abc", nil)
+	updated := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	base := func() *Email {
+		html := "<p>Hi</p>"
+		return &Email{
+			ID:             "abc",
+			Subject:        "Weekly #1",
+			UpdatedAt:      &updated,
+			MailingListID:  "weekly",
+			MailingListRef: ListRef{ID: "weekly", Slug: "weekly", Name: "Weekly", Color: "#ec3750"},
+			HTML:           &html,
+			Images:         []EmailImage{},
+		}
+	}
+	tag := contentETag(r, base())
+	if tag == "" || tag != contentETag(r, base()) {
+		t.Fatalf("contentETag = %q, want a stable tag", tag)
+	}
+
+	// Each of these changes what's served without moving updated_at.
+	changes := map[string]func(*Email){
+		"subject":      func(e *Email) { e.Subject = "Weekly #1 (fixed)" },
+		"excerpt":      func(e *Email) { e.Excerpt = ptr("New excerpt") },
+		"preview text": func(e *Email) { e.PreviewText = ptr("Hi") },
+		"list name":    func(e *Email) { e.MailingListRef.Name = "Hack Club Weekly" },
+		"list slug":    func(e *Email) { e.MailingListRef.Slug = "hack-club-weekly" },
+		"list color":   func(e *Email) { e.MailingListRef.Color = "#338eda" },
+		"list about":   func(e *Email) { e.MailingListRef.Description = "Every Friday" },
+		"list logo":    func(e *Email) { e.MailingListRef.LogoURL = ptr("https://example.com/logo.svg") },
+		"views":        func(e *Email) { e.Stats.Views++ },
+		"likes":        func(e *Email) { e.Stats.Likes++ },
+		"body":         func(e *Email) { e.HTML = ptr("<p>Hello</p>") },
+		"image size":   func(e *Email) { e.Images = []EmailImage{{Src: "https://example.com/a.png", Width: 600}} },
+		"summary":      func(e *Email) { e.Summary = ptr("A summary") },
+	}
+	for name, change := range changes {
+		e := base()
+		change(e)
+		if got := contentETag(r, e); got == tag {
+			t.Errorf("changing the %s kept the ETag %s", name, tag)
+		}
+	}
+
+	other := httptest.NewRequest(http.MethodGet, "/emails/abc?render=markdown", nil)
+	if contentETag(other, base()) == tag {
+		t.Error("ETag doesn't depend on the cache key")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ---------- Streaming Export ----------
+
+// NDJSON responses write one JSON document per line as rows are scanned, so
+// exporting the whole archive never buffers it in memory. They, and
+// everything under /export/, bypass the response cache and the request
+// timeout.
+
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonFlushEvery bounds how much a slow client can leave buffered.
+const ndjsonFlushEvery = 50
+
+// wantsNDJSON reports whether r asked for NDJSON via Accept (or ?format=ndjson,
+// for clients that can't set headers).
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mt, _, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && mt == ndjsonContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// exportFormat is how streamEmails encodes its output.
+type exportFormat int
+
+const (
+	formatNDJSON exportFormat = iota
+	formatJSONGzip
+)
+
+// streamEmails writes every published email matching f from ?offset= on, passing each through view first. NDJSON errors after the
+// first line can't change the status any more, so the stream ends with an
+// {"error": {...}} line instead; a gzip'd JSON array is left unterminated,
+// which no JSON parser will accept.
+func (s *Server) streamEmails(w http.ResponseWriter, r *http.Request, f EmailFilter, format exportFormat, view func(*Email) any) {
+	_, offset := parseLimitOffset(r, 0)
+	flusher, _ := w.(http.Flusher)
+	var out io.Writer = w
+	var gz *gzip.Writer
+	started := false
+	start := func() {
+		started = true
+		switch format {
+		case formatJSONGzip:
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", `attachment; filename="emails.json.gz"`)
+			gz = gzip.NewWriter(w)
+			out = gz
+			_, _ = io.WriteString(gz, "[")
+		default:
+			w.Header().Set("Content-Type", ndjsonContentType)
+		}
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	n := 0
+	err := s.store.EachEmail(r.Context(), r, f, offset, func(e *Email) error {
+		if !started {
+			start()
+		}
+		b, err := json.Marshal(view(e))
+		if err != nil {
+			return err
+		}
+		if format == formatJSONGzip && n > 0 {
+			b = append([]byte(","), b...)
+		} else if format == formatNDJSON {
+			b = append(b, '\n')
+		}
+		if _, err := out.Write(b); err != nil {
+			return errClientGone
+		}
+		n++
+		if n%ndjsonFlushEvery == 0 {
+			if gz != nil {
+				_ = gz.Flush()
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		httpError(w, r, err)
+		return
+	case err != nil && !errors.Is(err, errClientGone):
+		log.Printf("export stream [%s] aborted after %d emails: %v", middleware.GetReqID(r.Context()), n, err)
+		if format == formatNDJSON {
+			_ = json.NewEncoder(w).Encode(map[string]apiErr{"error": {Code: codeInternal, Message: "stream aborted", RequestID: middleware.GetReqID(r.Context())}})
+		}
+	case err == nil:
+		if !started {
+			start()
+		}
+		if gz != nil {
+			_, _ = io.WriteString(gz, "]\n")
+		}
+	}
+	if gz != nil {
+		_ = gz.Close()
+	}
+}
+
+var errClientGone = errors.New("client went away")
+
+// handleExportEmails mirrors the whole archive in one request: NDJSON by
+// default, or ?format=json.gz for a gzip'd JSON array. ?html=false drops the
+// HTML bodies, by far the largest field.
+func (s *Server) handleExportEmails(w http.ResponseWriter, r *http.Request) {
+	format := formatNDJSON
+	switch r.URL.Query().Get("format") {
+	case "", "ndjson":
+	case "json.gz":
+		format = formatJSONGzip
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "format must be ndjson or json.gz")
+		return
+	}
+	f, err := parseEmailFilter(r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	withHTML := r.URL.Query().Get("html") != "false"
+	// Shared caches may keep a copy briefly; exports are expensive to build.
+	w.Header().Set("Cache-Control", cacheControl(r, "max-age=300"))
+	s.streamEmails(w, r, f, format, func(e *Email) any {
+		if !withHTML {
+			e.HTML = nil
+		}
+		return e
+	})
+}
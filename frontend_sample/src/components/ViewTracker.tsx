@@ -13,7 +13,9 @@ export function ViewTracker({ emailId }: { emailId: string }) {
     
     async function trackView() {
       try {
-        await fetch(`${CMS_BASE_URL}/emails/${emailId}/view`, {
+        const params = new URLSearchParams();
+        if (document.referrer) params.set('ref', document.referrer);
+        await fetch(`${CMS_BASE_URL}/emails/${emailId}/view?${params}`, {
           credentials: 'include',
         });
         hasTracked.current = true;
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// ---------- Approximate Counts ----------
+
+// View and click counts are COUNT(DISTINCT ...) over the raw tracking
+// tables, which gets slow for the emails everyone reads. METRICS_COUNT_MODE
+// picks how those hot emails (a count at or over
+// METRICS_COUNT_HOT_THRESHOLD, default 10000) are counted:
+//
+//   - exact (default): every read counts.
+//   - cached: the last exact count is served and recounted in the
+//     background once it's older than METRICS_COUNT_REFRESH (default 30s).
+//   - hll: as cached, but recounts use a HyperLogLog estimate from the
+//     timescaledb_toolkit extension (within ~1% at these sizes), falling
+//     back to cached when the toolkit isn't installed.
+//
+// Emails below the threshold are always counted exactly, so small numbers
+// stay right.
+
+const (
+	countExact  = "exact"
+	countCached = "cached"
+	countHLL    = "hll"
+)
+
+// hotCounts holds the counts of hot emails. A nil *hotCounts counts
+// everything exactly.
+type hotCounts struct {
+	mode      string
+	threshold int64
+	refresh   time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*hotCount // "<kind> <email ID>"
+}
+
+type hotCount struct {
+	n          int64
+	at         time.Time
+	refreshing bool
+}
+
+// newHotCountsFromEnv reads METRICS_COUNT_*, checking for the toolkit when
+// mode is hll.
+func newHotCountsFromEnv(ctx context.Context, s *Store) *hotCounts {
+	mode := env("METRICS_COUNT_MODE", countExact)
+	switch mode {
+	case countExact:
+		return nil
+	case countCached, countHLL:
+	default:
+		log.Printf("unknown METRICS_COUNT_MODE %q; counting exactly", mode)
+		return nil
+	}
+	if s.metricsPool == nil {
+		return nil
+	}
+	if mode == countHLL {
+		var toolkit bool
+		if err := s.metricsPool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'timescaledb_toolkit')`).Scan(&toolkit); err != nil || !toolkit {
+			log.Printf("METRICS_COUNT_MODE=hll needs the timescaledb_toolkit extension; using cached exact counts")
+			mode = countCached
+		}
+	}
+	h := &hotCounts{
+		mode:      mode,
+		threshold: int64(envInt("METRICS_COUNT_HOT_THRESHOLD", 10000)),
+		refresh:   envDuration("METRICS_COUNT_REFRESH", 30*time.Second),
+		entries:   map[string]*hotCount{},
+	}
+	log.Printf("metrics counts: %s for emails with %d or more", h.mode, h.threshold)
+	return h
+}
+
+// count returns the count under key. Cold keys run exact; a hot key gets
+// its last count while a stale one is recounted in the background, with
+// approx in hll mode.
+func (h *hotCounts) count(ctx context.Context, key string, exact, approx func(context.Context) (int64, error)) (int64, error) {
+	if h == nil {
+		return exact(ctx)
+	}
+	h.mu.Lock()
+	if e := h.entries[key]; e != nil {
+		n := e.n
+		if !e.refreshing && time.Since(e.at) >= h.refresh {
+			e.refreshing = true
+			recount := exact
+			if h.mode == countHLL {
+				recount = approx
+			}
+			go h.recount(key, recount)
+		}
+		h.mu.Unlock()
+		return n, nil
+	}
+	h.mu.Unlock()
+
+	n, err := exact(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if n >= h.threshold {
+		h.mu.Lock()
+		h.entries[key] = &hotCount{n: n, at: time.Now()}
+		h.mu.Unlock()
+	}
+	return n, nil
+}
+
+// recount refreshes a hot entry. On failure the old count is kept for
+// another refresh interval rather than retried on every read.
+func (h *hotCounts) recount(key string, count func(context.Context) (int64, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	n, err := count(ctx)
+	if err != nil {
+		log.Printf("metrics counts: recount %s: %v", key, err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := h.entries[key]
+	if err == nil {
+		e.n = n
+	}
+	e.at, e.refreshing = time.Now(), false
+}
+
+// hotCount runs a count query for emailID through s.memo and s.counts.
+// exactSQL and approxSQL take emailID as $1 and return one bigint.
+func (s *Store) hotCount(ctx context.Context, kind, emailID, exactSQL, approxSQL string) (int64, error) {
+	query := func(sql string) func(context.Context) (int64, error) {
+		return func(ctx context.Context) (int64, error) {
+			var n int64
+			err := s.metricsPool.QueryRow(ctx, sql, emailID).Scan(&n)
+			return n, s.observe(depMetrics, err)
+		}
+	}
+	key := kind + " " + emailID
+	return s.memo.get(ctx, key, func(ctx context.Context) (int64, error) {
+		return s.counts.count(ctx, key, query(exactSQL), query(approxSQL))
+	})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- HTML Views ----------
+
+// archiveURL links an email's page on the archive site, which routes
+// /{mailing_list_slug}/{email_slug}.
+func (s *Server) archiveURL(e *Email) string {
+	return strings.TrimRight(s.archiveBase, "/") + "/" + e.MailingListRef.Slug + "/" + e.Slug
+}
+
+// sourceArchiveURL is archiveURL for an email not built by the Store.
+func (s *Server) sourceArchiveURL(src *SourceEmail) string {
+	e := Email{MailingListRef: src.MailingList, Slug: emailSlug(src.Slug, src.Subject, src.ID)}
+	e.MailingListRef.Slug = slugify(src.MailingList.Name)
+	return s.archiveURL(&e)
+}
+
+// embedScript keeps the card's view count live. It's a constant so the CSP
+// can allow it by hash rather than a per-response nonce, which would defeat
+// caching.
+const embedScript = `
+const el = document.getElementById("views");
+const es = new EventSource("stats/stream");
+es.onmessage = e => { el.textContent = JSON.parse(e.data).views.toLocaleString(); };
+`
+
+var embedScriptHash = func() string {
+	sum := sha256.Sum256([]byte(embedScript))
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}()
+
+var embedTemplate = template.Must(template.New("embed").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Email.Subject}}</title>
+<style>
+  body { margin: 0; font: 15px/1.45 system-ui, -apple-system, "Segoe UI", sans-serif; color: #1f2d3d; background: transparent; }
+  a.card { display: block; padding: 16px; border: 1px solid #e0e6ed; border-radius: 12px; background: #fff; color: inherit; text-decoration: none; }
+  a.card:hover { border-color: #ec3750; }
+  .badge { display: inline-block; padding: 2px 8px; border-radius: 999px; font-size: 12px; font-weight: 600; color: #fff; }
+  h1 { margin: 8px 0 4px; font-size: 18px; line-height: 1.25; }
+  p { margin: 0 0 8px; color: #3c4858; }
+  .meta { font-size: 13px; color: #8492a6; }
+</style>
+</head>
+<body>
+<a class="card" href="{{.URL}}" target="_blank" rel="noopener">
+  <span class="badge" style="background: {{.Color}}">{{.Email.MailingListRef.Name}}</span>
+  <h1>{{.Email.Subject}}</h1>
+  {{with .Email.Excerpt}}<p>{{.}}</p>{{end}}
+  <div class="meta">{{with .Email.SentAt}}{{.Format "Jan 2, 2006"}} · {{end}}<span id="views">{{.Email.Stats.Views}}</span> views</div>
+</a>
+<script>` + embedScript + `</script>
+</body>
+</html>
+`))
+
+var hexColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}$`)
+
+// handleEmailEmbed serves a small self-contained card for iframing on other
+// sites. Framing is allowed only for EMBED_FRAME_ANCESTORS.
+func (s *Server) handleEmailEmbed(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	w.Header().Del("X-Frame-Options")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; script-src "+embedScriptHash+
+		"; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors "+s.embedFrameAncestors+";")
+
+	s.cached(w, r, "text/html; charset=utf-8", func() ([]byte, error) {
+		e, err := s.store.GetEmail(r.Context(), r, emailID, false)
+		if err != nil {
+			return nil, err
+		}
+		color := e.MailingListRef.Color
+		if !hexColorRegex.MatchString(color) {
+			color = defaultListColor
+		}
+		var buf bytes.Buffer
+		err = embedTemplate.Execute(&buf, map[string]any{
+			"Email": e,
+			"URL":   s.archiveURL(e),
+			"Color": template.CSS(color),
+		})
+		return buf.Bytes(), err
+	})
+}
+
+// sanitizeEmailDoc removes active content from campaign HTML: scripts,
+// embedded frames and plugins, forms, meta refreshes, base overrides, inline
+// event handlers, and javascript: URLs. The CSP on /emails/{id}/html blocks
+// these anyway; stripping them keeps the markup safe wherever it's reused.
+func sanitizeEmailDoc(doc *goquery.Document) {
+	doc.Find("script, noscript, iframe, frame, frameset, object, embed, applet, form, base, meta[http-equiv]").Remove()
+	doc.Find("*").Each(func(_ int, sel *goquery.Selection) {
+		node := sel.Get(0)
+		attrs := node.Attr[:0]
+		for _, a := range node.Attr {
+			name := strings.ToLower(a.Key)
+			if strings.HasPrefix(name, "on") {
+				continue
+			}
+			if name == "href" || name == "src" || name == "action" || name == "formaction" || name == "xlink:href" {
+				v := strings.ToLower(strings.Join(strings.Fields(a.Val), ""))
+				if strings.HasPrefix(v, "javascript:") || strings.HasPrefix(v, "vbscript:") || strings.HasPrefix(v, "data:text/html") {
+					continue
+				}
+			}
+			attrs = append(attrs, a)
+		}
+		node.Attr = attrs
+	})
+}
+
+// renderEmailPage turns an email's (already link-rewritten) HTML into a
+// standalone, sanitized document, optionally in the dark theme or laid out
+// for print (see print.go), which takes precedence.
+func (s *Server) renderEmailPage(e *Email, dark, forPrint bool) ([]byte, error) {
+	body := ""
+	if e.HTML != nil {
+		body = *e.HTML
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sanitizeEmailDoc(doc)
+
+	// Links leave the frame: the archive iframes this page.
+	doc.Find("a[href]").SetAttr("target", "_blank").SetAttr("rel", "noopener")
+
+	head := doc.Find("head")
+	head.PrependHtml(`<meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">`)
+	if head.Find("title").Length() == 0 {
+		head.AppendHtml("<title>" + template.HTMLEscapeString(e.Subject) + "</title>")
+	}
+	head.AppendHtml(`<link rel="canonical" href="` + template.HTMLEscapeString(s.archiveURL(e)) + `">`)
+	if forPrint {
+		printEmailDoc(doc)
+	} else if dark {
+		head.AppendHtml(`<meta name="color-scheme" content="dark">`)
+		applyDarkTheme(doc, doc.Find("html"))
+	}
+
+	out, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	return []byte("<!doctype html>\n" + out), nil
+}
+
+// emailPageCSP lets campaign markup load its images, styles, and fonts over
+// HTTPS but never run script, submit forms, or navigate the embedding page.
+const emailPageCSP = "default-src 'none'; img-src https: data:; style-src 'unsafe-inline' https:; font-src https: data:; " +
+	"base-uri 'none'; form-action 'none'; sandbox allow-popups allow-popups-to-escape-sandbox; frame-ancestors "
+
+// handleEmailHTML serves a published email as a full HTML page, for the
+// archive to iframe or link instead of re-implementing email CSS handling.
+func (s *Server) handleEmailHTML(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	w.Header().Del("X-Frame-Options")
+	w.Header().Set("Content-Security-Policy", emailPageCSP+s.embedFrameAncestors+";")
+
+	forPrint := r.URL.Query().Get("format") == "print"
+	s.cached(w, r, "text/html; charset=utf-8", func() ([]byte, error) {
+		var e *Email
+		var err error
+		if forPrint {
+			e, err = s.store.GetPrintEmail(r.Context(), r, emailID)
+		} else {
+			e, err = s.store.GetEmail(r.Context(), r, emailID, false)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.renderEmailPage(e, r.URL.Query().Get("theme") == "dark", forPrint)
+	})
+}
+
+// darkFilter inverts lightness while keeping hues; applied twice (root and
+// media) it leaves images looking as designed.
+const darkFilter = "filter: invert(1) hue-rotate(180deg);"
+
+// applyDarkTheme adapts hard-coded light campaign HTML for dark pages by
+// inverting root and re-inverting images, video, and background images.
+// root is the element that receives the inversion: <html> for full pages, a
+// wrapper for fragments.
+func applyDarkTheme(doc *goquery.Document, root *goquery.Selection) {
+	addStyle := func(sel *goquery.Selection, css string) {
+		style, _ := sel.Attr("style")
+		if style != "" && !strings.HasSuffix(strings.TrimSpace(style), ";") {
+			style += ";"
+		}
+		sel.SetAttr("style", style+css)
+	}
+	addStyle(root, "background-color: #fff; "+darkFilter)
+	doc.Find("img, video, picture, svg, [background], [style*='background-image'], [style*='background: url'], [style*='background:url']").
+		Each(func(_ int, sel *goquery.Selection) {
+			// Nested media (e.g. img inside picture) must not flip back.
+			if sel.ParentsFiltered("picture, svg").Length() > 0 {
+				return
+			}
+			addStyle(sel, darkFilter)
+		})
+}
+
+// darkThemeFragment applies applyDarkTheme to an HTML fragment such as the
+// html field of /emails/{id}, wrapping it so the host page is unaffected.
+func darkThemeFragment(html string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<div data-theme="dark">` + html + `</div>`))
+	if err != nil {
+		return html, err
+	}
+	wrapper := doc.Find("body > div[data-theme=dark]").First()
+	applyDarkTheme(doc, wrapper)
+	return goquery.OuterHtml(wrapper)
+}
+
+// darkenHTML rewrites e.HTML for ?theme=dark.
+func (e *Email) darkenHTML() error {
+	if e.HTML == nil || *e.HTML == "" {
+		return nil
+	}
+	html, err := darkThemeFragment(*e.HTML)
+	if err != nil {
+		return err
+	}
+	e.HTML = &html
+	return nil
+}
+
+// EmailImage is one image referenced by an email's HTML.
+type EmailImage struct {
+	Src    string `json:"src"`
+	Alt    string `json:"alt,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// extractImages lists the distinct http(s) images in html, in document
+// order, skipping tracking pixels and spacers (declared 2px or smaller).
+func extractImages(html string) []EmailImage {
+	images := []EmailImage{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return images
+	}
+	seen := map[string]bool{}
+	doc.Find("img[src]").Each(func(_ int, sel *goquery.Selection) {
+		src := strings.TrimSpace(sel.AttrOr("src", ""))
+		if !strings.HasPrefix(src, "https://") && !strings.HasPrefix(src, "http://") || seen[src] {
+			return
+		}
+		img := EmailImage{
+			Src:    src,
+			Alt:    strings.TrimSpace(sel.AttrOr("alt", "")),
+			Width:  imageDimension(sel.AttrOr("width", "")),
+			Height: imageDimension(sel.AttrOr("height", "")),
+		}
+		if (img.Width > 0 && img.Width <= 2) || (img.Height > 0 && img.Height <= 2) {
+			return
+		}
+		seen[src] = true
+		images = append(images, img)
+	})
+	return images
+}
+
+// imageDimension parses a width/height attribute ("600" or "600px");
+// percentages and junk yield 0 (unknown).
+func imageDimension(v string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// pickCoverImage guesses the image a listing card should show: the largest
+// image declared at least 200px wide, else the first image with no declared
+// size (logos and icons are usually sized small), else nil.
+func pickCoverImage(images []EmailImage) *EmailImage {
+	var best *EmailImage
+	for i := range images {
+		img := &images[i]
+		if img.Width >= 200 && (best == nil || img.Width*max(img.Height, 1) > best.Width*max(best.Height, 1)) {
+			best = img
+		}
+	}
+	if best != nil {
+		return best
+	}
+	for i := range images {
+		if images[i].Width == 0 && images[i].Height == 0 {
+			return &images[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Responsive Images ----------
+
+// Campaign images are sized for a 600px inbox column but often uploaded at
+// 2-4x that, so archive pages shipped multi-megabyte heroes to phones, and
+// images without declared dimensions shifted the layout as they loaded.
+// Published HTML is rewritten so that each image:
+//
+//   - loads lazily, except the first (usually the hero, above the fold);
+//   - declares width and height, from its attributes or else its actual
+//     size, so the browser reserves space;
+//   - has a srcset of resized copies from IMAGE_PROXY_URL, when that's set
+//     and the image's actual width is known.
+//
+// Actual sizes come from fetching the start of each image once, in a
+// queued job, and are kept in image_sizes (and in memory). Until an
+// image's size is known its HTML is rewritten without it; rebuilt HTML
+// picks it up.
+//
+// IMAGE_PROXY_URL is a template with {url} (the escaped image URL) and
+// {width}, such as https://wsrv.nl/?url={url}&w={width} or an imgproxy
+// or CDN resizing endpoint.
+
+// imageSrcsetWidths are the resized widths offered, up to the original's.
+var imageSrcsetWidths = []int{320, 640, 960, 1280, 1920}
+
+const (
+	imageProbeBytes   = 64 << 10 // headers of every format we read fit well within this
+	imageProbeTimeout = 10 * time.Second
+)
+
+// imageProbeClient only reaches public addresses; see outbound.go.
+var imageProbeClient = publicHTTPClient(imageProbeTimeout)
+
+var errUnknownImageFormat = errors.New("unknown image format")
+
+// imageSize is an image's actual size; zero when it couldn't be read.
+type imageSize struct {
+	Width  int
+	Height int
+}
+
+// StartImageSizes reads IMAGE_PROXY_URL and handles image size fetches.
+func (s *Store) StartImageSizes() {
+	s.imageProxy = os.Getenv("IMAGE_PROXY_URL")
+	s.jobs.Handle("image.size", 3, func(ctx context.Context, payload json.RawMessage) error {
+		var p struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return err
+		}
+		return s.fetchImageSize(ctx, p.URL)
+	})
+}
+
+// fetchImageSize reads u's size and records it. Images that load but
+// can't be read are recorded as zero, so they aren't fetched again.
+func (s *Store) fetchImageSize(ctx context.Context, u string) error {
+	size, err := probeImageSize(ctx, u)
+	if err != nil && !errors.Is(err, errUnknownImageFormat) {
+		return err
+	}
+	s.imageSizes.Store(u, size)
+	if s.metricsPool == nil {
+		return nil
+	}
+	_, err = s.metricsPool.Exec(ctx, `
+		INSERT INTO image_sizes (url, width, height, fetched_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (url) DO UPDATE SET width = EXCLUDED.width, height = EXCLUDED.height, fetched_at = EXCLUDED.fetched_at
+	`, u, size.Width, size.Height)
+	return s.observe(depMetrics, err)
+}
+
+// probeImageSize fetches the start of u and reads its dimensions.
+func probeImageSize(ctx context.Context, u string) (imageSize, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return imageSize{}, err
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(imageProbeBytes-1))
+	req.Header.Set("User-Agent", "news-images/1")
+	resp, err := imageProbeClient.Do(req)
+	if err != nil {
+		return imageSize{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return imageSize{}, fmt.Errorf("image %s: status %d", u, resp.StatusCode)
+	}
+	head, err := io.ReadAll(io.LimitReader(resp.Body, imageProbeBytes))
+	if err != nil {
+		return imageSize{}, err
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		return imageSize{cfg.Width, cfg.Height}, nil
+	}
+	if size, ok := webpSize(head); ok {
+		return size, nil
+	}
+	return imageSize{}, errUnknownImageFormat
+}
+
+// webpSize reads a WebP header, which the standard library can't.
+func webpSize(b []byte) (imageSize, bool) {
+	if len(b) < 30 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return imageSize{}, false
+	}
+	le24 := func(p []byte) int { return int(p[0]) | int(p[1])<<8 | int(p[2])<<16 }
+	switch string(b[12:16]) {
+	case "VP8 ": // lossy: 14-bit sizes after the frame tag and start code
+		w := int(binary.LittleEndian.Uint16(b[26:28]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(b[28:30]) & 0x3fff)
+		return imageSize{w, h}, true
+	case "VP8L": // lossless: 14-bit sizes minus one after the signature
+		bits := binary.LittleEndian.Uint32(b[21:25])
+		return imageSize{int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1}, true
+	case "VP8X": // extended: 24-bit canvas sizes minus one
+		return imageSize{le24(b[24:27]) + 1, le24(b[27:30]) + 1}, true
+	}
+	return imageSize{}, false
+}
+
+// imageSizesFor returns the known sizes of srcs, from memory or the
+// metrics DB, and queues fetches for the rest.
+func (s *Store) imageSizesFor(ctx context.Context, srcs []string) map[string]imageSize {
+	sizes := map[string]imageSize{}
+	var missing []string
+	for _, src := range srcs {
+		if v, ok := s.imageSizes.Load(src); ok {
+			sizes[src] = v.(imageSize)
+		} else {
+			missing = append(missing, src)
+		}
+	}
+	if len(missing) > 0 && s.metricsPool != nil {
+		rows, err := s.metricsPool.Query(ctx, `SELECT url, width, height FROM image_sizes WHERE url = ANY($1)`, missing)
+		if s.observe(depMetrics, err) == nil {
+			for rows.Next() {
+				var u string
+				var size imageSize
+				if rows.Scan(&u, &size.Width, &size.Height) == nil {
+					s.imageSizes.Store(u, size)
+					sizes[u] = size
+				}
+			}
+			rows.Close()
+		}
+	}
+	if s.jobs == nil {
+		return sizes
+	}
+	for _, src := range missing {
+		if _, ok := sizes[src]; ok {
+			continue
+		}
+		// Once per instance; a fetch that keeps failing isn't retried
+		// until restart.
+		if _, queued := s.imageSizePending.LoadOrStore(src, true); !queued {
+			if err := s.jobs.Enqueue(ctx, "image.size", map[string]string{"url": src}); err != nil {
+				s.imageSizePending.Delete(src)
+			}
+		}
+	}
+	return sizes
+}
+
+// imageProxyURL is src resized to width through IMAGE_PROXY_URL.
+func (s *Store) imageProxyURL(src string, width int) string {
+	return strings.NewReplacer("{url}", url.QueryEscape(src), "{width}", strconv.Itoa(width)).Replace(s.imageProxy)
+}
+
+// responsiveImages rewrites the images in html as described above.
+func (s *Store) responsiveImages(ctx context.Context, html string) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return html, err
+	}
+	imgs := doc.Find("img[src]")
+	var srcs []string
+	imgs.Each(func(_ int, sel *goquery.Selection) {
+		if src := strings.TrimSpace(sel.AttrOr("src", "")); strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://") {
+			srcs = append(srcs, src)
+		}
+	})
+	if len(srcs) == 0 {
+		return html, nil
+	}
+	sizes := s.imageSizesFor(ctx, srcs)
+
+	first := true
+	imgs.Each(func(_ int, sel *goquery.Selection) {
+		src := strings.TrimSpace(sel.AttrOr("src", ""))
+		width, height := imageDimension(sel.AttrOr("width", "")), imageDimension(sel.AttrOr("height", ""))
+		if (width > 0 && width <= 2) || (height > 0 && height <= 2) {
+			return // tracking pixels and spacers
+		}
+		if !first {
+			if _, ok := sel.Attr("loading"); !ok {
+				sel.SetAttr("loading", "lazy")
+			}
+		}
+		first = false
+		if _, ok := sel.Attr("decoding"); !ok {
+			sel.SetAttr("decoding", "async")
+		}
+
+		actual := sizes[src]
+		if actual.Width == 0 || actual.Height == 0 {
+			return
+		}
+		// Fill in what's missing, keeping the declared aspect if one side
+		// is declared.
+		switch {
+		case width == 0 && height == 0:
+			width, height = actual.Width, actual.Height
+			sel.SetAttr("width", strconv.Itoa(width))
+			sel.SetAttr("height", strconv.Itoa(height))
+		case height == 0:
+			sel.SetAttr("height", strconv.Itoa(width*actual.Height/actual.Width))
+		case width == 0:
+			width = height * actual.Width / actual.Height
+			sel.SetAttr("width", strconv.Itoa(width))
+		}
+
+		if s.imageProxy == "" || sel.AttrOr("srcset", "") != "" || strings.HasSuffix(strings.ToLower(strings.SplitN(src, "?", 2)[0]), ".svg") {
+			return
+		}
+		var srcset []string
+		for _, w := range imageSrcsetWidths {
+			if w < actual.Width {
+				srcset = append(srcset, s.imageProxyURL(src, w)+" "+strconv.Itoa(w)+"w")
+			}
+		}
+		if len(srcset) == 0 {
+			return // already small
+		}
+		srcset = append(srcset, src+" "+strconv.Itoa(actual.Width)+"w")
+		sel.SetAttr("srcset", strings.Join(srcset, ", "))
+		sel.SetAttr("sizes", fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", width, width))
+	})
+	return doc.Html()
+}
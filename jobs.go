@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Background Jobs ----------
+
+// Jobs runs the server's background work, so features don't each start
+// their own goroutines. There are two kinds:
+//
+//   - Periodic tasks (Every) run on each instance, first right away and then
+//     every interval with up to 10% jitter, so replicas started together
+//     don't hit the databases in step.
+//   - Queued jobs (Enqueue, or EnqueueTx alongside other writes; run by a
+//     Handle'd kind) are rows in the jobs table in the metrics DB. Any
+//     instance may claim one; a failed run is retried with exponential
+//     backoff until it has had maxAttempts, then kept as failed for a week.
+//     Delivery is at least once, so handlers must be idempotent. Without a
+//     metrics DB, jobs are retried in memory and lost on restart.
+//
+// Each task and kind counts runs, failures and durations for
+// /admin/jobs. A failed periodic run, and a queued job out of attempts,
+// also raise an alert, which the Alerter deduplicates per task or kind.
+// Stop ends everything and waits for runs in progress.
+
+const (
+	jobPollInterval = time.Second
+	jobClaimBatch   = 10
+	jobLease        = 5 * time.Minute // a claimed job is retried after this if its instance died
+	jobTimeout      = time.Minute     // per queued run
+	jobRetryBase    = 30 * time.Second
+	jobRetryMax     = time.Hour
+	jobFailedKeep   = 7 * 24 * time.Hour
+)
+
+// JobStats is what /admin/jobs reports for a task or queued kind.
+type JobStats struct {
+	Name           string     `json:"name"`
+	Kind           string     `json:"kind"`               // periodic or queued
+	Interval       string     `json:"interval,omitempty"` // periodic only
+	Runs           int64      `json:"runs"`
+	Failures       int64      `json:"failures"`
+	Running        int        `json:"running"` // runs in progress
+	LastRun        *time.Time `json:"last_run,omitempty"`
+	LastDurationMS int64      `json:"last_duration_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastErrorAt    *time.Time `json:"last_error_at,omitempty"`
+}
+
+type jobHandler struct {
+	fn          func(ctx context.Context, payload json.RawMessage) error
+	maxAttempts int
+}
+
+type Jobs struct {
+	store  *Store
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	wake   chan struct{} // nudges the queue worker after an Enqueue
+
+	mu       sync.Mutex
+	stats    map[string]*JobStats
+	handlers map[string]jobHandler
+	polling  bool
+}
+
+// NewJobs returns a runner whose work ends with ctx or Stop.
+func NewJobs(ctx context.Context, store *Store) *Jobs {
+	ctx, cancel := context.WithCancel(ctx)
+	j := &Jobs{
+		store:    store,
+		ctx:      ctx,
+		cancel:   cancel,
+		wake:     make(chan struct{}, 1),
+		stats:    map[string]*JobStats{},
+		handlers: map[string]jobHandler{},
+	}
+	if store.metricsPool != nil {
+		j.Every("jobs.cleanup", 24*time.Hour, j.deleteFailed)
+	}
+	return j
+}
+
+// Stop cancels all work and waits up to timeout for runs in progress.
+func (j *Jobs) Stop(timeout time.Duration) {
+	j.cancel()
+	done := make(chan struct{})
+	go func() {
+		j.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("jobs: still running after %s, not waiting", timeout)
+	}
+}
+
+// Every runs fn now and then every interval until Stop. A failure is
+// logged and counted; the task runs again on schedule.
+func (j *Jobs) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	st := j.stat(name, "periodic")
+	j.mu.Lock()
+	st.Interval = interval.String()
+	j.mu.Unlock()
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		for {
+			if err := j.run(st, func() error { return fn(j.ctx) }); err != nil && j.ctx.Err() == nil {
+				log.Printf("%s: %v", name, err)
+				j.alert(name+" failed", err.Error())
+			}
+			timer := time.NewTimer(jitter(interval))
+			select {
+			case <-timer.C:
+			case <-j.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// alert sends a failure to the store's alert sinks, if any.
+func (j *Jobs) alert(title, message string) {
+	j.store.alerts.Notify(Alert{Severity: "warning", Source: "jobs", Title: title, Message: message})
+}
+
+// jitter stretches d by up to 10%.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d + rand.N(d/10+1)
+}
+
+// stat returns the stats entry for name, creating it.
+func (j *Jobs) stat(name, kind string) *JobStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := j.stats[name]
+	if st == nil {
+		st = &JobStats{Name: name, Kind: kind}
+		j.stats[name] = st
+	}
+	return st
+}
+
+// run calls fn, recording it in st.
+func (j *Jobs) run(st *JobStats, fn func() error) error {
+	j.mu.Lock()
+	st.Running++
+	j.mu.Unlock()
+	start := time.Now()
+	err := fn()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st.Running--
+	st.Runs++
+	st.LastRun = &start
+	st.LastDurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		at := time.Now()
+		st.Failures++
+		st.LastError, st.LastErrorAt = err.Error(), &at
+	}
+	return err
+}
+
+// Stats returns a snapshot of every task's and kind's stats by name.
+func (j *Jobs) Stats() []JobStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]JobStats, 0, len(j.stats))
+	for _, st := range j.stats {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Name < out[b].Name })
+	return out
+}
+
+// Handle registers fn to run queued jobs of kind, up to maxAttempts times
+// each.
+func (j *Jobs) Handle(kind string, maxAttempts int, fn func(ctx context.Context, payload json.RawMessage) error) {
+	j.stat(kind, "queued")
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.handlers[kind] = jobHandler{fn: fn, maxAttempts: max(1, maxAttempts)}
+	if j.store.metricsPool != nil && !j.polling {
+		j.polling = true
+		j.wg.Add(1)
+		go j.poll()
+	}
+}
+
+var errNoJobHandler = errors.New("no handler for job kind")
+
+// Enqueue queues a job of kind to run as soon as possible.
+func (j *Jobs) Enqueue(ctx context.Context, kind string, payload any) error {
+	h, body, err := j.prepare(kind, payload)
+	if err != nil {
+		return err
+	}
+	if j.store.metricsPool == nil {
+		j.wg.Add(1)
+		go j.runInMemory(kind, h, body)
+		return nil
+	}
+	_, err = j.store.metricsPool.Exec(ctx, `INSERT INTO jobs (kind, payload) VALUES ($1, $2)`, kind, string(body))
+	if err := j.store.observe(depMetrics, err); err != nil {
+		return err
+	}
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// EnqueueTx queues a job of kind as part of tx, a metrics DB transaction,
+// so the job exists exactly when the rest of tx's writes do. It runs from
+// the next poll after tx commits.
+func (j *Jobs) EnqueueTx(ctx context.Context, tx pgx.Tx, kind string, payload any) error {
+	_, body, err := j.prepare(kind, payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO jobs (kind, payload) VALUES ($1, $2)`, kind, string(body))
+	return err
+}
+
+// prepare looks up kind's handler and encodes payload.
+func (j *Jobs) prepare(kind string, payload any) (jobHandler, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return jobHandler{}, nil, err
+	}
+	j.mu.Lock()
+	h, ok := j.handlers[kind]
+	j.mu.Unlock()
+	if !ok {
+		return jobHandler{}, nil, fmt.Errorf("%w %q", errNoJobHandler, kind)
+	}
+	return h, body, nil
+}
+
+// retryDelay is the backoff before attempt n+1: 30s doubling to an hour,
+// with jitter.
+func retryDelay(attempts int) time.Duration {
+	d := jobRetryBase << min(attempts-1, 10)
+	return jitter(min(d, jobRetryMax))
+}
+
+func (j *Jobs) runInMemory(kind string, h jobHandler, payload json.RawMessage) {
+	defer j.wg.Done()
+	st := j.stat(kind, "queued")
+	for attempt := 1; ; attempt++ {
+		err := j.run(st, func() error {
+			ctx, cancel := context.WithTimeout(j.ctx, jobTimeout)
+			defer cancel()
+			return h.fn(ctx, payload)
+		})
+		if err == nil {
+			return
+		}
+		if j.ctx.Err() != nil {
+			return
+		}
+		if attempt >= h.maxAttempts {
+			log.Printf("jobs: %s failed after %d attempts, dropped: %v", kind, attempt, err)
+			j.alert(fmt.Sprintf("%s job failed after %d attempts", kind, attempt), err.Error())
+			return
+		}
+		select {
+		case <-time.After(retryDelay(attempt)):
+		case <-j.ctx.Done():
+			return
+		}
+	}
+}
+
+// poll claims and runs due queued jobs until Stop.
+func (j *Jobs) poll() {
+	defer j.wg.Done()
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		// A full batch means more may be due.
+		n := jobClaimBatch
+		for n == jobClaimBatch && j.ctx.Err() == nil {
+			n = j.claimAndRun()
+		}
+		select {
+		case <-ticker.C:
+		case <-j.wake:
+		case <-j.ctx.Done():
+			return
+		}
+	}
+}
+
+type claimedJob struct {
+	id       int64
+	kind     string
+	payload  json.RawMessage
+	attempts int
+}
+
+// claimAndRun leases up to jobClaimBatch due jobs of handled kinds, runs
+// them, and returns how many it claimed.
+func (j *Jobs) claimAndRun() int {
+	j.mu.Lock()
+	kinds := make([]string, 0, len(j.handlers))
+	for kind := range j.handlers {
+		kinds = append(kinds, kind)
+	}
+	j.mu.Unlock()
+
+	rows, err := j.store.metricsPool.Query(j.ctx, `
+		UPDATE jobs SET attempts = attempts + 1, locked_until = NOW() + $3 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM jobs
+			WHERE kind = ANY($1) AND failed_at IS NULL AND run_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY run_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, attempts
+	`, kinds, jobClaimBatch, jobLease.Seconds())
+	if err := j.store.observe(depMetrics, err); err != nil {
+		if j.ctx.Err() == nil {
+			log.Printf("jobs: claim: %v", err)
+		}
+		return 0
+	}
+	var claimed []claimedJob
+	for rows.Next() {
+		var c claimedJob
+		var payload []byte
+		if err := rows.Scan(&c.id, &c.kind, &payload, &c.attempts); err != nil {
+			rows.Close()
+			log.Printf("jobs: claim: %v", err)
+			return 0
+		}
+		c.payload = payload
+		claimed = append(claimed, c)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	for _, c := range claimed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.runClaimed(c)
+		}()
+	}
+	wg.Wait()
+	return len(claimed)
+}
+
+// runClaimed runs one leased job and records the outcome: deleted on
+// success, rescheduled or failed otherwise. A run cut short by Stop is
+// released without using up an attempt.
+func (j *Jobs) runClaimed(c claimedJob) {
+	j.mu.Lock()
+	h := j.handlers[c.kind]
+	j.mu.Unlock()
+	err := j.run(j.stat(c.kind, "queued"), func() error {
+		ctx, cancel := context.WithTimeout(j.ctx, jobTimeout)
+		defer cancel()
+		return h.fn(ctx, c.payload)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	switch {
+	case err == nil:
+		_, err = j.store.metricsPool.Exec(ctx, `DELETE FROM jobs WHERE id = $1`, c.id)
+	case j.ctx.Err() != nil:
+		_, err = j.store.metricsPool.Exec(ctx, `
+			UPDATE jobs SET attempts = attempts - 1, locked_until = NULL WHERE id = $1
+		`, c.id)
+	case c.attempts >= h.maxAttempts:
+		log.Printf("jobs: %s %d failed after %d attempts: %v", c.kind, c.id, c.attempts, err)
+		j.alert(fmt.Sprintf("%s job failed after %d attempts", c.kind, c.attempts), fmt.Sprintf("job %d: %v", c.id, err))
+		_, err = j.store.metricsPool.Exec(ctx, `
+			UPDATE jobs SET failed_at = NOW(), last_error = $2, locked_until = NULL WHERE id = $1
+		`, c.id, err.Error())
+	default:
+		_, err = j.store.metricsPool.Exec(ctx, `
+			UPDATE jobs SET run_at = NOW() + $3 * INTERVAL '1 second', last_error = $2, locked_until = NULL
+			WHERE id = $1
+		`, c.id, err.Error(), retryDelay(c.attempts).Seconds())
+	}
+	if err := j.store.observe(depMetrics, err); err != nil {
+		log.Printf("jobs: %s %d: record outcome: %v", c.kind, c.id, err)
+	}
+}
+
+// deleteFailed drops failed jobs older than jobFailedKeep.
+func (j *Jobs) deleteFailed(ctx context.Context) error {
+	_, err := j.store.metricsPool.Exec(ctx, `
+		DELETE FROM jobs WHERE failed_at < NOW() - $1 * INTERVAL '1 second'
+	`, jobFailedKeep.Seconds())
+	return j.store.observe(depMetrics, err)
+}
+
+// JobQueueCounts is the jobs table by kind.
+type JobQueueCounts struct {
+	Kind    string `json:"kind"`
+	Pending int64  `json:"pending"` // due or scheduled for a retry
+	Failed  int64  `json:"failed"`
+}
+
+func (j *Jobs) QueueCounts(ctx context.Context) ([]JobQueueCounts, error) {
+	out := []JobQueueCounts{}
+	if j.store.metricsPool == nil {
+		return out, nil
+	}
+	rows, err := j.store.metricsPool.Query(ctx, `
+		SELECT kind, COUNT(*) FILTER (WHERE failed_at IS NULL), COUNT(*) FILTER (WHERE failed_at IS NOT NULL)
+		FROM jobs
+		GROUP BY kind
+		ORDER BY kind
+	`)
+	if err := j.store.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c JobQueueCounts
+		if err := rows.Scan(&c.Kind, &c.Pending, &c.Failed); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// handleAdminJobs reports every task's and kind's stats on this instance,
+// and the shared queue.
+func (s *Server) handleAdminJobs(w http.ResponseWriter, r *http.Request) {
+	queue, err := s.store.jobs.QueueCounts(r.Context())
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": s.store.jobs.Stats(), "queue": queue})
+}
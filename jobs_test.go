@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// recordingSink collects alerts for tests.
+type recordingSink chan Alert
+
+func (s recordingSink) Name() string { return "recording" }
+
+func (s recordingSink) Send(ctx context.Context, a Alert) error {
+	s <- a
+	return nil
+}
+
+func TestJobFailuresAlert(t *testing.T) {
+	sink := make(recordingSink, 10)
+	store := &Store{alerts: NewAlerter([]AlertSink{sink})}
+	jobs := NewJobs(context.Background(), store)
+	defer jobs.Stop(time.Second)
+
+	next := func() Alert {
+		t.Helper()
+		select {
+		case a := <-sink:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("no alert")
+			return Alert{}
+		}
+	}
+
+	jobs.Every("test.periodic", time.Hour, func(ctx context.Context) error { return errors.New("boom") })
+	if a := next(); a.Source != "jobs" || a.Title != "test.periodic failed" || a.Message != "boom" {
+		t.Errorf("periodic failure alert = %+v", a)
+	}
+
+	jobs.Handle("test.queued", 1, func(ctx context.Context, payload json.RawMessage) error { return errors.New("bad payload") })
+	if err := jobs.Enqueue(context.Background(), "test.queued", nil); err != nil {
+		t.Fatal(err)
+	}
+	if a := next(); a.Title != "test.queued job failed after 1 attempts" || a.Message != "bad payload" {
+		t.Errorf("exhausted job alert = %+v", a)
+	}
+}
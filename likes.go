@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Likes ----------
+
+// Readers can like an email once per _track session. Likes live in
+// email_likes in the metrics DB, keyed by a stable (non-rotating) hash of
+// the session so a later unlike finds the row. Counts are part of an
+// email's stats, every toggle is pushed to stats streams like a new view,
+// and /emails/most_liked ranks community favorites.
+
+// errNoLikeStore is returned by writes when there's no metrics DB to hold
+// likes; reads just report none.
+var errNoLikeStore = &statusError{status: http.StatusNotImplemented, code: codeNotImplemented, message: "likes need METRICS_DATABASE_URL"}
+
+// SetLike likes (or unlikes) emailID for sessionID, reporting whether that
+// changed anything.
+func (s *Store) SetLike(ctx context.Context, emailID, sessionID string, liked bool) (bool, error) {
+	if s.metricsPool == nil {
+		return false, errNoLikeStore
+	}
+	query := `
+		INSERT INTO email_likes (email_id, session_id) VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	if !liked {
+		query = `DELETE FROM email_likes WHERE email_id = $1 AND session_id = $2`
+	}
+	tag, err := s.metricsPool.Exec(ctx, query, emailID, s.stableSession(sessionID))
+	if err := s.observe(depMetrics, err); err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) GetLikeCount(ctx context.Context, emailID string) (int64, error) {
+	if s.metricsPool == nil {
+		return 0, nil
+	}
+	return s.memo.get(ctx, "likes "+emailID, func(ctx context.Context) (int64, error) {
+		var count int64
+		err := s.metricsPool.QueryRow(ctx, `
+			SELECT COUNT(*) FROM email_likes WHERE email_id = $1
+		`, emailID).Scan(&count)
+		return count, s.observe(depMetrics, err)
+	})
+}
+
+// HasLiked reports whether sessionID currently likes emailID.
+func (s *Store) HasLiked(ctx context.Context, emailID, sessionID string) (bool, error) {
+	if s.metricsPool == nil {
+		return false, nil
+	}
+	var liked bool
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM email_likes WHERE email_id = $1 AND session_id = $2)
+	`, emailID, s.stableSession(sessionID)).Scan(&liked)
+	if err := s.observe(depMetrics, err); err != nil {
+		return false, err
+	}
+	return liked, nil
+}
+
+// MostLiked returns the IDs of the emails with the most likes given since
+// then, most liked first.
+func (s *Store) MostLiked(ctx context.Context, since time.Time, limit int) ([]string, error) {
+	if s.metricsPool == nil {
+		return []string{}, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT email_id
+		FROM email_likes
+		WHERE liked_at >= $1
+		GROUP BY email_id
+		ORDER BY COUNT(*) DESC, MAX(liked_at) DESC
+		LIMIT $2
+	`, since, limit)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// LikeState is what the like endpoints return: this session's like and the
+// email's total.
+type LikeState struct {
+	Liked bool  `json:"liked"`
+	Likes int64 `json:"likes"`
+}
+
+// handleEmailLikeState reports the caller's like without setting a session;
+// without a _track cookie it can't have liked anything.
+func (s *Server) handleEmailLikeState(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	var state LikeState
+	var err error
+	if cookie, cerr := r.Cookie("_track"); cerr == nil && cookie.Value != "" {
+		if state.Liked, err = s.store.HasLiked(r.Context(), emailID, cookie.Value); err != nil {
+			httpError(w, r, err)
+			return
+		}
+	}
+	if state.Likes, err = s.store.GetLikeCount(r.Context(), emailID); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (s *Server) handleEmailLike(w http.ResponseWriter, r *http.Request) {
+	s.setLike(w, r, true)
+}
+
+func (s *Server) handleEmailUnlike(w http.ResponseWriter, r *http.Request) {
+	s.setLike(w, r, false)
+}
+
+// setLike is idempotent: liking twice leaves one like. Only an actual
+// change notifies stats streams.
+func (s *Server) setLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	emailID := chi.URLParam(r, "id")
+	if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	cookie := getOrCreateSession(w, r)
+	changed, err := s.store.SetLike(r.Context(), emailID, cookie.Value, liked)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if changed {
+		s.notifier.Notify(emailID)
+	}
+	state := LikeState{Liked: liked}
+	if state.Likes, err = s.store.GetLikeCount(r.Context(), emailID); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, state)
+}
+
+// handleMostLiked lists published emails by likes given in the last ?days=
+// (default 30), most liked first.
+func (s *Server) handleMostLiked(w http.ResponseWriter, r *http.Request) {
+	limit, _ := parseLimitOffset(r, 20)
+	since := parseSinceDays(r, 30)
+	s.jsonCached(w, r, func() (any, error) {
+		ids, err := s.store.MostLiked(r.Context(), since, limit)
+		if err != nil {
+			return nil, err
+		}
+		// Likes outlive publication; whatever was pulled is skipped.
+		emails := make([]Email, 0, len(ids))
+		for _, id := range ids {
+			e, err := s.store.GetEmail(r.Context(), r, id, false)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			emails = append(emails, *e)
+		}
+		return map[string]any{"since": since, "items": emails}, nil
+	})
+}
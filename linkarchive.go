@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ---------- Archived Link Fallback ----------
+
+// With LINK_ARCHIVE_FALLBACK=wayback, the link checker (see linkcheck.go)
+// looks up each link it finds broken in the Wayback Machine, asking for
+// the snapshot closest to when the first email linking it was sent, and
+// clicks on that link are redirected to the snapshot instead. Clicks are
+// still tracked against the original URL, and a link that comes back is
+// followed directly again. Links with no snapshot keep redirecting to the
+// original. The broken links with snapshots are kept in memory and
+// refreshed every few minutes, so every replica redirects them.
+
+const waybackAvailableURL = "https://archive.org/wayback/available"
+
+var waybackClient = &http.Client{Timeout: 15 * time.Second}
+
+// archiveBrokenLink records u's closest Wayback snapshot, or that it has
+// none.
+func (s *Store) archiveBrokenLink(ctx context.Context, u string) error {
+	var linkedAt *time.Time
+	err := s.metricsPool.QueryRow(ctx, `SELECT linked_at FROM link_checks WHERE url = $1`, u).Scan(&linkedAt)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	snapshot, err := waybackSnapshot(ctx, u, linkedAt)
+	if err != nil {
+		return err
+	}
+	_, err = s.metricsPool.Exec(ctx, `UPDATE link_checks SET archive_url = $2 WHERE url = $1`, u, snapshot)
+	return s.observe(depMetrics, err)
+}
+
+// waybackSnapshot returns the URL of the available snapshot of u closest
+// to at (or the latest), or "" if there's none.
+func waybackSnapshot(ctx context.Context, u string, at *time.Time) (string, error) {
+	q := url.Values{"url": {u}}
+	if at != nil {
+		q.Set("timestamp", at.UTC().Format("20060102150405"))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, waybackAvailableURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "news-linkcheck/1")
+	resp, err := waybackClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("wayback: status %d", resp.StatusCode)
+	}
+	var body struct {
+		ArchivedSnapshots struct {
+			Closest *struct {
+				Available bool   `json:"available"`
+				URL       string `json:"url"`
+				Status    string `json:"status"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	c := body.ArchivedSnapshots.Closest
+	if c == nil || !c.Available || !strings.HasPrefix(c.Status, "2") {
+		return "", nil
+	}
+	return strings.Replace(c.URL, "http://", "https://", 1), nil
+}
+
+// LoadArchivedLinks replaces the in-memory map of broken links to their
+// snapshots.
+func (s *Store) LoadArchivedLinks(ctx context.Context) error {
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT url, archive_url FROM link_checks WHERE failures >= $1 AND archive_url <> ''
+	`, linkCheckBrokenAfter)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	defer rows.Close()
+	links := map[string]string{}
+	for rows.Next() {
+		var u, archived string
+		if err := rows.Scan(&u, &archived); err != nil {
+			return err
+		}
+		links[u] = archived
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.archivedLinks.Store(&links)
+	return nil
+}
+
+// clickDestination is where a click on u goes: its snapshot if it's
+// broken and has one, else u.
+func (s *Store) clickDestination(u string) string {
+	if links := s.archivedLinks.Load(); links != nil {
+		if archived, ok := (*links)[u]; ok {
+			return archived
+		}
+	}
+	return u
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Link Health ----------
+
+// Old newsletters keep getting read long after the pages they link to are
+// gone. Every LINK_CHECK_INTERVAL (default 24h, "0" disables it) the link
+// checker collects the outbound links of every published email into
+// link_checks, then requests each one not checked within the interval:
+// HEAD, or GET where HEAD isn't allowed, following redirects. A link is
+// broken once it has failed linkCheckBrokenAfter checks in a row, so a
+// site that's briefly down isn't reported; 401, 403 and 429 mean the site
+// is up but turned us away, and count as fine. Replicas claim links with
+// SKIP LOCKED, so they share the work. /admin/links/broken lists broken
+// links, those in the most-read emails first.
+
+const (
+	defaultLinkCheckInterval = 24 * time.Hour
+	linkCheckBrokenAfter     = 2
+	linkCheckBatch           = 200
+	linkCheckWorkers         = 4
+	linkCheckTimeout         = 15 * time.Second
+)
+
+// errNoLinkChecks is returned when there's no metrics DB to record checks.
+var errNoLinkChecks = &statusError{status: http.StatusNotImplemented, code: codeNotImplemented, message: "link checks need METRICS_DATABASE_URL"}
+
+// linkCheckClient only reaches public addresses (see outbound.go); links
+// to internal ones fail their checks.
+var linkCheckClient = publicHTTPClient(linkCheckTimeout)
+
+// BrokenLink is a link that's failed its recent checks.
+type BrokenLink struct {
+	URL         string    `json:"url"`
+	Status      *int      `json:"status,omitempty"` // last HTTP status; absent when the request failed
+	Error       string    `json:"error,omitempty"`
+	Failures    int       `json:"failures"` // consecutive
+	BrokenSince time.Time `json:"broken_since"`
+	CheckedAt   time.Time `json:"checked_at"`
+	EmailIDs    []string  `json:"email_ids"`
+	Views       int64     `json:"views"`                 // tracked views of those emails, from the stats rollup
+	ArchiveURL  string    `json:"archive_url,omitempty"` // clicks go here; see linkarchive.go
+}
+
+// StartLinkChecker schedules the checker.
+func (s *Store) StartLinkChecker() {
+	interval := envDuration("LINK_CHECK_INTERVAL", defaultLinkCheckInterval)
+	if s.metricsPool == nil || interval <= 0 {
+		return
+	}
+	switch fallback := os.Getenv("LINK_ARCHIVE_FALLBACK"); fallback {
+	case "", "wayback":
+		s.linkArchive = fallback
+	default:
+		log.Printf("link checker: unknown LINK_ARCHIVE_FALLBACK %q (want wayback), not redirecting broken links", fallback)
+	}
+	s.jobs.Every("check.links", interval, func(ctx context.Context) error {
+		n, err := s.CollectLinks(ctx)
+		if err != nil {
+			return fmt.Errorf("collect: %w", err)
+		}
+		checked, broken, err := s.CheckLinks(ctx, interval)
+		log.Printf("link checker: %d links in published emails, checked %d, %d newly broken", n, checked, broken)
+		return err
+	})
+	if s.linkArchive != "" {
+		s.jobs.Every("refresh.archived_links", 5*time.Minute, s.LoadArchivedLinks)
+	}
+}
+
+// emailOutboundLinks returns the distinct http(s) destinations linked from
+// html, unwrapped as they are for click tracking.
+func emailOutboundLinks(html string) []string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var out []string
+	doc.Find("a[href]").Each(func(_ int, sel *goquery.Selection) {
+		href := unwrapTrackingURL(strings.TrimSpace(sel.AttrOr("href", "")))
+		u, err := url.Parse(href)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || seen[href] {
+			return
+		}
+		seen[href] = true
+		out = append(out, href)
+	})
+	return out
+}
+
+// CollectLinks records every outbound link in published emails with the
+// emails linking to it, and forgets links no longer in any. It returns
+// how many distinct links there are.
+func (s *Store) CollectLinks(ctx context.Context) (int, error) {
+	emails := map[string][]string{}   // URL -> email IDs
+	linked := map[string]*time.Time{} // URL -> earliest send linking it
+	err := s.source.EachEmail(ctx, EmailFilter{}, 0, func(src *SourceEmail) error {
+		if src.HTML == nil {
+			return nil
+		}
+		html := *src.HTML
+		if resolved, err := s.resolveRelativeURLs(html); err == nil {
+			html = resolved
+		}
+		for _, u := range emailOutboundLinks(html) {
+			emails[u] = append(emails[u], src.ID)
+			if at := linked[u]; at == nil || (src.SentAt != nil && src.SentAt.Before(*at)) {
+				linked[u] = src.SentAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	seenAt := time.Now()
+	urls := make([]string, 0, len(emails))
+	ids := make([]string, 0, len(emails)) // comma-joined; arrays of arrays don't unnest
+	linkedAt := make([]*time.Time, 0, len(emails))
+	for u, e := range emails {
+		urls = append(urls, u)
+		ids = append(ids, strings.Join(e, ","))
+		linkedAt = append(linkedAt, linked[u])
+	}
+	_, err = s.metricsPool.Exec(ctx, `
+		INSERT INTO link_checks (url, email_ids, linked_at, seen_at)
+		SELECT u, string_to_array(e, ','), at, $4
+		FROM unnest($1::text[], $2::text[], $3::timestamptz[]) AS l(u, e, at)
+		ON CONFLICT (url) DO UPDATE SET email_ids = EXCLUDED.email_ids, linked_at = EXCLUDED.linked_at, seen_at = EXCLUDED.seen_at
+	`, urls, ids, linkedAt, seenAt)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	_, err = s.metricsPool.Exec(ctx, `DELETE FROM link_checks WHERE seen_at < $1`, seenAt)
+	return len(urls), s.observe(depMetrics, err)
+}
+
+// CheckLinks checks links not checked within interval, a batch at a time,
+// returning how many it checked and how many became broken.
+func (s *Store) CheckLinks(ctx context.Context, interval time.Duration) (checked, broken int, err error) {
+	for ctx.Err() == nil {
+		urls, err := s.claimLinkChecks(ctx, interval)
+		if err != nil || len(urls) == 0 {
+			return checked, broken, err
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		work := make(chan string)
+		for range linkCheckWorkers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for u := range work {
+					status, cerr := checkLink(ctx, u)
+					failures, archived, rerr := s.recordLinkCheck(ctx, u, status, cerr)
+					if rerr != nil {
+						log.Printf("link checker: record %s: %v", u, rerr)
+					}
+					if failures >= linkCheckBrokenAfter && !archived && s.linkArchive != "" {
+						if err := s.archiveBrokenLink(ctx, u); err != nil {
+							log.Printf("link checker: archived copy of %s: %v", u, err)
+						}
+					}
+					mu.Lock()
+					checked++
+					if failures == linkCheckBrokenAfter {
+						broken++
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, u := range urls {
+			work <- u
+		}
+		close(work)
+		wg.Wait()
+	}
+	return checked, broken, ctx.Err()
+}
+
+// claimLinkChecks marks a batch of due links checked and returns them.
+func (s *Store) claimLinkChecks(ctx context.Context, interval time.Duration) ([]string, error) {
+	rows, err := s.metricsPool.Query(ctx, `
+		UPDATE link_checks SET checked_at = NOW()
+		WHERE url IN (
+			SELECT url FROM link_checks
+			WHERE checked_at IS NULL OR checked_at < NOW() - $1 * INTERVAL '1 second'
+			ORDER BY checked_at NULLS FIRST
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING url
+	`, interval.Seconds(), linkCheckBatch)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+// checkLink requests u, returning the final status after redirects.
+func checkLink(ctx context.Context, u string) (int, error) {
+	status, err := requestLink(ctx, http.MethodHead, u)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = requestLink(ctx, http.MethodGet, u)
+	}
+	return status, err
+}
+
+func requestLink(ctx context.Context, method, u string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "news-linkcheck/1")
+	resp, err := linkCheckClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// linkCheckFailed reports whether a check's outcome counts against a link.
+func linkCheckFailed(status int, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400
+}
+
+// recordLinkCheck saves a check's outcome, returning the link's
+// consecutive failures and whether an archived copy was looked up.
+func (s *Store) recordLinkCheck(ctx context.Context, u string, status int, checkErr error) (int, bool, error) {
+	var statusArg *int
+	if status != 0 {
+		statusArg = &status
+	}
+	errText := ""
+	if checkErr != nil {
+		errText = checkErr.Error()
+		var uerr *url.Error
+		if errors.As(checkErr, &uerr) {
+			errText = uerr.Err.Error() // without the method and URL
+		}
+	}
+	var failures int
+	var archived bool
+	err := s.metricsPool.QueryRow(ctx, `
+		UPDATE link_checks SET
+			status = $2, error = NULLIF($3, ''), checked_at = NOW(),
+			failures = CASE WHEN $4 THEN failures + 1 ELSE 0 END,
+			failing_since = CASE WHEN $4 THEN COALESCE(failing_since, NOW()) END
+		WHERE url = $1
+		RETURNING failures, archive_url IS NOT NULL
+	`, u, statusArg, errText, linkCheckFailed(status, checkErr)).Scan(&failures, &archived)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, false, err
+	}
+	return failures, archived, nil
+}
+
+// ListBrokenLinks returns broken links, those in the most viewed emails
+// first.
+func (s *Store) ListBrokenLinks(ctx context.Context, limit, offset int) ([]BrokenLink, error) {
+	if s.metricsPool == nil {
+		return nil, errNoLinkChecks
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT l.url, l.status, COALESCE(l.error, ''), l.failures, l.failing_since, l.checked_at, l.email_ids,
+		       COALESCE(l.archive_url, ''),
+		       COALESCE((SELECT SUM(t.views) FROM email_stats_totals t WHERE t.email_id = ANY(l.email_ids)), 0)::bigint AS views
+		FROM link_checks l
+		WHERE l.failures >= $1
+		ORDER BY views DESC, l.url
+		LIMIT $2 OFFSET $3
+	`, linkCheckBrokenAfter, limit, offset)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BrokenLink{}
+	for rows.Next() {
+		var b BrokenLink
+		if err := rows.Scan(&b.URL, &b.Status, &b.Error, &b.Failures, &b.BrokenSince, &b.CheckedAt, &b.EmailIDs, &b.ArchiveURL, &b.Views); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleAdminBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parseLimitOffset(r, 100)
+	// One extra row tells us whether there's another page.
+	items, err := s.store.ListBrokenLinks(r.Context(), limit+1, offset)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	page := Paginated[BrokenLink]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		next := offset + limit
+		page.Next = &next
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, page)
+}
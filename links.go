@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ---------- Link Positions ----------
+
+// When links are rewritten for click tracking we also note where each one
+// sits in the email: how far down the text it appears and under which
+// heading. The map is saved to email_links in the metrics DB, keyed like
+// clicks by (email, link index), so /emails/{id}/links can tell authors
+// whether anyone clicks below the fold.
+
+// EmailLink is one tracked link in an email and where it appears.
+type EmailLink struct {
+	Index    int
+	URL      string
+	Code     string  // its /l/{code} short link; see shortlinks.go
+	Section  string  // nearest heading above the link
+	Position float64 // share of the email's text before the link, 0-1
+}
+
+const maxLinkSectionLen = 120
+
+// linkPositions walks doc in order and describes each node in indexes.
+func linkPositions(doc *goquery.Document, indexes map[*html.Node]EmailLink) []EmailLink {
+	out := make([]EmailLink, len(indexes))
+	seen, section := 0, ""
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if t := strings.Join(strings.Fields(n.Data), " "); t != "" {
+				seen += utf8.RuneCountInString(t) + 1
+			}
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style":
+				return
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				section = strings.Join(strings.Fields(goquery.NewDocumentFromNode(n).Text()), " ")
+				if len(section) > maxLinkSectionLen {
+					section = strings.ToValidUTF8(section[:maxLinkSectionLen], "")
+				}
+			}
+			if l, ok := indexes[n]; ok {
+				l.Position = float64(seen) // normalized below
+				l.Section = section
+				out[l.Index] = l
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Nodes {
+		walk(n)
+	}
+	if seen > 0 {
+		for i := range out {
+			out[i].Position = math.Round(out[i].Position/float64(seen)*1000) / 1000
+		}
+	}
+	return out
+}
+
+// linkMapHash identifies a link map so unchanged ones aren't rewritten.
+func linkMapHash(links []EmailLink) string {
+	h := sha1.New()
+	for _, l := range links {
+		fmt.Fprintf(h, "%d %q %q %g\n", l.Index, l.URL, l.Section, l.Position)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SaveLinkMap records an email's link positions in the background. Emails
+// are rebuilt on every cache miss, so a map is only written when it differs
+// from the last one this instance saved.
+func (s *Store) SaveLinkMap(emailID string, links []EmailLink) {
+	s.rememberShortlinks(emailID, links)
+	if s.metricsPool == nil {
+		return
+	}
+	sum := linkMapHash(links)
+	if prev, ok := s.linkMaps.Swap(emailID, sum); ok && prev == sum {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.saveLinkMap(ctx, emailID, links); err != nil {
+			s.linkMaps.Delete(emailID)
+			log.Printf("link map %s: %v", emailID, err)
+		}
+	}()
+}
+
+func (s *Store) saveLinkMap(ctx context.Context, emailID string, links []EmailLink) error {
+	n := len(links)
+	indexes := make([]int32, n)
+	urls := make([]string, n)
+	codes := make([]string, n)
+	sections := make([]string, n)
+	positions := make([]float64, n)
+	for i, l := range links {
+		indexes[i] = int32(l.Index)
+		urls[i] = l.URL
+		codes[i] = l.Code
+		sections[i] = l.Section
+		positions[i] = l.Position
+	}
+	tx, err := s.metricsPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	// Links past the end are from an older version of the email.
+	if _, err := tx.Exec(ctx, `DELETE FROM email_links WHERE email_id = $1 AND link_index >= $2`, emailID, n); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO email_links (email_id, link_index, url, code, section, position, updated_at)
+		SELECT $1, l.link_index, l.url, NULLIF(l.code, ''), NULLIF(l.section, ''), l.position, NOW()
+		FROM unnest($2::int[], $3::text[], $4::text[], $5::text[], $6::float8[]) AS l(link_index, url, code, section, position)
+		ON CONFLICT (email_id, link_index) DO UPDATE SET url = EXCLUDED.url, code = EXCLUDED.code,
+			section = EXCLUDED.section, position = EXCLUDED.position, updated_at = EXCLUDED.updated_at
+		WHERE (email_links.url, email_links.code, email_links.section, email_links.position)
+			IS DISTINCT FROM (EXCLUDED.url, EXCLUDED.code, EXCLUDED.section, EXCLUDED.position)
+	`, emailID, indexes, urls, codes, sections, positions); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// LinkClicks is a link with its unique clicks. Position is unknown for
+// links clicked before the email's link map was saved.
+type LinkClicks struct {
+	Index    int      `json:"index"`
+	URL      string   `json:"url"`
+	Section  string   `json:"section,omitempty"`
+	Position *float64 `json:"position"`
+	Clicks   int64    `json:"clicks"`
+}
+
+// PositionBucket totals clicks on links in one quarter of an email.
+type PositionBucket struct {
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Links  int     `json:"links"`
+	Clicks int64   `json:"clicks"`
+}
+
+// GetLinkClicks returns an email's links in order with unique clicks each.
+// Clicked links missing from the saved map are included with the URL they
+// were clicked through to.
+func (s *Store) GetLinkClicks(ctx context.Context, emailID string) ([]LinkClicks, error) {
+	out := []LinkClicks{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH c AS (
+			SELECT link_index, MIN(link_url) AS link_url, COUNT(DISTINCT session_id) AS clicks
+			FROM email_link_clicks
+			WHERE email_id = $1
+			GROUP BY link_index
+		)
+		SELECT COALESCE(l.link_index, c.link_index), COALESCE(l.url, c.link_url), COALESCE(l.section, ''),
+		       l.position, COALESCE(c.clicks, 0)
+		FROM (SELECT * FROM email_links WHERE email_id = $1) l
+		FULL OUTER JOIN c ON c.link_index = l.link_index
+		ORDER BY 1
+	`, emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lc LinkClicks
+		if err := rows.Scan(&lc.Index, &lc.URL, &lc.Section, &lc.Position, &lc.Clicks); err != nil {
+			return nil, err
+		}
+		out = append(out, lc)
+	}
+	return out, rows.Err()
+}
+
+// positionBuckets sums clicks by quarter of the email, skipping links with
+// no known position.
+func positionBuckets(links []LinkClicks) []PositionBucket {
+	out := make([]PositionBucket, 4)
+	for i := range out {
+		out[i].From, out[i].To = float64(i)/4, float64(i+1)/4
+	}
+	for _, l := range links {
+		if l.Position == nil {
+			continue
+		}
+		b := &out[min(int(*l.Position*4), 3)]
+		b.Links++
+		b.Clicks += l.Clicks
+	}
+	return out
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- List Logos ----------
+
+// Color alone doesn't tell lists apart on the archive's index page, so
+// operators can give each list a logo in mailing_list_branding: either a
+// URL, or an image uploaded through the admin API and served from
+// /mailing_lists/{id}/logo. Either way the list's logo_url, in MailingList
+// and every ListRef, is where to load it from; an upload's URL carries a
+// content hash, so it changes (and busts caches) with the image. Uploads
+// need PUBLIC_BASE_URL: the URL is shared by every request, so it can't
+// come from one request's Host. The mapping is kept in memory and
+// refreshed like sender names.
+
+const maxListLogoBytes = 512 << 10
+
+// listLogoTypes are the image types uploads may have.
+var listLogoTypes = map[string]bool{
+	"image/png":     true,
+	"image/jpeg":    true,
+	"image/gif":     true,
+	"image/webp":    true,
+	"image/svg+xml": true,
+}
+
+// ListLogoURL returns the list's logo URL, if it has one.
+func (s *Store) ListLogoURL(listID string) *string {
+	logos := s.listLogos.Load()
+	if logos == nil {
+		return nil
+	}
+	if u, ok := (*logos)[listID]; ok {
+		return &u
+	}
+	return nil
+}
+
+// LoadListLogos replaces the in-memory logo mapping from the metrics DB.
+func (s *Store) LoadListLogos(ctx context.Context) error {
+	logos := map[string]string{}
+	if s.metricsPool != nil {
+		rows, err := s.metricsPool.Query(ctx, `
+			SELECT mailing_list_id, logo_url, COALESCE(md5(logo_data), '')
+			FROM mailing_list_branding
+			WHERE logo_url <> '' OR logo_data IS NOT NULL
+		`)
+		if err := s.observe(depMetrics, err); err != nil {
+			return err
+		}
+		defer rows.Close()
+		skipped := 0
+		for rows.Next() {
+			var id, u, sum string
+			if err := rows.Scan(&id, &u, &sum); err != nil {
+				return err
+			}
+			if u == "" {
+				if s.publicBase == "" {
+					skipped++
+					continue
+				}
+				u = s.publicBase + "/mailing_lists/" + url.PathEscape(id) + "/logo?v=" + sum[:12]
+			}
+			logos[id] = u
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if skipped > 0 {
+			log.Printf("list logos: %d uploaded logos hidden until PUBLIC_BASE_URL is set", skipped)
+		}
+	}
+	s.listLogos.Store(&logos)
+	return nil
+}
+
+// StartListLogoRefresh loads logos now and every 5 minutes, so edits made
+// through another replica show up here too.
+func (s *Store) StartListLogoRefresh(ctx context.Context) {
+	if err := s.LoadListLogos(ctx); err != nil {
+		log.Printf("list logos load error: %v", err)
+	}
+	if s.metricsPool == nil {
+		return
+	}
+	s.jobs.Every("refresh.list_logos", 5*time.Minute, s.LoadListLogos)
+}
+
+// SetListLogoURL points the list's logo at u, replacing any upload.
+func (s *Store) SetListLogoURL(ctx context.Context, listID, u string) error {
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO mailing_list_branding (mailing_list_id, logo_url, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (mailing_list_id) DO UPDATE SET
+			logo_url = EXCLUDED.logo_url, logo_data = NULL, logo_type = NULL, updated_at = NOW()
+	`, listID, u)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	return s.LoadListLogos(ctx)
+}
+
+// SetListLogoImage stores an uploaded logo, replacing any URL.
+func (s *Store) SetListLogoImage(ctx context.Context, listID, contentType string, data []byte) error {
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO mailing_list_branding (mailing_list_id, logo_type, logo_data, updated_at) VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (mailing_list_id) DO UPDATE SET
+			logo_url = '', logo_type = EXCLUDED.logo_type, logo_data = EXCLUDED.logo_data, updated_at = NOW()
+	`, listID, contentType, data)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	return s.LoadListLogos(ctx)
+}
+
+func (s *Store) DeleteListLogo(ctx context.Context, listID string) error {
+	_, err := s.metricsPool.Exec(ctx, `DELETE FROM mailing_list_branding WHERE mailing_list_id = $1`, listID)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	return s.LoadListLogos(ctx)
+}
+
+// ListLogo is a list's stored logo: a URL, or an uploaded image.
+type ListLogo struct {
+	URL         string
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
+
+// GetListLogo returns the list's logo, or errNotFound.
+func (s *Store) GetListLogo(ctx context.Context, listID string) (*ListLogo, error) {
+	if s.metricsPool == nil {
+		return nil, errNotFound
+	}
+	var l ListLogo
+	var contentType *string
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT logo_url, logo_type, logo_data, updated_at
+		FROM mailing_list_branding
+		WHERE mailing_list_id = $1 AND (logo_url <> '' OR logo_data IS NOT NULL)
+	`, listID).Scan(&l.URL, &contentType, &l.Data, &l.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	if contentType != nil {
+		l.ContentType = *contentType
+	}
+	return &l, nil
+}
+
+// handleMailingListLogo serves an uploaded logo, or redirects to a logo
+// URL. It's loaded by <img> tags, so it's open like embeds.
+func (s *Server) handleMailingListLogo(w http.ResponseWriter, r *http.Request) {
+	logo, err := s.store.GetListLogo(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if logo.URL != "" {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.Redirect(w, r, logo.URL, http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", logo.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	// SVGs can carry scripts; this keeps one opened directly inert.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	http.ServeContent(w, r, "", logo.UpdatedAt, bytes.NewReader(logo.Data))
+}
+
+func (s *Server) handleAdminListLogos(w http.ResponseWriter, r *http.Request) {
+	logos := map[string]string{}
+	if l := s.store.listLogos.Load(); l != nil {
+		logos = *l
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"logos": logos})
+}
+
+// handleAdminSetListLogo takes {"logo_url": "https://..."}, or an image
+// body (PNG, JPEG, GIF, WebP or SVG, up to 512KB) to upload.
+func (s *Server) handleAdminSetListLogo(w http.ResponseWriter, r *http.Request) {
+	listID := chi.URLParam(r, "id")
+	if s.store.metricsPool == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "METRICS_DATABASE_URL not configured")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var err error
+	if contentType == "application/json" {
+		var req struct {
+			LogoURL string `json:"logo_url"`
+		}
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&req)
+		u, perr := url.Parse(req.LogoURL)
+		if err != nil || perr != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || len(req.LogoURL) > 2048 {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected {\"logo_url\": \"https://...\"}")
+			return
+		}
+		err = s.store.SetListLogoURL(r.Context(), listID, req.LogoURL)
+	} else {
+		if s.store.publicBase == "" {
+			writeError(w, r, http.StatusConflict, codeConflict, "PUBLIC_BASE_URL not configured; uploaded logos need it for their URL")
+			return
+		}
+		data, rerr := io.ReadAll(http.MaxBytesReader(w, r.Body, maxListLogoBytes))
+		if rerr != nil {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeBadRequest, "logos are limited to 512KB")
+			return
+		}
+		if !validListLogo(contentType, data) {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected a PNG, JPEG, GIF, WebP or SVG body with a matching Content-Type, or JSON with logo_url")
+			return
+		}
+		err = s.store.SetListLogoImage(r.Context(), listID, contentType, data)
+	}
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	n := s.cache.Purge(func(string) bool { return true })
+	log.Printf("admin: logo for list %s set, purged %d cache entries", listID, n)
+	writeJSON(w, http.StatusOK, map[string]any{"mailing_list_id": listID, "logo_url": s.store.ListLogoURL(listID)})
+}
+
+// validListLogo checks data is an image of the declared type. net/http
+// doesn't sniff SVG, so it's parsed instead; see safeSVG.
+func validListLogo(contentType string, data []byte) bool {
+	if !listLogoTypes[contentType] || len(data) == 0 {
+		return false
+	}
+	if contentType == "image/svg+xml" {
+		return safeSVG(data)
+	}
+	return http.DetectContentType(data) == contentType
+}
+
+// svgBannedElements can run script or pull in other documents.
+var svgBannedElements = map[string]bool{
+	"script": true, "foreignobject": true, "iframe": true, "embed": true,
+	"object": true, "use": true, "image": true, "feimage": true,
+	"animate": true, "set": true, "animatemotion": true, "animatetransform": true,
+}
+
+// safeSVG reports whether data is well-formed XML with an <svg> root and
+// nothing that runs script or loads from elsewhere: no DTD, no banned
+// elements, no on* handlers, and references only within the document.
+func safeSVG(data []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	depth, roots := 0, 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return roots == 1 && depth == 0
+		}
+		if err != nil {
+			return false
+		}
+		switch t := tok.(type) {
+		case xml.Directive:
+			return false // DOCTYPE, and with it entities
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				return false // e.g. xml-stylesheet
+			}
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			if depth == 0 {
+				roots++
+				if name != "svg" || (t.Name.Space != "" && t.Name.Space != "http://www.w3.org/2000/svg") {
+					return false
+				}
+			}
+			if svgBannedElements[name] {
+				return false
+			}
+			for _, a := range t.Attr {
+				attr := strings.ToLower(a.Name.Local)
+				value := strings.ToLower(strings.TrimSpace(a.Value))
+				if strings.HasPrefix(attr, "on") {
+					return false
+				}
+				if attr == "href" && !strings.HasPrefix(value, "#") {
+					return false
+				}
+				if !svgLocalURLs(value) {
+					return false
+				}
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			// Only <style> text can load anything.
+			if !svgLocalURLs(strings.ToLower(string(t))) {
+				return false
+			}
+		}
+	}
+}
+
+// svgLocalURLs reports whether every url(...) and @import in s points
+// within the document.
+func svgLocalURLs(s string) bool {
+	if strings.Contains(s, "@import") {
+		return false
+	}
+	for {
+		i := strings.Index(s, "url(")
+		if i < 0 {
+			return true
+		}
+		s = strings.TrimLeft(s[i+len("url("):], " \t\n\r'\"")
+		if !strings.HasPrefix(s, "#") {
+			return false
+		}
+	}
+}
+
+func (s *Server) handleAdminDeleteListLogo(w http.ResponseWriter, r *http.Request) {
+	listID := chi.URLParam(r, "id")
+	if s.store.metricsPool == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "METRICS_DATABASE_URL not configured")
+		return
+	}
+	if err := s.store.DeleteListLogo(r.Context(), listID); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	n := s.cache.Purge(func(string) bool { return true })
+	log.Printf("admin: logo for list %s removed, purged %d cache entries", listID, n)
+	w.WriteHeader(http.StatusNoContent)
+}
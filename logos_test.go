@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestSafeSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		want bool
+	}{
+		{"plain", `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><rect width="10" height="10" fill="#ec3750"/></svg>`, true},
+		{"xml declaration", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, true},
+		{"local references", `<svg xmlns="http://www.w3.org/2000/svg"><defs><linearGradient id="g"/></defs><rect fill="url(#g)"/><a href="#top"/><style>rect { fill: url( '#g') }</style></svg>`, true},
+		{"not svg", `<html><body/></html>`, false},
+		{"two roots", `<svg></svg><svg></svg>`, false},
+		{"foreign namespace", `<svg xmlns="http://example.com/ns"></svg>`, false},
+		{"malformed", `<svg><rect></svg>`, false},
+		{"doctype", `<!DOCTYPE svg [<!ENTITY x "y">]><svg>&x;</svg>`, false},
+		{"stylesheet", `<?xml-stylesheet href="https://evil.example/a.css"?><svg></svg>`, false},
+		{"script", `<svg><script>alert(1)</script></svg>`, false},
+		{"uppercase script", `<svg><SCRIPT>alert(1)</SCRIPT></svg>`, false},
+		{"foreignObject", `<svg><foreignObject><div/></foreignObject></svg>`, false},
+		{"use", `<svg><use href="#a"/></svg>`, false},
+		{"image", `<svg><image href="#a"/></svg>`, false},
+		{"animate", `<svg><animate attributeName="href" to="javascript:alert(1)"/></svg>`, false},
+		{"event handler", `<svg onload="alert(1)"></svg>`, false},
+		{"external href", `<svg><a href="https://evil.example"/></svg>`, false},
+		{"xlink href", `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><a xlink:href="javascript:alert(1)"/></svg>`, false},
+		{"external url attribute", `<svg><rect fill="url(https://evil.example/track)"/></svg>`, false},
+		{"external url in style", `<svg><style>rect { background: url("https://evil.example/") }</style></svg>`, false},
+		{"import", `<svg><style>@import "https://evil.example/a.css";</style></svg>`, false},
+	}
+	for _, tt := range tests {
+		if got := safeSVG([]byte(tt.svg)); got != tt.want {
+			t.Errorf("%s: safeSVG = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSVGLocalURLs(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"", true},
+		{"fill: red", true},
+		{"url(#a) url( '#b') url(\n\"#c\")", true},
+		{"url(#a) url(https://evil.example/)", false},
+		{"url(data:image/png;base64,AAAA)", false},
+		{"url(", false},
+		{"@import 'a.css'", false},
+	}
+	for _, tt := range tests {
+		if got := svgLocalURLs(tt.s); got != tt.want {
+			t.Errorf("svgLocalURLs(%q) = %t, want %t", tt.s, got, tt.want)
+		}
+	}
+}
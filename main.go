@@ -2,30 +2,45 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	mathrand "math/rand/v2"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/httprate"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
+	nethtml "golang.org/x/net/html"
 )
 
 /*
@@ -51,39 +66,95 @@ type MailingList struct {
 	LastUpdatedAt   *time.Time `json:"last_updated_at,omitempty"`
 	LastSentAt      *time.Time `json:"last_sent_at,omitempty"`
 	SentEmailCount  int64      `json:"sent_email_count"`
+	Sender          string     `json:"sender"` // curated display name, never an address
+	LogoURL         *string    `json:"logo_url,omitempty"`
 }
 
 type EmailStats struct {
-	Clicks int64 `json:"clicks"`
-	Views  int64 `json:"views"`
+	Clicks            int64 `json:"clicks"`
+	Views             int64 `json:"views"`
+	Likes             int64 `json:"likes"`
+	MedianReadSeconds *int  `json:"median_read_seconds,omitempty"` // from read-time beacons
 }
 
 type Email struct {
-	ID             string     `json:"id"`
-	Slug           string     `json:"slug"` // derived from subject or name
-	Subject        string     `json:"subject"`
-	Excerpt        *string    `json:"excerpt,omitempty"`
-	SentAt         *time.Time `json:"sent_at,omitempty"`
-	MailingListID  string     `json:"mailing_list_id"`
-	MailingListRef ListRef    `json:"mailing_list"`
-	Stats          EmailStats `json:"stats"`
-	HTML           *string    `json:"html,omitempty"`
-	Markdown       *string    `json:"markdown,omitempty"`
-	PreviewText    *string    `json:"preview_text,omitempty"` // first ~200 chars for listing cards
+	ID             string       `json:"id"`
+	Slug           string       `json:"slug"` // derived from subject or name
+	Subject        string       `json:"subject"`
+	Excerpt        *string      `json:"excerpt,omitempty"`
+	SentAt         *time.Time   `json:"sent_at,omitempty"`
+	UpdatedAt      *time.Time   `json:"updated_at,omitempty"` // watermark for ?updated_since=
+	MailingListID  string       `json:"mailing_list_id"`
+	MailingListRef ListRef      `json:"mailing_list"`
+	Stats          EmailStats   `json:"stats"`
+	HTML           *string      `json:"html,omitempty"`
+	Markdown       *string      `json:"markdown,omitempty"`
+	Styles         *string      `json:"styles,omitempty"`
+	PreviewText    *string      `json:"preview_text,omitempty"` // first ~200 chars for listing cards
+	Sender         string       `json:"sender"`                 // curated display name, never an address
+	Series         *SeriesRef   `json:"series,omitempty"`
+	Images         []EmailImage `json:"images"`
+	CoverImage     *EmailImage  `json:"cover_image,omitempty"` // best guess for listing thumbnails
+	A11y           *EmailA11y   `json:"a11y,omitempty"`        // see a11y.go
+	Summary        *string      `json:"summary,omitempty"`     // written by an LLM; see enrich.go
+	KeyPoints      []string     `json:"key_points,omitempty"`
+	Topics         []string     `json:"topics,omitempty"` // see topics.go
+
+	links []EmailLink // the rewritten HTML's links, for renderMarkdown
 }
 
 type ListRef struct {
-	ID          string `json:"id"`
-	Slug        string `json:"slug"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Color       string `json:"color"`
+	ID          string  `json:"id"`
+	Slug        string  `json:"slug"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Color       string  `json:"color"`
+	LogoURL     *string `json:"logo_url,omitempty"` // see logos.go
 }
 
 type Paginated[T any] struct {
-	Items []T   `json:"items"`
-	Next  *int  `json:"next_offset,omitempty"`
-	Count *int  `json:"count,omitempty"`
+	Items []T           `json:"items"`
+	Next  *int          `json:"next_offset,omitempty"`
+	Count *int          `json:"count,omitempty"`
+	Meta  *ResponseMeta `json:"meta,omitempty"`
+}
+
+// ---------- Build info ----------
+
+// Set at build time: -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = ""
+)
+
+type VersionInfo struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit,omitempty"`
+	GoVersion string            `json:"go_version"`
+	StartedAt time.Time         `json:"started_at"`
+	Features  map[string]bool   `json:"features"`
+	Settings  map[string]string `json:"settings"` // non-secret configuration only
+}
+
+func newVersionInfo() VersionInfo {
+	vi := VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		StartedAt: time.Now().UTC(),
+		Features:  map[string]bool{},
+		Settings:  map[string]string{},
+	}
+	if vi.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, kv := range bi.Settings {
+				if kv.Key == "vcs.revision" {
+					vi.Commit = kv.Value
+				}
+			}
+		}
+	}
+	return vi
 }
 
 // ---------- Utilities ----------
@@ -124,6 +195,131 @@ func weakETag(payload []byte) string {
 	return `W/"` + hex.EncodeToString(sum[:]) + `"`
 }
 
+// normalizeReferrer reduces a referrer URL to its origin so paths and query
+// strings (which can carry PII or campaign tokens) are never stored.
+func normalizeReferrer(raw string) *string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	return &origin
+}
+
+var (
+	socialHosts = []string{"twitter.com", "x.com", "t.co", "facebook.com", "instagram.com", "linkedin.com", "lnkd.in", "reddit.com", "youtube.com", "tiktok.com", "bsky.app", "threads.net", "slack.com", "discord.com"}
+	searchHosts = []string{"google.com", "bing.com", "duckduckgo.com", "yahoo.com", "yandex.com", "baidu.com", "ecosia.org", "search.brave.com", "kagi.com"}
+)
+
+func hostMatches(host string, domains []string) bool {
+	for _, d := range domains {
+		d = strings.TrimSpace(d)
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// referrerCategory buckets a normalized referrer origin for reporting.
+func referrerCategory(origin string) string {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "other"
+	}
+	host := u.Hostname()
+	switch {
+	case hostMatches(host, strings.Split(env("REFERRER_SITE_HOSTS", "hackclub.com"), ",")):
+		return "site"
+	case hostMatches(host, socialHosts):
+		return "social"
+	case hostMatches(host, searchHosts), strings.HasPrefix(strings.TrimPrefix(host, "www."), "google."):
+		return "search"
+	}
+	return "other"
+}
+
+// DeviceInfo is the coarse bucket derived from a User-Agent. Only the bucket
+// is ever stored, never the raw UA string.
+type DeviceInfo struct {
+	Class   string // mobile, tablet, desktop, bot, unknown
+	Browser string // chrome, safari, firefox, edge, opera, samsung, other, unknown
+}
+
+func parseDevice(ua string) DeviceInfo {
+	ua = strings.ToLower(ua)
+	if ua == "" {
+		return DeviceInfo{Class: "unknown", Browser: "unknown"}
+	}
+
+	var d DeviceInfo
+	switch {
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawl") || strings.Contains(ua, "spider") || strings.Contains(ua, "slurp"):
+		d.Class = "bot"
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		d.Class = "tablet"
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod") || strings.Contains(ua, "android"):
+		d.Class = "mobile"
+	default:
+		d.Class = "desktop"
+	}
+
+	// Order matters: most UAs claim to be Safari and Chromium forks claim Chrome.
+	switch {
+	case strings.Contains(ua, "edg/") || strings.Contains(ua, "edga/") || strings.Contains(ua, "edgios/"):
+		d.Browser = "edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		d.Browser = "opera"
+	case strings.Contains(ua, "samsungbrowser"):
+		d.Browser = "samsung"
+	case strings.Contains(ua, "firefox") || strings.Contains(ua, "fxios"):
+		d.Browser = "firefox"
+	case strings.Contains(ua, "chrome") || strings.Contains(ua, "crios") || strings.Contains(ua, "chromium"):
+		d.Browser = "chrome"
+	case strings.Contains(ua, "safari"):
+		d.Browser = "safari"
+	default:
+		d.Browser = "other"
+	}
+	return d
+}
+
+// Preview tokens are "<payload>.<sig>" where payload is base64url of
+// "<email_id>|<unix expiry>" and sig is its base64url HMAC-SHA256. An
+// email_id of "*" grants preview of any campaign.
+func signPreviewToken(secret []byte, emailID string, exp time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(emailID + "|" + strconv.FormatInt(exp.Unix(), 10)))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifyPreviewToken(secret []byte, token, emailID string, now time.Time) bool {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(want)) != 1 {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return false
+	}
+	id, expStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || now.Unix() > exp {
+		return false
+	}
+	return id == "*" || id == emailID
+}
+
 func generateSessionID() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
@@ -145,6 +341,8 @@ type TTLCache struct {
 	store map[string]cacheItem
 	ttl   time.Duration
 	max   int
+
+	hits, misses, stale atomic.Int64 // lookups since startup
 }
 
 func NewTTLCache(ttl time.Duration, max int) *TTLCache {
@@ -156,12 +354,28 @@ func (c *TTLCache) Get(key string) (val []byte, etag string, ok bool) {
 	it, ok := c.store[key]
 	c.mu.RUnlock()
 	if !ok || time.Now().After(it.expiresAt) {
+		c.misses.Add(1)
+		return nil, "", false
+	}
+	c.hits.Add(1)
+	return it.val, it.etag, true
+}
+
+// GetStale returns an entry even if it has expired, as long as it has not
+// been evicted yet. Used to serve something when a rebuild fails.
+func (c *TTLCache) GetStale(key string) (val []byte, etag string, ok bool) {
+	c.mu.RLock()
+	it, ok := c.store[key]
+	c.mu.RUnlock()
+	if !ok {
 		return nil, "", false
 	}
+	c.stale.Add(1)
 	return it.val, it.etag, true
 }
 
-func (c *TTLCache) Set(key string, val []byte) string {
+// Set stores val and returns its ETag: etag if given, else a hash of val.
+func (c *TTLCache) Set(key string, val []byte, etag string) string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if len(c.store) >= c.max {
@@ -177,23 +391,126 @@ func (c *TTLCache) Set(key string, val []byte) string {
 			delete(c.store, oldestKey)
 		}
 	}
-	etag := weakETag(val)
+	if etag == "" {
+		etag = weakETag(val)
+	}
 	c.store[key] = cacheItem{val: val, etag: etag, expiresAt: time.Now().Add(c.ttl)}
 	return etag
 }
 
+// Purge removes every entry whose key matches and returns how many went.
+func (c *TTLCache) Purge(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for k := range c.store {
+		if match(k) {
+			delete(c.store, k)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *TTLCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.store)
+}
+
+// cacheKeyPath extracts the URL path from a cacheKey.
+func cacheKeyPath(key string) string {
+	_, rest, _ := strings.Cut(key, " ")
+	path, _, _ := strings.Cut(rest, "?")
+	return path
+}
+
+// cacheParams lists the query parameters cached handlers read. Anything else
+// is ignored when keying, so unknown params can't fragment the cache; a
+// handler that starts reading a new param must add it here.
+var cacheParams = map[string]bool{
+	"css":             true,
+	"cursor":          true,
+	"days":            true,
+	"ecc":             true,
+	"email_id":        true,
+	"format":          true,
+	"from":            true,
+	"group_all":       true,
+	"limit":           true,
+	"limit_per_list":  true,
+	"mailing_list_id": true,
+	"metric":          true,
+	"mode":            true,
+	"offset":          true,
+	"page":            true,
+	"q":               true,
+	"render":          true,
+	"since":           true,
+	"size":            true,
+	"theme":           true,
+	"to":              true,
+	"topic":           true,
+	"updated_since":   true,
+}
+
+// cacheKey is "<method> <path>?<query>" with the query reduced to recognized
+// params, first value only (what Query().Get sees), sorted by name.
 func cacheKey(r *http.Request) string {
-	return r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery
+	q := url.Values{}
+	for k, v := range r.URL.Query() {
+		if cacheParams[k] && len(v) > 0 {
+			q.Set(k, v[0])
+		}
+	}
+	return r.Method + " " + r.URL.Path + "?" + q.Encode()
 }
 
 // ---------- Database layer ----------
 
 type Store struct {
+	health      *HealthTracker
 	pool        *pgxpool.Pool
+	replica     *pgxpool.Pool // optional read replica of pool, used while replicaOK
+	replicaOK   atomic.Bool
+	secondary   *pgxpool.Pool // optional second warehouse for blue/green switching
+	split       atomic.Int32  // percent (0-100) of content reads sent to secondary
 	metricsPool *pgxpool.Pool
+	timescale   bool     // metrics DB has the timescaledb extension
+	alerts      *Alerter // operational alerts; nil when no sinks are configured
+	region      string   // REGION of this instance, stamped on tracking events
+	publicBase  string   // PUBLIC_BASE_URL; canonical origin for URLs we emit
+	senders     atomic.Pointer[senderNames]
+	listLogos   atomic.Pointer[map[string]string]
+	overrides   atomic.Pointer[emailOverrides]
+	source      ContentSource  // lists and emails; see content.go
+	linkMaps    sync.Map       // email ID -> hash of the link map last saved; see links.go
+	revisions   sync.Map       // email ID -> content hash last snapshotted; see revisions.go
+	sessions    *SessionHasher // pseudonymizes session IDs before they're stored
+	counts      *hotCounts     // approximate counts for hot emails; see hotcounts.go
+	memo        *countMemo     // short-lived per-email counts; see countmemo.go
+	totalsReady atomic.Bool    // email_stats_totals is current; see statsrollup.go
+	jobs        *Jobs          // background work; see jobs.go
+
+	linkArchive   string // LINK_ARCHIVE_FALLBACK; see linkarchive.go
+	archivedLinks atomic.Pointer[map[string]string]
+
+	shortLinks bool     // LINK_SHORTLINKS; see shortlinks.go
+	shortCodes sync.Map // short code -> shortlink generated here
+	linkSlots  sync.Map // linkSlot -> shortlink generated here
+
+	imageProxy       string   // IMAGE_PROXY_URL; see images.go
+	imageSizes       sync.Map // image URL -> imageSize
+	imageSizePending sync.Map // image URLs this instance has queued fetches for
+
+	linkBase string // LINK_RELATIVE_BASE; see relurls.go
+
+	llm         *llmClient // ENRICH_LLM_URL; nil when enrichment is off; see enrich.go
+	enrichments sync.Map   // email ID -> enrichment
+	embedder    *llmClient // ENRICH_EMBEDDINGS_URL; nil when semantic search is off; see embeddings.go
 }
 
-func NewStore(ctx context.Context, url string, metricsURL string) (*Store, error) {
+func openWarehousePool(ctx context.Context, url string) (*pgxpool.Pool, error) {
 	if os.Getenv("ALLOW_DB_INSECURE") != "1" && !strings.Contains(url, "sslmode=") {
 		sep := "?"
 		if strings.Contains(url, "?") {
@@ -205,7 +522,7 @@ func NewStore(ctx context.Context, url string, metricsURL string) (*Store, error
 	if err != nil {
 		return nil, err
 	}
-	cfg.MaxConns = 10
+	cfg.MaxConns = int32(envInt("DB_MAX_CONNS", 10))
 	cfg.MinConns = 1
 	cfg.HealthCheckPeriod = 30 * time.Second
 	cfg.MaxConnLifetime = 55 * time.Minute
@@ -217,8 +534,37 @@ func NewStore(ctx context.Context, url string, metricsURL string) (*Store, error
 	ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if err := pool.Ping(ctx2); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// NewStore connects the warehouse pool, plus the optional read replica,
+// secondary warehouse (blue/green source switching) and metrics pools. An
+// unreachable replica is not fatal; reads just stay on the primary.
+func NewStore(ctx context.Context, url, replicaURL, secondaryURL, metricsURL string) (*Store, error) {
+	pool, err := openWarehousePool(ctx, url)
+	if err != nil {
 		return nil, err
 	}
+	var replica *pgxpool.Pool
+	if replicaURL != "" {
+		replica, err = openWarehousePool(ctx, replicaURL)
+		if err != nil {
+			log.Printf("warning: read replica unavailable, reading from primary: %v", err)
+			replica = nil
+		}
+	}
+	var secondary *pgxpool.Pool
+	if secondaryURL != "" {
+		secondary, err = openWarehousePool(ctx, secondaryURL)
+		if err != nil {
+			return nil, fmt.Errorf("secondary db connect: %w", err)
+		}
+	}
+	ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
 	var metricsPool *pgxpool.Pool
 	if metricsURL != "" {
@@ -226,7 +572,7 @@ func NewStore(ctx context.Context, url string, metricsURL string) (*Store, error
 		if err != nil {
 			return nil, fmt.Errorf("metrics db config: %w", err)
 		}
-		metricsCfg.MaxConns = 5
+		metricsCfg.MaxConns = int32(envInt("METRICS_DB_MAX_CONNS", 5))
 		metricsCfg.MinConns = 1
 		metricsPool, err = pgxpool.NewWithConfig(ctx, metricsCfg)
 		if err != nil {
@@ -237,7 +583,96 @@ func NewStore(ctx context.Context, url string, metricsURL string) (*Store, error
 		}
 	}
 
-	return &Store{pool: pool, metricsPool: metricsPool}, nil
+	store := &Store{health: NewHealthTracker(), pool: pool, replica: replica, secondary: secondary, metricsPool: metricsPool}
+	store.replicaOK.Store(replica != nil)
+	store.senders.Store(&senderNames{byScope: map[string]string{}, fallback: env("SENDER_DEFAULT_NAME", "Hack Club")})
+	if metricsPool != nil {
+		// RunMetricsMigrations may enable the extension later; this covers
+		// SKIP_MIGRATIONS deployments.
+		if err := metricsPool.QueryRow(ctx2, `SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&store.timescale); err != nil {
+			return nil, fmt.Errorf("metrics db extensions: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// content picks the warehouse pool for one content read according to the
+// current blue/green split. Reads bound for the primary go to its replica
+// while the replica is healthy.
+func (s *Store) content() *pgxpool.Pool {
+	if s.secondary != nil {
+		switch pct := s.split.Load(); {
+		case pct >= 100:
+			return s.secondary
+		case pct > 0 && mathrand.Int32N(100) < pct:
+			return s.secondary
+		}
+	}
+	if s.replica != nil && s.replicaOK.Load() {
+		return s.replica
+	}
+	return s.pool
+}
+
+// StartReplicaMonitor pings the read replica every few seconds, taking it out
+// of rotation while it fails and back once it answers again.
+func (s *Store) StartReplicaMonitor(ctx context.Context) {
+	if s.replica == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			err := s.replica.Ping(pingCtx)
+			cancel()
+			switch ok := err == nil; {
+			case !ok && s.replicaOK.Swap(false):
+				log.Printf("read replica failing, falling back to primary: %v", err)
+				s.alerts.Notify(Alert{Severity: "warning", Source: "health", Title: "read replica failing, reads moved to primary", Message: err.Error()})
+			case ok && !s.replicaOK.Swap(true):
+				log.Printf("read replica recovered, reads moved back")
+				s.alerts.Notify(Alert{Severity: "info", Source: "health", Title: "read replica recovered"})
+			}
+		}
+	}()
+}
+
+// SetContentSplit routes percent (clamped to 0-100) of content reads to the
+// secondary warehouse. It is a no-op without one.
+func (s *Store) SetContentSplit(percent int) {
+	s.split.Store(int32(max(0, min(100, percent))))
+}
+
+// migrationLockKey is the pg_advisory_lock key guarding metrics DDL.
+const migrationLockKey int64 = 0x6e657773 // "news"
+
+// detectTimescale reports whether timescaledb is installed, installing it
+// first when the server offers it and we have the privileges to do so.
+func detectTimescale(ctx context.Context, conn *pgxpool.Conn) (bool, error) {
+	var installed, available bool
+	err := conn.QueryRow(ctx, `
+		SELECT
+			EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'timescaledb'),
+			EXISTS(SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb')
+	`).Scan(&installed, &available)
+	if err != nil {
+		return false, err
+	}
+	if installed || !available {
+		return installed, nil
+	}
+	if _, err := conn.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS timescaledb`); err != nil {
+		log.Printf("timescaledb available but could not be enabled: %v", err)
+		return false, nil
+	}
+	return true, nil
 }
 
 func (s *Store) RunMetricsMigrations(ctx context.Context) error {
@@ -246,274 +681,353 @@ func (s *Store) RunMetricsMigrations(ctx context.Context) error {
 		return nil
 	}
 
-	log.Println("running metrics database migrations...")
+	// Multiple replicas may boot at once; serialize DDL behind a session-level
+	// advisory lock held on a single dedicated connection.
+	conn, err := s.metricsPool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("acquire migration conn: %w", err)
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Printf("release migration lock: %v", err)
+		}
+	}()
 
-	migrations := []string{
-		`CREATE TABLE IF NOT EXISTS email_views (
-			time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			session_id TEXT NOT NULL,
-			email_id TEXT NOT NULL
-		)`,
-		
-		`SELECT create_hypertable('email_views', 'time', if_not_exists => TRUE)`,
-		
-		`CREATE UNIQUE INDEX IF NOT EXISTS idx_email_views_dedup 
-		ON email_views (session_id, email_id, time_bucket('5 minutes', time), time)`,
-		
-		`CREATE MATERIALIZED VIEW IF NOT EXISTS email_view_counts
-		WITH (timescaledb.continuous) AS
-		SELECT 
-			time_bucket('1 hour', time) as bucket,
-			email_id,
-			COUNT(DISTINCT session_id) as view_count
-		FROM email_views
-		GROUP BY bucket, email_id
-		WITH NO DATA`,
-		
-		`SELECT add_continuous_aggregate_policy('email_view_counts',
-			start_offset => INTERVAL '1 day',
-			end_offset => INTERVAL '1 hour',
-			schedule_interval => INTERVAL '1 hour',
-			if_not_exists => TRUE)`,
-		
-		`CREATE INDEX IF NOT EXISTS idx_email_views_email_id ON email_views(email_id, time DESC)`,
-		
-		`CREATE TABLE IF NOT EXISTS email_link_clicks (
-			time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
-			session_id TEXT NOT NULL,
-			email_id TEXT NOT NULL,
-			link_url TEXT NOT NULL,
-			link_index INT NOT NULL
-		)`,
-		
-		`SELECT create_hypertable('email_link_clicks', 'time', if_not_exists => TRUE)`,
-		
-		`CREATE UNIQUE INDEX IF NOT EXISTS idx_email_link_clicks_dedup 
-		ON email_link_clicks (session_id, email_id, link_index, time_bucket('5 minutes', time), time)`,
-		
-		`CREATE INDEX IF NOT EXISTS idx_email_link_clicks_email_id ON email_link_clicks(email_id, time DESC)`,
+	timescale, err := detectTimescale(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("detect timescaledb: %w", err)
+	}
+	s.timescale = timescale
+	if !timescale {
+		log.Println("timescaledb not available, using plain postgres tables")
 	}
 
-	for i, migration := range migrations {
-		_, err := s.metricsPool.Exec(ctx, migration)
-		if err != nil {
-			return fmt.Errorf("migration %d failed: %w", i+1, err)
-		}
+	log.Println("running metrics database migrations...")
+	if err := applyMigrations(ctx, conn, timescale); err != nil {
+		return err
 	}
 
 	log.Println("metrics database migrations completed successfully")
 	return nil
 }
 
+// RefreshViewCountRollup recomputes the last day of hourly view counts. It
+// stands in for the continuous aggregate policy on plain Postgres.
+func (s *Store) RefreshViewCountRollup(ctx context.Context) error {
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO email_view_counts (bucket, email_id, view_count)
+		SELECT date_trunc('hour', time), email_id, COUNT(DISTINCT session_id)
+		FROM email_views
+		WHERE time >= date_trunc('hour', NOW() - INTERVAL '1 day')
+		GROUP BY 1, 2
+		ON CONFLICT (bucket, email_id) DO UPDATE SET view_count = EXCLUDED.view_count
+	`)
+	return err
+}
+
+type RecountResult struct {
+	Since          time.Time `json:"since"`
+	EmailID        string    `json:"email_id,omitempty"`
+	BucketsChecked int64     `json:"buckets_checked"`
+	Discrepancies  int64     `json:"discrepancies"` // (bucket, email) rows that disagreed
+	ViewDelta      int64     `json:"view_delta"`    // sum of |raw - materialized| over those rows
+	Remaining      int64     `json:"remaining"`     // discrepancies left after repair
+}
+
+// viewCountDiff compares hourly unique views recomputed from raw email_views
+// with the email_view_counts rollup.
+func (s *Store) viewCountDiff(ctx context.Context, since time.Time, emailID string) (checked, diffs, delta int64, err error) {
+	bucket := "date_trunc('hour', time)"
+	if s.timescale {
+		bucket = "time_bucket('1 hour', time)"
+	}
+	err = s.metricsPool.QueryRow(ctx, fmt.Sprintf(`
+		WITH raw AS (
+			SELECT %s AS bucket, email_id, COUNT(DISTINCT session_id) AS view_count
+			FROM email_views
+			WHERE time >= $1 AND ($2 = '' OR email_id = $2)
+			GROUP BY 1, 2
+		), mat AS (
+			SELECT bucket, email_id, view_count
+			FROM email_view_counts
+			WHERE bucket >= $1 AND ($2 = '' OR email_id = $2)
+		)
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE raw.view_count IS DISTINCT FROM mat.view_count),
+		       COALESCE(SUM(ABS(COALESCE(raw.view_count, 0) - COALESCE(mat.view_count, 0))), 0)::bigint
+		FROM raw FULL OUTER JOIN mat USING (bucket, email_id)
+	`, bucket), since, emailID).Scan(&checked, &diffs, &delta)
+	return
+}
+
+// RecountViews recomputes hourly view counters from raw events since the
+// given time (e.g. after bot rows were scrubbed or retention changed) and
+// rewrites the rollup to match.
+func (s *Store) RecountViews(ctx context.Context, since time.Time, emailID string) (RecountResult, error) {
+	res := RecountResult{Since: since, EmailID: emailID}
+	if s.metricsPool == nil {
+		return res, errors.New("metrics database not configured")
+	}
+	since = since.UTC().Truncate(time.Hour)
+
+	var err error
+	res.BucketsChecked, res.Discrepancies, res.ViewDelta, err = s.viewCountDiff(ctx, since, emailID)
+	if err != nil {
+		return res, fmt.Errorf("diff: %w", err)
+	}
+	if res.Discrepancies == 0 {
+		return res, nil
+	}
+
+	if s.timescale {
+		// Continuous aggregates can only be refreshed by time range, so the
+		// whole window is rebuilt even when a single email was requested.
+		// Timestamps are formatted here, never user-supplied text.
+		_, err = s.metricsPool.Exec(ctx, fmt.Sprintf(
+			`CALL refresh_continuous_aggregate('email_view_counts', '%s'::timestamptz, NULL)`,
+			since.Format(time.RFC3339)))
+	} else {
+		err = pgx.BeginFunc(ctx, s.metricsPool, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, `
+				DELETE FROM email_view_counts
+				WHERE bucket >= $1 AND ($2 = '' OR email_id = $2)
+			`, since, emailID); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `
+				INSERT INTO email_view_counts (bucket, email_id, view_count)
+				SELECT date_trunc('hour', time), email_id, COUNT(DISTINCT session_id)
+				FROM email_views
+				WHERE time >= $1 AND ($2 = '' OR email_id = $2)
+				GROUP BY 1, 2
+			`, since, emailID)
+			return err
+		})
+	}
+	if err != nil {
+		return res, fmt.Errorf("repair: %w", err)
+	}
+
+	_, res.Remaining, _, err = s.viewCountDiff(ctx, since, emailID)
+	if err != nil {
+		return res, fmt.Errorf("verify: %w", err)
+	}
+	return res, nil
+}
+
+// StartViewCountRollup runs RefreshViewCountRollup hourly when the metrics DB
+// lacks timescaledb; with timescale the aggregate policy handles it.
+func (s *Store) StartViewCountRollup() {
+	if s.metricsPool == nil || s.timescale {
+		return
+	}
+	s.jobs.Every("rollup.view_counts", time.Hour, s.RefreshViewCountRollup)
+}
+
+// ListMailingLists returns the source's lists with our sender bylines.
 func (s *Store) ListMailingLists(ctx context.Context, limit, offset int) ([]MailingList, *int, error) {
-	q := `
-WITH sent_counts AS (
-  SELECT mailing_list_id, COUNT(*) AS sent_email_count, MAX(sent_at) as last_sent_at
-  FROM loops.campaigns
-  WHERE status = 'Sent' AND mailing_list_id IS NOT NULL AND ai_publishable = true
-  GROUP BY mailing_list_id
-),
-sub_counts AS (
-  SELECT mailing_list_id, COUNT(*)::bigint AS subscriber_count
-  FROM loops.audience_mailing_lists
-  GROUP BY mailing_list_id
-)
-SELECT ml.id,
-       ml.friendly_name,
-       ml.description,
-       COALESCE(ml.is_public, false) AS is_public,
-       COALESCE(ml.color_scheme, '#000000') AS color_scheme,
-       ml.last_updated_at,
-       COALESCE(sc.subscriber_count, 0) AS subscriber_count,
-       COALESCE(se.sent_email_count, 0) AS sent_email_count,
-       se.last_sent_at
-FROM loops.mailing_lists ml
-LEFT JOIN sub_counts sc ON sc.mailing_list_id = ml.id
-LEFT JOIN sent_counts se ON se.mailing_list_id = ml.id
-WHERE COALESCE(se.sent_email_count, 0) > 0
-ORDER BY (se.last_sent_at IS NULL) ASC, se.last_sent_at DESC NULLS LAST, ml.friendly_name ASC
-LIMIT $1 OFFSET $2;
-`
-	rows, err := s.pool.Query(ctx, q, limit, offset)
+	out, next, err := s.source.ListMailingLists(ctx, limit, offset)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer rows.Close()
+	for i := range out {
+		out[i].Slug = slugify(out[i].Name)
+		out[i].Sender = s.SenderName("", out[i].ID)
+		out[i].LogoURL = s.ListLogoURL(out[i].ID)
+	}
+	return out, next, nil
+}
 
-	out := make([]MailingList, 0, limit)
-	for rows.Next() {
-		var ml MailingList
-		var name, desc, color string
-		var isPublic bool
-		var lastUpdated *time.Time
-		var lastSent *time.Time
-		var subCount, sentCount int64
-		var id string
-		if err := rows.Scan(&id, &name, &desc, &isPublic, &color, &lastUpdated, &subCount, &sentCount, &lastSent); err != nil {
-			return nil, nil, err
-		}
-		ml.ID = id
-		ml.Name = name
-		ml.Description = desc
-		ml.Color = color
-		ml.IsPublic = isPublic
-		ml.LastUpdatedAt = lastUpdated
-		ml.LastSentAt = lastSent
-		ml.SubscriberCount = subCount
-		ml.SentEmailCount = sentCount
-		ml.Slug = slugify(name)
-		out = append(out, ml)
-	}
-	var next *int
-	if len(out) == limit {
-		n := offset + limit
-		next = &n
-	}
-	return out, next, rows.Err()
-}
-
-func (s *Store) ListEmails(ctx context.Context, r *http.Request, mailingListID *string, limit, offset int) ([]Email, *int, error) {
-	args := []any{}
-	where := "WHERE c.status = 'Sent' AND c.mailing_list_id IS NOT NULL AND c.ai_publishable = true"
-	if mailingListID != nil && *mailingListID != "" {
-		where += " AND c.mailing_list_id = $1"
-		args = append(args, *mailingListID)
-	}
-	q := fmt.Sprintf(`
-SELECT
-  c.id,
-  c.ai_publishable_response_json->>'title',
-  c.sent_at,
-  c.mailing_list_id,
-  ml.friendly_name,
-  ml.description,
-  COALESCE(ml.color_scheme, '#000000'),
-  COALESCE(c.clicks, 0)::bigint,
-  COALESCE(c.opens, 0)::bigint,
-  c.ai_publishable_content_html,
-  c.ai_publishable_content_markdown,
-  c.ai_publishable_slug,
-  c.ai_publishable_response_json->>'excerpt'
-FROM loops.campaigns c
-JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
-%s
-ORDER BY c.sent_at DESC NULLS LAST, c.created_at DESC
-LIMIT %s OFFSET %s;
-`, where,
-		fmt.Sprintf("$%d", len(args)+1),
-		fmt.Sprintf("$%d", len(args)+2),
-	)
-	args = append(args, limit, offset)
-	rows, err := s.pool.Query(ctx, q, args...)
+func (s *Store) ListEmails(ctx context.Context, r *http.Request, f EmailFilter, limit, offset int) ([]Email, *int, error) {
+	if f.Topic != "" {
+		f.IDs = s.topicEmailIDs(f.Topic)
+	}
+	src, next, err := s.source.ListEmails(ctx, f, limit, offset)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer rows.Close()
+	ids := make([]string, len(src))
+	for i := range src {
+		ids[i] = src[i].ID
+	}
+	totals, _ := s.StatsTotals(ctx, ids)
+	out := make([]Email, 0, len(src))
+	for i := range src {
+		out = append(out, s.buildEmail(ctx, r, &src[i], true, totals))
+	}
+	return out, next, nil
+}
 
-	out := make([]Email, 0, limit)
-	for rows.Next() {
-		var e Email
-		var sentAt *time.Time
-		var mlName, mlDesc, mlColor string
-		var clicks, warehouseOpens int64
-		var html, md *string
-		var aiSlug, excerpt *string
-		if err := rows.Scan(
-			&e.ID, &e.Subject, &sentAt, &e.MailingListID,
-			&mlName, &mlDesc, &mlColor,
-			&clicks, &warehouseOpens,
-			&html, &md, &aiSlug, &excerpt,
-		); err != nil {
-			return nil, nil, err
-		}
-		e.SentAt = sentAt
-		e.MailingListRef = ListRef{
-			ID:          e.MailingListID,
-			Slug:        slugify(mlName),
-			Name:        mlName,
-			Description: mlDesc,
-			Color:       mlColor,
+// EachEmail streams published emails from offset on to fn, in ListEmails
+// order, building each as it's read.
+func (s *Store) EachEmail(ctx context.Context, r *http.Request, f EmailFilter, offset int, fn func(*Email) error) error {
+	totals, _ := s.StatsTotals(ctx, nil)
+	if f.Topic != "" {
+		f.IDs = s.topicEmailIDs(f.Topic)
+	}
+	return s.source.EachEmail(ctx, f, offset, func(src *SourceEmail) error {
+		e := s.buildEmail(ctx, r, src, true, totals)
+		return fn(&e)
+	})
+}
+
+// GetEmail fetches a single campaign by ID. Unless preview is set it must be
+// published; previews also match unsent drafts, skip link rewriting (so
+// editors' clicks aren't tracked) and tolerate a missing mailing list.
+func (s *Store) GetEmail(ctx context.Context, r *http.Request, id string, preview bool) (*Email, error) {
+	src, err := s.source.GetEmail(ctx, id, preview)
+	if err != nil {
+		return nil, err
+	}
+	e := s.buildEmail(ctx, r, src, !preview, nil)
+	return &e, nil
+}
+
+// RequireEmail returns errNotFound unless id is a published email, so
+// per-email analytics 404 like the email itself rather than reporting zeros.
+func (s *Store) RequireEmail(ctx context.Context, id string) error {
+	_, err := s.source.GetEmail(ctx, id, false)
+	return err
+}
+
+// buildEmail turns a source email into the API shape, adding our tracked
+// stats, byline, series, images and rewritten links. Tracked stats and
+// likes come from totals when it has the email (see StatsTotals), else are
+// counted.
+func (s *Store) buildEmail(ctx context.Context, r *http.Request, src *SourceEmail, rewriteLinks bool, totals map[string]StatsTotal) Email {
+	e := Email{
+		ID:            src.ID,
+		Subject:       src.Subject,
+		SentAt:        src.SentAt,
+		UpdatedAt:     src.UpdatedAt,
+		MailingListID: src.MailingList.ID,
+	}
+	e.MailingListRef = src.MailingList
+	e.MailingListRef.Slug = slugify(src.MailingList.Name)
+	e.MailingListRef.LogoURL = s.ListLogoURL(e.MailingListID)
+
+	tracked, ok := totals[e.ID]
+	if !ok {
+		tracked.Views, _ = s.GetMetricsViewCount(ctx, e.ID)
+		tracked.Clicks, _ = s.GetMetricsClickCount(ctx, e.ID)
+		tracked.MedianReadSeconds, _ = s.GetMedianReadSeconds(ctx, e.ID)
+		tracked.Likes, _ = s.GetLikeCount(ctx, e.ID)
+	}
+
+	e.Stats = EmailStats{
+		Clicks:            src.Clicks + tracked.Clicks,
+		Views:             src.Opens + tracked.Views,
+		Likes:             tracked.Likes,
+		MedianReadSeconds: tracked.MedianReadSeconds,
+	}
+
+	html := src.HTML
+	if html != nil && *html != "" {
+		if resolved, err := s.resolveRelativeURLs(*html); err == nil {
+			html = &resolved
 		}
-		
-		metricsViews, _ := s.GetMetricsViewCount(ctx, e.ID)
-		
-		metricsClicks, _ := s.GetMetricsClickCount(ctx, e.ID)
-		
-		e.Stats = EmailStats{
-			Clicks: clicks + metricsClicks,
-			Views:  warehouseOpens + metricsViews,
+		if stripped, err := stripTrackers(*html, publicBaseURL(r, s.publicBase)); err == nil {
+			html = &stripped
 		}
-		
-		if html != nil && *html != "" {
-			rewritten, err := rewriteEmailLinks(r, e.ID, *html)
-			if err == nil {
-				e.HTML = &rewritten
-			} else {
-				e.HTML = html
+	}
+	e.Sender = s.SenderName(e.ID, e.MailingListID)
+	e.Series = detectSeries(e.Subject)
+	e.Images = []EmailImage{}
+	if html != nil && *html != "" {
+		e.Images = extractImages(*html)
+		e.CoverImage = pickCoverImage(e.Images)
+		e.A11y = auditA11y(*html)
+	}
+
+	// Only what's published is snapshotted, and previews don't rewrite links.
+	if rewriteLinks {
+		s.SaveRevision(src)
+	}
+
+	if html != nil && *html != "" && rewriteLinks {
+		rewritten, links, err := rewriteEmailLinks(publicBaseURL(r, s.publicBase), e.ID, *html, s.shortLinks)
+		if err == nil {
+			e.HTML = &rewritten
+			e.links = links
+			s.SaveLinkMap(e.ID, links)
+			if responsive, err := s.responsiveImages(ctx, rewritten); err == nil {
+				e.HTML = &responsive
 			}
 		} else {
 			e.HTML = html
 		}
-		e.Markdown = md
-		e.Excerpt = excerpt
-		if aiSlug != nil && *aiSlug != "" {
-			e.Slug = *aiSlug
-		} else {
-			e.Slug = slugify(e.Subject)
-			if e.Slug == "" {
-				e.Slug = e.ID
-			}
+	} else {
+		e.HTML = html
+	}
+	e.Markdown = src.Markdown
+	e.Excerpt = src.Excerpt
+	s.applyEnrichment(&e, src)
+	e.Slug = emailSlug(src.Slug, e.Subject, e.ID)
+
+	if e.Markdown != nil && *e.Markdown != "" {
+		preview := strings.TrimSpace(*e.Markdown)
+		if len(preview) > 200 {
+			preview = preview[:200]
 		}
-
-		if e.Markdown != nil && *e.Markdown != "" {
-			preview := strings.TrimSpace(*e.Markdown)
-			if len(preview) > 200 {
-				preview = preview[:200]
-			}
-			e.PreviewText = &preview
-		} else if e.HTML != nil && *e.HTML != "" {
-			preview := stripTags(*e.HTML)
-			if len(preview) > 200 {
-				preview = preview[:200]
-			}
-			e.PreviewText = &preview
+		e.PreviewText = &preview
+	} else if e.HTML != nil && *e.HTML != "" {
+		preview := stripTags(*e.HTML)
+		if len(preview) > 200 {
+			preview = preview[:200]
 		}
+		e.PreviewText = &preview
+	}
+	return e
+}
 
-		out = append(out, e)
+// emailSlug prefers the provider's slug, then the subject's, then the ID.
+func emailSlug(slug, subject, id string) string {
+	if slug != "" {
+		return slug
 	}
-	var next *int
-	if len(out) == limit {
-		n := offset + limit
-		next = &n
+	if slug = slugify(subject); slug != "" {
+		return slug
 	}
-	return out, next, rows.Err()
+	return id
 }
 
 var scriptStyleRegex = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
 
-func rewriteEmailLinks(r *http.Request, emailID string, html string) (string, error) {
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
-	if err != nil {
-		return html, err
+// publicBaseURL is the origin for absolute URLs we emit: PUBLIC_BASE_URL when
+// configured, else derived from the request (fine for local development, but
+// the Host header is client-controlled and isn't part of cache keys).
+func publicBaseURL(r *http.Request, configured string) string {
+	if configured != "" {
+		return configured
 	}
-	
-	// Determine scheme (http or https)
+
+	// Forwarded headers only survive trustProxyRealIP from trusted proxies.
 	scheme := "http"
-	if r.TLS != nil {
+	if isHTTPS(r) {
 		scheme = "https"
 	}
-	// Check X-Forwarded-Proto header (for reverse proxies)
-	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
-		scheme = proto
-	}
-	
-	// Get host from request
 	host := r.Host
-	
-	// Build base URL
-	baseURL := fmt.Sprintf("%s://%s", scheme, host)
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+// rewriteEmailLinks routes links through the click tracker, as short links
+// when short is set, and returns where each tracked link sits in the email
+// (see links.go).
+func rewriteEmailLinks(baseURL string, emailID string, html string, short bool) (string, []EmailLink, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return html, nil, err
+	}
 	
 	linkIndex := 0
+	tracked := map[*nethtml.Node]EmailLink{}
 	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
 		href, exists := s.Attr("href")
 		if !exists {
@@ -523,17 +1037,23 @@ func rewriteEmailLinks(r *http.Request, emailID string, html string) (string, er
 		if strings.HasPrefix(href, "mailto:") || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "tel:") {
 			return
 		}
+		href = unwrapTrackingURL(href)
 		
+		code := shortlinkCode(emailID, linkIndex, href)
 		newURL := fmt.Sprintf("%s/emails/%s/click/%d?url=%s", baseURL, emailID, linkIndex, url.QueryEscape(href))
+		if short {
+			newURL = baseURL + "/l/" + code
+		}
 		s.SetAttr("href", newURL)
+		tracked[s.Nodes[0]] = EmailLink{Index: linkIndex, URL: href, Code: code}
 		linkIndex++
 	})
 	
 	rewritten, err := doc.Html()
 	if err != nil {
-		return html, err
+		return html, nil, err
 	}
-	return rewritten, nil
+	return rewritten, linkPositions(doc, tracked), nil
 }
 
 func stripTags(s string) string {
@@ -556,116 +1076,597 @@ func stripTags(s string) string {
 	return strings.Join(strings.Fields(b.String()), " ")
 }
 
-func (s *Store) TrackEmailView(ctx context.Context, sessionID, emailID string) error {
-	if s.metricsPool == nil {
-		return nil
-	}
-	
-	// Check if this session already viewed this email in the last 5 minutes
-	var exists bool
-	err := s.metricsPool.QueryRow(ctx, `
-		SELECT EXISTS(
-			SELECT 1 FROM email_views
-			WHERE session_id = $1
-			  AND email_id = $2
-			  AND time > NOW() - INTERVAL '5 minutes'
-			LIMIT 1
-		)
-	`, sessionID, emailID).Scan(&exists)
-	
-	if err != nil {
-		return err
-	}
-	
-	// Only insert if not already viewed in last 5 minutes
-	if !exists {
-		_, err = s.metricsPool.Exec(ctx, `
-			INSERT INTO email_views (session_id, email_id)
-			VALUES ($1, $2)
-		`, sessionID, emailID)
-		return err
-	}
-	
-	return nil
+// Tracking events are versioned so new fields can be added as nullable
+// columns without disturbing existing aggregates: writers stamp the current
+// version, and readers of a field filter on the version that introduced it.
+const (
+	trackingSchemaV1 = 1 // session, email (+ link for clicks)
+	trackingSchemaV2 = 2 // + referrer origin (views), device class, browser family
+	trackingSchemaV3 = 3 // + region of the instance that recorded the event
+
+	trackingSchemaVersion = trackingSchemaV3
+)
+
+type ViewEvent struct {
+	Time      time.Time
+	SessionID string
+	EmailID   string
+	PageKey   string  // set instead of EmailID for non-email pages
+	Referrer  *string // normalized origin, v2+
+	Device    DeviceInfo
 }
 
-func (s *Store) TrackLinkClick(ctx context.Context, sessionID, emailID, linkURL string, linkIndex int) error {
-	if s.metricsPool == nil {
+type ClickEvent struct {
+	Time      time.Time
+	SessionID string
+	EmailID   string
+	LinkURL   string
+	LinkIndex int
+	Device    DeviceInfo
+}
+
+// InsertViewEvents writes a batch of email views, dropping any that repeat a
+// session+email seen within the previous 5 minutes (in the DB or the batch).
+// It returns the email IDs that received new rows.
+func (s *Store) InsertViewEvents(ctx context.Context, events []ViewEvent) ([]string, error) {
+	return s.insertViews(ctx, "email_views", "email_id", events, func(ev ViewEvent) string { return ev.EmailID })
+}
+
+// InsertPageViewEvents is InsertViewEvents for non-email pages, keyed by
+// ViewEvent.PageKey.
+func (s *Store) InsertPageViewEvents(ctx context.Context, events []ViewEvent) ([]string, error) {
+	return s.insertViews(ctx, "page_views", "page_key", events, func(ev ViewEvent) string { return ev.PageKey })
+}
+
+// insertViews implements the shared session/5-minute dedup insert for view
+// tables. table and keyCol are trusted identifiers, never user input.
+func (s *Store) insertViews(ctx context.Context, table, keyCol string, events []ViewEvent, keyOf func(ViewEvent) string) ([]string, error) {
+	if s.metricsPool == nil || len(events) == 0 {
+		return nil, nil
+	}
+
+	n := len(events)
+	times := make([]time.Time, n)
+	sessions := make([]string, n)
+	keys := make([]string, n)
+	referrers := make([]*string, n)
+	devices := make([]string, n)
+	browsers := make([]string, n)
+	for i, ev := range events {
+		times[i] = ev.Time
+		sessions[i] = s.hashSession(ev.SessionID, ev.Time)
+		keys[i] = keyOf(ev)
+		referrers[i] = ev.Referrer
+		devices[i] = ev.Device.Class
+		browsers[i] = ev.Device.Browser
+	}
+
+	rows, err := s.metricsPool.Query(ctx, fmt.Sprintf(`
+		INSERT INTO %[1]s (schema_version, region, session_hashed, time, session_id, %[2]s, referrer, device_class, browser_family)
+		SELECT DISTINCT ON (e.session_id, e.key)
+		       $1::smallint, NULLIF($8, ''), NULLIF($9::boolean, false), e.time, e.session_id, e.key, e.referrer, e.device_class, e.browser_family
+		FROM unnest($2::timestamptz[], $3::text[], $4::text[], $5::text[], $6::text[], $7::text[])
+		     AS e(time, session_id, key, referrer, device_class, browser_family)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM %[1]s v
+			WHERE v.session_id = e.session_id
+			  AND v.%[2]s = e.key
+			  AND v.time > e.time - INTERVAL '5 minutes'
+		)
+		ORDER BY e.session_id, e.key, e.time
+		ON CONFLICT DO NOTHING
+		RETURNING %[2]s
+	`, table, keyCol), trackingSchemaVersion, times, sessions, keys, referrers, devices, browsers, s.region, s.sessions != nil)
+	if err != nil {
+		return nil, err
+	}
+	return collectKeys(rows)
+}
+
+// InsertClickEvents is InsertViewEvents for link clicks, deduplicated per
+// session+email+link.
+func (s *Store) InsertClickEvents(ctx context.Context, events []ClickEvent) ([]string, error) {
+	if s.metricsPool == nil || len(events) == 0 {
+		return nil, nil
+	}
+
+	n := len(events)
+	times := make([]time.Time, n)
+	sessions := make([]string, n)
+	emails := make([]string, n)
+	urls := make([]string, n)
+	indexes := make([]int32, n)
+	devices := make([]string, n)
+	browsers := make([]string, n)
+	for i, ev := range events {
+		times[i] = ev.Time
+		sessions[i] = s.hashSession(ev.SessionID, ev.Time)
+		emails[i] = ev.EmailID
+		urls[i] = ev.LinkURL
+		indexes[i] = int32(ev.LinkIndex)
+		devices[i] = ev.Device.Class
+		browsers[i] = ev.Device.Browser
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		INSERT INTO email_link_clicks (schema_version, region, session_hashed, time, session_id, email_id, link_url, link_index, device_class, browser_family)
+		SELECT DISTINCT ON (e.session_id, e.email_id, e.link_index)
+		       $1::smallint, NULLIF($9, ''), NULLIF($10::boolean, false), e.time, e.session_id, e.email_id, e.link_url, e.link_index, e.device_class, e.browser_family
+		FROM unnest($2::timestamptz[], $3::text[], $4::text[], $5::text[], $6::int[], $7::text[], $8::text[])
+		     AS e(time, session_id, email_id, link_url, link_index, device_class, browser_family)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM email_link_clicks c
+			WHERE c.session_id = e.session_id
+			  AND c.email_id = e.email_id
+			  AND c.link_index = e.link_index
+			  AND c.time > e.time - INTERVAL '5 minutes'
+		)
+		ORDER BY e.session_id, e.email_id, e.link_index, e.time
+		ON CONFLICT DO NOTHING
+		RETURNING email_id
+	`, trackingSchemaVersion, times, sessions, emails, urls, indexes, devices, browsers, s.region, s.sessions != nil)
+	if err != nil {
+		return nil, err
+	}
+	return collectKeys(rows)
+}
+
+// RUMEvent is one anonymous web-vitals sample. Only the page slug is kept;
+// no session, IP, or full URL.
+type RUMEvent struct {
+	Time        time.Time
+	Page        string
+	Metric      string
+	Value       float64
+	DeviceClass string
+}
+
+func (s *Store) InsertRUMEvents(ctx context.Context, events []RUMEvent) error {
+	if s.metricsPool == nil || len(events) == 0 {
 		return nil
 	}
-	
-	// Check if this session already clicked this link in the last 5 minutes
-	var exists bool
-	err := s.metricsPool.QueryRow(ctx, `
-		SELECT EXISTS(
-			SELECT 1 FROM email_link_clicks
-			WHERE session_id = $1
-			  AND email_id = $2
-			  AND link_index = $3
-			  AND time > NOW() - INTERVAL '5 minutes'
-			LIMIT 1
+
+	n := len(events)
+	times := make([]time.Time, n)
+	pages := make([]string, n)
+	metrics := make([]string, n)
+	values := make([]float64, n)
+	devices := make([]string, n)
+	for i, ev := range events {
+		times[i] = ev.Time
+		pages[i] = ev.Page
+		metrics[i] = ev.Metric
+		values[i] = ev.Value
+		devices[i] = ev.DeviceClass
+	}
+
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO rum_vitals (time, page, metric, value, device_class, region)
+		SELECT e.*, NULLIF($6, '')
+		FROM unnest($1::timestamptz[], $2::text[], $3::text[], $4::float8[], $5::text[]) AS e
+	`, times, pages, metrics, values, devices, s.region)
+	return err
+}
+
+type RUMSummary struct {
+	Metric  string  `json:"metric"`
+	Samples int64   `json:"samples"`
+	P50     float64 `json:"p50"`
+	P75     float64 `json:"p75"`
+	P95     float64 `json:"p95"`
+}
+
+func (s *Store) GetRUMSummary(ctx context.Context, page string, since time.Time) ([]RUMSummary, error) {
+	out := []RUMSummary{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT metric, COUNT(*),
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY value),
+		       percentile_cont(0.75) WITHIN GROUP (ORDER BY value),
+		       percentile_cont(0.95) WITHIN GROUP (ORDER BY value)
+		FROM rum_vitals
+		WHERE time >= $1 AND ($2 = '' OR page = $2)
+		GROUP BY metric
+		ORDER BY metric
+	`, since, page)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rs RUMSummary
+		if err := rows.Scan(&rs.Metric, &rs.Samples, &rs.P50, &rs.P75, &rs.P95); err != nil {
+			return nil, err
+		}
+		out = append(out, rs)
+	}
+	return out, rows.Err()
+}
+
+type RUMBucket struct {
+	Bucket  time.Time `json:"bucket"`
+	Samples int64     `json:"samples"`
+	P75     float64   `json:"p75"`
+	Views   int64     `json:"views"` // email views recorded in the same hour
+}
+
+// GetRUMTimeseries returns hourly p75 for one metric alongside total email
+// views, so performance regressions can be lined up with traffic spikes.
+func (s *Store) GetRUMTimeseries(ctx context.Context, metric, page string, since time.Time) ([]RUMBucket, error) {
+	out := []RUMBucket{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH r AS (
+			SELECT date_trunc('hour', time) AS bucket, COUNT(*) AS samples,
+			       percentile_cont(0.75) WITHIN GROUP (ORDER BY value) AS p75
+			FROM rum_vitals
+			WHERE metric = $1 AND time >= $2 AND ($3 = '' OR page = $3)
+			GROUP BY 1
+		), v AS (
+			SELECT date_trunc('hour', time) AS bucket, COUNT(*) AS views
+			FROM email_views
+			WHERE time >= $2
+			GROUP BY 1
 		)
-	`, sessionID, emailID, linkIndex).Scan(&exists)
-	
+		SELECT r.bucket, r.samples, r.p75, COALESCE(v.views, 0)
+		FROM r LEFT JOIN v ON v.bucket = r.bucket
+		ORDER BY r.bucket
+	`, metric, since, page)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	
-	// Only insert if not already clicked in last 5 minutes
-	if !exists {
-		_, err = s.metricsPool.Exec(ctx, `
-			INSERT INTO email_link_clicks (session_id, email_id, link_url, link_index)
-			VALUES ($1, $2, $3, $4)
-		`, sessionID, emailID, linkURL, linkIndex)
-		return err
+	defer rows.Close()
+
+	for rows.Next() {
+		var b RUMBucket
+		if err := rows.Scan(&b.Bucket, &b.Samples, &b.P75, &b.Views); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
 	}
-	
-	return nil
+	return out, rows.Err()
 }
 
-func (s *Store) GetMetricsViewCount(ctx context.Context, emailID string) (int64, error) {
+func collectKeys(rows pgx.Rows) ([]string, error) {
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+type ReferrerCount struct {
+	Origin   string `json:"origin"`   // scheme://host, or "direct" when none was sent
+	Category string `json:"category"` // site, social, search, direct, other
+	Views    int64  `json:"views"`
+}
+
+func (s *Store) GetReferrerBreakdown(ctx context.Context, emailID string) ([]ReferrerCount, error) {
+	out := []ReferrerCount{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT referrer, COUNT(DISTINCT session_id)
+		FROM email_views
+		WHERE email_id = $1 AND schema_version >= $2
+		GROUP BY referrer
+		ORDER BY 2 DESC
+	`, emailID, trackingSchemaV2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var origin *string
+		var views int64
+		if err := rows.Scan(&origin, &views); err != nil {
+			return nil, err
+		}
+		rc := ReferrerCount{Origin: "direct", Category: "direct", Views: views}
+		if origin != nil && *origin != "" {
+			rc.Origin = *origin
+			rc.Category = referrerCategory(*origin)
+		}
+		out = append(out, rc)
+	}
+	return out, rows.Err()
+}
+
+type DeviceCount struct {
+	Device  string `json:"device"`
+	Browser string `json:"browser"`
+	Views   int64  `json:"views"`
+	Clicks  int64  `json:"clicks"`
+}
+
+func (s *Store) GetDeviceBreakdown(ctx context.Context, emailID string) ([]DeviceCount, error) {
+	out := []DeviceCount{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH v AS (
+			SELECT COALESCE(device_class, 'unknown') AS device,
+			       COALESCE(browser_family, 'unknown') AS browser,
+			       COUNT(DISTINCT session_id) AS views
+			FROM email_views
+			WHERE email_id = $1 AND schema_version >= $2
+			GROUP BY 1, 2
+		), c AS (
+			SELECT COALESCE(device_class, 'unknown') AS device,
+			       COALESCE(browser_family, 'unknown') AS browser,
+			       COUNT(DISTINCT (session_id, link_index)) AS clicks
+			FROM email_link_clicks
+			WHERE email_id = $1 AND schema_version >= $2
+			GROUP BY 1, 2
+		)
+		SELECT COALESCE(v.device, c.device), COALESCE(v.browser, c.browser),
+		       COALESCE(v.views, 0), COALESCE(c.clicks, 0)
+		FROM v FULL OUTER JOIN c ON c.device = v.device AND c.browser = v.browser
+		ORDER BY 3 DESC, 4 DESC
+	`, emailID, trackingSchemaV2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dc DeviceCount
+		if err := rows.Scan(&dc.Device, &dc.Browser, &dc.Views, &dc.Clicks); err != nil {
+			return nil, err
+		}
+		out = append(out, dc)
+	}
+	return out, rows.Err()
+}
+
+type RegionCount struct {
+	Region string `json:"region"`
+	Views  int64  `json:"views"`
+	Clicks int64  `json:"clicks"`
+}
+
+// GetRegionBreakdown splits an email's unique views and clicks by the region
+// of the instance that recorded them.
+func (s *Store) GetRegionBreakdown(ctx context.Context, emailID string) ([]RegionCount, error) {
+	out := []RegionCount{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH v AS (
+			SELECT COALESCE(region, 'unknown') AS region, COUNT(DISTINCT session_id) AS views
+			FROM email_views
+			WHERE email_id = $1 AND schema_version >= $2
+			GROUP BY 1
+		), c AS (
+			SELECT COALESCE(region, 'unknown') AS region, COUNT(DISTINCT (session_id, link_index)) AS clicks
+			FROM email_link_clicks
+			WHERE email_id = $1 AND schema_version >= $2
+			GROUP BY 1
+		)
+		SELECT COALESCE(v.region, c.region), COALESCE(v.views, 0), COALESCE(c.clicks, 0)
+		FROM v FULL OUTER JOIN c ON c.region = v.region
+		ORDER BY 2 DESC, 3 DESC
+	`, emailID, trackingSchemaV3)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rc RegionCount
+		if err := rows.Scan(&rc.Region, &rc.Views, &rc.Clicks); err != nil {
+			return nil, err
+		}
+		out = append(out, rc)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) GetPageViewCount(ctx context.Context, pageKey string) (int64, error) {
 	if s.metricsPool == nil {
 		return 0, nil
 	}
-	
+
 	var count int64
 	err := s.metricsPool.QueryRow(ctx, `
 		SELECT COUNT(DISTINCT session_id)
-		FROM email_views
+		FROM page_views
+		WHERE page_key = $1
+	`, pageKey).Scan(&count)
+	return count, err
+}
+
+type PageCount struct {
+	Key   string `json:"key"`
+	Views int64  `json:"views"`
+}
+
+// GetTopPages ranks pages and emails together by unique sessions since the
+// given time. Emails appear under the key "email:<id>".
+func (s *Store) GetTopPages(ctx context.Context, since time.Time, limit int) ([]PageCount, error) {
+	out := []PageCount{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT key, COUNT(DISTINCT session_id) AS views
+		FROM (
+			SELECT page_key AS key, session_id FROM page_views WHERE time >= $1
+			UNION ALL
+			SELECT 'email:' || email_id, session_id FROM email_views WHERE time >= $1
+		) t
+		GROUP BY key
+		ORDER BY views DESC, key
+		LIMIT $2
+	`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pc PageCount
+		if err := rows.Scan(&pc.Key, &pc.Views); err != nil {
+			return nil, err
+		}
+		out = append(out, pc)
+	}
+	return out, rows.Err()
+}
+
+type NextRead struct {
+	EmailID  string `json:"email_id"`
+	Sessions int64  `json:"sessions"`
+}
+
+// GetNextReads returns the emails most often read next (within a day) by
+// sessions that viewed emailID. Transitions seen by fewer than minSessions
+// sessions are suppressed so no individual reading path is exposed.
+func (s *Store) GetNextReads(ctx context.Context, emailID string, since time.Time, minSessions, limit int) ([]NextRead, error) {
+	out := []NextRead{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH readers AS (
+			SELECT DISTINCT session_id FROM email_views
+			WHERE email_id = $1 AND time >= $2
+		), seq AS (
+			SELECT v.session_id, v.email_id, v.time,
+			       LEAD(v.email_id) OVER w AS next_email,
+			       LEAD(v.time) OVER w AS next_time
+			FROM email_views v
+			JOIN readers r ON r.session_id = v.session_id
+			WHERE v.time >= $2
+			WINDOW w AS (PARTITION BY v.session_id ORDER BY v.time)
+		)
+		SELECT next_email, COUNT(DISTINCT session_id) AS sessions
+		FROM seq
 		WHERE email_id = $1
-	`, emailID).Scan(&count)
-	
-	if err != nil && err.Error() != "no rows in result set" {
+		  AND next_email IS NOT NULL
+		  AND next_email <> $1
+		  AND next_time - time < INTERVAL '1 day'
+		GROUP BY next_email
+		HAVING COUNT(DISTINCT session_id) >= $3
+		ORDER BY sessions DESC, next_email
+		LIMIT $4
+	`, emailID, since, minSessions, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nr NextRead
+		if err := rows.Scan(&nr.EmailID, &nr.Sessions); err != nil {
+			return nil, err
+		}
+		out = append(out, nr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return s.filterPublished(ctx, out)
+}
+
+// filterPublished drops transitions to campaigns that aren't (or are no
+// longer) publishable, since the metrics DB knows nothing about publishing.
+func (s *Store) filterPublished(ctx context.Context, reads []NextRead) ([]NextRead, error) {
+	if len(reads) == 0 {
+		return reads, nil
+	}
+	ids := make([]string, len(reads))
+	for i, nr := range reads {
+		ids[i] = nr.EmailID
+	}
+	overrides, args := s.emailOverrides().join([]any{ids})
+	rows, err := s.content().Query(ctx, `
+		SELECT c.id FROM loops.campaigns c
+		JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		`+overrides+`
+		`+publishedEmailsWhere+` AND c.id = ANY($1)
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	published, err := collectKeys(rows)
+	if err != nil {
+		return nil, err
+	}
+	ok := make(map[string]bool, len(published))
+	for _, id := range published {
+		ok[id] = true
+	}
+	out := reads[:0]
+	for _, nr := range reads {
+		if ok[nr.EmailID] {
+			out = append(out, nr)
+		}
+	}
+	return out, nil
+}
+
+func (s *Store) GetMetricsViewCount(ctx context.Context, emailID string) (int64, error) {
+	if s.metricsPool == nil {
 		return 0, nil
 	}
-	
-	return count, nil
+
+	return s.hotCount(ctx, "views", emailID, `
+		SELECT COUNT(DISTINCT session_id)
+		FROM email_views
+		WHERE email_id = $1
+	`, `
+		SELECT COALESCE(distinct_count(hyperloglog(32768, session_id)), 0)
+		FROM email_views
+		WHERE email_id = $1
+	`)
 }
 
 func (s *Store) GetMetricsClickCount(ctx context.Context, emailID string) (int64, error) {
 	if s.metricsPool == nil {
 		return 0, nil
 	}
-	
-	var count int64
-	err := s.metricsPool.QueryRow(ctx, `
+
+	return s.hotCount(ctx, "clicks", emailID, `
 		SELECT COUNT(DISTINCT (session_id, link_index))
 		FROM email_link_clicks
 		WHERE email_id = $1
-	`, emailID).Scan(&count)
-	
-	if err != nil && err.Error() != "no rows in result set" {
-		return 0, nil
-	}
-	
-	return count, nil
+	`, `
+		SELECT COALESCE(distinct_count(hyperloglog(32768, session_id || ' ' || link_index)), 0)
+		FROM email_link_clicks
+		WHERE email_id = $1
+	`)
 }
 
 func (s *Store) GetEmailViewCount(ctx context.Context, emailID string) (int64, error) {
 	metricsCount, _ := s.GetMetricsViewCount(ctx, emailID)
 	
+	if s.pool == nil {
+		return metricsCount, nil
+	}
 	var warehouseOpens int64
-	err := s.pool.QueryRow(ctx, `
+	err := s.content().QueryRow(ctx, `
 		SELECT COALESCE(opens, 0)
 		FROM loops.campaigns
 		WHERE id = $1
@@ -681,16 +1682,509 @@ func (s *Store) GetEmailViewCount(ctx context.Context, emailID string) (int64, e
 	return metricsCount + warehouseOpens, nil
 }
 
+// ---------- Dependency Health ----------
+
+const (
+	depWarehouse = "warehouse"
+	depMetrics   = "metrics"
+)
+
+// HealthEvent is one dependency state transition.
+type HealthEvent struct {
+	Time       time.Time `json:"time"`
+	Dependency string    `json:"dependency"`
+	State      string    `json:"state"` // degraded, recovered
+	Error      string    `json:"error,omitempty"`
+}
+
+type DependencyHealth struct {
+	Degraded      bool       `json:"degraded"`
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+	Failures      int64      `json:"failures"` // total since start
+	LastError     string     `json:"-"`
+}
+
+// HealthTracker records dependency failures observed on real traffic, so a
+// down metrics DB shows up as "degraded" rather than as silently low counts.
+// Transitions are logged and kept in a short in-memory event log.
+type HealthTracker struct {
+	mu      sync.Mutex
+	deps    map[string]*DependencyHealth
+	events  []HealthEvent
+	onEvent func(HealthEvent) // optional; called for every transition, must not block
+}
+
+const healthEventLogSize = 100
+
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{deps: make(map[string]*DependencyHealth)}
+}
+
+func (h *HealthTracker) dep(name string) *DependencyHealth {
+	d, ok := h.deps[name]
+	if !ok {
+		d = &DependencyHealth{}
+		h.deps[name] = d
+	}
+	return d
+}
+
+func (h *HealthTracker) record(ev HealthEvent) {
+	log.Printf("health: dependency=%s state=%s error=%q", ev.Dependency, ev.State, ev.Error)
+	h.events = append(h.events, ev)
+	if len(h.events) > healthEventLogSize {
+		h.events = h.events[len(h.events)-healthEventLogSize:]
+	}
+	if h.onEvent != nil {
+		h.onEvent(ev)
+	}
+}
+
+func (h *HealthTracker) Fail(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.dep(name)
+	d.Failures++
+	d.LastError = err.Error()
+	if !d.Degraded {
+		now := time.Now().UTC()
+		d.Degraded = true
+		d.DegradedSince = &now
+		h.record(HealthEvent{Time: now, Dependency: name, State: "degraded", Error: d.LastError})
+	}
+}
+
+func (h *HealthTracker) OK(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	d := h.dep(name)
+	if d.Degraded {
+		d.Degraded = false
+		d.DegradedSince = nil
+		h.record(HealthEvent{Time: time.Now().UTC(), Dependency: name, State: "recovered"})
+	}
+}
+
+// Degraded lists dependencies currently failing, sorted.
+func (h *HealthTracker) Degraded() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var out []string
+	for name, d := range h.deps {
+		if d.Degraded {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (h *HealthTracker) Snapshot() map[string]DependencyHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[string]DependencyHealth, len(h.deps))
+	for name, d := range h.deps {
+		out[name] = *d
+	}
+	return out
+}
+
+func (h *HealthTracker) Events() []HealthEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]HealthEvent(nil), h.events...)
+}
+
+// observe feeds a query result into the health tracker and returns err.
+// Client cancellations say nothing about the dependency and are ignored.
+func (s *Store) observe(dep string, err error) error {
+	switch {
+	case err == nil:
+		s.health.OK(dep)
+	case errors.Is(err, context.Canceled):
+	default:
+		s.health.Fail(dep, err)
+	}
+	return err
+}
+
+// ResponseMeta annotates list responses; omitted when everything is healthy.
+type ResponseMeta struct {
+	Degraded []string `json:"degraded,omitempty"` // dependencies failing while building this response
+}
+
+func (s *Store) responseMeta() *ResponseMeta {
+	if d := s.health.Degraded(); len(d) > 0 {
+		return &ResponseMeta{Degraded: d}
+	}
+	return nil
+}
+
+// ---------- Async Metrics Writer ----------
+
+// MetricsWriter buffers tracking events and batch-inserts them from a single
+// background goroutine so tracking endpoints never wait on the metrics DB.
+// When the buffer is full new events are dropped rather than blocking.
+type MetricsWriter struct {
+	store    *Store
+	onInsert func(emailID string)
+	views    chan ViewEvent
+	clicks   chan ClickEvent
+	rum      chan RUMEvent
+	scrolls  chan ScrollEvent
+	reads    chan ReadEvent
+	dropped  atomic.Int64
+	closeC   chan struct{}
+	doneC    chan struct{}
+}
+
+const (
+	metricsBatchSize     = 500
+	metricsFlushInterval = 1 * time.Second
+)
+
+func NewMetricsWriter(store *Store, bufferSize int, onInsert func(emailID string)) *MetricsWriter {
+	mw := &MetricsWriter{
+		store:    store,
+		onInsert: onInsert,
+		views:    make(chan ViewEvent, bufferSize),
+		clicks:   make(chan ClickEvent, bufferSize),
+		rum:      make(chan RUMEvent, bufferSize),
+		scrolls:  make(chan ScrollEvent, bufferSize),
+		reads:    make(chan ReadEvent, bufferSize),
+		closeC:   make(chan struct{}),
+		doneC:    make(chan struct{}),
+	}
+	go mw.run()
+	return mw
+}
+
+func (mw *MetricsWriter) TrackView(ev ViewEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case mw.views <- ev:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+func (mw *MetricsWriter) TrackClick(ev ClickEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case mw.clicks <- ev:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+func (mw *MetricsWriter) TrackRUM(ev RUMEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case mw.rum <- ev:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+func (mw *MetricsWriter) TrackScroll(ev ScrollEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case mw.scrolls <- ev:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+func (mw *MetricsWriter) TrackRead(ev ReadEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	select {
+	case mw.reads <- ev:
+	default:
+		mw.dropped.Add(1)
+	}
+}
+
+// Close flushes everything still buffered and stops the writer. Callers must
+// not track further events afterwards.
+func (mw *MetricsWriter) Close() {
+	close(mw.closeC)
+	<-mw.doneC
+}
+
+func (mw *MetricsWriter) run() {
+	defer close(mw.doneC)
+	ticker := time.NewTicker(metricsFlushInterval)
+	defer ticker.Stop()
+
+	views := make([]ViewEvent, 0, metricsBatchSize)
+	clicks := make([]ClickEvent, 0, metricsBatchSize)
+	rum := make([]RUMEvent, 0, metricsBatchSize)
+	scrolls := make([]ScrollEvent, 0, metricsBatchSize)
+	reads := make([]ReadEvent, 0, metricsBatchSize)
+	flush := func() {
+		if len(views) > 0 {
+			mw.flushViews(views)
+			views = views[:0]
+		}
+		if len(clicks) > 0 {
+			mw.flushClicks(clicks)
+			clicks = clicks[:0]
+		}
+		if len(rum) > 0 {
+			mw.flushRUM(rum)
+			rum = rum[:0]
+		}
+		if len(scrolls) > 0 {
+			mw.flushScrolls(scrolls)
+			scrolls = scrolls[:0]
+		}
+		if len(reads) > 0 {
+			mw.flushReads(reads)
+			reads = reads[:0]
+		}
+		if n := mw.dropped.Swap(0); n > 0 {
+			log.Printf("metrics writer: buffer full, dropped %d events", n)
+			mw.store.alerts.Notify(Alert{
+				Severity: "warning",
+				Source:   "metrics-writer",
+				Title:    "tracking events dropped",
+				Message:  fmt.Sprintf("buffer full, dropped %d events", n),
+			})
+		}
+	}
+
+	for {
+		select {
+		case ev := <-mw.views:
+			views = append(views, ev)
+			if len(views) >= metricsBatchSize {
+				flush()
+			}
+		case ev := <-mw.clicks:
+			clicks = append(clicks, ev)
+			if len(clicks) >= metricsBatchSize {
+				flush()
+			}
+		case ev := <-mw.rum:
+			rum = append(rum, ev)
+			if len(rum) >= metricsBatchSize {
+				flush()
+			}
+		case ev := <-mw.scrolls:
+			scrolls = append(scrolls, ev)
+			if len(scrolls) >= metricsBatchSize {
+				flush()
+			}
+		case ev := <-mw.reads:
+			reads = append(reads, ev)
+			if len(reads) >= metricsBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-mw.closeC:
+			for {
+				select {
+				case ev := <-mw.views:
+					views = append(views, ev)
+				case ev := <-mw.clicks:
+					clicks = append(clicks, ev)
+				case ev := <-mw.rum:
+					rum = append(rum, ev)
+				case ev := <-mw.scrolls:
+					scrolls = append(scrolls, ev)
+				case ev := <-mw.reads:
+					reads = append(reads, ev)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (mw *MetricsWriter) flushViews(events []ViewEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var emailViews, pageViews []ViewEvent
+	for _, ev := range events {
+		if ev.PageKey != "" {
+			pageViews = append(pageViews, ev)
+		} else {
+			emailViews = append(emailViews, ev)
+		}
+	}
+	if _, err := mw.store.InsertPageViewEvents(ctx, pageViews); err != nil {
+		log.Printf("track page view error: %v (%d events lost)", err, len(pageViews))
+	}
+	ids, err := mw.store.InsertViewEvents(ctx, emailViews)
+	if err := mw.store.observe(depMetrics, err); err != nil {
+		log.Printf("track view error: %v (%d events lost)", err, len(emailViews))
+		return
+	}
+	mw.notify(ids)
+}
+
+func (mw *MetricsWriter) flushClicks(events []ClickEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ids, err := mw.store.InsertClickEvents(ctx, events)
+	if err := mw.store.observe(depMetrics, err); err != nil {
+		log.Printf("track click error: %v (%d events lost)", err, len(events))
+		return
+	}
+	mw.notify(ids)
+}
+
+func (mw *MetricsWriter) flushRUM(events []RUMEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := mw.store.InsertRUMEvents(ctx, events); err != nil {
+		log.Printf("rum insert error: %v (%d events lost)", err, len(events))
+	}
+}
+
+func (mw *MetricsWriter) flushScrolls(events []ScrollEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := mw.store.InsertScrollEvents(ctx, events)
+	if err := mw.store.observe(depMetrics, err); err != nil {
+		log.Printf("track scroll error: %v (%d events lost)", err, len(events))
+	}
+}
+
+func (mw *MetricsWriter) flushReads(events []ReadEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := mw.store.InsertReadEvents(ctx, events)
+	if err := mw.store.observe(depMetrics, err); err != nil {
+		log.Printf("track read time error: %v (%d events lost)", err, len(events))
+	}
+}
+
+func (mw *MetricsWriter) notify(emailIDs []string) {
+	if mw.onInsert == nil {
+		return
+	}
+	seen := make(map[string]bool, len(emailIDs))
+	for _, id := range emailIDs {
+		if !seen[id] {
+			seen[id] = true
+			mw.onInsert(id)
+		}
+	}
+}
+
 // ---------- View Notifier ----------
 
+// Notifier receives "this email's counts changed" signals from the metrics
+// writer. *ViewNotifier delivers them to this process's SSE subscribers;
+// *PGNotifier additionally shares them with other replicas.
+type Notifier interface {
+	Notify(emailID string)
+}
+
+// ViewChange is one firehose notification. Seq increases monotonically per
+// process; with the notifier's epoch it makes the SSE event ID (see
+// EventID).
+type ViewChange struct {
+	Seq     uint64
+	EmailID string
+	At      time.Time
+}
+
 type ViewNotifier struct {
 	mu          sync.RWMutex
 	subscribers map[string][]chan struct{}
+	firehose    map[chan ViewChange]struct{} // receive every change
+	epoch       string                       // random per process, so IDs from another replica or run aren't misread
+	seq         uint64
+	recent      []ViewChange // last viewChangeLogSize changes, for resuming firehose clients
+
+	// onNotify runs before subscribers are woken, so what they read next
+	// isn't memoized from before the change (see countMemo).
+	onNotify func(emailID string)
 }
 
+const viewChangeLogSize = 1024
+
 func NewViewNotifier() *ViewNotifier {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
 	return &ViewNotifier{
 		subscribers: make(map[string][]chan struct{}),
+		firehose:    make(map[chan ViewChange]struct{}),
+		epoch:       hex.EncodeToString(b),
+	}
+}
+
+// EventID is the SSE event ID of change seq: "<epoch>.<seq>".
+func (vn *ViewNotifier) EventID(seq uint64) string {
+	return vn.epoch + "." + strconv.FormatUint(seq, 10)
+}
+
+// SubscribeAll returns a channel that receives every email count change.
+// Sends never block, so a slow reader misses changes rather than stalling
+// tracking.
+func (vn *ViewNotifier) SubscribeAll() chan ViewChange {
+	vn.mu.Lock()
+	defer vn.mu.Unlock()
+	ch := make(chan ViewChange, 256)
+	vn.firehose[ch] = struct{}{}
+	return ch
+}
+
+// ChangesSince returns logged changes after the one with event ID id. ok
+// is false when id came from another replica or process lifetime, or is
+// older than the log, in which case the caller can't resume exactly.
+func (vn *ViewNotifier) ChangesSince(id string) (changes []ViewChange, ok bool) {
+	epoch, seqStr, _ := strings.Cut(id, ".")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || epoch != vn.epoch {
+		return nil, false
+	}
+	vn.mu.RLock()
+	defer vn.mu.RUnlock()
+	if seq > vn.seq || (len(vn.recent) > 0 && seq < vn.recent[0].Seq-1) {
+		return nil, false
+	}
+	for _, c := range vn.recent {
+		if c.Seq > seq {
+			changes = append(changes, c)
+		}
+	}
+	return changes, true
+}
+
+// Latest returns up to n logged changes, newest first.
+func (vn *ViewNotifier) Latest(n int) []ViewChange {
+	vn.mu.RLock()
+	defer vn.mu.RUnlock()
+	out := make([]ViewChange, 0, min(n, len(vn.recent)))
+	for i := len(vn.recent) - 1; i >= 0 && len(out) < n; i-- {
+		out = append(out, vn.recent[i])
+	}
+	return out
+}
+
+func (vn *ViewNotifier) UnsubscribeAll(ch chan ViewChange) {
+	vn.mu.Lock()
+	defer vn.mu.Unlock()
+	if _, ok := vn.firehose[ch]; ok {
+		delete(vn.firehose, ch)
+		close(ch)
 	}
 }
 
@@ -719,31 +2213,98 @@ func (vn *ViewNotifier) Unsubscribe(emailID string, ch chan struct{}) {
 }
 
 func (vn *ViewNotifier) Notify(emailID string) {
-	vn.mu.RLock()
-	defer vn.mu.RUnlock()
+	if vn.onNotify != nil {
+		vn.onNotify(emailID)
+	}
+	vn.mu.Lock()
+	defer vn.mu.Unlock()
+	vn.seq++
+	change := ViewChange{Seq: vn.seq, EmailID: emailID, At: time.Now()}
+	vn.recent = append(vn.recent, change)
+	if len(vn.recent) > viewChangeLogSize {
+		vn.recent = vn.recent[len(vn.recent)-viewChangeLogSize:]
+	}
 	for _, ch := range vn.subscribers[emailID] {
 		select {
 		case ch <- struct{}{}:
 		default:
 		}
 	}
+	for ch := range vn.firehose {
+		select {
+		case ch <- change:
+		default:
+		}
+	}
 }
 
 // ---------- Click Tracker Rate Limiter ----------
 
+// ClickLimiter decides whether a click should be tracked. Each key (see
+// clickLimitKey) gets a token bucket: burst clicks at once, refilled at rate
+// per second. The redirect happens either way.
+type ClickLimiter interface {
+	ShouldTrack(ctx context.Context, key string) bool
+}
+
+const (
+	defaultClickRate  = "10/1s"
+	defaultClickBurst = 20
+)
+
+// clickLimitFromEnv reads CLICK_RATE ("<clicks>/<window>") and CLICK_BURST
+// as a refill rate per second and a bucket size.
+func clickLimitFromEnv() (rate, burst float64) {
+	n, window, err := parseRateLimit(env("CLICK_RATE", defaultClickRate))
+	if err != nil {
+		log.Fatalf("invalid CLICK_RATE: %v", err)
+	}
+	b := envInt("CLICK_BURST", defaultClickBurst)
+	if b < 1 {
+		log.Printf("CLICK_BURST %d is under 1; using %d", b, defaultClickBurst)
+		b = defaultClickBurst
+	}
+	return float64(n) / window.Seconds(), float64(b)
+}
+
+// clickLimitKey is what click tracking is limited by: the client (see
+// clientKey) and session, so readers behind one NAT don't share a budget. A request
+// without a session cookie (a new reader, or a client discarding cookies)
+// is limited by IP alone, so dropping the cookie doesn't refill the bucket.
+func clickLimitKey(r *http.Request) string {
+	client := clientKey(r)
+	if c, err := r.Cookie("_track"); err == nil {
+		return client + " " + c.Value
+	}
+	return client
+}
+
+// tokenBucket is one key's state in ClickTracker.
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// ClickTracker is the in-process ClickLimiter. With several replicas each
+// keeps its own buckets, so the effective limit scales with the replica
+// count; see PGClickLimiter.
 type ClickTracker struct {
-	mu       sync.RWMutex
-	clicks   map[string]time.Time // key: IP address
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	rate     float64 // tokens per second
+	burst    float64
 	cleanupC chan struct{}
 }
 
-func NewClickTracker() *ClickTracker {
+func NewClickTracker(rate, burst float64) *ClickTracker {
 	ct := &ClickTracker{
-		clicks:   make(map[string]time.Time),
+		buckets:  make(map[string]*tokenBucket),
+		rate:     rate,
+		burst:    burst,
 		cleanupC: make(chan struct{}),
 	}
-	
-	// Cleanup old entries every minute
+
+	// Cleanup full buckets every minute
 	go func() {
 		ticker := time.NewTicker(1 * time.Minute)
 		defer ticker.Stop()
@@ -756,93 +2317,322 @@ func NewClickTracker() *ClickTracker {
 			}
 		}
 	}()
-	
+
 	return ct
 }
 
+// refillTime is how long an untouched bucket takes to fill up; after that
+// it's the same as a new one and can be forgotten.
+func (ct *ClickTracker) refillTime() time.Duration {
+	return time.Duration(ct.burst / ct.rate * float64(time.Second))
+}
+
 func (ct *ClickTracker) cleanup() {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
-	
-	cutoff := time.Now().Add(-1 * time.Minute)
-	for ip, lastClick := range ct.clicks {
-		if lastClick.Before(cutoff) {
-			delete(ct.clicks, ip)
+
+	cutoff := time.Now().Add(-ct.refillTime())
+	for key, b := range ct.buckets {
+		if b.at.Before(cutoff) {
+			delete(ct.buckets, key)
 		}
 	}
 }
 
-// ShouldTrack returns true if this IP should be tracked (max 10 clicks/second)
-func (ct *ClickTracker) ShouldTrack(ip string) bool {
+func (ct *ClickTracker) ShouldTrack(_ context.Context, key string) bool {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
-	
+
 	now := time.Now()
-	lastClick, exists := ct.clicks[ip]
-	
-	// Allow if never seen or last click was >100ms ago (10/sec max)
-	if !exists || now.Sub(lastClick) > 100*time.Millisecond {
-		ct.clicks[ip] = now
-		return true
+	b, ok := ct.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: ct.burst, at: now}
+		ct.buckets[key] = b
 	}
-	
-	return false
+	b.tokens = min(ct.burst, b.tokens+now.Sub(b.at).Seconds()*ct.rate)
+	b.at = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// PGClickLimiter enforces the click limit across replicas through an
+// unlogged table in the metrics DB, with the same buckets as ClickTracker.
+// Keys are stored hashed. On DB errors it fails open: an extra tracked
+// click beats a slow or missing redirect.
+type PGClickLimiter struct {
+	store *Store
+	rate  float64
+	burst float64
+}
+
+func NewPGClickLimiter(ctx context.Context, store *Store, rate, burst float64) *PGClickLimiter {
+	l := &PGClickLimiter{store: store, rate: rate, burst: burst}
+	// Full buckets are the same as missing ones.
+	refill := burst / rate
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := store.metricsPool.Exec(ctx, `DELETE FROM click_rate_limits WHERE updated_at < NOW() - $1 * INTERVAL '1 second'`, refill)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("click limiter cleanup error: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return l
+}
+
+func (l *PGClickLimiter) ShouldTrack(ctx context.Context, key string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+
+	sum := sha256.Sum256([]byte(key))
+	var tracked bool
+	err := l.store.metricsPool.QueryRow(ctx, `
+		INSERT INTO click_rate_limits (key, tokens, updated_at) VALUES ($1, $3 - 1, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST($3, click_rate_limits.tokens + EXTRACT(EPOCH FROM NOW() - click_rate_limits.updated_at) * $2) - 1,
+			updated_at = NOW()
+		WHERE LEAST($3, click_rate_limits.tokens + EXTRACT(EPOCH FROM NOW() - click_rate_limits.updated_at) * $2) >= 1
+		RETURNING true
+	`, hex.EncodeToString(sum[:16]), l.rate, l.burst).Scan(&tracked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Printf("click limiter error: %v", err)
+	}
+	return true
+}
+
+// trackClick records ev unless r's click budget is spent, counting both
+// outcomes for the admin dashboard.
+func (s *Server) trackClick(r *http.Request, ev ClickEvent) {
+	if !s.clickLimiter.ShouldTrack(r.Context(), clickLimitKey(r)) {
+		s.clicksLimited.Add(1)
+		return
+	}
+	s.clicksTracked.Add(1)
+	s.metricsWriter.TrackClick(ev)
 }
 
 // ---------- HTTP Handlers ----------
 
 type Server struct {
-	store        *Store
-	cache        *TTLCache
-	viewNotifier *ViewNotifier
-	clickTracker *ClickTracker
+	store         *Store
+	cache         *TTLCache
+	viewNotifier  *ViewNotifier
+	clickLimiter  ClickLimiter
+	clicksTracked atomic.Int64 // since startup; see trackClick
+	clicksLimited atomic.Int64
+	metricsWriter *MetricsWriter
+	notifier      Notifier    // tells stats streams an email's counts changed
+	pgNotifier    *PGNotifier // nil unless NOTIFY_BACKEND=postgres
+	webhooks      *Webhooks   // nil unless WEBHOOK_URLS is set
+	slack         *Slack      // nil unless SLACK_WEBHOOK_URL is set
+	searchIndex   searchIndex // nil unless SEARCH_INDEX_PROVIDER is set
+	cacheDebug    bool        // emit X-Cache / X-Cache-Key-Hash
+	versionInfo   VersionInfo
+	previewSecret []byte // signs preview tokens; previews disabled when empty
+	cachePrefix   string // "<region>/" so cache keys stay distinct if a cache is ever shared across regions
+	archiveBase   string // public archive site, for links back to an email's page
+
+	embedFrameAncestors string // CSP frame-ancestors for /emails/{id}/embed
+
+	loopsAPIKey     string // enables POST /mailing_lists/{id}/subscribe
+	captchaProvider string // turnstile or hcaptcha
+	captchaSecret   string // subscribe requires a captcha when set
+
+	publisherName string // schema.org publisher in JSON-LD
+	publisherLogo string
+	robotsTxt     []byte
+
+	recountMu sync.Mutex
+	recount   *recountJob // most recent admin recount, if any
+
+	streamStop     chan struct{} // closed on shutdown to end SSE streams
+	streamStopOnce sync.Once
 }
 
 func NewServer(store *Store) *Server {
+	vn := NewViewNotifier()
+	vn.onNotify = store.memo.Forget
+	cachePrefix := ""
+	if store.region != "" {
+		cachePrefix = store.region + "/"
+	}
+
+	clickRate, clickBurst := clickLimitFromEnv()
+	var clickLimiter ClickLimiter = NewClickTracker(clickRate, clickBurst)
+	switch backend := env("CLICK_LIMITER", "local"); backend {
+	case "local":
+	case "postgres":
+		if store.metricsPool == nil {
+			log.Printf("CLICK_LIMITER=postgres needs METRICS_DATABASE_URL; using the in-process limiter")
+			break
+		}
+		clickLimiter = NewPGClickLimiter(context.Background(), store, clickRate, clickBurst)
+	default:
+		log.Printf("unknown CLICK_LIMITER %q; using the in-process limiter", backend)
+	}
+
+	var notifier Notifier = vn
+	var pgNotifier *PGNotifier
+	switch backend := env("NOTIFY_BACKEND", "local"); backend {
+	case "local":
+	case "postgres":
+		if store.metricsPool == nil {
+			log.Printf("NOTIFY_BACKEND=postgres needs METRICS_DATABASE_URL; using local notifications")
+			break
+		}
+		pgNotifier = NewPGNotifier(store, vn)
+		notifier = pgNotifier
+	default:
+		log.Printf("unknown NOTIFY_BACKEND %q; using local notifications", backend)
+	}
+	bufSize, err := strconv.Atoi(env("METRICS_BUFFER_SIZE", "10000"))
+	if err != nil || bufSize <= 0 {
+		bufSize = 10000
+	}
 	return &Server{
-		store:        store,
-		cache:        NewTTLCache(30*time.Second, 512),
-		viewNotifier: NewViewNotifier(),
-		clickTracker: NewClickTracker(),
+		store:         store,
+		cache:         NewTTLCache(envDuration("CACHE_TTL", 30*time.Second), envInt("CACHE_MAX_ENTRIES", 512)),
+		viewNotifier:  vn,
+		clickLimiter:  clickLimiter,
+		metricsWriter: NewMetricsWriter(store, bufSize, notifier.Notify),
+		notifier:      notifier,
+		pgNotifier:    pgNotifier,
+		webhooks:      NewWebhooksFromEnv(store),
+		cacheDebug:    os.Getenv("CACHE_DEBUG_HEADERS") == "1",
+		previewSecret: []byte(os.Getenv("PREVIEW_SECRET")),
+		cachePrefix:   cachePrefix,
+		streamStop:    make(chan struct{}),
+		archiveBase:   env("ARCHIVE_BASE_URL", "https://news.hackclub.com"),
+
+		embedFrameAncestors: env("EMBED_FRAME_ANCESTORS", "https://hackclub.com https://*.hackclub.com"),
+
+		loopsAPIKey:     os.Getenv("LOOPS_API_KEY"),
+		captchaProvider: env("CAPTCHA_PROVIDER", "turnstile"),
+		captchaSecret:   os.Getenv("CAPTCHA_SECRET"),
+
+		publisherName: env("PUBLISHER_NAME", "Hack Club"),
+		publisherLogo: env("PUBLISHER_LOGO_URL", "https://assets.hackclub.com/icon-rounded.png"),
+		robotsTxt:     []byte(defaultRobotsTxt),
+	}
+}
+
+// StopStreams ends open SSE streams, which would otherwise hold graceful
+// shutdown open until its deadline. Registered with http.Server.RegisterOnShutdown.
+func (s *Server) StopStreams() {
+	s.streamStopOnce.Do(func() { close(s.streamStop) })
+}
+
+// Close flushes buffered tracking events. Call after the HTTP server has
+// stopped accepting requests.
+func (s *Server) Close() {
+	s.metricsWriter.Close()
+	if s.pgNotifier != nil {
+		s.pgNotifier.Close()
 	}
 }
 
+// jsonCached serves build's value as cached JSON. Values that implement
+// contentVersioned get an ETag derived from what they contain (see
+// contentETag); anything else is tagged by its bytes.
 func (s *Server) jsonCached(w http.ResponseWriter, r *http.Request, build func() (any, error)) {
-	key := cacheKey(r)
-	if body, etag, ok := s.cache.Get(key); ok {
-		if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
-			w.WriteHeader(http.StatusNotModified)
-			return
+	s.cachedVersioned(w, r, "application/json; charset=utf-8", func() ([]byte, string, error) {
+		v, err := build()
+		if err != nil {
+			return nil, "", err
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.Header().Set("Cache-Control", "public, max-age=30, stale-while-revalidate=60")
-		w.Header().Set("ETag", etag)
-		_, _ = w.Write(body)
-		return
-	}
+		body, err := json.Marshal(v)
+		return body, contentETag(r, v), err
+	})
+}
 
-	v, err := build()
-	if err != nil {
-		httpError(w, err)
+// cached serves a response body through the TTL cache, with the same ETag,
+// stale-on-error, and debug header handling for every content type.
+func (s *Server) cached(w http.ResponseWriter, r *http.Request, contentType string, build func() ([]byte, error)) {
+	s.cachedVersioned(w, r, contentType, func() ([]byte, string, error) {
+		body, err := build()
+		return body, "", err
+	})
+}
+
+// cachedVersioned is cached for builders that compute their own ETag; an
+// empty one falls back to hashing the body.
+func (s *Server) cachedVersioned(w http.ResponseWriter, r *http.Request, contentType string, build func() ([]byte, string, error)) {
+	key := s.cachePrefix + cacheKey(r)
+	if body, etag, ok := s.cache.Get(key); ok {
+		s.writeCached(w, r, key, "HIT", contentType, body, etag)
 		return
 	}
-	body, err := json.MarshalIndent(v, "", "  ")
+
+	body, etag, err := build()
 	if err != nil {
-		httpError(w, err)
+		if body, etag, ok := s.cache.GetStale(key); ok && !errors.Is(err, errNotFound) {
+			log.Printf("serving stale cache after error: %v", err)
+			s.writeCached(w, r, key, "STALE", contentType, body, etag)
+			return
+		}
+		httpError(w, r, err)
 		return
 	}
-	etag := s.cache.Set(key, body)
+	etag = s.cache.Set(key, body, etag)
+	s.writeCached(w, r, key, "MISS", contentType, body, etag)
+}
 
+func (s *Server) writeCached(w http.ResponseWriter, r *http.Request, key, status, contentType string, body []byte, etag string) {
+	if s.cacheDebug {
+		sum := sha1.Sum([]byte(key))
+		w.Header().Set("X-Cache", status)
+		w.Header().Set("X-Cache-Key-Hash", hex.EncodeToString(sum[:6]))
+	}
+	if d := s.store.health.Degraded(); len(d) > 0 {
+		w.Header().Set("X-Degraded", strings.Join(d, ","))
+	}
 	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
 		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Cache-Control", "public, max-age=30, stale-while-revalidate=60")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", cacheControl(r, "max-age=30, stale-while-revalidate=60"))
 	w.Header().Set("ETag", etag)
+	// The cache holds compact JSON; indent only for ?pretty=true. The ETag
+	// is weak, so both forms can share it.
+	if isPretty(r) && strings.HasPrefix(contentType, "application/json") {
+		var buf bytes.Buffer
+		if json.Indent(&buf, body, "", "  ") == nil {
+			buf.WriteByte('\n')
+			body = buf.Bytes()
+		}
+	}
 	_, _ = w.Write(body)
 }
 
+// cacheControl is a Cache-Control value for a response shared caches may
+// keep, unless it was served for an API key: a CDN would replay that to
+// clients without one.
+func cacheControl(r *http.Request, directives string) string {
+	if r.Context().Value(apiKeyCtxKey{}) != nil {
+		return "private, " + directives
+	}
+	return "public, " + directives
+}
+
+func isPretty(r *http.Request) bool {
+	v := r.URL.Query().Get("pretty")
+	return v == "true" || v == "1"
+}
+
 func parseLimitOffset(r *http.Request, defLimit int) (limit, offset int) {
 	limit = defLimit
 	offset = 0
@@ -859,6 +2649,23 @@ func parseLimitOffset(r *http.Request, defLimit int) (limit, offset int) {
 	return
 }
 
+// parseEmailFilter reads ?mailing_list_id=, ?updated_since= (RFC 3339) and
+// ?topic=.
+func parseEmailFilter(r *http.Request) (EmailFilter, error) {
+	f := EmailFilter{MailingListID: r.URL.Query().Get("mailing_list_id"), Topic: r.URL.Query().Get("topic")}
+	if f.Topic != "" && !isTopic(f.Topic) {
+		return f, badRequest("unknown topic; see /topics")
+	}
+	if v := r.URL.Query().Get("updated_since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return f, badRequest("updated_since must be an RFC 3339 timestamp")
+		}
+		f.UpdatedSince = &t
+	}
+	return f, nil
+}
+
 func (s *Server) handleMailingLists(w http.ResponseWriter, r *http.Request) {
 	limit, offset := parseLimitOffset(r, 50)
 	s.jsonCached(w, r, func() (any, error) {
@@ -866,25 +2673,82 @@ func (s *Server) handleMailingLists(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			return nil, err
 		}
-		return Paginated[MailingList]{Items: lists, Next: next}, nil
+		return Paginated[MailingList]{Items: lists, Next: next, Meta: s.store.responseMeta()}, nil
 	})
 }
 
 func (s *Server) handleEmails(w http.ResponseWriter, r *http.Request) {
-	limit, offset := parseLimitOffset(r, 50)
-	var mlid *string
-	if v := r.URL.Query().Get("mailing_list_id"); v != "" {
-		mlid = &v
+	f, err := parseEmailFilter(r)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if wantsNDJSON(r) {
+		s.streamEmails(w, r, f, formatNDJSON, func(e *Email) any { return e })
+		return
 	}
+	limit, offset := parseLimitOffset(r, 50)
 	s.jsonCached(w, r, func() (any, error) {
-		emails, next, err := s.store.ListEmails(r.Context(), r, mlid, limit, offset)
+		emails, next, err := s.store.ListEmails(r.Context(), r, f, limit, offset)
 		if err != nil {
 			return nil, err
 		}
-		return Paginated[Email]{Items: emails, Next: next}, nil
+		return Paginated[Email]{Items: emails, Next: next, Meta: s.store.responseMeta()}, nil
 	})
 }
 
+func (s *Server) handleEmail(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	token := r.URL.Query().Get("preview_token")
+	if token == "" {
+		token = r.Header.Get("X-Preview-Token")
+	}
+	dark := r.URL.Query().Get("theme") == "dark"
+	markdown := r.URL.Query().Get("render") == "markdown"
+	css := r.URL.Query().Get("css")
+	if css != cssInline && css != cssExtract {
+		css = ""
+	}
+	if token == "" {
+		s.jsonCached(w, r, func() (any, error) {
+			e, err := s.store.GetEmail(r.Context(), r, emailID, false)
+			if err == nil && markdown {
+				err = s.store.renderMarkdown(r, e, true)
+			}
+			if err == nil && css != "" {
+				err = e.transformCSS(css)
+			}
+			if err == nil && dark {
+				err = e.darkenHTML()
+			}
+			return e, err
+		})
+		return
+	}
+
+	if len(s.previewSecret) == 0 || !verifyPreviewToken(s.previewSecret, token, emailID, time.Now()) {
+		writeError(w, r, http.StatusForbidden, codeForbidden, "invalid or expired preview token")
+		return
+	}
+	e, err := s.store.GetEmail(r.Context(), r, emailID, true)
+	if err == nil && markdown {
+		err = s.store.renderMarkdown(r, e, false)
+	}
+	if err == nil && css != "" {
+		err = e.transformCSS(css)
+	}
+	if err == nil && dark {
+		err = e.darkenHTML()
+	}
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	// Never let previews reach shared caches or search engines.
+	w.Header().Set("X-Robots-Tag", "noindex")
+	writeJSON(w, http.StatusOK, e)
+}
+
 type GroupedEmails struct {
 	MailingList MailingList `json:"mailing_list"`
 	Emails      []Email     `json:"emails"`
@@ -900,7 +2764,7 @@ func getOrCreateSession(w http.ResponseWriter, r *http.Request) *http.Cookie {
 			MaxAge:   30 * 24 * 60 * 60,
 			HttpOnly: true,
 			SameSite: http.SameSiteLaxMode,
-			Secure:   r.TLS != nil,
+			Secure:   isHTTPS(r),
 			Path:     "/",
 		}
 		http.SetCookie(w, cookie)
@@ -911,67 +2775,454 @@ func getOrCreateSession(w http.ResponseWriter, r *http.Request) *http.Cookie {
 func (s *Server) handleEmailView(w http.ResponseWriter, r *http.Request) {
 	emailID := chi.URLParam(r, "id")
 	if emailID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(apiErr{Message: "missing email id"})
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing email id")
 		return
 	}
 
 	cookie := getOrCreateSession(w, r)
 
-	if err := s.store.TrackEmailView(r.Context(), cookie.Value, emailID); err != nil {
-		log.Printf("track view error: %v", err)
-	} else {
-		s.viewNotifier.Notify(emailID)
+	// The API is called from the archive page, so the Referer header is the
+	// page itself; the frontend forwards document.referrer as ?ref= instead.
+	referrer := normalizeReferrer(r.URL.Query().Get("ref"))
+	if referrer == nil {
+		referrer = normalizeReferrer(r.Referer())
+	}
+
+	// Recorded asynchronously; SSE subscribers are notified once it lands.
+	s.metricsWriter.TrackView(ViewEvent{
+		SessionID: cookie.Value,
+		EmailID:   emailID,
+		Referrer:  referrer,
+		Device:    parseDevice(r.UserAgent()),
+	})
+
+	viewCount, err := s.store.GetEmailViewCount(r.Context(), emailID)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+
+	resp := map[string]any{"views": viewCount}
+	if meta := s.store.responseMeta(); meta != nil {
+		resp["meta"] = meta
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// transparentGIF is a 1x1 transparent GIF89a.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// handleEmailPixel records a view like /view for readers that only load
+// images (RSS readers, no-JS pages). It always answers with the pixel, and
+// nothing caches it, so every load reaches us and is deduplicated here.
+func (s *Server) handleEmailPixel(w http.ResponseWriter, r *http.Request) {
+	cookie := getOrCreateSession(w, r)
+	s.metricsWriter.TrackView(ViewEvent{
+		SessionID: cookie.Value,
+		EmailID:   chi.URLParam(r, "id"),
+		Referrer:  normalizeReferrer(r.Referer()),
+		Device:    parseDevice(r.UserAgent()),
+	})
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	_, _ = w.Write(transparentGIF)
+}
+
+// pageKeyRegex restricts page keys to short slug-like identifiers such as
+// "home" or "lists/arcade". The "email:" namespace is reserved.
+var pageKeyRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9/_-]{0,199}$`)
+
+func (s *Server) handlePageView(w http.ResponseWriter, r *http.Request) {
+	key := strings.ToLower(strings.Trim(r.URL.Query().Get("key"), "/"))
+	if key == "" {
+		key = "home"
+	}
+	if !pageKeyRegex.MatchString(key) {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid page key")
+		return
+	}
+
+	cookie := getOrCreateSession(w, r)
+
+	referrer := normalizeReferrer(r.URL.Query().Get("ref"))
+	if referrer == nil {
+		referrer = normalizeReferrer(r.Referer())
+	}
+
+	s.metricsWriter.TrackView(ViewEvent{
+		SessionID: cookie.Value,
+		PageKey:   key,
+		Referrer:  referrer,
+		Device:    parseDevice(r.UserAgent()),
+	})
+
+	viewCount, err := s.store.GetPageViewCount(r.Context(), key)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]any{"key": key, "views": viewCount})
+}
+
+func (s *Server) handleTopPages(w http.ResponseWriter, r *http.Request) {
+	limit, _ := parseLimitOffset(r, 50)
+	since := parseSinceDays(r, 7)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.GetTopPages(r.Context(), since, limit)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"since": since, "items": items}, nil
+	})
+}
+
+func (s *Server) handleEmailNextReads(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	limit, _ := parseLimitOffset(r, 10)
+	since := parseSinceDays(r, 30)
+	minSessions, err := strconv.Atoi(env("JOURNEY_MIN_SESSIONS", "5"))
+	if err != nil || minSessions < 1 {
+		minSessions = 5
+	}
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.GetNextReads(r.Context(), emailID, since, minSessions, limit)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items}, nil
+	})
+}
+
+func (s *Server) handleEmailReferrers(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.GetReferrerBreakdown(r.Context(), emailID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items}, nil
+	})
+}
+
+func (s *Server) handleEmailDevices(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.GetDeviceBreakdown(r.Context(), emailID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items}, nil
+	})
+}
+
+func (s *Server) handleEmailRegions(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.GetRegionBreakdown(r.Context(), emailID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items}, nil
+	})
+}
+
+func (s *Server) handleEmailLinks(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		if err := s.store.RequireEmail(r.Context(), emailID); err != nil {
+			return nil, err
+		}
+		items, err := s.store.GetLinkClicks(r.Context(), emailID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"email_id": emailID, "items": items, "by_position": positionBuckets(items)}, nil
+	})
+}
+
+// rumMetricLimits whitelists accepted web-vitals and caps plausible values
+// (milliseconds, except CLS which is unitless).
+var rumMetricLimits = map[string]float64{
+	"lcp":  120000,
+	"fcp":  120000,
+	"ttfb": 120000,
+	"inp":  120000,
+	"cls":  100,
+}
+
+var rumPageRegex = regexp.MustCompile(`^[a-z0-9/_-]{0,200}$`)
+
+func (s *Server) handleRUM(w http.ResponseWriter, r *http.Request) {
+	// navigator.sendBeacon posts text/plain, so don't insist on a content type.
+	var beacon struct {
+		Page    string             `json:"page"`
+		Metrics map[string]float64 `json:"metrics"`
 	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&beacon); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid beacon")
+		return
+	}
+	page := strings.Trim(strings.ToLower(beacon.Page), "/")
+	if i := strings.IndexAny(page, "?#"); i >= 0 {
+		page = page[:i]
+	}
+	if !rumPageRegex.MatchString(page) {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid page")
+		return
+	}
+
+	device := parseDevice(r.UserAgent()).Class
+	for name, value := range beacon.Metrics {
+		name = strings.ToLower(name)
+		limit, ok := rumMetricLimits[name]
+		if !ok || value < 0 || value > limit {
+			continue
+		}
+		s.metricsWriter.TrackRUM(RUMEvent{Page: page, Metric: name, Value: value, DeviceClass: device})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseSinceDays(r *http.Request, def int) time.Time {
+	days := def
+	if v := r.URL.Query().Get("days"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 90 {
+			days = n
+		}
+	}
+	return time.Now().AddDate(0, 0, -days).Truncate(time.Hour)
+}
+
+func (s *Server) handleRUMSummary(w http.ResponseWriter, r *http.Request) {
+	page := r.URL.Query().Get("page")
+	since := parseSinceDays(r, 7)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.GetRUMSummary(r.Context(), page, since)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"since": since, "items": items}, nil
+	})
+}
 
-	viewCount, err := s.store.GetEmailViewCount(r.Context(), emailID)
-	if err != nil {
-		httpError(w, err)
+func (s *Server) handleRUMTimeseries(w http.ResponseWriter, r *http.Request) {
+	metric := strings.ToLower(r.URL.Query().Get("metric"))
+	if _, ok := rumMetricLimits[metric]; !ok {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "unknown metric")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	_ = json.NewEncoder(w).Encode(map[string]int64{"views": viewCount})
+	page := r.URL.Query().Get("page")
+	since := parseSinceDays(r, 2)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.GetRUMTimeseries(r.Context(), metric, page, since)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"metric": metric, "since": since, "items": items}, nil
+	})
 }
 
 func (s *Server) handleLinkClick(w http.ResponseWriter, r *http.Request) {
 	emailID := chi.URLParam(r, "id")
 	linkIndexStr := chi.URLParam(r, "index")
-	targetURL := r.URL.Query().Get("url")
+	targetURL := unwrapTrackingURL(r.URL.Query().Get("url"))
 	
 	if emailID == "" || linkIndexStr == "" || targetURL == "" {
-		http.Error(w, "missing parameters", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing parameters")
 		return
 	}
 	
 	linkIndex, err := strconv.Atoi(linkIndexStr)
 	if err != nil {
-		http.Error(w, "invalid link index", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid link index")
 		return
 	}
 	
+	s.clickThrough(w, r, emailID, linkIndex, targetURL)
+}
+
+// clickThrough records a click on the link at linkIndex in emailID and
+// redirects to it. Both click URL forms end here (see shortlinks.go).
+func (s *Server) clickThrough(w http.ResponseWriter, r *http.Request, emailID string, linkIndex int, targetURL string) {
 	// Always get/set session cookie
 	cookie := getOrCreateSession(w, r)
-	
-	// Rate limit tracking (not redirect) - max 10 clicks/sec per IP
-	clientIP := r.RemoteAddr
-	if shouldTrack := s.clickTracker.ShouldTrack(clientIP); shouldTrack {
-		if err := s.store.TrackLinkClick(r.Context(), cookie.Value, emailID, targetURL, linkIndex); err != nil {
-			log.Printf("track click error: %v", err)
-		} else {
-			s.viewNotifier.Notify(emailID)
+
+	// Rate limit tracking (not redirect); see ClickLimiter
+	s.trackClick(r, ClickEvent{
+		SessionID: cookie.Value,
+		EmailID:   emailID,
+		LinkURL:   targetURL,
+		LinkIndex: linkIndex,
+		Device:    parseDevice(r.UserAgent()),
+	})
+
+	// ALWAYS redirect regardless of tracking
+	http.Redirect(w, r, s.store.clickDestination(targetURL), http.StatusFound)
+}
+
+// LiveStats is one SSE stats update.
+type LiveStats struct {
+	EmailID string `json:"email_id,omitempty"` // firehose only
+	Views   int64  `json:"views"`
+	Clicks  int64  `json:"clicks"`
+	Likes   int64  `json:"likes"`
+}
+
+// eventID identifies a per-email snapshot by its content, so a client
+// reconnecting with Last-Event-ID can be told "nothing changed" by silence.
+func (ls LiveStats) eventID() string {
+	return fmt.Sprintf("v%d-c%d-l%d", ls.Views, ls.Clicks, ls.Likes)
+}
+
+// liveStats reads the counts pushed to stats streams: views and likes from
+// the metrics DB, clicks from the metrics DB plus the warehouse's historical
+// total.
+func (s *Server) liveStats(ctx context.Context, emailID string) (LiveStats, error) {
+	viewCount, err := s.store.GetEmailViewCount(ctx, emailID)
+	if err != nil {
+		return LiveStats{}, err
+	}
+
+	metricsClicks, _ := s.store.GetMetricsClickCount(ctx, emailID)
+	var warehouseClicks int64
+	if s.store.pool != nil {
+		_ = s.store.content().QueryRow(ctx, `
+		SELECT COALESCE(clicks, 0)
+		FROM loops.campaigns
+		WHERE id = $1
+	`, emailID).Scan(&warehouseClicks)
+	}
+	likes, _ := s.store.GetLikeCount(ctx, emailID)
+	return LiveStats{Views: viewCount, Clicks: metricsClicks + warehouseClicks, Likes: likes}, nil
+}
+
+// sseKeepAliveInterval spaces ": ping" comments on SSE streams, comfortably
+// under the idle timeouts of common proxies and load balancers (~60s).
+const sseKeepAliveInterval = 15 * time.Second
+
+// isStreamRequest reports whether r is for a long-lived SSE route or NDJSON
+// stream, which must not be subject to the request timeout. Only the
+// /emails list streams NDJSON outside /export/; asking any other route for
+// it doesn't lift its timeout.
+func isStreamRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/stats/stream") ||
+		strings.HasPrefix(r.URL.Path, "/export/") ||
+		r.URL.Path == "/emails" && wantsNDJSON(r)
+}
+
+// firehoseMaxPerTick caps how many emails one firehose client refreshes per
+// second; the rest stay pending for the next tick.
+const firehoseMaxPerTick = 50
+
+// handleStatsStream is the archive-wide counterpart of
+// handleEmailStatsStream: one stream carrying updates for every email whose
+// views or clicks change.
+func (s *Server) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "streaming not supported")
+		return
+	}
+
+	changes := s.viewNotifier.SubscribeAll()
+	defer s.viewNotifier.UnsubscribeAll(changes)
+
+	throttle := time.NewTicker(1 * time.Second)
+	defer throttle.Stop()
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	// pending maps email ID to the sequence number of its latest change.
+	pending := make(map[string]uint64)
+
+	// On reconnect, replay the latest counts of everything that changed
+	// since the client's last event. If we can't tell what that was (it
+	// came from another replica, a restart, or before the log), send a
+	// snapshot of every email in the log instead.
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		missed, ok := s.viewNotifier.ChangesSince(last)
+		if !ok {
+			missed = s.viewNotifier.Latest(viewChangeLogSize)
+		}
+		for _, c := range missed {
+			pending[c.EmailID] = max(pending[c.EmailID], c.Seq)
+		}
+	}
+
+	// Flush headers so clients see the stream open before the first change.
+	flusher.Flush()
+
+	for {
+		select {
+		case c := <-changes:
+			pending[c.EmailID] = c.Seq
+		case <-throttle.C:
+			if len(pending) == 0 {
+				continue
+			}
+			// Oldest changes first, so event IDs stay increasing and a
+			// resumed client never skips a change it hasn't seen.
+			batch := make([]ViewChange, 0, len(pending))
+			for id, seq := range pending {
+				batch = append(batch, ViewChange{Seq: seq, EmailID: id})
+			}
+			sort.Slice(batch, func(i, j int) bool { return batch[i].Seq < batch[j].Seq })
+			if len(batch) > firehoseMaxPerTick {
+				batch = batch[:firehoseMaxPerTick]
+			}
+			for _, c := range batch {
+				delete(pending, c.EmailID)
+				stats, err := s.liveStats(r.Context(), c.EmailID)
+				if err != nil {
+					log.Printf("firehose stats error: %v", err)
+					continue
+				}
+				stats.EmailID = c.EmailID
+				data, _ := json.Marshal(stats)
+				fmt.Fprintf(w, "id: %s\ndata: %s\n\n", s.viewNotifier.EventID(c.Seq), data)
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.streamStop:
+			return
 		}
 	}
-	// If rate limited, we skip tracking but still redirect
-	
-	// ALWAYS redirect regardless of tracking
-	http.Redirect(w, r, targetURL, http.StatusFound)
 }
 
 func (s *Server) handleEmailStatsStream(w http.ResponseWriter, r *http.Request) {
 	emailID := chi.URLParam(r, "id")
 	if emailID == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "missing email id")
 		return
 	}
 
@@ -982,7 +3233,7 @@ func (s *Server) handleEmailStatsStream(w http.ResponseWriter, r *http.Request)
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "streaming not supported")
 		return
 	}
 
@@ -991,32 +3242,29 @@ func (s *Server) handleEmailStatsStream(w http.ResponseWriter, r *http.Request)
 
 	throttle := time.NewTicker(333 * time.Millisecond)
 	defer throttle.Stop()
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
 
+	lastID := r.Header.Get("Last-Event-ID")
 	sendUpdate := func() {
-		viewCount, err := s.store.GetEmailViewCount(r.Context(), emailID)
+		stats, err := s.liveStats(r.Context(), emailID)
 		if err != nil {
 			log.Printf("stream view count error: %v", err)
 			return
 		}
-		
-		metricsClicks, _ := s.store.GetMetricsClickCount(r.Context(), emailID)
-		var warehouseClicks int64
-		_ = s.store.pool.QueryRow(r.Context(), `
-			SELECT COALESCE(clicks, 0)
-			FROM loops.campaigns
-			WHERE id = $1
-		`, emailID).Scan(&warehouseClicks)
-		clickCount := metricsClicks + warehouseClicks
-		
-		stats := map[string]int64{
-			"views":  viewCount,
-			"clicks": clickCount,
+		id := stats.eventID()
+		if id == lastID {
+			return
 		}
+		lastID = id
 		data, _ := json.Marshal(stats)
-		fmt.Fprintf(w, "data: %s\n\n", data)
+		fmt.Fprintf(w, "id: %s\ndata: %s\n\n", id, data)
 		flusher.Flush()
 	}
 
+	// A reconnecting client that already has the latest snapshot gets
+	// nothing until counts change; otherwise it gets the current snapshot.
+	flusher.Flush()
 	sendUpdate()
 
 	var pending bool
@@ -1029,8 +3277,13 @@ func (s *Server) handleEmailStatsStream(w http.ResponseWriter, r *http.Request)
 				sendUpdate()
 				pending = false
 			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
 		case <-r.Context().Done():
 			return
+		case <-s.streamStop:
+			return
 		}
 	}
 }
@@ -1050,8 +3303,7 @@ func (s *Server) handleMailingListsEmails(w http.ResponseWriter, r *http.Request
 		}
 		out := make([]GroupedEmails, 0, len(lists))
 		for _, ml := range lists {
-			mlid := ml.ID
-			emails, _, err := s.store.ListEmails(r.Context(), r, &mlid, limitPerList, 0)
+			emails, _, err := s.store.ListEmails(r.Context(), r, EmailFilter{MailingListID: ml.ID}, limitPerList, 0)
 			if err != nil {
 				return nil, err
 			}
@@ -1072,77 +3324,594 @@ func (s *Server) handleMailingListsEmails(w http.ResponseWriter, r *http.Request
 	})
 }
 
+type dependencyStatus struct {
+	Status    string `json:"status"` // ok, down, not_configured
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+}
+
+func pingDependency(ctx context.Context, pool *pgxpool.Pool) dependencyStatus {
+	if pool == nil {
+		return dependencyStatus{Status: "not_configured"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := pool.Ping(ctx); err != nil {
+		// Driver errors can name hosts; keep them in the logs only.
+		log.Printf("readiness ping failed: %v", err)
+		return dependencyStatus{Status: "down"}
+	}
+	return dependencyStatus{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+}
+
+// pools names every database pool, configured or not.
+func (s *Server) pools() map[string]*pgxpool.Pool {
+	return map[string]*pgxpool.Pool{
+		"warehouse":           s.store.pool,
+		"warehouse_replica":   s.store.replica,
+		"warehouse_secondary": s.store.secondary,
+		"metrics":             s.store.metricsPool,
+	}
+}
+
+// pingDependencies pings every pool concurrently.
+func (s *Server) pingDependencies(ctx context.Context) map[string]*dependencyStatus {
+	pools := s.pools()
+	deps := make(map[string]*dependencyStatus, len(pools))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st := pingDependency(ctx, pool)
+			mu.Lock()
+			deps[name] = &st
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return deps
+}
+
+// handleReadyz reports whether this instance can serve content. The
+// warehouse is required; the metrics DB only degrades tracking, so its
+// failure is reported without failing readiness.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	deps := s.pingDependencies(r.Context())
+
+	status, code := "ok", http.StatusOK
+	switch {
+	case s.store.pool != nil && deps["warehouse"].Status != "ok",
+		s.store.split.Load() > 0 && deps["warehouse_secondary"].Status != "ok":
+		status, code = "unavailable", http.StatusServiceUnavailable
+	case deps["metrics"].Status == "down", deps["warehouse_secondary"].Status == "down",
+		deps["warehouse_replica"].Status == "down":
+		status = "degraded"
+	}
+
+	// Failures seen on live traffic since the last successful query, which
+	// a single ping can miss (e.g. timeouts under load).
+	observed := s.store.health.Snapshot()
+	if status == "ok" && len(s.store.health.Degraded()) > 0 {
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{"status": status, "dependencies": deps, "observed": observed})
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(s.versionInfo)
+}
+
 func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
 	_, _ = w.Write([]byte(apiDocsMarkdown))
 }
 
+// ---------- Admin Handlers ----------
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type poolStats struct {
+	TotalConns    int32 `json:"total_conns"`
+	IdleConns     int32 `json:"idle_conns"`
+	AcquiredConns int32 `json:"acquired_conns"`
+	MaxConns      int32 `json:"max_conns"`
+}
+
+// handleHealthDetails is an admin-only triage view: dependency latency and
+// pool usage, cache size, goroutines, and build version in one response.
+func (s *Server) handleHealthDetails(w http.ResponseWriter, r *http.Request) {
+	pools := map[string]poolStats{}
+	for name, pool := range s.pools() {
+		if pool == nil {
+			continue
+		}
+		st := pool.Stat()
+		pools[name] = poolStats{
+			TotalConns:    st.TotalConns(),
+			IdleConns:     st.IdleConns(),
+			AcquiredConns: st.AcquiredConns(),
+			MaxConns:      st.MaxConns(),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"version":       s.versionInfo.Version,
+		"commit":        s.versionInfo.Commit,
+		"uptime":        time.Since(s.versionInfo.StartedAt).Round(time.Second).String(),
+		"dependencies":  s.pingDependencies(r.Context()),
+		"observed":      s.store.health.Snapshot(),
+		"pools":         pools,
+		"cache_entries": s.cache.Len(),
+		"goroutines":    runtime.NumGoroutine(),
+	})
+}
+
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"build":          s.versionInfo,
+		"content_source": s.contentSourceState(),
+		"cache_entries":  s.cache.Len(),
+	})
+}
+
+func (s *Server) handleAdminHealthEvents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"dependencies": s.store.health.Snapshot(),
+		"events":       s.store.health.Events(),
+	})
+}
+
+type recountJob struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Result     *RecountResult `json:"result,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func (s *Server) handleAdminRecountStatus(w http.ResponseWriter, r *http.Request) {
+	s.recountMu.Lock()
+	defer s.recountMu.Unlock()
+	if s.recount == nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "no recount has run")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.recount)
+}
+
+// handleAdminRecount starts a background recount of view counters over the
+// last ?days= (default 30), optionally for one ?email_id=. Poll GET for the
+// result; only one recount runs at a time.
+func (s *Server) handleAdminRecount(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 3650 {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "days must be 1-3650")
+			return
+		}
+		days = n
+	}
+	emailID := r.URL.Query().Get("email_id")
+
+	s.recountMu.Lock()
+	defer s.recountMu.Unlock()
+	if s.recount != nil && s.recount.FinishedAt == nil {
+		writeJSON(w, http.StatusConflict, s.recount)
+		return
+	}
+	job := &recountJob{StartedAt: time.Now().UTC()}
+	s.recount = job
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		defer cancel()
+		res, err := s.store.RecountViews(ctx, time.Now().AddDate(0, 0, -days), emailID)
+		s.recountMu.Lock()
+		defer s.recountMu.Unlock()
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		job.Result = &res
+		if err != nil {
+			job.Error = err.Error()
+			log.Printf("admin: recount failed: %v", err)
+			s.store.alerts.Notify(Alert{Severity: "warning", Source: "recount", Title: "view recount failed", Message: err.Error()})
+			return
+		}
+		log.Printf("admin: recount fixed %d discrepancies (%d views), %d remaining", res.Discrepancies, res.ViewDelta, res.Remaining)
+	}()
+
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleAdminPreviewToken mints a signed, expiring preview token for one
+// email ("*" for any email) so editors can proof unpublished campaigns.
+func (s *Server) handleAdminPreviewToken(w http.ResponseWriter, r *http.Request) {
+	if len(s.previewSecret) == 0 {
+		writeError(w, r, http.StatusConflict, codeConflict, "PREVIEW_SECRET not configured")
+		return
+	}
+	var req struct {
+		EmailID  string `json:"email_id"`
+		TTLHours int    `json:"ttl_hours"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil || req.EmailID == "" {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected {\"email_id\": \"...\", \"ttl_hours\": 72}")
+		return
+	}
+	if req.TTLHours <= 0 || req.TTLHours > 720 {
+		req.TTLHours = 72
+	}
+	exp := time.Now().Add(time.Duration(req.TTLHours) * time.Hour).UTC().Truncate(time.Second)
+	token := signPreviewToken(s.previewSecret, req.EmailID, exp)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token":      token,
+		"email_id":   req.EmailID,
+		"expires_at": exp,
+	})
+}
+
+// handleAdminCachePurge drops cached responses, optionally only those whose
+// path starts with ?prefix= (e.g. /emails).
+func (s *Server) handleAdminCachePurge(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	n := s.cache.Purge(func(key string) bool {
+		return strings.HasPrefix(cacheKeyPath(key), prefix)
+	})
+	log.Printf("admin: purged %d cache entries (prefix %q)", n, prefix)
+	writeJSON(w, http.StatusOK, map[string]any{"purged": n})
+}
+
+// handleAdminRewriteLinks forces tracking links to be re-rewritten. Rewriting
+// happens when email payloads are built, so this purges every cached
+// response that embeds email HTML.
+func (s *Server) handleAdminRewriteLinks(w http.ResponseWriter, r *http.Request) {
+	n := s.cache.Purge(func(key string) bool {
+		path := cacheKeyPath(key)
+		return path == "/emails" || path == "/mailing_lists/emails"
+	})
+	log.Printf("admin: purged %d email payloads for link rewriting", n)
+	writeJSON(w, http.StatusOK, map[string]any{"purged": n})
+}
+
+func (s *Server) contentSourceState() map[string]any {
+	return map[string]any{
+		"secondary_configured": s.store.secondary != nil,
+		"secondary_percent":    s.store.split.Load(),
+	}
+}
+
+func (s *Server) handleGetContentSource(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.contentSourceState())
+}
+
+// handleSetContentSource switches (0 or 100) or splits content reads between
+// the primary and secondary warehouses. The cache is left alone; entries
+// age out within its TTL.
+func (s *Server) handleSetContentSource(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SecondaryPercent *int `json:"secondary_percent"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil || req.SecondaryPercent == nil {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected {\"secondary_percent\": 0-100}")
+		return
+	}
+	if s.store.secondary == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "SECONDARY_DATABASE_URL not configured")
+		return
+	}
+	s.store.SetContentSplit(*req.SecondaryPercent)
+	log.Printf("admin: content reads to secondary set to %d%%", s.store.split.Load())
+	writeJSON(w, http.StatusOK, s.contentSourceState())
+}
+
+// ---------- Shadow Traffic ----------
+
+// ShadowMirror replays a sample of read requests against another deployment
+// (typically staging), fire-and-forget. Responses are discarded and mirroring
+// never delays or fails the real request.
+type ShadowMirror struct {
+	base    *url.URL
+	percent float64
+	client  *http.Client
+	sem     chan struct{}
+}
+
+func NewShadowMirror(baseURL string, percent float64) (*ShadowMirror, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return &ShadowMirror{
+		base:    u,
+		percent: percent,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		sem:     make(chan struct{}, 32),
+	}, nil
+}
+
+func (sm *ShadowMirror) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && mathrand.Float64()*100 < sm.percent {
+			sm.mirror(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (sm *ShadowMirror) mirror(r *http.Request) {
+	// Bounded in-flight mirrors: if staging is slow, drop samples rather
+	// than pile up goroutines.
+	select {
+	case sm.sem <- struct{}{}:
+	default:
+		return
+	}
+
+	target := *sm.base
+	target.Path = sm.base.Path + r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		<-sm.sem
+		return
+	}
+	// Deliberately no cookies or forwarded-for headers: shadow requests
+	// must not carry reader identity to another environment.
+	req.Header.Set("Accept", r.Header.Get("Accept"))
+	req.Header.Set("User-Agent", "news-shadow/1")
+	req.Header.Set("X-Shadow-Request", "1")
+
+	go func() {
+		defer func() { <-sm.sem }()
+		resp, err := sm.client.Do(req)
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
+
 // ---------- Errors ----------
 
+// apiErr is the body of every error response. Code is stable for clients to
+// branch on; Message is for humans and may change. RequestID is logged with
+// server errors, so a report quoting it can be traced.
 type apiErr struct {
-	Message string `json:"message"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error codes. New codes may be added; existing ones don't change meaning.
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeRateLimited      = "rate_limited"
+	codeInternal         = "internal"
+	codeNotImplemented   = "not_implemented"
+	codeUpstreamError    = "upstream_error"
+	codeUpstreamTimeout  = "upstream_timeout"
+)
+
+var errNotFound = errors.New("not found")
+
+// statusError is an error a handler wants surfaced with a specific status,
+// e.g. a bad parameter discovered inside a jsonCached builder.
+type statusError struct {
+	status  int
+	code    string
+	message string
 }
 
-func httpError(w http.ResponseWriter, err error) {
-	status := http.StatusInternalServerError
-	public := "internal server error"
+func (e *statusError) Error() string { return e.message }
+
+func badRequest(message string) error {
+	return &statusError{status: http.StatusBadRequest, code: codeBadRequest, message: message}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, apiErr{Code: code, Message: message, RequestID: middleware.GetReqID(r.Context())})
+}
+
+func httpError(w http.ResponseWriter, r *http.Request, err error) {
+	var se *statusError
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+		return
+	case errors.As(err, &se):
+		writeError(w, r, se.status, se.code, se.message)
+		return
+	case errors.Is(err, errNoWarehouse):
+		writeError(w, r, http.StatusNotImplemented, codeNotImplemented, err.Error())
+		return
+	}
 
+	status, code, public := http.StatusInternalServerError, codeInternal, "internal server error"
 	switch {
 	case errors.Is(err, context.DeadlineExceeded):
-		status = http.StatusGatewayTimeout
-		public = "upstream timed out"
+		status, code, public = http.StatusGatewayTimeout, codeUpstreamTimeout, "upstream timed out"
 	case func() bool { nerr, ok := err.(net.Error); return ok && nerr.Timeout() }():
-		status = http.StatusGatewayTimeout
-		public = "network timeout"
+		status, code, public = http.StatusGatewayTimeout, codeUpstreamTimeout, "network timeout"
 	}
-
-	log.Printf("error: %v", err)
-
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(apiErr{Message: public})
+	log.Printf("error [%s]: %v", middleware.GetReqID(r.Context()), err)
+	writeError(w, r, status, code, public)
 }
 
 // ---------- Main ----------
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	_ = godotenv.Load()
-	ctx := context.Background()
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		runServe(args)
+	case "migrate":
+		runMigrate(args)
+	case "export":
+		runExport(args)
+	case "warm":
+		runWarm(args)
+	case "seed":
+		runSeed(args)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
 
+// openStore connects to every configured database; close releases them.
+func openStore(ctx context.Context) (store *Store, close func()) {
+	if os.Getenv("MOCK_DATA") == "1" {
+		store, err := newMockStore()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("MOCK_DATA=1, serving embedded fixtures without any database")
+		return store, func() {}
+	}
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
 		log.Fatal("DATABASE_URL is required")
 	}
 	metricsDBURL := os.Getenv("METRICS_DATABASE_URL")
 	
-	store, err := NewStore(ctx, dbURL, metricsDBURL)
+	store, err := NewStore(ctx, dbURL, os.Getenv("DATABASE_REPLICA_URL"), os.Getenv("SECONDARY_DATABASE_URL"), metricsDBURL)
 	if err != nil {
 		log.Fatalf("db connect: %v", err)
 	}
-	defer store.pool.Close()
-	if store.metricsPool != nil {
-		defer store.metricsPool.Close()
+	if store.source, err = newContentSource(store); err != nil {
+		log.Fatal(err)
+	}
+	if store.replica != nil {
+		log.Printf("read replica configured, content reads routed to it")
+	}
+	if store.secondary != nil {
+		pct, err := strconv.Atoi(env("CONTENT_SECONDARY_PERCENT", "0"))
+		if err != nil {
+			log.Fatalf("invalid CONTENT_SECONDARY_PERCENT: %v", err)
+		}
+		store.SetContentSplit(pct)
+		log.Printf("secondary warehouse configured, %d%% of content reads routed to it", store.split.Load())
+	}
+	return store, func() {
+		for _, p := range []*pgxpool.Pool{store.pool, store.replica, store.secondary, store.metricsPool} {
+			if p != nil {
+				p.Close()
+			}
+		}
 	}
+}
+
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "", "TOML config file (overrides CONFIG_FILE)")
+	_ = fs.Parse(args)
+	loadEnv(*configPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, closeStore := openStore(ctx)
+	defer closeStore()
 
-	if err := store.RunMetricsMigrations(ctx); err != nil {
+	if os.Getenv("SKIP_MIGRATIONS") == "1" {
+		log.Println("SKIP_MIGRATIONS=1, not running metrics migrations")
+	} else if err := store.RunMetricsMigrations(ctx); err != nil {
 		log.Fatalf("metrics migrations failed: %v", err)
 	}
+	if os.Getenv("MIGRATE_ONLY") == "1" {
+		log.Println("MIGRATE_ONLY=1, exiting after migrations (prefer the migrate command)")
+		return
+	}
+
+	if alerts := NewAlerterFromEnv(); alerts != nil {
+		store.alerts = alerts
+		store.health.onEvent = alerts.HealthEvent
+	}
+	store.region = os.Getenv("REGION")
+	store.publicBase = strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	store.shortLinks = os.Getenv("LINK_SHORTLINKS") == "1"
+	store.linkBase = env("LINK_RELATIVE_BASE", "https://hackclub.com")
+	store.sessions = NewSessionHasherFromEnv(store.metricsPool != nil)
+	store.counts = newHotCountsFromEnv(ctx, store)
+	store.memo = newCountMemoFromEnv()
+	store.jobs = NewJobs(ctx, store)
+	store.StartSessionHashBackfill()
+	store.StartViewCountRollup()
+	store.StartStatsRollup()
+	store.StartReplicaMonitor(ctx)
+	store.StartEmailOverrideRefresh(ctx)
+	store.StartSenderNameRefresh(ctx)
+	store.StartListLogoRefresh(ctx)
+	store.StartSubscriberSnapshots()
+	store.StartLinkChecker()
+	store.StartImageSizes()
+	store.StartEnrichment()
+	store.StartEmbeddings(ctx)
 
 	srv := NewServer(store)
+	srv.slack = NewSlackFromEnv(srv)
+	srv.StartPublishWatcher()
+	index, err := newSearchIndexFromEnv()
+	if err != nil {
+		log.Fatalf("search index: %v", err)
+	}
+	srv.searchIndex = index
+	srv.StartSearchIndexSync()
 
-	var trustedCIDRs []*net.IPNet
-	if cidrStr := os.Getenv("TRUSTED_PROXY_CIDRS"); cidrStr != "" {
-		for _, cidr := range strings.Split(cidrStr, ",") {
-			_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
-			if err != nil {
-				log.Printf("warning: invalid CIDR %q: %v", cidr, err)
-				continue
-			}
-			trustedCIDRs = append(trustedCIDRs, n)
+	tlsConf, err := tlsFromEnv()
+	if err != nil {
+		log.Fatalf("tls config: %v", err)
+	}
+
+	if path := os.Getenv("ROBOTS_TXT_PATH"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("ROBOTS_TXT_PATH: %v", err)
 		}
+		srv.robotsTxt = b
+	}
+
+	if srv.captchaSecret != "" && captchaVerifyURLs[srv.captchaProvider] == "" {
+		log.Fatalf("unknown CAPTCHA_PROVIDER %q (want turnstile or hcaptcha)", srv.captchaProvider)
 	}
 
+	trustedCIDRs := parseCIDRList(os.Getenv("TRUSTED_PROXY_CIDRS"))
+	rateLimitBypass := parseCIDRList(os.Getenv("RATE_LIMIT_BYPASS_CIDRS"))
+	publicLimit := rateLimitFromEnv("PUBLIC", "30/1s", rateLimitBypass)
+	streamLimit := rateLimitFromEnv("STREAM", "100/1s", rateLimitBypass)
+	adminLimit := rateLimitFromEnv("ADMIN", "10/1s", nil)
+	subscribeLimit := rateLimitFromEnv("SUBSCRIBE", "5/1m", nil)
+	exportLimit := rateLimitFromEnv("EXPORT", "6/1m", rateLimitBypass)
+
 	var allowedOrigins []string
 	if originsStr := os.Getenv("CORS_ALLOWED_ORIGINS"); originsStr != "" {
 		for _, origin := range strings.Split(originsStr, ",") {
@@ -1151,71 +3920,541 @@ func main() {
 		log.Printf("CORS allowed origins: %v", allowedOrigins)
 	}
 
+	var shadow *ShadowMirror
+	if base := os.Getenv("SHADOW_BASE_URL"); base != "" {
+		percent, err := strconv.ParseFloat(env("SHADOW_SAMPLE_PERCENT", "1"), 64)
+		if err != nil {
+			log.Fatalf("invalid SHADOW_SAMPLE_PERCENT: %v", err)
+		}
+		shadow, err = NewShadowMirror(base, percent)
+		if err != nil {
+			log.Fatalf("invalid SHADOW_BASE_URL: %v", err)
+		}
+		log.Printf("shadowing %.2f%% of read traffic to %s", percent, base)
+	}
+
+	emailHTMLPublic := os.Getenv("EMAIL_HTML_PUBLIC") == "1"
+	apiKeys, err := parseAPIKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		log.Fatalf("invalid API_KEYS: %v", err)
+	}
+	if len(apiKeys) > 0 {
+		log.Printf("read API requires one of %d API keys", len(apiKeys))
+	}
+
+	vi := newVersionInfo()
+	vi.Features = map[string]bool{
+		"metrics":             store.metricsPool != nil,
+		"timescaledb":         store.timescale,
+		"secondary_warehouse": store.secondary != nil,
+		"read_replica":        store.replica != nil,
+		"shadow_traffic":      shadow != nil,
+		"cache_debug_headers": srv.cacheDebug,
+		"admin_api":           os.Getenv("ADMIN_API_KEY") != "" || os.Getenv("ADMIN_API_KEYS") != "",
+		"cors":                len(allowedOrigins) > 0,
+		"api_keys":            len(apiKeys) > 0,
+		"email_html_public":   emailHTMLPublic,
+		"hsts":                os.Getenv("ENABLE_HSTS") == "1",
+		"native_tls":          tlsConf != nil,
+		"alerts":              store.alerts != nil,
+		"pg_notify":           srv.pgNotifier != nil,
+		"subscribe":           srv.loopsAPIKey != "",
+		"upcoming":            os.Getenv("ENABLE_UPCOMING") == "1",
+		"subscribe_captcha":   srv.captchaSecret != "",
+		"mock_data":           store.source.Name() == "mock",
+		"session_hash_secret": os.Getenv("SESSION_HASH_SECRET") != "",
+		"webhooks":            os.Getenv("WEBHOOK_URLS") != "",
+		"webhook_signing":     os.Getenv("WEBHOOK_SECRET") != "",
+		"slack":               srv.slack != nil,
+		"search_index":        srv.searchIndex != nil,
+		"shortlinks":          store.shortLinks,
+		"image_proxy":         store.imageProxy != "",
+		"enrichment":          store.llm != nil,
+		"semantic_search":     store.embedder != nil,
+	}
+	vi.Settings = map[string]string{
+		"cache_ttl":                 srv.cache.ttl.String(),
+		"metrics_buffer_size":       env("METRICS_BUFFER_SIZE", "10000"),
+		"content_secondary_percent": strconv.Itoa(int(store.split.Load())),
+		"shadow_sample_percent":     env("SHADOW_SAMPLE_PERCENT", "1"),
+		"cors_allowed_origins":      strings.Join(allowedOrigins, ","),
+		"trusted_proxy_cidrs":       os.Getenv("TRUSTED_PROXY_CIDRS"),
+		"rate_limit_public":         env("RATE_LIMIT_PUBLIC", "30/1s"),
+		"rate_limit_stream":         env("RATE_LIMIT_STREAM", "100/1s"),
+		"rate_limit_admin":          env("RATE_LIMIT_ADMIN", "10/1s"),
+		"rate_limit_subscribe":      env("RATE_LIMIT_SUBSCRIBE", "5/1m"),
+		"rate_limit_export":         env("RATE_LIMIT_EXPORT", "6/1m"),
+		"rate_limit_bypass_cidrs":   os.Getenv("RATE_LIMIT_BYPASS_CIDRS"),
+		"referrer_site_hosts":       env("REFERRER_SITE_HOSTS", "hackclub.com"),
+		"journey_min_sessions":      env("JOURNEY_MIN_SESSIONS", "5"),
+		"region":                    store.region,
+		"archive_base_url":          srv.archiveBase,
+		"public_base_url":           store.publicBase,
+		"link_relative_base":        store.linkBase,
+		"enrich_llm_model":          env("ENRICH_LLM_MODEL", defaultEnrichModel),
+		"enrich_embeddings_model":   env("ENRICH_EMBEDDINGS_MODEL", defaultEmbeddingsModel),
+		"publisher_name":            srv.publisherName,
+		"publisher_logo_url":        srv.publisherLogo,
+		"embed_frame_ancestors":     srv.embedFrameAncestors,
+		"notify_backend":            env("NOTIFY_BACKEND", "local"),
+		"click_limiter":             env("CLICK_LIMITER", "local"),
+		"click_rate":                env("CLICK_RATE", defaultClickRate),
+		"click_burst":               strconv.Itoa(envInt("CLICK_BURST", defaultClickBurst)),
+		"tls_mode":                  tlsConf.mode(),
+		"http_redirect_addr":        os.Getenv("HTTP_REDIRECT_ADDR"),
+		"listen":                    env("LISTEN", env("HOST", "127.0.0.1")+":"+env("PORT", "8080")),
+		"config_file":               os.Getenv("CONFIG_FILE"),
+		"cache_max_entries":         strconv.Itoa(srv.cache.max),
+		"db_max_conns":              strconv.Itoa(envInt("DB_MAX_CONNS", 10)),
+		"metrics_db_max_conns":      strconv.Itoa(envInt("METRICS_DB_MAX_CONNS", 5)),
+		"content_provider":          store.source.Name(),
+		"metrics_count_mode":        env("METRICS_COUNT_MODE", countExact),
+		"metrics_count_ttl":         env("METRICS_COUNT_TTL", "5s"),
+		"stats_rollup_interval":     envDuration("STATS_ROLLUP_INTERVAL", defaultStatsRollupInterval).String(),
+		"cache_warm_interval":       envDuration("CACHE_WARM_INTERVAL", 0).String(),
+		"session_hash_rotation":     store.sessions.rotation.String(),
+		"publish_watch_interval":    envDuration("PUBLISH_WATCH_INTERVAL", defaultPublishWatchInterval).String(),
+		"link_check_interval":       envDuration("LINK_CHECK_INTERVAL", defaultLinkCheckInterval).String(),
+		"link_archive_fallback":     store.linkArchive,
+		"search_index_provider":     os.Getenv("SEARCH_INDEX_PROVIDER"),
+	}
+	srv.versionInfo = vi
+	log.Printf("news %s (commit %s, %s)", vi.Version, vi.Commit, vi.GoVersion)
+	log.Printf("features: %v", vi.Features)
+
 	r := chi.NewRouter()
 	r.Use(trustProxyRealIP(trustedCIDRs))
 	r.Use(middleware.RealIP)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/healthz"))
-	r.Use(middleware.Timeout(30 * time.Second))
+	r.Use(timeoutExcept(30*time.Second, isStreamRequest))
 	if len(allowedOrigins) > 0 {
 		r.Use(corsMiddleware(allowedOrigins))
 	}
 	r.Use(securityHeaders())
 
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method not allowed")
+	})
+
+	r.Get("/readyz", srv.handleReadyz)
+	r.Get("/version", srv.handleVersion)
+
 	r.Group(func(r chi.Router) {
-		r.Use(httprate.LimitByIP(30, 1*time.Second))
+		r.Use(publicLimit)
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) { http.Redirect(w, r, "/docs", http.StatusFound) })
 		r.Get("/docs", srv.handleDocs)
-		r.Get("/mailing_lists", srv.handleMailingLists)
-		r.Get("/emails", srv.handleEmails)
+		r.Get("/robots.txt", srv.handleRobots)
+
+		// Tracking beacons are called from readers' browsers, which can't
+		// hold an API key, so they stay open even when API_KEYS is set.
 		r.Get("/emails/{id}/view", srv.handleEmailView)
-		r.Get("/mailing_lists/emails", srv.handleMailingListsEmails)
+		r.Get("/emails/{id}/pixel.gif", srv.handleEmailPixel)
+		r.Get("/pages/view", srv.handlePageView)
+		r.Post("/rum", srv.handleRUM)
+		r.Post("/emails/{id}/scroll", srv.handleEmailScroll)
+		r.Post("/emails/{id}/read", srv.handleEmailRead)
+		r.Get("/emails/{id}/like", srv.handleEmailLikeState)
+		r.Post("/emails/{id}/like", srv.handleEmailLike)
+		r.Delete("/emails/{id}/like", srv.handleEmailUnlike)
+		r.Post("/track/batch", srv.handleTrackBatch)
+		// Embeds and rendered pages are loaded in iframes on other sites,
+		// which can't send API keys either.
+		r.Get("/emails/{id}/embed", srv.handleEmailEmbed)
+		// Full email pages carry the whole email, so they're keyed like
+		// /emails/{id} unless EMAIL_HTML_PUBLIC=1 opts them into iframes.
+		if emailHTMLPublic {
+			r.Get("/emails/{id}/html", srv.handleEmailHTML)
+		}
+		r.Get("/emails/{id}/qr.png", srv.handleEmailQR)
+		r.Get("/mailing_lists/{id}/logo", srv.handleMailingListLogo)
+
+		if srv.loopsAPIKey != "" {
+			r.With(subscribeLimit).Post("/mailing_lists/{id}/subscribe", srv.handleSubscribe)
+		}
+
+		r.Group(func(r chi.Router) {
+			if len(apiKeys) > 0 {
+				r.Use(requireAPIKey(apiKeys))
+			}
+			r.Get("/emails/{id}", srv.handleEmail)
+			if !emailHTMLPublic {
+				r.Get("/emails/{id}/html", srv.handleEmailHTML)
+			}
+			r.With(exportLimit).Get("/export/emails", srv.handleExportEmails)
+
+			// Side-effect-free reads; only these are eligible for shadowing.
+			r.Group(func(r chi.Router) {
+				if shadow != nil {
+					r.Use(shadow.Middleware)
+				}
+				r.Get("/mailing_lists", srv.handleMailingLists)
+				r.Get("/emails", srv.handleEmails)
+				r.Get("/changes", srv.handleChanges)
+				r.Get("/emails/{id}/referrers", srv.handleEmailReferrers)
+				r.Get("/emails/{id}/devices", srv.handleEmailDevices)
+				r.Get("/emails/{id}/regions", srv.handleEmailRegions)
+				r.Get("/emails/{id}/links", srv.handleEmailLinks)
+				r.Get("/emails/{id}/scroll", srv.handleEmailScrollDepth)
+				r.Get("/emails/{id}/next", srv.handleEmailNextReads)
+				r.Get("/emails/{id}/similar", srv.handleEmailSimilar)
+				r.Get("/emails/{id}/jsonld", srv.handleEmailJSONLD)
+				r.Get("/emails/{id}/social", srv.handleEmailSocial)
+				r.Get("/emails/most_liked", srv.handleMostLiked)
+				r.Get("/pages/top", srv.handleTopPages)
+				r.Get("/rum/summary", srv.handleRUMSummary)
+				r.Get("/rum/timeseries", srv.handleRUMTimeseries)
+				r.Get("/mailing_lists/emails", srv.handleMailingListsEmails)
+				r.Get("/mailing_lists/{id}/subscribers/timeseries", srv.handleSubscriberTimeseries)
+				r.Get("/mailing_lists/{id}/theme", srv.handleMailingListTheme)
+				r.Get("/series", srv.handleSeries)
+				r.Get("/topics", srv.handleTopics)
+				r.Get("/search", srv.handleSearch)
+				if os.Getenv("ENABLE_UPCOMING") == "1" {
+					r.Get("/upcoming", srv.handleUpcoming)
+				}
+			})
+		})
 	})
 
 	r.Group(func(r chi.Router) {
-		r.Use(httprate.LimitByIP(100, 1*time.Second))
+		r.Use(streamLimit)
+		if len(apiKeys) > 0 {
+			r.Use(requireAPIKey(apiKeys))
+		}
 		r.Get("/emails/{id}/stats/stream", srv.handleEmailStatsStream)
+		r.Get("/stats/stream", srv.handleStatsStream)
 	})
 
+	// Operator endpoints only exist when ADMIN_API_KEY(S) is set.
+	if adminKeys := adminKeysFromEnv(); len(adminKeys) > 0 {
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(adminLimit)
+			r.Use(requireAdminKey(adminKeys))
+			r.Use(srv.auditAdmin)
+			r.Get("/audit", srv.handleAdminAudit)
+			r.Get("/jobs", srv.handleAdminJobs)
+			r.Get("/webhooks", srv.handleAdminWebhooks)
+			r.Get("/webhooks/{id}", srv.handleAdminWebhook)
+			r.Post("/webhooks/{id}/retry", srv.handleAdminRetryWebhook)
+			r.Get("/config", srv.handleAdminConfig)
+			r.Get("/dashboard", srv.handleAdminDashboard)
+			r.Get("/dashboard/data", srv.handleAdminDashboardData)
+			r.With(streamLimit).Get("/stats/stream", srv.handleStatsStream)
+			r.Get("/health/events", srv.handleAdminHealthEvents)
+			r.Post("/cache/purge", srv.handleAdminCachePurge)
+			r.Get("/stats/recount", srv.handleAdminRecountStatus)
+			r.Post("/stats/recount", srv.handleAdminRecount)
+			r.Post("/links/rewrite", srv.handleAdminRewriteLinks)
+			r.Get("/links/broken", srv.handleAdminBrokenLinks)
+			r.Post("/preview-tokens", srv.handleAdminPreviewToken)
+			r.Get("/content-source", srv.handleGetContentSource)
+			r.Put("/content-source", srv.handleSetContentSource)
+			r.Get("/pending", srv.handleAdminPendingEmails)
+			r.Get("/pending/{id}", srv.handleAdminPendingEmail)
+			r.Post("/pending/{id}/approve", srv.handleAdminApproveEmail)
+			r.Post("/pending/{id}/reject", srv.handleAdminRejectEmail)
+			r.Get("/emails/{id}/revisions", srv.handleAdminEmailRevisions)
+			r.Get("/emails/{id}/revisions/diff", srv.handleAdminEmailRevisionDiff)
+			r.Get("/emails/{id}/revisions/{revision}", srv.handleAdminEmailRevision)
+			r.Post("/emails/{id}/revisions/{revision}/restore", srv.handleAdminRestoreRevision)
+			r.Get("/sender-names", srv.handleAdminSenderNames)
+			r.Put("/sender-names", srv.handleAdminSetSenderName)
+			r.Get("/mailing_lists/logos", srv.handleAdminListLogos)
+			r.Put("/mailing_lists/{id}/logo", srv.handleAdminSetListLogo)
+			r.Delete("/mailing_lists/{id}/logo", srv.handleAdminDeleteListLogo)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(adminLimit)
+			r.Use(requireAdminKey(adminKeys))
+			r.Get("/healthz/details", srv.handleHealthDetails)
+		})
+	}
+
 	// Link clicks: ALWAYS redirect, but rate limit tracking
 	r.Get("/emails/{id}/click/{index}", srv.handleLinkClick)
+	r.Get("/l/{code}", srv.handleShortlink)
 
 	addr := env("HOST", "127.0.0.1") + ":" + env("PORT", "8080")
-	log.Printf("listening on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	httpSrv := &http.Server{Addr: addr, Handler: r}
+	httpSrv.RegisterOnShutdown(srv.StopStreams)
+	if tlsConf != nil {
+		tlsConf.configure(httpSrv)
+		if redirectAddr := os.Getenv("HTTP_REDIRECT_ADDR"); redirectAddr != "" {
+			tlsConf.startHTTPRedirect(ctx, redirectAddr)
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("http shutdown: %v", err)
+		}
+	}()
+
+	ln, listenAddr, err := listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("listening on %s (tls: %s)", listenAddr, tlsConf.mode())
+	startCacheWarming(store.jobs)
+	if tlsConf != nil {
+		err = tlsConf.serve(httpSrv, ln)
+	} else {
+		err = httpSrv.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	store.jobs.Stop(10 * time.Second)
+	srv.Close()
+}
+
+// timeoutExcept is middleware.Timeout for every request except those
+// matching skip.
+func timeoutExcept(d time.Duration, skip func(*http.Request) bool) func(http.Handler) http.Handler {
+	timeout := middleware.Timeout(d)
+	return func(next http.Handler) http.Handler {
+		timed := timeout(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			timed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// parseCIDRList parses a comma-separated CIDR list, skipping (and logging)
+// invalid entries.
+func parseCIDRList(s string) []*net.IPNet {
+	if s == "" {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			log.Printf("warning: invalid CIDR %q: %v", cidr, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// parseRateLimit parses "<requests>/<window>", e.g. "30/1s" or "600/1m".
+func parseRateLimit(s string) (int, time.Duration, error) {
+	reqStr, winStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("want <requests>/<window>, got %q", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(reqStr))
+	if err != nil || n <= 0 {
+		return 0, 0, fmt.Errorf("invalid request count %q", reqStr)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(winStr))
+	if err != nil || window <= 0 {
+		return 0, 0, fmt.Errorf("invalid window %q", winStr)
+	}
+	return n, window, nil
+}
+
+// rateLimitFromEnv builds the per-IP limiter for one route group from
+// RATE_LIMIT_<GROUP> (default def). Clients in bypass (CDN and build servers
+// that crawl every page) skip the limiter entirely.
+func rateLimitFromEnv(group, def string, bypass []*net.IPNet) func(http.Handler) http.Handler {
+	name := "RATE_LIMIT_" + group
+	n, window, err := parseRateLimit(env(name, def))
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	limit := httprate.Limit(n, window, httprate.WithKeyFuncs(keyByClient), httprate.WithLimitHandler(writeRateLimited))
+	return func(next http.Handler) http.Handler {
+		limited := limit(next)
+		if len(bypass) == 0 {
+			return limited
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := remoteIP(r); ip != nil {
+				for _, n := range bypass {
+					if n.Contains(ip) {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			limited.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeRateLimited is the 429 response for every limiter. httprate has
+// already set X-RateLimit-Limit/Remaining/Reset; its Retry-After is the full
+// window length truncated to seconds (0 for sub-second windows), so replace
+// it with the time left until the reset, rounded up.
+func writeRateLimited(w http.ResponseWriter, r *http.Request) {
+	retry := int64(1)
+	if reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		retry = max(1, reset-time.Now().Unix())
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+}
+
+// remoteIP parses r.RemoteAddr, which middleware.RealIP may have replaced
+// with a bare IP.
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// clientKey identifies the client behind r for rate limiting. IPv4 clients
+// are keyed by address (without the port, which changes per connection);
+// IPv6 clients by their /64, since one host is typically handed a whole
+// prefix and could otherwise rotate through addresses to dodge limits.
+// IPv4-mapped IPv6 addresses count as their IPv4 address.
+func clientKey(r *http.Request) string {
+	ip := remoteIP(r)
+	if ip == nil {
+		return r.RemoteAddr // unix socket peer
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
+
+// keyByClient is clientKey for httprate.
+func keyByClient(r *http.Request) (string, error) {
+	return clientKey(r), nil
+}
+
+var forwardedHostRegex = regexp.MustCompile(`^[a-zA-Z0-9.-]{1,253}(:\d{1,5})?$`)
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
+// trustProxyRealIP makes forwarding headers safe to read downstream. From
+// untrusted peers they're all dropped. From trusted proxies, X-Forwarded-For
+// is resolved to the first untrusted hop (walking right to left, since each
+// proxy appends) and handed to middleware.RealIP as X-Real-IP, and
+// X-Forwarded-Proto/-Host are reduced to the value our proxy appended, the
+// rightmost (anything left of it came from the client), or dropped if
+// malformed.
 func trustProxyRealIP(trustedCIDRs []*net.IPNet) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			host, _, _ := net.SplitHostPort(r.RemoteAddr)
-			ip := net.ParseIP(host)
-			trusted := false
-			for _, n := range trustedCIDRs {
-				if n.Contains(ip) {
-					trusted = true
-					break
+			peer := remoteIP(r)
+			// Unix socket peers (LISTEN=unix:...) have no IP: they're the
+			// proxy on this host, so they're trusted.
+			if peer != nil && !ipInNets(peer, trustedCIDRs) {
+				for _, h := range []string{"X-Forwarded-For", "X-Real-IP", "True-Client-IP", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded"} {
+					r.Header.Del(h)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+				client := peer
+				hops := strings.Split(strings.Join(xff, ","), ",")
+				for i := len(hops) - 1; i >= 0; i-- {
+					ip := net.ParseIP(strings.TrimSpace(hops[i]))
+					if ip == nil {
+						break // garbage from the client; keep the last hop we could vouch for
+					}
+					client = ip
+					if !ipInNets(ip, trustedCIDRs) {
+						break
+					}
 				}
+				r.Header.Del("True-Client-IP")
+				r.Header.Set("X-Real-IP", client.String())
 			}
-			if !trusted {
-				r.Header.Del("X-Forwarded-For")
-				r.Header.Del("X-Real-IP")
+
+			if proto := strings.ToLower(lastForwardedValue(r.Header, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+				r.Header.Set("X-Forwarded-Proto", proto)
+			} else {
+				r.Header.Del("X-Forwarded-Proto")
+			}
+			if host := lastForwardedValue(r.Header, "X-Forwarded-Host"); forwardedHostRegex.MatchString(host) {
+				r.Header.Set("X-Forwarded-Host", host)
+			} else {
+				r.Header.Del("X-Forwarded-Host")
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// lastForwardedValue is the rightmost entry of a comma-separated forwarding
+// header, across all its lines.
+func lastForwardedValue(h http.Header, name string) string {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	last := values[len(values)-1]
+	if i := strings.LastIndexByte(last, ','); i >= 0 {
+		last = last[i+1:]
+	}
+	return strings.TrimSpace(last)
+}
+
+// isHTTPS reports whether the client reached us over TLS, directly or via a
+// trusted proxy (trustProxyRealIP strips X-Forwarded-Proto otherwise).
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+var originLabelsRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+
+// originMatches reports whether origin is allowed by pattern: an exact
+// origin, "*", or a wildcard subdomain like "https://*.hackclub.com" (which
+// matches any depth of subdomain but not the bare domain).
+func originMatches(origin, pattern string) bool {
+	if origin == pattern || pattern == "*" {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*.")
+	if !ok || !strings.HasSuffix(prefix, "://") {
+		return false
+	}
+	suffix = "." + suffix
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) || len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	return originLabelsRegex.MatchString(origin[len(prefix) : len(origin)-len(suffix)])
+}
+
 func corsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 	localhostRegex := regexp.MustCompile(`^https?://localhost(:\d+)?$|^https?://127\.0\.0\.1(:\d+)?$|^https?://\[::1\](:\d+)?$`)
 	
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
-			
+
+			// The CORS headers depend on Origin, so shared caches must key
+			// on it even when this origin isn't allowed.
+			w.Header().Add("Vary", "Origin")
+
 			if origin != "" {
 				allowed := false
 				
@@ -1223,7 +4462,7 @@ func corsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 					allowed = true
 				} else if len(allowedOrigins) > 0 {
 					for _, allowedOrigin := range allowedOrigins {
-						if origin == allowedOrigin || allowedOrigin == "*" {
+						if originMatches(origin, allowedOrigin) {
 							allowed = true
 							break
 						}
@@ -1232,10 +4471,11 @@ func corsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 				
 				if allowed {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-					w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+					w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 					w.Header().Set("Access-Control-Allow-Credentials", "true")
 					w.Header().Set("Access-Control-Max-Age", "86400")
+					w.Header().Set("Access-Control-Expose-Headers", "X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
 				}
 			}
 			
@@ -1249,6 +4489,101 @@ func corsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
 	}
 }
 
+type apiKey struct {
+	ID  string // non-secret name used in logs
+	Key string
+	RPS int // per-key requests/second
+}
+
+// parseAPIKeys reads "id:key[:rps],..." (rps defaults to 50).
+func parseAPIKeys(spec string) ([]apiKey, error) {
+	var keys []apiKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("entry %q: want id:key[:rps]", parts[0])
+		}
+		k := apiKey{ID: parts[0], Key: parts[1], RPS: 50}
+		if len(parts) == 3 {
+			n, err := strconv.Atoi(parts[2])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("entry %q: invalid rps", parts[0])
+			}
+			k.RPS = n
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+type apiKeyCtxKey struct{}
+
+// requireAPIKey accepts "Authorization: Bearer <key>" for any configured key
+// and applies that key's own rate limit.
+func requireAPIKey(keys []apiKey) func(http.Handler) http.Handler {
+	limiters := make([]func(http.Handler) http.Handler, len(keys))
+	for i, k := range keys {
+		id := k.ID
+		limiters[i] = httprate.Limit(k.RPS, 1*time.Second,
+			httprate.WithKeyFuncs(func(r *http.Request) (string, error) { return id, nil }),
+			httprate.WithLimitHandler(writeRateLimited))
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok {
+				// Compare against every key so timing doesn't reveal which matched.
+				match := -1
+				for i, k := range keys {
+					if subtle.ConstantTimeCompare([]byte(got), []byte(k.Key)) == 1 {
+						match = i
+					}
+				}
+				if match >= 0 {
+					ctx := context.WithValue(r.Context(), apiKeyCtxKey{}, keys[match].ID)
+					limiters[match](next).ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="news"`)
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "missing or invalid API key")
+		})
+	}
+}
+
+// requireAdminKey gates operator endpoints behind a bearer token and notes
+// which key was used for the audit log. Per-key rps is ignored; adminLimit
+// applies.
+func requireAdminKey(keys []apiKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			// Browsers (the dashboard) send the key as a Basic password.
+			if _, pass, ok := r.BasicAuth(); ok {
+				got = pass
+			}
+			// Compare against every key so timing doesn't reveal which matched.
+			match := -1
+			for i, k := range keys {
+				if subtle.ConstantTimeCompare([]byte(got), []byte(k.Key)) == 1 {
+					match = i
+				}
+			}
+			if match < 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="news admin", charset="UTF-8"`)
+				writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "unauthorized")
+				return
+			}
+			ctx := context.WithValue(r.Context(), adminKeyCtxKey{}, keys[match].ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func securityHeaders() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1272,8 +4607,45 @@ A read-only, PII-safe headless CMS for generating a blog from mailing lists and
 
 Base URL: ` + "`/`" + `
 
+JSON responses are compact. Add ` + "`?pretty=true`" + ` to any content read for indented output while debugging; it doesn't affect caching.
+
+Absolute URLs this API emits (such as click-tracking links in email HTML) use ` + "`PUBLIC_BASE_URL`" + ` when it's set, rather than the request's ` + "`Host`" + `. Production deployments should always set it.
+
+` + "`/robots.txt`" + ` lets crawlers index the docs and content reads but keeps them off click redirects, tracking beacons, previews, and operator endpoints (override with ` + "`ROBOTS_TXT_PATH`" + `).
+
 ## Authentication
-None (read-only). You should front this behind your CDN or add your own layer if needed.
+None by default (read-only). You should front this behind your CDN or add your own layer if needed.
+
+Deployments can require API keys by setting ` + "`API_KEYS=id:key[:rps],...`" + ` (e.g. ` + "`ssg:s3cret:100,frontend:0ther`" + `). Clients then send ` + "`Authorization: Bearer <key>`" + ` on content and analytics reads; each key gets its own per-second rate limit (default 50). These stay open regardless: ` + "`/docs`" + `, ` + "`/robots.txt`" + `, ` + "`/healthz`" + `, ` + "`/readyz`" + `, ` + "`/version`" + `, and the browser-side tracking endpoints (` + "`/emails/{id}/view`" + `, ` + "`/emails/{id}/click/{index}`" + `, ` + "`/l/{code}`" + `, ` + "`/pages/view`" + `, ` + "`POST /rum`" + `), ` + "`POST /mailing_lists/{id}/subscribe`" + `, the iframe-able ` + "`/emails/{id}/embed`" + ` card, QR codes (` + "`/emails/{id}/qr.png`" + `), and list logos (` + "`/mailing_lists/{id}/logo`" + `). ` + "`/emails/{id}/html`" + ` carries the whole email, so it needs a key like ` + "`/emails/{id}`" + ` unless ` + "`EMAIL_HTML_PUBLIC=1`" + ` opens it for iframes on other sites. Responses served for a key are ` + "`Cache-Control: private`" + `, so shared caches and CDNs don't replay them to clients without one.
+
+## Rate limits
+Requests are limited per client IP (30/s by default) and, with API keys, per key. IPv6 clients are limited per /64 prefix, which is usually one host or household. Every limited response carries:
+- ` + "`X-RateLimit-Limit`" + ` — requests allowed per window
+- ` + "`X-RateLimit-Remaining`" + ` — requests left in the current window
+- ` + "`X-RateLimit-Reset`" + ` — Unix time the current window ends
+
+Over the limit you get ` + "`429`" + ` with code ` + "`rate_limited`" + ` (see Errors) and a ` + "`Retry-After`" + ` header (seconds). Crawlers and SDKs should wait that long before retrying rather than failing the build.
+
+## Errors
+Every error response is JSON with a stable machine-readable ` + "`code`" + `, a human-readable ` + "`message`" + ` (wording may change; don't match on it), and the ` + "`request_id`" + ` to quote when reporting a problem:
+
+` + "```json" + `
+{ "code": "not_found", "message": "not found", "request_id": "api-1/abc123-000042" }
+` + "```" + `
+
+| Code | Status | Meaning |
+| --- | --- | --- |
+| ` + "`bad_request`" + ` | 400 | A parameter or body is invalid |
+| ` + "`unauthorized`" + ` | 401 | Missing or wrong API key |
+| ` + "`forbidden`" + ` | 403 | Credentials are valid but not for this (e.g. an expired preview token) |
+| ` + "`not_found`" + ` | 404 | No such route, or no published email/list with that ID (per-email analytics included) |
+| ` + "`method_not_allowed`" + ` | 405 | Route exists with another method |
+| ` + "`conflict`" + ` | 409 | The server isn't configured for this operation |
+| ` + "`rate_limited`" + ` | 429 | See Rate limits |
+| ` + "`internal`" + ` | 500 | Unexpected server error |
+| ` + "`not_implemented`" + ` | 501 | Unavailable in this deployment (e.g. ` + "`MOCK_DATA`" + `) |
+| ` + "`upstream_error`" + ` | 502 | A provider we call (Loops, captcha) failed |
+| ` + "`upstream_timeout`" + ` | 504 | A database or provider timed out |
 
 ## Data guarantees
 - **No PII**: We never expose recipient emails, names, or per-user data.
@@ -1281,15 +4653,26 @@ None (read-only). You should front this behind your CDN or add your own layer if
 - **Stability**: Fields are chosen for static site generation (SSG) and caching.
 
 ## Caching
-- Server-side in-memory TTL cache (30s).
-- HTTP cache headers: ` + "`Cache-Control: public, max-age=30, stale-while-revalidate=60`" + ` and ` + "`ETag`" + `.
+- Server-side in-memory TTL cache (30s). Cache entries are keyed on the path plus the query params the endpoint understands, in any order; unknown params are ignored.
+- HTTP cache headers: ` + "`Cache-Control: public, max-age=30, stale-while-revalidate=60`" + ` (` + "`private`" + ` instead of ` + "`public`" + ` when served for an API key) and ` + "`ETag`" + `.
 - Respect ` + "`If-None-Match`" + ` to avoid bytes over the wire.
+- ETags for emails, mailing lists, and ` + "`/changes`" + ` are derived from the content (every field of each email or list as served, including stats and the mailing list's name and logo; each change's ID and time), not the response bytes, so every replica returns the same ETag for the same content and CDN revalidation works across instances and restarts.
+- If a rebuild fails, the last cached copy is served (even if expired) rather than an error.
+- Content unpublished upstream is noticed within a minute (` + "`PUBLISH_WATCH_INTERVAL`" + `): cached copies are purged and each configured webhook (` + "`WEBHOOK_URLS`" + `) gets a POST like ` + "`{\"event\": \"email.unpublished\", \"at\": \"...\", \"data\": {<change>}}`" + ` (or ` + "`mailing_list.unpublished`" + `), with ` + "`data`" + ` shaped like a ` + "`/changes`" + ` item. Use it to purge your CDN. Each change is sent once however many replicas notice it, with the same ` + "`X-Webhook-ID`" + ` on every retry; a list made private sends one for the list and one for each of its emails.
+- Webhook deliveries are retried with exponential backoff for about five hours and carry ` + "`X-Webhook-ID`" + ` (the same on every retry, to deduplicate). With ` + "`WEBHOOK_SECRET`" + ` set they are signed: ` + "`X-Webhook-Timestamp`" + ` is the Unix time of the attempt and ` + "`X-Webhook-Signature`" + ` is ` + "`sha256=`" + ` plus the hex HMAC-SHA256 of ` + "`<timestamp>.<body>`" + `. Verify it and reject timestamps more than a few minutes old. Operators can inspect deliveries at ` + "`/admin/webhooks`" + `.
+- With ` + "`SLACK_WEBHOOK_URL`" + ` set, each newly published email is announced once in Slack (subject linked to its archive page, list and excerpt), within ` + "`PUBLISH_WATCH_INTERVAL`" + ` of publication.
+- With ` + "`CACHE_DEBUG_HEADERS=1`" + `, responses carry ` + "`X-Cache: HIT|MISS|STALE`" + ` and ` + "`X-Cache-Key-Hash`" + ` (a short hash of the server-side cache key, so identical keys can be spotted across requests).
+
+## Local development
+Run the server with ` + "`MOCK_DATA=1`" + ` to build against the API without database access: it serves a handful of realistic lists and emails from embedded fixtures, with send dates relative to startup. ` + "`/version`" + ` reports the ` + "`mock_data`" + ` feature. Series, search, upcoming, and subscribe read the warehouse directly and answer ` + "`501`" + ` in this mode, and nothing is tracked.
+
+For the full stack, ` + "`news seed`" + ` creates the ` + "`loops.*`" + ` tables in a local ` + "`DATABASE_URL`" + ` and loads the same demo lists and emails (plus a draft and a scheduled send), so every endpoint works against it.
 
 ---
 
 ## GET /mailing_lists
 
-List mailing lists with metadata and aggregate counts.
+List mailing lists with metadata and aggregate counts. Only public lists with at least one published email are listed; a private list's emails aren't served anywhere.
 
 ### Query Params
 - ` + "`limit`" + ` (int, default 50, max 200)
@@ -1305,17 +4688,44 @@ List mailing lists with metadata and aggregate counts.
       "name": "HCB Newsletter",
       "description": "Occasional emails about new features on HCB! hackclub.com/fiscal-sponsorship",
       "color": "#c87ae4",
-      "is_public": false,
+      "is_public": true,
       "subscriber_count": 12345,
       "last_updated_at": "2025-10-24T16:31:26.469823Z",
       "last_sent_at": "2025-10-10T03:47:14.357Z",
-      "sent_email_count": 12
+      "sent_email_count": 12,
+      "sender": "Team HCB"
     }
   ],
   "next_offset": 50
 }
 ` + "```" + `
 
+- ` + "`sender`" + ` is a curated display name for bylines (default ` + "`Hack Club`" + `); it is never a sender address.
+- ` + "`logo_url`" + ` is present when the list has a logo, here and in every email's ` + "`mailing_list`" + `. It's either an external image or ` + "`{PUBLIC_BASE_URL}/mailing_lists/{id}/logo?v=<hash>`" + `, which serves an uploaded one and changes with it; uploads are refused (409), and earlier ones hidden, while ` + "`PUBLIC_BASE_URL`" + ` is unset. Operators set logos with ` + "`PUT /admin/mailing_lists/{id}/logo`" + `, sending either ` + "`{\"logo_url\": \"https://...\"}`" + ` or the image itself (PNG, JPEG, GIF, WebP or SVG, up to 512KB, with its ` + "`Content-Type`" + `; SVGs must be well-formed with no scripts, event handlers, DTDs or references outside the file), and remove them with ` + "`DELETE`" + `.
+
+---
+
+## GET /mailing_lists/{id}/theme
+
+Branding derived from a list's color, so every consumer renders list badges the same way. 404 for lists not in ` + "`/mailing_lists`" + `.
+
+- ` + "`primary`" + `: the list's color as ` + "`#rrggbb`" + ` (` + "`#ec3750`" + ` when it isn't a usable hex color).
+- ` + "`contrast`" + `: black or white, whichever reads better on ` + "`primary`" + `; ` + "`contrast_ratio`" + ` is its WCAG ratio and ` + "`accessible`" + ` whether that's at least 4.5 (AA for body text).
+- ` + "`gradient`" + `: a lighter tint into ` + "`primary`" + ` (or ` + "`primary`" + ` into a shade, for very light colors), with a ready-made CSS value.
+- ` + "`logo_url`" + `: present when the list has a logo configured.
+
+` + "```json" + `
+{
+  "mailing_list_id": "clzvjqcvk00kq0ll4a8qu4qzz",
+  "primary": "#c87ae4",
+  "contrast": "#000000",
+  "contrast_ratio": 7.36,
+  "accessible": true,
+  "gradient": { "from": "#d69beb", "to": "#c87ae4", "css": "linear-gradient(135deg, #d69beb, #c87ae4)" },
+  "logo_url": "https://assets.hackclub.com/hcb.png"
+}
+` + "```" + `
+
 ---
 
 ## GET /emails
@@ -1326,6 +4736,11 @@ List **sent** emails. Returns content + stats and a compact reference to the mai
 - ` + "`limit`" + ` (int, default 50, max 200)
 - ` + "`offset`" + ` (int, default 0)
 - ` + "`mailing_list_id`" + ` (string, optional) — filter to a specific list.
+- ` + "`updated_since`" + ` (RFC 3339 timestamp, optional) — only emails changed after it (per the campaign's ` + "`updated_at`" + `, or ` + "`sent_at`" + ` if it was never edited). For incremental builds and mirrors: remember the largest ` + "`updated_at`" + ` you've seen and pass it next time. Emails unpublished since then aren't reported here; see ` + "`/changes`" + `.
+- ` + "`topic`" + ` (string, optional) — only emails classified into this topic (a slug from ` + "`/topics`" + `, e.g. ` + "`hackathons`" + `). Empty unless enrichment is on.
+
+### NDJSON
+With ` + "`Accept: application/x-ndjson`" + ` (or ` + "`?format=ndjson`" + `), every matching email from ` + "`offset`" + ` on is streamed as one JSON object per line, with no ` + "`limit`" + ` and no ` + "`items`" + `/` + "`next`" + ` envelope. Use it for full-archive exports; the server never buffers the whole result. These responses aren't cached. If the stream fails partway, its last line is ` + "`{\"error\": {...}}`" + ` (see Errors).
 
 ### Response
 ` + "```json" + `
@@ -1338,6 +4753,7 @@ List **sent** emails. Returns content + stats and a compact reference to the mai
       "internal_title": "RM Outreach > Counterspell",
       "emoji": null,
       "sent_at": "2025-10-10T03:47:14.357Z",
+      "updated_at": "2025-10-10T03:47:14.357Z",
       "mailing_list_id": "cm1fqxdc900qn0ll9fd5m3wdv",
       "mailing_list": {
         "id": "cm1fqxdc900qn0ll9fd5m3wdv",
@@ -1348,12 +4764,27 @@ List **sent** emails. Returns content + stats and a compact reference to the mai
       },
       "stats": {
         "clicks": 82,
-        "views": 1234
+        "views": 1234,
+        "likes": 17,
+        "median_read_seconds": 75
       },
       "html": "<!doctype html> ...",
       "markdown": "Hey there, ...",
       "content_json": { "root": { "...": "..." } },
-      "preview_text": "Hey there, My name is..."
+      "preview_text": "Hey there, My name is...",
+      "sender": "Counterspell Team",
+      "images": [
+        { "src": "https://cdn.hackclub.com/banner.png", "alt": "Counterspell banner", "width": 600, "height": 300 },
+        { "src": "https://cdn.hackclub.com/orpheus.png", "alt": "Orpheus", "width": 64, "height": 64 }
+      ],
+      "cover_image": { "src": "https://cdn.hackclub.com/banner.png", "alt": "Counterspell banner", "width": 600, "height": 300 },
+      "a11y": {
+        "images_missing_alt": 1,
+        "heading_issues": ["h4 follows h2, skipping a level"],
+        "low_contrast": [
+          { "text": "Unsubscribe anytime", "color": "#aaaaaa", "background": "#ffffff", "ratio": 2.32, "required": 4.5 }
+        ]
+      }
     }
   ],
   "next_offset": 50
@@ -1364,10 +4795,198 @@ List **sent** emails. Returns content + stats and a compact reference to the mai
 - ` + "`stats.views`" + ` = real-time TimescaleDB views + warehouse opens (email opens from Loops).
 - ` + "`stats.clicks`" + ` = real-time TimescaleDB link clicks + warehouse clicks from Loops.
 - ` + "`html`" + ` field contains **rewritten links** for click tracking (see Link Click Tracking below).
+- Images in ` + "`html`" + ` are made responsive: all but the first load lazily, and each declares ` + "`width`" + ` and ` + "`height`" + ` (its actual size, fetched once in the background from public addresses only, where the campaign didn't declare one). With ` + "`IMAGE_PROXY_URL`" + ` set to a resizing proxy template with ` + "`{url}`" + ` and ` + "`{width}`" + ` (e.g. ` + "`https://wsrv.nl/?url={url}&w={width}`" + `), images also get a ` + "`srcset`" + ` of copies 320-1920px wide, up to their actual width, so phones don't download full-size heroes. Sizes fetched since the HTML was cached appear when it's rebuilt.
+- Upstream trackers are stripped from ` + "`html`" + `, in previews too: the sending platform's open-tracking pixels (images declared 2x2 or smaller, hidden ones, and known open trackers such as SES's ` + "`/I0/`" + ` and Mailchimp's ` + "`/track/open.php`" + `), scripts, and ` + "`noscript`" + ` beacon fallbacks. Our own ` + "`/emails/{id}/pixel.gif`" + ` and inline (` + "`data:`" + `) images are kept.
+- Relative URLs in ` + "`html`" + ` (` + "`href`" + `, ` + "`src`" + `, ` + "`srcset`" + `, ` + "`background`" + `) are resolved against ` + "`LINK_RELATIVE_BASE`" + ` (the sending domain, default ` + "`https://hackclub.com`" + `), or the email's own ` + "`<base href>`" + `, before links are rewritten, so ` + "`/join`" + ` is tracked as ` + "`https://hackclub.com/join`" + ` rather than a path on this host. The same applies to ` + "`render=markdown`" + `.
+- ` + "`images`" + ` lists the distinct http(s) images in ` + "`html`" + ` in document order (tracking pixels and spacers excluded); ` + "`width`" + `/` + "`height`" + ` are the declared attributes, when present. ` + "`cover_image`" + ` is a best guess for card thumbnails: the largest image declared at least 200px wide, else the first image without declared size; omitted when there's no candidate.
+- ` + "`summary`" + ` and ` + "`key_points`" + ` (3-5 short bullets), for richer listing cards, are written by an LLM when ` + "`ENRICH_LLM_URL`" + ` points at an OpenAI-compatible chat completions endpoint (with ` + "`ENRICH_LLM_API_KEY`" + `, ` + "`ENRICH_LLM_MODEL`" + `; needs the metrics DB). Each email is enriched once in the background, and again when its content changes; until then, and for previews of unpublished edits, the fields are absent.
+- ` + "`topics`" + ` are the 1-3 topics, from the fixed taxonomy at ` + "`/topics`" + `, the same LLM classified the email into, most relevant first. They complement the list and series: filter with ` + "`?topic=`" + `. An edited email keeps its topics until it's enriched again.
+- ` + "`a11y`" + ` is an accessibility audit of the campaign HTML, for fixing recurring problems in templates: ` + "`images_missing_alt`" + ` counts images with no ` + "`alt`" + ` attribute (` + "`alt=\"\"`" + ` marks decorative images and is fine); ` + "`heading_issues`" + ` flags a missing or repeated ` + "`h1`" + `, skipped levels and empty headings; ` + "`low_contrast`" + ` lists text below WCAG AA contrast (4.5:1, or 3:1 for large text), the first example of each color pair and size, up to 20. Contrast is read from inline styles, ` + "`<style>`" + ` rules and ` + "`bgcolor`" + `/` + "`color`" + ` attributes; text over background images or in colors that can't be read, and hidden text such as preheaders, isn't checked.
+- ` + "`sender`" + ` is a curated byline: a per-email override, else the list's, else ` + "`Hack Club`" + `.
 - We do **not** expose ` + "`from_email`" + `, ` + "`reply_to_email`" + `, or any per-recipient stats.
 
 ---
 
+## GET /emails/{id}
+
+Fetch a single published email in the same shape as ` + "`/emails`" + ` items. Returns ` + "`404`" + ` if it doesn't exist or isn't published.
+
+### Query Params
+- ` + "`theme`" + ` (optional) — ` + "`dark`" + ` transforms ` + "`html`" + ` for dark pages: the content is wrapped in a ` + "`<div data-theme=\"dark\">`" + ` that inverts colors, with images, video, and background images inverted back so they look as designed.
+- ` + "`render`" + ` (optional) — ` + "`markdown`" + ` replaces ` + "`html`" + ` with the email's ` + "`markdown`" + ` rendered as plain semantic HTML (CommonMark with strikethrough; no inline styles, and no classes beyond ` + "`language-*`" + ` on fenced code), a lighter alternative to the campaign layout for frontends that style content themselves. Raw HTML in the markdown is left out, and links and images other than http(s), mailto and tel are reduced to their text. Links are click-tracked like the campaign's and share their ` + "`/emails/{id}/links`" + ` indexes when the campaign links the same URL. Emails without markdown keep their campaign ` + "`html`" + `. Combines with ` + "`theme=dark`" + `.
+- ` + "`css`" + ` (optional) — moves ` + "`<style>`" + ` blocks out of ` + "`html`" + `, for pages whose CSP or HTML sanitizer strips them. ` + "`extract`" + ` removes them and returns their CSS in a ` + "`styles`" + ` field. ` + "`inline`" + ` applies each rule to the elements it matches as ` + "`style`" + ` attributes, following the cascade (` + "`!important`" + `, specificity, source order, and existing ` + "`style`" + ` attributes over rules); only what can't be inlined (` + "`@media`" + `, ` + "`@font-face`" + `, and selectors like ` + "`a:hover`" + ` or ` + "`::before`" + `) is returned in ` + "`styles`" + `. Applied after ` + "`render`" + ` and before ` + "`theme`" + `.
+
+### Preview mode
+Editors can proof campaigns that are sent but not yet publishable, or still drafts, by passing a signed token as ` + "`?preview_token=`" + ` or the ` + "`X-Preview-Token`" + ` header. Tokens are minted by operators (` + "`POST /admin/preview-tokens`" + `), are scoped to one email (or all), and expire.
+
+- Preview responses are ` + "`Cache-Control: no-store`" + ` and ` + "`X-Robots-Tag: noindex`" + `.
+- Links are **not** rewritten in previews, so proofing doesn't count as clicks.
+- Invalid or expired tokens return ` + "`403`" + `.
+- Sent campaigns awaiting review are listed to operators at ` + "`/admin/pending`" + `, and publishing one (` + "`POST /admin/pending/{id}/approve`" + `) is what makes it appear here without a token. Decisions are kept in the metrics DB, not written to the warehouse, and take precedence over the campaign's ` + "`ai_publishable`" + ` flag.
+
+---
+
+## GET /emails/{id}/embed
+
+A small self-contained HTML card (list badge, subject, excerpt, date, live view count) linking to the email on the archive site, for embedding on other Hack Club sites:
+
+` + "```html" + `
+<iframe src="https://<api-host>/emails/cmgkb2b058ngw210ij7jpskf4/embed"
+        width="400" height="170" style="border:0" loading="lazy"></iframe>
+` + "```" + `
+
+- Only pages on ` + "`EMBED_FRAME_ANCESTORS`" + ` (default ` + "`https://hackclub.com https://*.hackclub.com`" + `) may frame it.
+- The view count updates live over ` + "`/emails/{id}/stats/stream`" + `.
+- Links open ` + "`ARCHIVE_BASE_URL/{mailing_list_slug}/{email_slug}`" + ` in a new tab.
+
+---
+
+## GET /emails/{id}/html
+
+The published email rendered as a standalone ` + "`text/html`" + ` page, for iframing or linking a faithful rendering without re-implementing email CSS handling. With ` + "`API_KEYS`" + ` set it needs a key, unless ` + "`EMAIL_HTML_PUBLIC=1`" + ` opens it for iframes on other sites.
+
+- Same content as ` + "`html`" + ` on ` + "`/emails/{id}`" + ` (click-tracked links), with scripts, frames, forms, event handlers, and ` + "`javascript:`" + ` URLs removed.
+- Served with a CSP that allows HTTPS images, styles, and fonts only, sandboxes the page, and permits framing from ` + "`EMBED_FRAME_ANCESTORS`" + `.
+- Links open in a new tab; ` + "`<link rel=\"canonical\">`" + ` points at the archive page.
+- ` + "`?theme=dark`" + ` applies the same dark transform as on ` + "`/emails/{id}`" + `.
+- ` + "`?format=print`" + ` lays the email out for printing or saving as PDF: background colors and images and fixed widths are removed, links point straight at their destinations instead of through click tracking, and a print stylesheet is inlined (plain black text, images scaled to the page, and each link's URL printed after it). It takes precedence over ` + "`theme`" + `.
+
+---
+
+## GET /emails/{id}/qr.png
+
+A QR code (PNG) for the published email's archive page, ` + "`ARCHIVE_BASE_URL/{mailing_list_slug}/{email_slug}`" + ` (the same URL as its ` + "`<link rel=\"canonical\">`" + `), for posters, printouts and event slides.
+
+Query params:
+- ` + "`size`" + ` - pixels per module, 1-40 (default 8). The image includes the standard 4-module quiet zone.
+- ` + "`ecc`" + ` - error correction level: ` + "`L`" + `, ` + "`M`" + ` (default), ` + "`Q`" + ` or ` + "`H`" + `. Higher levels scan better when printed small or partly covered, at the cost of a denser code.
+
+---
+
+## POST /mailing_lists/{id}/subscribe
+
+Subscribes an address to a public mailing list, for signup forms on the archive. Enabled when the server has a ` + "`LOOPS_API_KEY`" + `; the address is forwarded to Loops and never stored here.
+
+Send JSON or a form post:
+` + "```json" + `
+{ "email": "orpheus@example.com", "captcha_token": "..." }
+` + "```" + `
+
+- ` + "`captcha_token`" + ` is required when the server sets ` + "`CAPTCHA_SECRET`" + ` (Cloudflare Turnstile by default, or hCaptcha with ` + "`CAPTCHA_PROVIDER=hcaptcha`" + `). Form posts may use the widgets' default ` + "`cf-turnstile-response`" + ` / ` + "`h-captcha-response`" + ` fields instead.
+- Limited to 5 requests per minute per IP (` + "`RATE_LIMIT_SUBSCRIBE`" + `).
+- ` + "`202`" + ` with ` + "`{\"status\": \"subscribed\", \"mailing_list_id\": \"...\"}`" + ` on success; ` + "`400`" + ` for an invalid address, ` + "`403`" + ` for a failed captcha, ` + "`404`" + ` for unknown or private lists, ` + "`502`" + ` when Loops is unavailable.
+
+---
+
+## GET /emails/{id}/jsonld
+
+schema.org ` + "`NewsArticle`" + ` markup for the email's archive page, served as ` + "`application/ld+json`" + ` so SSGs can paste it into ` + "`<script type=\"application/ld+json\">`" + ` as-is.
+
+` + "```json" + `
+{
+  "@context": "https://schema.org",
+  "@type": "NewsArticle",
+  "headline": "Arcade Week 1: Kickoff",
+  "description": "...",
+  "datePublished": "2024-06-17T17:00:00Z",
+  "image": ["https://cloud-....hackclub.dev/arcade-banner.png"],
+  "url": "https://news.hackclub.com/arcade/arcade-week-1-kickoff",
+  "mainEntityOfPage": { "@type": "WebPage", "@id": "https://news.hackclub.com/arcade/arcade-week-1-kickoff" },
+  "author": { "@type": "Organization", "name": "Hack Club" },
+  "publisher": { "@type": "Organization", "name": "Hack Club", "logo": { "@type": "ImageObject", "url": "https://assets.hackclub.com/icon-rounded.png" } },
+  "isPartOf": { "@type": "Periodical", "name": "Arcade" }
+}
+` + "```" + `
+
+- ` + "`image`" + ` is the email's ` + "`cover_image`" + ` and ` + "`description`" + ` its excerpt; both are omitted when absent.
+- ` + "`author`" + ` is the email's ` + "`sender`" + `; the publisher comes from ` + "`PUBLISHER_NAME`" + ` / ` + "`PUBLISHER_LOGO_URL`" + `.
+
+---
+
+## GET /emails/{id}/social
+
+Ready-to-post snippets announcing the email, one per network, each fitting that network's length limit.
+
+` + "```json" + `
+{
+  "email_id": "abc123",
+  "url": "https://news.hackclub.com/arcade/arcade-week-1-kickoff",
+  "snippets": [
+    { "network": "x", "text": "Arcade Week 1: Kickoff\n\nArcade is here! Build projects, earn tickets, and trade them for prizes…\n\nhttps://news.hackclub.com/arcade/arcade-week-1-kickoff", "length": 123, "limit": 280 },
+    { "network": "bluesky", "text": "...", "length": 156, "limit": 300 },
+    { "network": "mastodon", "text": "...", "length": 123, "limit": 500 },
+    { "network": "linkedin", "text": "Arcade Week 1: Kickoff\n\n...\n\n• ...\n\nRead it: https://news.hackclub.com/arcade/arcade-week-1-kickoff\n\n#Programs #Hackathons", "length": 412, "limit": 3000 }
+  ]
+}
+` + "```" + `
+
+- Each snippet is the subject, then the email's ` + "`summary`" + ` (falling back to its ` + "`excerpt`" + `, then ` + "`preview_text`" + `), then the archive page's URL. The body is cut at a word, with an ellipsis, to fit.
+- The LinkedIn snippet also lists the ` + "`key_points`" + ` and ends with the email's ` + "`topics`" + ` as hashtags.
+- ` + "`length`" + ` counts characters the way the network does: X and Mastodon count any link as 23.
+
+---
+
+## GET /admin/emails/{id}/revisions
+
+Admin only (` + "`ADMIN_API_KEYS`" + `), since old revisions keep whatever later edits removed. Every version of the email's publishable content we've served, newest first, so accidental upstream edits to published posts can be spotted and reverted. A revision is captured when the email is first served with content (subject, slug, excerpt, HTML, or markdown) that differs from the previous one.
+
+` + "```json" + `
+{
+  "email_id": "abc123",
+  "items": [
+    { "email_id": "abc123", "revision": 2, "content_hash": "9f2c...", "subject": "Arcade Week 1: Kickoff", "slug": "arcade-week-1-kickoff", "source_updated_at": "2024-06-18T09:12:00Z", "captured_at": "2024-06-18T09:13:05Z" },
+    { "email_id": "abc123", "revision": 1, "content_hash": "41ab...", "subject": "Arcade Week 1: Kickoff", "slug": "arcade-week-1-kickoff", "source_updated_at": "2024-06-17T17:00:00Z", "captured_at": "2024-06-17T17:02:41Z" }
+  ]
+}
+` + "```" + `
+
+- ` + "`GET /admin/emails/{id}/revisions/{revision}`" + ` returns one revision including its ` + "`html`" + ` and ` + "`markdown`" + `.
+- ` + "`GET /admin/emails/{id}/revisions/diff?from=1&to=2`" + ` returns a unified line diff of the markdown (or the HTML, when either side has no markdown) in ` + "`diff`" + `, plus ` + "`subject_changed`" + `, ` + "`excerpt_changed`" + `, and ` + "`html_changed`" + `. ` + "`to`" + ` defaults to the latest revision and ` + "`from`" + ` to the one before it.
+- Operators restore a revision with ` + "`POST /admin/emails/{id}/revisions/{revision}/restore`" + `. The warehouse is left alone: the restored content is served in its place until the email is next edited upstream, and shows up as a new revision.
+
+---
+
+## GET /export/emails
+
+Mirror the whole archive in one request. Streams every published email, newest first, in the same shape as ` + "`/emails`" + ` items, without ever buffering the archive.
+
+### Query Params
+- ` + "`format`" + ` — ` + "`ndjson`" + ` (default; one email per line) or ` + "`json.gz`" + ` (a gzip'd JSON array, served as the attachment ` + "`emails.json.gz`" + `)
+- ` + "`html`" + ` — ` + "`false`" + ` omits the HTML bodies, by far the largest field
+- ` + "`mailing_list_id`" + ` (optional) — one list only
+- ` + "`updated_since`" + ` (optional) — only emails changed since a previous mirror, as on ` + "`/emails`" + `
+- ` + "`offset`" + ` (int, default 0) — resume a partial mirror
+
+Responses carry ` + "`Cache-Control: public, max-age=300`" + ` so a CDN can absorb repeat mirrors (` + "`private`" + ` when served for an API key). The endpoint has its own per-IP rate limit (` + "`RATE_LIMIT_EXPORT`" + `, default 6 per minute). A failed NDJSON stream ends with an ` + "`{\"error\": {...}}`" + ` line; a failed ` + "`json.gz`" + ` stream is left as an unterminated array.
+
+---
+
+## GET /changes
+
+Publish-state changes to emails and mailing lists, oldest first, so static sites and mirrors know which pages to delete as well as which to (re)build. An email is deleted when it's pulled from the archive (its ` + "`ai_publishable`" + ` flag is turned off, or an operator rejects it) or its mailing list stops being public; the list is deleted then too, and each of its emails is listed again with ` + "`delete`" + `.
+
+### Query Params
+- ` + "`since`" + ` (RFC 3339 timestamp, optional) — start with changes at or after this time; omit to replay the whole feed
+- ` + "`cursor`" + ` (optional) — the ` + "`next_cursor`" + ` from a previous response; takes precedence over ` + "`since`" + `
+- ` + "`limit`" + ` (int, default 100, max 200)
+
+### Response
+` + "```json" + `
+{
+  "items": [
+    { "kind": "email", "id": "cmgkb2b058ngw210ij7jpskf4", "slug": "hack-club-events-fellowship-apply-today", "mailing_list_id": "cm1fqxdc900qn0ll9fd5m3wdv", "action": "upsert", "changed_at": "2025-10-10T03:47:14.357Z" },
+    { "kind": "email", "id": "cmgk9x1q70001l50h2b6c3d4e", "slug": "sent-by-mistake", "mailing_list_id": "cm1fqxdc900qn0ll9fd5m3wdv", "action": "delete", "changed_at": "2025-10-10T05:02:00Z" },
+    { "kind": "mailing_list", "id": "cm1fqxdc900qn0ll9fd5m3wdv", "slug": "counterspell", "action": "upsert", "changed_at": "2025-10-11T00:00:00Z" }
+  ],
+  "next_cursor": "MjAyNS0xMC0xMVQwMDowMDowMFogbWFpbGluZ19saXN0IGNtMWZxeGRjOTAwcW4wbGw5ZmQ1bTN3ZHY",
+  "has_more": false
+}
+` + "```" + `
+
+Entries only say what changed; fetch ` + "`upsert`" + `s from ` + "`/emails/{id}`" + ` or ` + "`/mailing_lists`" + `. Page until ` + "`has_more`" + ` is false, then keep the last ` + "`next_cursor`" + ` for your next poll; it's returned even when there's nothing new. The feed can include deletes for emails you never saw (sent but never published) and repeats for items that changed more than once, so apply entries idempotently.
+
+---
+
 ## GET /mailing_lists/emails
 
 Convenience endpoint for building index pages.
@@ -1390,7 +5009,7 @@ Convenience endpoint for building index pages.
       "name": "Arcade",
       "description": "Spend your summer coding projects, get prizes! hackclub.com/arcade",
       "color": "#ff8a00",
-      "is_public": false,
+      "is_public": true,
       "subscriber_count": 9999,
       "last_sent_at": "2025-10-10T03:45:58.073Z",
       "sent_email_count": 3
@@ -1402,6 +5021,89 @@ Convenience endpoint for building index pages.
 
 ---
 
+## GET /search
+
+Searches published emails by subject, list name, and body. Query params: ` + "`q`" + ` (required, 3-200 characters; supports ` + "`\"quoted phrases\"`" + `, ` + "`or`" + `, and ` + "`-exclusions`" + `), ` + "`limit`" + ` (default 20, max 200).
+
+Results come from full-text search, ranked by relevance. When that finds nothing, a typo-tolerant fallback matches ` + "`q`" + ` against subjects and list names by trigram similarity, so ` + "`hackclb arcade`" + ` still finds "Hack Club Arcade" emails. ` + "`match`" + ` says which one produced a result; ` + "`score`" + ` is only comparable within one response.
+
+With ` + "`mode=semantic`" + `, results are instead the emails closest in meaning to ` + "`q`" + `, so ` + "`robots`" + ` finds an email about a hardware grant that never says "robots"; ` + "`match`" + ` is ` + "`semantic`" + ` and ` + "`score`" + ` is cosine similarity (higher is closer). Emails and queries are embedded through ` + "`ENRICH_EMBEDDINGS_URL`" + `, an OpenAI-compatible embeddings endpoint (model ` + "`ENRICH_EMBEDDINGS_MODEL`" + `, default ` + "`text-embedding-3-small`" + `), and stored with pgvector in the metrics DB; each email is embedded in the background within ` + "`ENRICH_INTERVAL`" + ` of publishing or changing. Without that setup, semantic mode returns 501. ` + "`mode=keyword`" + ` (the default) is the search described above.
+
+For search-as-you-type, the server can instead keep a Meilisearch or Algolia index in sync (` + "`SEARCH_INDEX_PROVIDER`" + `, index ` + "`SEARCH_INDEX_NAME`" + `, default ` + "`emails`" + `) within about a minute of changes, and frontends query it directly. Documents look like ` + "`{\"id\", \"title\", \"excerpt\", \"plaintext\", \"tags\", \"slug\", \"mailing_list\": {\"id\", \"slug\", \"name\"}, \"url\", \"sent_at\"}`" + `, where ` + "`tags`" + ` holds the list's slug and, for multi-part emails, the series' slug, ` + "`url`" + ` is the archive page and ` + "`sent_at`" + ` is Unix seconds. ` + "`mailing_list.id`" + ` and ` + "`tags`" + ` are filterable.
+
+` + "```json" + `
+{ "items": [ { "id": "...", "slug": "arcade-week-1-kickoff", "subject": "Arcade Week 1: Kickoff", "excerpt": "...", "sent_at": "2024-06-17T17:00:00Z", "mailing_list": { "id": "...", "slug": "arcade", "name": "Arcade", "description": "...", "color": "#ec3750" }, "score": 0.82, "match": "fuzzy" } ] }
+` + "```" + `
+
+---
+
+## GET /upcoming
+
+Campaigns scheduled to send in the next 30 days, for a "coming soon" strip. Only enabled when the server sets ` + "`ENABLE_UPCOMING=1`" + ` (404 otherwise). Each item is a teaser: subject, list, and scheduled day (UTC) only, never content or the exact send time. Query params: ` + "`limit`" + ` (default 10, max 200).
+
+` + "```json" + `
+{ "items": [ { "subject": "Arcade Week 4: Finale", "mailing_list": { "id": "...", "slug": "arcade", "name": "Arcade", "description": "...", "color": "#ec3750" }, "scheduled_for": "2025-10-14" } ] }
+` + "```" + `
+
+---
+
+
+Daily subscriber counts for one list, for growth charts. Counts are snapshotted once a day (UTC) into the metrics DB, so history starts when snapshotting was first deployed and days without a snapshot are absent. Query params: ` + "`days`" + ` (default 365, max 3650).
+
+` + "```json" + `
+{ "mailing_list_id": "cm1fqxdc900qn0ll9fd5m3wdv", "since": "2024-10-10", "items": [ { "day": "2025-10-09", "subscriber_count": 12301 }, { "day": "2025-10-10", "subscriber_count": 12345 } ] }
+` + "```" + `
+
+---
+
+## GET /topics
+
+The topic taxonomy emails are classified into (see ` + "`topics`" + ` on emails), with how many published emails each has, for building topic filters. Counts are zero unless enrichment is on.
+
+### Response
+` + "```json" + `
+{
+  "items": [
+    { "slug": "events", "name": "Events", "description": "Meetups, calls, livestreams and in-person gatherings", "count": 12 },
+    { "slug": "hackathons", "name": "Hackathons", "description": "Hackathons to attend or organize, and their results", "count": 31 },
+    { "slug": "fiscal-sponsorship", "name": "Fiscal sponsorship", "description": "HCB, fundraising and managing money for projects and events", "count": 7 }
+  ]
+}
+` + "```" + `
+
+---
+
+## GET /series
+
+Multi-part campaigns (e.g. "Arcade Week 1/2/3") as ordered collections. Series are detected from subjects of the form ` + "`<name> Week|Part|Day|Vol|Episode|Issue <n>`" + ` or ` + "`<name> #<n>`" + `; only series with at least two published parts are listed, most recently updated first.
+
+### Query Params
+- ` + "`mailing_list_id`" + ` (string, optional) — only series from this list.
+
+### Response
+` + "```json" + `
+{
+  "items": [
+    {
+      "slug": "arcade",
+      "name": "Arcade",
+      "mailing_list_id": "cm1fqxdc900qn0ll9fd5m3wdv",
+      "count": 3,
+      "last_sent_at": "2024-07-01T17:00:00Z",
+      "emails": [
+        { "id": "cm1...", "slug": "arcade-week-1-kickoff", "subject": "Arcade Week 1: Kickoff", "part": 1, "sent_at": "2024-06-17T17:00:00Z" },
+        { "id": "cm2...", "slug": "arcade-week-2-ships", "subject": "Arcade Week 2: Ships", "part": 2, "sent_at": "2024-06-24T17:00:00Z" },
+        { "id": "cm3...", "slug": "arcade-week-3-prizes", "subject": "Arcade Week 3: Prizes", "part": 3, "sent_at": "2024-07-01T17:00:00Z" }
+      ]
+    }
+  ]
+}
+` + "```" + `
+
+Each email in ` + "`/emails`" + ` also carries ` + "`\"series\": {\"slug\": \"arcade\", \"name\": \"Arcade\", \"part\": 2}`" + ` when its subject matches, even before a second part exists.
+
+---
+
 ## Sorting & Pagination
 - ` + "`/mailing_lists`" + ` is ordered by most recently sent email (desc), then by name.
 - ` + "`/emails`" + ` is ordered by ` + "`sent_at`" + ` (desc).
@@ -1413,9 +5115,28 @@ We expose **email_html**, **email_markdown**, and **email_content_json** straigh
 ## Privacy
 - Endpoint never returns audience emails or per-recipient events.
 - If you later ingest anything recipient-specific, keep it out of this surface.
+- Tracking cookies are stored only as rotating salted hashes (see Privacy & Session Tracking). Rows recorded before hashing are hashed in place by a background job on startup, which runs hourly after that to catch rows an older instance writes during a deploy.
 
 ## Status & Health
-- ` + "`/healthz`" + ` returns 200 OK when the server is alive.
+- ` + "`/healthz`" + ` returns 200 OK when the server is alive (liveness; no dependency checks).
+- ` + "`/readyz`" + ` pings each database (2s timeout) and returns per-dependency status:
+
+` + "```json" + `
+{
+  "status": "degraded",
+  "dependencies": {
+    "warehouse": { "status": "ok", "latency_ms": 3 },
+    "warehouse_replica": { "status": "ok", "latency_ms": 2 },
+    "warehouse_secondary": { "status": "not_configured" },
+    "metrics": { "status": "down" }
+  }
+}
+` + "```" + `
+
+  ` + "`503`" + ` with ` + "`status: unavailable`" + ` when the warehouse (or a secondary warehouse receiving reads) is down. A down metrics DB or read replica only reports ` + "`degraded`" + ` since content is still served (reads fall back to the primary warehouse).
+- When a dependency is failing on live traffic, list responses include ` + "`\"meta\": {\"degraded\": [\"metrics\"]}`" + ` and every cached response carries ` + "`X-Degraded: metrics`" + `. Stats in such responses may undercount; ` + "`/readyz`" + ` reports the same under ` + "`observed`" + `.
+- ` + "`/version`" + ` returns build version, commit, Go version, start time, enabled features, and non-secret settings.
+- Operators can see background work (rollups, snapshots, the publish watcher, queued jobs) at ` + "`/admin/jobs`" + `: runs, failures, durations and last errors per task on the answering instance, plus pending and failed queued jobs across all of them.
 
 ---
 
@@ -1426,6 +5147,7 @@ Track a page view for an email and return the total view count.
 ### Behavior
 - **Automatic tracking**: Sets a ` + "`_track`" + ` cookie (30-day session ID) and records the view.
 - **Deduplication**: Same session + email + 5-minute window = counted once.
+- **Asynchronous**: Views are buffered and written in batches (~1s), so the returned count may not yet include this view; SSE subscribers are notified once it is recorded.
 - **Privacy-first**: Only tracks anonymous session IDs, no PII.
 - **Combined counts**: Returns views from both TimescaleDB (real-time) + warehouse analytics.
 
@@ -1436,6 +5158,9 @@ Track a page view for an email and return the total view count.
 }
 ` + "```" + `
 
+### Query Params
+- ` + "`ref`" + ` (string, optional) — the page's ` + "`document.referrer`" + `. Only its origin (scheme + host) is stored; falls back to the ` + "`Referer`" + ` header.
+
 ### Cookie
 The server sets ` + "`_track`" + ` cookie automatically:
 - ` + "`HttpOnly`" + `, ` + "`SameSite=Lax`" + `, ` + "`Secure`" + ` (on HTTPS)
@@ -1444,6 +5169,320 @@ The server sets ` + "`_track`" + ` cookie automatically:
 
 ---
 
+## GET /emails/{id}/pixel.gif
+
+A 1x1 transparent GIF that records a view, for places the JSON ` + "`/view`" + ` call can't run: RSS readers that load images, no-JS pages, and other embeds.
+
+` + "```html" + `
+<img src="https://news.hackclub.com/emails/{id}/pixel.gif" width="1" height="1" alt="">
+` + "```" + `
+
+- Same session cookie and 5-minute deduplication as ` + "`/view`" + `. Readers that block third-party cookies get a new session per load, so their repeat views aren't deduplicated.
+- The referrer is taken from the ` + "`Referer`" + ` header (origin only).
+- Always returns the image, with ` + "`Cache-Control: no-store`" + ` so each load is seen.
+
+---
+
+## GET /emails/{id}/referrers
+
+Per-email breakdown of unique views by referrer origin.
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [
+    { "origin": "https://hackclub.com", "category": "site", "views": 120 },
+    { "origin": "https://t.co", "category": "social", "views": 45 },
+    { "origin": "direct", "category": "direct", "views": 30 }
+  ]
+}
+` + "```" + `
+
+- Only the referrer **origin** is recorded, never the full URL.
+- ` + "`category`" + ` is one of ` + "`site`" + `, ` + "`social`" + `, ` + "`search`" + `, ` + "`direct`" + `, ` + "`other`" + `. Site hosts come from ` + "`REFERRER_SITE_HOSTS`" + ` (default ` + "`hackclub.com`" + `).
+- Only views recorded since referrers were introduced (tracking schema v2) are included.
+
+---
+
+## GET /emails/{id}/devices
+
+Per-email breakdown of unique views and clicks by coarse device class and browser family.
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [
+    { "device": "mobile", "browser": "safari", "views": 210, "clicks": 31 },
+    { "device": "desktop", "browser": "chrome", "views": 180, "clicks": 44 }
+  ]
+}
+` + "```" + `
+
+- ` + "`device`" + ` is one of ` + "`mobile`" + `, ` + "`tablet`" + `, ` + "`desktop`" + `, ` + "`bot`" + `, ` + "`unknown`" + `.
+- ` + "`browser`" + ` is one of ` + "`chrome`" + `, ` + "`safari`" + `, ` + "`firefox`" + `, ` + "`edge`" + `, ` + "`opera`" + `, ` + "`samsung`" + `, ` + "`other`" + `, ` + "`unknown`" + `.
+- The User-Agent is bucketed at tracking time; raw UA strings are never stored.
+- Breakdowns only cover events recorded since the field was introduced (tracking schema v2); older events still count toward ` + "`stats`" + `.
+
+---
+
+## GET /emails/{id}/regions
+
+Per-email breakdown of unique views and clicks by the deployment region that recorded them (the server's ` + "`REGION`" + ` setting).
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [
+    { "region": "iad", "views": 310, "clicks": 52 },
+    { "region": "fra", "views": 95, "clicks": 12 },
+    { "region": "unknown", "views": 4, "clicks": 0 }
+  ]
+}
+` + "```" + `
+
+- ` + "`unknown`" + ` counts events recorded by instances without ` + "`REGION`" + ` set.
+- Only events recorded since regions were introduced (tracking schema v3) are included.
+
+---
+
+## GET /emails/{id}/links
+
+Click-by-position data for an email's tracked links, so authors can see whether readers click below the fold. Each link's position is recorded when links are rewritten for click tracking.
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [
+    { "index": 0, "url": "https://apply.hackclub.com", "section": "Hack Club Events Fellowship", "position": 0.12, "clicks": 61 },
+    { "index": 1, "url": "https://hackclub.com/fellowship/faq", "section": "FAQ", "position": 0.87, "clicks": 4 }
+  ],
+  "by_position": [
+    { "from": 0, "to": 0.25, "links": 1, "clicks": 61 },
+    { "from": 0.25, "to": 0.5, "links": 0, "clicks": 0 },
+    { "from": 0.5, "to": 0.75, "links": 0, "clicks": 0 },
+    { "from": 0.75, "to": 1, "links": 1, "clicks": 4 }
+  ]
+}
+` + "```" + `
+
+- ` + "`index`" + ` matches the ` + "`/emails/{id}/click/{index}`" + ` links in the served HTML.
+- ` + "`position`" + ` is the share of the email's text that comes before the link (0 = top, 1 = bottom); ` + "`section`" + ` is the nearest heading above it.
+- ` + "`clicks`" + ` counts unique sessions. Links clicked before the email's positions were recorded have ` + "`position: null`" + ` and aren't in ` + "`by_position`" + `.
+
+---
+
+## POST /emails/{id}/scroll
+
+Scroll-depth beacon from the archive page. Send the furthest point the reader has reached, as a percentage of the email, whenever it grows or when the page is hidden; designed for ` + "`navigator.sendBeacon`" + `.
+
+### Request
+` + "```json" + `
+{ "depth": 80 }
+` + "```" + `
+
+- Or send no body and pass ` + "`?depth=80`" + `.
+- Uses the same ` + "`_track`" + ` session cookie as views. Only a session's new maximum is stored, so repeated beacons count once.
+- Returns ` + "`204 No Content`" + `, or ` + "`400`" + ` if depth isn't between 0 and 100.
+
+---
+
+## POST /emails/{id}/read
+
+Read-time beacon from the archive page: the reader's active time on the email so far, counting only while the page is visible. Send it periodically and when the page is hidden; designed for ` + "`navigator.sendBeacon`" + `.
+
+### Request
+` + "```json" + `
+{ "seconds": 94 }
+` + "```" + `
+
+- Or send no body and pass ` + "`?seconds=94`" + `.
+- Times are bucketed before storage (5s steps under a minute, 15s under five minutes, then whole minutes) and capped at 30 minutes.
+- Uses the ` + "`_track`" + ` session cookie; only a session's new maximum is stored.
+- The median across sessions is served as ` + "`stats.median_read_seconds`" + ` on emails, once any session has reported.
+- Returns ` + "`204 No Content`" + `.
+
+---
+
+## POST /emails/{id}/like
+
+Like an email, once per ` + "`_track`" + ` session (the cookie is set if missing). ` + "`DELETE /emails/{id}/like`" + ` unlikes it and ` + "`GET /emails/{id}/like`" + ` reads the current state. All three return:
+
+` + "```json" + `
+{ "liked": true, "likes": 17 }
+` + "```" + `
+
+- Idempotent: liking twice still counts once. Likes survive session hash rotation.
+- The total is served as ` + "`stats.likes`" + ` on emails, and each change is pushed to the stats streams.
+- Needs the metrics DB; without one, liking returns ` + "`501`" + `.
+
+## GET /emails/most_liked
+
+Community favorites: published emails ordered by likes given in the last ` + "`days`" + ` (default 30, max 90). ` + "`limit`" + ` defaults to 20.
+
+` + "```json" + `
+{ "since": "...", "items": [ { "id": "cmgkb2b058ngw210ij7jpskf4", "stats": { "likes": 17, "...": "..." }, "...": "..." } ] }
+` + "```" + `
+
+---
+
+## POST /track/batch
+
+Send queued tracking events in one request, for busy pages and offline-first frontends. Each event is validated and deduplicated exactly like its single-event endpoint (` + "`/emails/{id}/view`" + `, ` + "`/emails/{id}/click/{index}`" + `, ` + "`/emails/{id}/scroll`" + `, ` + "`/emails/{id}/read`" + `), under the request's ` + "`_track`" + ` session. Designed for ` + "`navigator.sendBeacon`" + `.
+
+### Request
+` + "```json" + `
+{
+  "events": [
+    { "type": "view", "email_id": "cmgkb2b058ngw210ij7jpskf4", "ref": "https://hackclub.com/", "at": "2025-10-10T04:01:00Z" },
+    { "type": "click", "email_id": "cmgkb2b058ngw210ij7jpskf4", "link_index": 0, "url": "https://apply.hackclub.com" },
+    { "type": "scroll", "email_id": "cmgkb2b058ngw210ij7jpskf4", "depth": 80 },
+    { "type": "read", "email_id": "cmgkb2b058ngw210ij7jpskf4", "seconds": 94 }
+  ]
+}
+` + "```" + `
+
+- Up to 100 events and 64 KB per batch.
+- ` + "`at`" + ` (RFC 3339, optional) is when the event happened, for events queued while offline; it must be within the last 24 hours. Defaults to now.
+- Every event's ` + "`email_id`" + ` must be a published email.
+- Clicks are recorded against the URL at ` + "`link_index`" + ` in the email's served HTML (see ` + "`/emails/{id}/links`" + `); ` + "`url`" + ` is optional, but when sent it must match. Unknown indexes are rejected.
+- Exact repeats within a batch are rejected as duplicates.
+
+### Response
+` + "```json" + `
+{ "accepted": 3, "rejected": [ { "index": 2, "error": "depth must be a percentage from 0 to 100" } ] }
+` + "```" + `
+
+Invalid events are reported by their position and don't fail the rest of the batch. ` + "`400`" + ` only if the body isn't a batch or has too many events.
+
+---
+
+## GET /emails/{id}/scroll
+
+Scroll-depth distribution for an email, from each session's furthest reported depth.
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "sessions": 412,
+  "median_depth": 70,
+  "reached": [
+    { "depth": 25, "sessions": 390, "share": 0.947 },
+    { "depth": 50, "sessions": 301, "share": 0.731 },
+    { "depth": 75, "sessions": 188, "share": 0.456 },
+    { "depth": 100, "sessions": 97, "share": 0.235 }
+  ]
+}
+` + "```" + `
+
+` + "`median_depth`" + ` is ` + "`null`" + ` until a session has reported.
+
+---
+
+## GET /emails/{id}/next
+
+"Read next" recommendations from anonymized reader journeys: the emails most often viewed next (within 24h) by sessions that read this one.
+
+### Query Params
+- ` + "`limit`" + ` (int, default 10, max 200)
+- ` + "`days`" + ` (int, default 30, max 90) — how far back to look
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [ { "email_id": "cm1fqxdc900qn0ll9fd5m3wdv", "sessions": 42 } ]
+}
+` + "```" + `
+
+- Only aggregate counts are returned; transitions seen by fewer than ` + "`JOURNEY_MIN_SESSIONS`" + ` (default 5) sessions are omitted.
+- Only published emails are returned.
+
+---
+
+## GET /emails/{id}/similar
+
+"More like this": the published emails closest in meaning to this one, by cosine similarity of their embeddings (see semantic search under ` + "`/search`" + `). Unlike ` + "`/next`" + ` it needs no reader traffic, so it works for new and rarely read emails.
+
+### Query Params
+- ` + "`limit`" + ` (int, default 5, max 200)
+
+### Response
+` + "```json" + `
+{
+  "email_id": "cmgkb2b058ngw210ij7jpskf4",
+  "items": [ { "id": "cm1fqxdc900qn0ll9fd5m3wdv", "slug": "arcade-week-1-kickoff", "subject": "Arcade Week 1: Kickoff", "excerpt": "...", "sent_at": "2024-06-17T17:00:00Z", "mailing_list": { "id": "...", "slug": "arcade", "name": "Arcade", "description": "...", "color": "#ec3750" }, "score": 0.8612, "match": "semantic" } ]
+}
+` + "```" + `
+
+- ` + "`items`" + ` is empty until the email has been embedded, within ` + "`ENRICH_INTERVAL`" + ` (default 10m) of publishing.
+- 501 when semantic search isn't configured.
+
+---
+
+## GET /pages/view?key={key}
+
+Track a view of a non-email page (homepage, list index, ...) with the same session cookie and 5-minute dedup as ` + "`/emails/{id}/view`" + `.
+
+### Query Params
+- ` + "`key`" + ` (string, default ` + "`home`" + `) — lowercase slug path, e.g. ` + "`home`" + ` or ` + "`lists/arcade`" + ` (` + "`[a-z0-9/_-]`" + `, max 200 chars).
+- ` + "`ref`" + ` (string, optional) — the page's ` + "`document.referrer`" + `; only the origin is stored.
+
+### Response
+` + "```json" + `
+{ "key": "home", "views": 5120 }
+` + "```" + `
+
+## GET /pages/top
+
+Most-viewed pages and emails by unique sessions. Emails are keyed ` + "`email:{id}`" + `.
+
+### Query Params
+- ` + "`limit`" + ` (int, default 50, max 200)
+- ` + "`days`" + ` (int, default 7, max 90)
+
+` + "```json" + `
+{ "since": "...", "items": [ { "key": "home", "views": 5120 }, { "key": "email:cmgkb2b058ngw210ij7jpskf4", "views": 1234 } ] }
+` + "```" + `
+
+---
+
+## POST /rum
+
+Anonymous real-user monitoring beacon for archive page performance. Designed for ` + "`navigator.sendBeacon`" + `.
+
+### Request
+` + "```json" + `
+{ "page": "arcade/arcade-week-1", "metrics": { "lcp": 1830, "cls": 0.04, "ttfb": 210 } }
+` + "```" + `
+
+- ` + "`page`" + ` is a slug path only (` + "`[a-z0-9/_-]`" + `, max 200 chars); query strings are discarded.
+- Accepted metrics: ` + "`lcp`" + `, ` + "`fcp`" + `, ` + "`ttfb`" + `, ` + "`inp`" + ` (ms) and ` + "`cls`" + `. Unknown or out-of-range values are ignored.
+- No session, cookie, IP, or URL is stored; only a coarse device class.
+- Returns ` + "`204 No Content`" + `.
+
+## GET /rum/summary
+
+p50/p75/p95 per metric. Query params: ` + "`page`" + ` (optional), ` + "`days`" + ` (default 7, max 90).
+
+` + "```json" + `
+{ "since": "2025-10-03T00:00:00Z", "items": [ { "metric": "lcp", "samples": 812, "p50": 1400, "p75": 2100, "p95": 4300 } ] }
+` + "```" + `
+
+## GET /rum/timeseries
+
+Hourly p75 for one metric next to total email views for the same hour. Query params: ` + "`metric`" + ` (required), ` + "`page`" + ` (optional), ` + "`days`" + ` (default 2, max 90).
+
+` + "```json" + `
+{ "metric": "lcp", "since": "...", "items": [ { "bucket": "2025-10-10T04:00:00Z", "samples": 90, "p75": 2300, "views": 1400 } ] }
+` + "```" + `
+
+---
+
 ## Link Click Tracking
 
 All links in email HTML are automatically rewritten to track clicks while preserving the user experience.
@@ -1452,7 +5491,8 @@ All links in email HTML are automatically rewritten to track clicks while preser
 
 1. **Automatic Link Rewriting**: When you fetch email HTML from ` + "`/emails`" + `, all ` + "`<a href>`" + ` tags are rewritten:
    - Original: ` + "`<a href=\"https://example.com\">Click here</a>`" + `
-   - Rewritten: ` + "`<a href=\"/emails/{id}/click/0?url=https%3A%2F%2Fexample.com\">Click here</a>`" + `
+   - Rewritten: ` + "`<a href=\"{PUBLIC_BASE_URL}/emails/{id}/click/0?url=https%3A%2F%2Fexample.com\">Click here</a>`" + `
+   - With ` + "`LINK_SHORTLINKS=1`" + `: ` + "`<a href=\"{PUBLIC_BASE_URL}/l/Xk3v9QpL2a0w\">Click here</a>`" + ` (see ` + "`GET /l/{code}`" + `)
 
 2. **Link Indexing**: Each link gets a sequential index (0, 1, 2...) for tracking which specific links are clicked.
 
@@ -1481,6 +5521,11 @@ Track a link click and redirect to the original URL.
 - Records click in TimescaleDB with deduplication
 - Emits real-time event to SSE subscribers
 - Returns 302 redirect to original URL
+- Links wrapped by known click trackers (Loops/Amazon SES, Outlook Safe Links, Google and Facebook redirectors) are unwrapped, both when HTML is rewritten and on redirect, so readers go straight to the destination and ` + "`/emails/{id}/links`" + ` reports real URLs
+- Destinations are health-checked every ` + "`LINK_CHECK_INTERVAL`" + ` (default 24h). A link that fails two checks in a row (an error status other than 401, 403 or 429, or no response) is listed at ` + "`/admin/links/broken`" + ` with its last status or error and the emails linking to it, those with the most tracked views first. Checks only connect to public addresses, including after DNS and on every redirect, so links to loopback, private or link-local hosts are reported broken rather than requested
+- With ` + "`LINK_ARCHIVE_FALLBACK=wayback`" + `, clicks on a broken link redirect to its Wayback Machine snapshot closest to when the email was sent, when there is one (its ` + "`archive_url`" + ` in ` + "`/admin/links/broken`" + `). The click is still counted against the original URL, and a link that starts working again is followed directly
+
+Tracking (never the redirect) is rate limited per IP and ` + "`_track`" + ` session with a token bucket: ` + "`CLICK_BURST`" + ` clicks at once (default 20), refilling at ` + "`CLICK_RATE`" + ` (default ` + "`10/1s`" + `). Requests without a ` + "`_track`" + ` cookie share their IP's bucket. Clicks over the limit are still redirected but not recorded; ` + "`/admin/dashboard`" + ` shows how many were.
 
 ### Example
 ` + "```" + `
@@ -1492,26 +5537,41 @@ GET /emails/abc123/click/0?url=https%3A%2F%2Fexample.com
 
 ---
 
+## GET /l/{code}
+
+The short form of a tracked link. Every rewritten link gets a 12-character code, stable across rebuilds of the email and saved with its link map; with ` + "`LINK_SHORTLINKS=1`" + `, rewritten HTML uses ` + "`{PUBLIC_BASE_URL}/l/{code}`" + ` instead of the click URL above, which keeps the HTML smaller and gives links that can be shared on their own. Click URLs in HTML served before keep working.
+
+Clicks are tracked, rate limited and redirected exactly as for ` + "`/emails/{id}/click/{index}`" + `. Unknown codes are ` + "`404`" + `.
+
+---
+
 ## GET /emails/{id}/stats/stream
 
-Real-time Server-Sent Events (SSE) stream of **both** view and click count updates.
+Real-time Server-Sent Events (SSE) stream of view, click and like count updates.
 
 ### Behavior
-- Streams stats updates whenever views OR clicks are tracked
+- Streams stats updates whenever views, clicks or likes change
 - Throttled to max 3 updates/second to prevent flooding
 - Auto-closes when client disconnects
 - Sends initial stats immediately on connection
+- Sends a ` + "`: ping`" + ` comment every 15s so proxies don't drop idle connections (` + "`EventSource`" + ` ignores comments)
+- Not subject to the 30s request timeout; streams stay open until the client disconnects or the server shuts down
 
 ### Response Format
 ` + "```" + `
-data: {"views":1234,"clicks":82}
+id: v1234-c82-l17
+data: {"views":1234,"clicks":82,"likes":17}
 
-data: {"views":1235,"clicks":82}
+id: v1235-c82-l17
+data: {"views":1235,"clicks":82,"likes":17}
 
-data: {"views":1235,"clicks":83}
+id: v1235-c83-l17
+data: {"views":1235,"clicks":83,"likes":17}
 ` + "```" + `
 
-Each message is a JSON object with both view and click counts.
+Each message is a JSON object with view, click and like counts. The ` + "`id`" + ` identifies the snapshot; when a browser reconnects it sends it back as ` + "`Last-Event-ID`" + ` and the initial snapshot is skipped if counts haven't changed since, otherwise the latest snapshot is sent right away. ` + "`EventSource`" + ` handles this automatically.
+
+Both stat streams require an API key when ` + "`API_KEYS`" + ` is set. ` + "`EventSource`" + ` can't send an ` + "`Authorization`" + ` header, so browsers should then reach the stream through a proxy on your own backend that adds it.
 
 ### Frontend Example
 ` + "```javascript" + `
@@ -1527,20 +5587,46 @@ es.onmessage = e => {
 Events are emitted when:
 - A view is tracked (` + "`/emails/{id}/view`" + `)
 - A link click is tracked (` + "`/emails/{id}/click/{index}`" + `)
+- An email is liked or unliked (` + "`/emails/{id}/like`" + `)
 - Updates are throttled: rapid events are batched into periodic updates (333ms interval)
 
 ---
 
+## GET /stats/stream
+
+Archive-wide SSE "firehose": one stream with updates for **every** email whose views, clicks or likes change, for live-activity dashboards that would otherwise need a stream per email.
+
+### Response Format
+` + "```" + `
+id: 9c41e07a.1042
+data: {"email_id":"cmgkb2b058ngw210ij7jpskf4","views":1235,"clicks":82,"likes":17}
+
+id: 9c41e07a.1043
+data: {"email_id":"cm1fqxdc900qn0ll9fd5m3wdv","views":311,"clicks":9,"likes":2}
+` + "```" + `
+
+- No initial snapshot; messages start with the next change.
+- Keepalive comments and timeouts behave as for the per-email stream.
+- On reconnect with ` + "`Last-Event-ID`" + `, the latest counts of every email changed since that event are replayed. IDs are opaque and specific to the server process that sent them; when the reconnect lands on another replica or process, or the event is older than the last 1024 changes, a snapshot of the latest counts of every email among those 1024 changes is sent instead.
+- Changes are batched once per second, at most 50 emails per batch; an email that changes several times within a batch is sent once with its latest counts.
+- Requires an API key when ` + "`API_KEYS`" + ` is set, like the per-email stream. The same firehose is served to operators at ` + "`/admin/stats/stream`" + ` behind the admin key, which browsers can send as a Basic password; ` + "`/admin/dashboard`" + ` reads it there.
+
+---
+
 ## Click Analytics
 
 ### Counting Method
 - **Database**: Stores all click events with session_id, email_id, link_index, link_url, timestamp
 - **Deduplication**: Uses ` + "`COUNT(DISTINCT (session_id, link_index))`" + ` to count unique clicks
 - **Combined Total**: TimescaleDB tracked clicks + warehouse clicks from Loops
+- **Hot emails**: with ` + "`METRICS_COUNT_MODE=cached`" + ` or ` + "`hll`" + `, emails with ` + "`METRICS_COUNT_HOT_THRESHOLD`" + ` (default 10000) or more views or clicks are served a count up to ` + "`METRICS_COUNT_REFRESH`" + ` (default 30s) old, recounted in the background exactly (` + "`cached`" + `) or as a HyperLogLog estimate within about 1% (` + "`hll`" + `, needs timescaledb_toolkit). Smaller counts are always exact.
+- **Memoized**: an email's view, click and like counts are shared by all requests and streams for up to ` + "`METRICS_COUNT_TTL`" + ` (default 5s, ` + "`0`" + ` disables), and recounted as soon as a change is tracked.
+- **Lists**: ` + "`/emails`" + `, ` + "`/mailing_lists/emails`" + ` and exports read tracked views, clicks and read time from per-email totals rolled up every ` + "`STATS_ROLLUP_INTERVAL`" + ` (default 5m), so they can lag a single ` + "`/emails/{id}`" + ` or the stats streams, which count live, by that much.
 
 ### Privacy & Session Tracking
 - Same ` + "`_track`" + ` cookie used for both views and clicks
 - Anonymous session IDs only (no PII)
+- The cookie value is never stored: tracking rows hold an HMAC of it, salted per rotation period (` + "`SESSION_HASH_ROTATION`" + `, default 30 days, keyed by ` + "`SESSION_HASH_SECRET`" + `, which every instance must share and is required with a metrics DB). A metrics DB leak can't be matched to cookies, and a browser's activity only links up within one period; readers active across a rotation count as two sessions.
 - HttpOnly, SameSite=Lax, Secure (on HTTPS)
 - 30-day cookie lifetime
 
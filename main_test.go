@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeReferrer(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // "" for nil
+	}{
+		{"https://x.com/hackclub/status/1?s=20", "https://x.com"},
+		{"  HTTPS://News.HackClub.com:8443/a#b ", "https://news.hackclub.com:8443"},
+		{"http://localhost:3000/", "http://localhost:3000"},
+		{"android-app://com.slack/", ""},
+		{"javascript:alert(1)", ""},
+		{"/relative/path", ""},
+		{"", ""},
+		{"https://%zz", ""},
+	}
+	for _, tt := range tests {
+		got := normalizeReferrer(tt.raw)
+		if (got == nil) != (tt.want == "") || got != nil && *got != tt.want {
+			t.Errorf("normalizeReferrer(%q) = %v, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want DeviceInfo
+	}{
+		{"", DeviceInfo{"unknown", "unknown"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", DeviceInfo{"desktop", "chrome"}},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36 Edg/124.0", DeviceInfo{"desktop", "edge"}},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", DeviceInfo{"desktop", "safari"}},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0", DeviceInfo{"desktop", "firefox"}},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0 Mobile/15E148 Safari/604.1", DeviceInfo{"mobile", "chrome"}},
+		{"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0 Mobile Safari/537.36", DeviceInfo{"mobile", "samsung"}},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", DeviceInfo{"tablet", "chrome"}},
+		{"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", DeviceInfo{"tablet", "safari"}},
+		{"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36 OPR/109.0", DeviceInfo{"desktop", "opera"}},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", DeviceInfo{"bot", "other"}},
+		{"curl/8.5.0", DeviceInfo{"desktop", "other"}},
+	}
+	for _, tt := range tests {
+		if got := parseDevice(tt.ua); got != tt.want {
+			t.Errorf("parseDevice(%q) = %+v, want %+v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		method, target string
+		want           string
+	}{
+		{"GET", "/emails", "GET /emails?"},
+		{"GET", "/emails?offset=20&limit=10", "GET /emails?limit=10&offset=20"},
+		{"GET", "/emails?limit=10&utm_source=x&_=123", "GET /emails?limit=10"},
+		{"GET", "/emails?limit=10&limit=50", "GET /emails?limit=10"},
+		{"GET", "/search?q=hack+club", "GET /search?q=hack+club"},
+		{"HEAD", "/emails/abc", "HEAD /emails/abc?"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.target, nil)
+		if got := cacheKey(r); got != tt.want {
+			t.Errorf("cacheKey(%s %s) = %q, want %q", tt.method, tt.target, got, tt.want)
+		}
+		if got, want := cacheKeyPath(cacheKey(r)), r.URL.Path; got != want {
+			t.Errorf("cacheKeyPath(cacheKey(%s)) = %q, want %q", tt.target, got, want)
+		}
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"203.0.113.7:51234", "203.0.113.7"},
+		{"203.0.113.7", "203.0.113.7"},
+		{"[2001:db8:1:2:3:4:5:6]:443", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff::1]:443", "2001:db8:1:2::/64"},
+		{"[::ffff:192.0.2.1]:80", "192.0.2.1"},
+		{"@", "@"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if got := clientKey(r); got != tt.want {
+			t.Errorf("clientKey(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestTrustProxyRealIP(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       map[string]string // "" for dropped
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.1:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"1.2.3.4"},
+				"X-Real-Ip":         {"1.2.3.4"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"news.hackclub.com"},
+			},
+			want: map[string]string{"X-Real-Ip": "", "X-Forwarded-Proto": "", "X-Forwarded-Host": "", "X-Forwarded-For": ""},
+		},
+		{
+			name:       "first untrusted hop from the right",
+			remoteAddr: "10.0.0.2:1234",
+			header:     http.Header{"X-Forwarded-For": {"6.6.6.6, 1.2.3.4, 10.0.0.5"}, "True-Client-Ip": {"6.6.6.6"}},
+			want:       map[string]string{"X-Real-Ip": "1.2.3.4", "True-Client-Ip": ""},
+		},
+		{
+			name:       "multiple header lines",
+			remoteAddr: "10.0.0.2:1234",
+			header:     http.Header{"X-Forwarded-For": {"6.6.6.6", "1.2.3.4"}},
+			want:       map[string]string{"X-Real-Ip": "1.2.3.4"},
+		},
+		{
+			name:       "garbage hop",
+			remoteAddr: "10.0.0.2:1234",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4, garbage, 10.0.0.5"}},
+			want:       map[string]string{"X-Real-Ip": "10.0.0.5"},
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.2:1234",
+			header:     http.Header{"X-Forwarded-For": {"10.0.0.9"}},
+			want:       map[string]string{"X-Real-Ip": "10.0.0.9"},
+		},
+		{
+			name:       "rightmost proto and host",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"http, HTTPS"},
+				"X-Forwarded-Host":  {"evil.example", "news.hackclub.com"},
+			},
+			want: map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "news.hackclub.com", "X-Real-Ip": ""},
+		},
+		{
+			name:       "malformed proto and host",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"X-Forwarded-Proto": {"https, ftp"},
+				"X-Forwarded-Host":  {"news.hackclub.com, evil.example/path"},
+			},
+			want: map[string]string{"X-Forwarded-Proto": "", "X-Forwarded-Host": ""},
+		},
+		{
+			name:       "trailing comma",
+			remoteAddr: "10.0.0.2:1234",
+			header:     http.Header{"X-Forwarded-Host": {"news.hackclub.com,"}},
+			want:       map[string]string{"X-Forwarded-Host": ""},
+		},
+		{
+			name:       "unix socket peer",
+			remoteAddr: "@",
+			header:     http.Header{"X-Forwarded-For": {"1.2.3.4"}, "X-Forwarded-Proto": {"https"}},
+			want:       map[string]string{"X-Real-Ip": "1.2.3.4", "X-Forwarded-Proto": "https"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			h := trustProxyRealIP([]*net.IPNet{trusted})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header = tt.header
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			for name, want := range tt.want {
+				if v := got.Get(name); v != want {
+					t.Errorf("%s = %q, want %q", name, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOriginMatches(t *testing.T) {
+	tests := []struct {
+		origin, pattern string
+		want            bool
+	}{
+		{"https://hackclub.com", "https://hackclub.com", true},
+		{"https://anything.example", "*", true},
+		{"https://news.hackclub.com", "https://*.hackclub.com", true},
+		{"https://a.b.hackclub.com", "https://*.hackclub.com", true},
+		{"https://hackclub.com", "https://*.hackclub.com", false},
+		{"http://news.hackclub.com", "https://*.hackclub.com", false},
+		{"https://evilhackclub.com", "https://*.hackclub.com", false},
+		{"https://news.hackclub.com.evil.example", "https://*.hackclub.com", false},
+		{"https://x/y.hackclub.com", "https://*.hackclub.com", false},
+		{"https://-bad.hackclub.com", "https://*.hackclub.com", false},
+		{"https://news.hackclub.com", "https://hackclub.com", false},
+		{"https://news.hackclub.com", "*.hackclub.com", false},
+	}
+	for _, tt := range tests {
+		if got := originMatches(tt.origin, tt.pattern); got != tt.want {
+			t.Errorf("originMatches(%q, %q) = %t, want %t", tt.origin, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestIsStreamRequest(t *testing.T) {
+	tests := []struct {
+		target string
+		accept string
+		want   bool
+	}{
+		{"/stats/stream", "", true},
+		{"/admin/stats/stream", "", true},
+		{"/emails/abc/stats/stream", "", true},
+		{"/export/emails.csv", "", true},
+		{"/emails?format=ndjson", "", true},
+		{"/emails", "application/x-ndjson", true},
+		{"/emails", "application/json", false},
+		{"/emails/abc?format=ndjson", "", false},
+		{"/search?q=x", "application/x-ndjson", false},
+		{"/mailing_lists/emails?format=ndjson", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := isStreamRequest(r); got != tt.want {
+			t.Errorf("isStreamRequest(%s, Accept %q) = %t, want %t", tt.target, tt.accept, got, tt.want)
+		}
+	}
+}
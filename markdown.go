@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// ---------- Markdown Rendering ----------
+
+// ?render=markdown on /emails/{id} replaces the campaign HTML with HTML
+// rendered from the email's markdown: plain semantic elements with no
+// inline styles, for frontends that would rather style content
+// themselves than contain an email layout. Links are rewritten for click
+// tracking like the campaign's, reusing the campaign link's index (and
+// short code) when the same URL appears there, so clicks land in the same
+// /emails/{id}/links rows.
+//
+// Markdown is rendered by goldmark as CommonMark with strikethrough. Raw
+// HTML is omitted rather than passed through, and links and images with
+// schemes other than http(s), mailto and tel are reduced to their text.
+
+// markdownRenderer is CommonMark plus strikethrough. Raw HTML is left out
+// (goldmark's default, without html.WithUnsafe), and mdURLFilter unlinks
+// links and images with schemes we don't allow.
+var markdownRenderer = goldmark.New(
+	goldmark.WithExtensions(extension.Strikethrough),
+	goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(mdURLFilter{}, 100))),
+)
+
+// markdownToHTML renders md as an HTML fragment.
+func markdownToHTML(md string) (string, error) {
+	var b strings.Builder
+	if err := markdownRenderer.Convert([]byte(md), &b); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// mdSafeURL returns u unless it has a scheme we don't link to.
+func mdSafeURL(u string) string {
+	u = strings.TrimSpace(u)
+	scheme, _, ok := strings.Cut(u, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return u // relative
+	}
+	switch strings.ToLower(scheme) {
+	case "http", "https", "mailto", "tel":
+		return u
+	}
+	return ""
+}
+
+// mdURLFilter replaces links, images and autolinks whose destinations
+// mdSafeURL rejects with their text.
+type mdURLFilter struct{}
+
+func (mdURLFilter) Transform(doc *ast.Document, reader text.Reader, _ parser.Context) {
+	source := reader.Source()
+	var unsafe []ast.Node
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			if n.Destination = []byte(mdSafeURL(string(n.Destination))); len(n.Destination) == 0 {
+				unsafe = append(unsafe, n)
+			}
+		case *ast.Image:
+			if n.Destination = []byte(mdSafeURL(string(n.Destination))); len(n.Destination) == 0 {
+				unsafe = append(unsafe, n)
+			}
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && mdSafeURL(string(n.URL(source))) == "" {
+				unsafe = append(unsafe, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, n := range unsafe {
+		parent := n.Parent()
+		if a, ok := n.(*ast.AutoLink); ok {
+			parent.ReplaceChild(parent, a, ast.NewString(a.Label(source)))
+			continue
+		}
+		for c := n.FirstChild(); c != nil; {
+			next := c.NextSibling()
+			parent.InsertBefore(parent, n, c)
+			c = next
+		}
+		parent.RemoveChild(parent, n)
+	}
+}
+
+// renderMarkdown replaces e.HTML with its markdown rendered, with links
+// rewritten for click tracking when track is set (it isn't for previews).
+// Emails without markdown keep their campaign HTML.
+func (s *Store) renderMarkdown(r *http.Request, e *Email, track bool) error {
+	if e.Markdown == nil || strings.TrimSpace(*e.Markdown) == "" {
+		return nil
+	}
+	rendered, err := markdownToHTML(*e.Markdown)
+	if err != nil {
+		return err
+	}
+	if base := s.linkBaseURL(); base != nil {
+		doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + rendered + "</body>"))
+		if err != nil {
+			return err
+		}
+		resolveDocURLs(doc, base)
+		if rendered, err = doc.Find("body").Html(); err != nil {
+			return err
+		}
+	}
+	if track {
+		rendered, err = rewriteMarkdownLinks(publicBaseURL(r, s.publicBase), e.ID, rendered, s.shortLinks, e.links)
+		if err != nil {
+			return err
+		}
+	}
+	e.HTML = &rendered
+	return nil
+}
+
+// rewriteMarkdownLinks routes a rendered fragment's links through the click
+// tracker. A link to a URL the campaign HTML also links to (in campaign, its
+// link map) takes that link's index and code; others are numbered after the
+// campaign's links and always use the long form, since only the campaign's
+// codes are saved.
+func rewriteMarkdownLinks(baseURL, emailID, fragment string, short bool, campaign []EmailLink) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader("<body>" + fragment + "</body>"))
+	if err != nil {
+		return fragment, err
+	}
+	known := map[string]EmailLink{}
+	for _, l := range campaign {
+		if _, ok := known[l.URL]; !ok {
+			known[l.URL] = l
+		}
+	}
+	next := len(campaign)
+	doc.Find("a[href]").Each(func(_ int, sel *goquery.Selection) {
+		href := sel.AttrOr("href", "")
+		if strings.HasPrefix(href, "mailto:") || strings.HasPrefix(href, "#") || strings.HasPrefix(href, "tel:") {
+			return
+		}
+		href = unwrapTrackingURL(href)
+		l, ok := known[href]
+		if !ok {
+			l = EmailLink{Index: next, URL: href}
+			known[href] = l
+			next++
+		}
+		if short && l.Code != "" {
+			sel.SetAttr("href", baseURL+"/l/"+l.Code)
+		} else {
+			sel.SetAttr("href", fmt.Sprintf("%s/emails/%s/click/%d?url=%s", baseURL, emailID, l.Index, url.QueryEscape(href)))
+		}
+	})
+	return doc.Find("body").Html()
+}
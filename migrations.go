@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ---------- Metrics Migrations ----------
+
+// Metrics DB schema changes live in migrations/NNNN_name.sql, applied in
+// version order and recorded in schema_migrations. A version may add
+// NNNN_name.timescale.sql and/or NNNN_name.plain.sql, run after the common
+// file depending on whether timescaledb is available. Applied migrations
+// must never be edited; add a new version instead. Statements should stay
+// idempotent (IF NOT EXISTS) so a migration interrupted midway can rerun.
+// Data backfills too large for one statement go in migrationBackfills,
+// run after the version's statements and before it's recorded.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version   int
+	name      string
+	common    string
+	timescale string
+	plain     string
+}
+
+// checksum covers every variant, so editing an applied file is noticed
+// whichever mode the database runs in.
+func (m migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.common + "\x00" + m.timescale + "\x00" + m.plain))
+	return hex.EncodeToString(sum[:8])
+}
+
+var migrationFileRegex = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+?)(?:\.(timescale|plain))?\.sql$`)
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationFileRegex.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrations/%s: name must be NNNN_name[.timescale|.plain].sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(migrationFiles, path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: m[2]}
+			byVersion[version] = mig
+		} else if mig.name != m[2] {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, mig.name, m[2])
+		}
+		switch m[3] {
+		case "":
+			mig.common = string(body)
+		case "timescale":
+			mig.timescale = string(body)
+		case "plain":
+			mig.plain = string(body)
+		}
+	}
+	out := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	return out, nil
+}
+
+// applyMigrations runs pending migrations on conn, which must hold the
+// migration lock. Statements run one at a time outside a transaction:
+// timescale refuses to create continuous aggregates inside one.
+func applyMigrations(ctx context.Context, conn *pgxpool.Conn, timescale bool) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied := map[int]string{}
+	rows, err := conn.Query(ctx, `SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v int
+		var sum string
+		if err := rows.Scan(&v, &sum); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = sum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range migrations {
+		if sum, ok := applied[m.version]; ok {
+			if sum != m.checksum() {
+				log.Printf("warning: migration %04d_%s changed after it was applied; add a new migration instead", m.version, m.name)
+			}
+			continue
+		}
+		variant := m.plain
+		if timescale {
+			variant = m.timescale
+		}
+		for i, stmt := range append(splitSQL(m.common), splitSQL(variant)...) {
+			if _, err := conn.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("migration %04d_%s statement %d: %w", m.version, m.name, i+1, err)
+			}
+		}
+		if backfill := migrationBackfills[m.version]; backfill != nil {
+			if err := backfill(ctx, conn); err != nil {
+				return fmt.Errorf("migration %04d_%s backfill: %w", m.version, m.name, err)
+			}
+		}
+		if _, err := conn.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+			m.version, m.name, m.checksum()); err != nil {
+			return fmt.Errorf("record migration %04d: %w", m.version, err)
+		}
+		log.Printf("applied migration %04d_%s", m.version, m.name)
+		pending++
+	}
+	if pending == 0 {
+		log.Printf("metrics schema up to date (version %d)", migrations[len(migrations)-1].version)
+	}
+	return nil
+}
+
+// migrationBackfills are the Go steps of migrations, by version. Each must
+// be idempotent, and commit as it goes rather than in one transaction.
+var migrationBackfills = map[int]func(ctx context.Context, conn *pgxpool.Conn) error{
+	31: backfillTrackingSchemaVersion,
+}
+
+// backfillTrackingBatch is the span of event time reclassified per UPDATE.
+const backfillTrackingBatch = 24 * time.Hour
+
+// backfillTrackingSchemaVersion marks events recorded before schema_version
+// existed (still v1; writers have set it since) as v2 if they carry any v2
+// dimension: a device bucket, or on views a referrer (recorded before
+// device buckets were). Direct views from that window carry neither and
+// stay v1. It walks one day of event time per
+// statement, so no single transaction locks or rewrites the whole table.
+func backfillTrackingSchemaVersion(ctx context.Context, conn *pgxpool.Conn) error {
+	tables := []struct{ name, v2 string }{
+		{"email_views", "device_class IS NOT NULL OR referrer IS NOT NULL"},
+		{"email_link_clicks", "device_class IS NOT NULL"},
+	}
+	for _, t := range tables {
+		var from, to *time.Time
+		err := conn.QueryRow(ctx, fmt.Sprintf(`SELECT MIN(time), MAX(time) FROM %s WHERE schema_version = 1`, t.name)).Scan(&from, &to)
+		if err != nil {
+			return err
+		}
+		if from == nil {
+			continue
+		}
+		updated := int64(0)
+		for start := *from; !start.After(*to); start = start.Add(backfillTrackingBatch) {
+			tag, err := conn.Exec(ctx, fmt.Sprintf(`
+				UPDATE %s SET schema_version = 2
+				WHERE time >= $1 AND time < $2 AND schema_version = 1 AND (%s)
+			`, t.name, t.v2), start, start.Add(backfillTrackingBatch))
+			if err != nil {
+				return err
+			}
+			updated += tag.RowsAffected()
+		}
+		log.Printf("%s: marked %d earlier events schema_version 2", t.name, updated)
+	}
+	return nil
+}
+
+// splitSQL splits a file into statements on semicolons outside quotes,
+// dollar-quoted bodies, and comments. Empty statements are dropped.
+func splitSQL(sql string) []string {
+	var stmts []string
+	start := 0
+	flush := func(end int) {
+		if stmt := strings.TrimSpace(sql[start:end]); stmt != "" && !onlySQLComments(stmt) {
+			stmts = append(stmts, stmt)
+		}
+		start = end + 1
+	}
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case c == '\'' || c == '"':
+			if j := strings.IndexByte(sql[i+1:], c); j >= 0 {
+				i += j + 1
+			}
+		case c == '$':
+			// $$ or $tag$ ... matching close.
+			if j := strings.IndexByte(sql[i+1:], '$'); j >= 0 && isDollarTag(sql[i+1:i+1+j]) {
+				tag := sql[i : i+j+2]
+				if k := strings.Index(sql[i+len(tag):], tag); k >= 0 {
+					i += len(tag) + k + len(tag) - 1
+				}
+			}
+		case c == ';':
+			flush(i)
+		}
+	}
+	if start < len(sql) {
+		flush(len(sql))
+	}
+	return stmts
+}
+
+func isDollarTag(s string) bool {
+	for _, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func onlySQLComments(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,11 @@
+CREATE INDEX IF NOT EXISTS idx_email_views_dedup
+	ON email_views (session_id, email_id, time);
+
+-- Without continuous aggregates the rollup is a plain table kept fresh by
+-- Store.RefreshViewCountRollup.
+CREATE TABLE IF NOT EXISTS email_view_counts (
+	bucket TIMESTAMPTZ NOT NULL,
+	email_id TEXT NOT NULL,
+	view_count BIGINT NOT NULL,
+	PRIMARY KEY (bucket, email_id)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS email_views (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	session_id TEXT NOT NULL,
+	email_id TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_views_email_id ON email_views(email_id, time DESC);
@@ -0,0 +1,20 @@
+SELECT create_hypertable('email_views', 'time', if_not_exists => TRUE);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_views_dedup
+	ON email_views (session_id, email_id, time_bucket('5 minutes', time), time);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS email_view_counts
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket('1 hour', time) as bucket,
+	email_id,
+	COUNT(DISTINCT session_id) as view_count
+FROM email_views
+GROUP BY bucket, email_id
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('email_view_counts',
+	start_offset => INTERVAL '1 day',
+	end_offset => INTERVAL '1 hour',
+	schedule_interval => INTERVAL '1 hour',
+	if_not_exists => TRUE);
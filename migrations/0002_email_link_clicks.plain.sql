@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_email_link_clicks_dedup
+	ON email_link_clicks (session_id, email_id, link_index, time);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS email_link_clicks (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	session_id TEXT NOT NULL,
+	email_id TEXT NOT NULL,
+	link_url TEXT NOT NULL,
+	link_index INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_link_clicks_email_id ON email_link_clicks(email_id, time DESC);
@@ -0,0 +1,4 @@
+SELECT create_hypertable('email_link_clicks', 'time', if_not_exists => TRUE);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_link_clicks_dedup
+	ON email_link_clicks (session_id, email_id, link_index, time_bucket('5 minutes', time), time);
@@ -0,0 +1,37 @@
+ALTER TABLE email_views ADD COLUMN IF NOT EXISTS referrer TEXT;
+
+ALTER TABLE email_views
+	ADD COLUMN IF NOT EXISTS device_class TEXT,
+	ADD COLUMN IF NOT EXISTS browser_family TEXT;
+
+ALTER TABLE email_link_clicks
+	ADD COLUMN IF NOT EXISTS device_class TEXT,
+	ADD COLUMN IF NOT EXISTS browser_family TEXT;
+
+-- schema_version: rows written before it existed are v2 if they carry a
+-- device bucket (always set since it was introduced), else v1.
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'email_views' AND column_name = 'schema_version'
+	) THEN
+		ALTER TABLE email_views ADD COLUMN schema_version SMALLINT;
+		UPDATE email_views SET schema_version = CASE WHEN device_class IS NOT NULL THEN 2 ELSE 1 END;
+		ALTER TABLE email_views ALTER COLUMN schema_version SET DEFAULT 1,
+			ALTER COLUMN schema_version SET NOT NULL;
+	END IF;
+END $$;
+
+DO $$
+BEGIN
+	IF NOT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'email_link_clicks' AND column_name = 'schema_version'
+	) THEN
+		ALTER TABLE email_link_clicks ADD COLUMN schema_version SMALLINT;
+		UPDATE email_link_clicks SET schema_version = CASE WHEN device_class IS NOT NULL THEN 2 ELSE 1 END;
+		ALTER TABLE email_link_clicks ALTER COLUMN schema_version SET DEFAULT 1,
+			ALTER COLUMN schema_version SET NOT NULL;
+	END IF;
+END $$;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS rum_vitals (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	page TEXT NOT NULL,
+	metric TEXT NOT NULL,
+	value DOUBLE PRECISION NOT NULL,
+	device_class TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_rum_vitals_metric ON rum_vitals(metric, time DESC);
@@ -0,0 +1 @@
+SELECT create_hypertable('rum_vitals', 'time', if_not_exists => TRUE);
@@ -0,0 +1,15 @@
+-- page_views mirrors email_views for non-email pages (homepage, list
+-- indexes, ...), created directly at the current tracking schema.
+CREATE TABLE IF NOT EXISTS page_views (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	schema_version SMALLINT NOT NULL DEFAULT 1,
+	session_id TEXT NOT NULL,
+	page_key TEXT NOT NULL,
+	referrer TEXT,
+	device_class TEXT,
+	browser_family TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_page_views_dedup ON page_views(session_id, page_key, time);
+
+CREATE INDEX IF NOT EXISTS idx_page_views_page_key ON page_views(page_key, time DESC);
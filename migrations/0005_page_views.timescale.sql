@@ -0,0 +1 @@
+SELECT create_hypertable('page_views', 'time', if_not_exists => TRUE);
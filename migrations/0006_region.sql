@@ -0,0 +1,4 @@
+ALTER TABLE email_views ADD COLUMN IF NOT EXISTS region TEXT;
+ALTER TABLE email_link_clicks ADD COLUMN IF NOT EXISTS region TEXT;
+ALTER TABLE page_views ADD COLUMN IF NOT EXISTS region TEXT;
+ALTER TABLE rum_vitals ADD COLUMN IF NOT EXISTS region TEXT;
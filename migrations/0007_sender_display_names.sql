@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS sender_display_names (
+	scope TEXT PRIMARY KEY,
+	display_name TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,6 @@
+-- Shared click rate limiter state (CLICK_LIMITER=postgres); disposable, so
+-- unlogged.
+CREATE UNLOGGED TABLE IF NOT EXISTS click_rate_limits (
+	key TEXT PRIMARY KEY,
+	last_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS list_subscriber_counts (
+	day DATE NOT NULL,
+	mailing_list_id TEXT NOT NULL,
+	subscriber_count BIGINT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (mailing_list_id, day)
+);
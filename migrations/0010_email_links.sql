@@ -0,0 +1,11 @@
+-- Where each tracked link sits in its email, saved when links are rewritten
+-- (see links.go). link_index matches email_link_clicks.
+CREATE TABLE IF NOT EXISTS email_links (
+	email_id TEXT NOT NULL,
+	link_index INT NOT NULL,
+	url TEXT NOT NULL,
+	section TEXT,
+	position DOUBLE PRECISION NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (email_id, link_index)
+);
@@ -0,0 +1,13 @@
+-- Furthest scroll depth (percent) per session and email; a row is only
+-- written when a session passes its previous maximum.
+CREATE TABLE IF NOT EXISTS email_scroll_depth (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	session_id TEXT NOT NULL,
+	email_id TEXT NOT NULL,
+	depth SMALLINT NOT NULL,
+	device_class TEXT,
+	region TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_scroll_depth_email_id ON email_scroll_depth(email_id, time DESC);
+CREATE INDEX IF NOT EXISTS idx_email_scroll_depth_dedup ON email_scroll_depth(session_id, email_id, time);
@@ -0,0 +1 @@
+SELECT create_hypertable('email_scroll_depth', 'time', if_not_exists => TRUE);
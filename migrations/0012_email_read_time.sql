@@ -0,0 +1,13 @@
+-- Longest bucketed read time (seconds) per session and email; a row is only
+-- written when a session passes its previous maximum.
+CREATE TABLE IF NOT EXISTS email_read_time (
+	time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	session_id TEXT NOT NULL,
+	email_id TEXT NOT NULL,
+	seconds SMALLINT NOT NULL,
+	device_class TEXT,
+	region TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_read_time_email_id ON email_read_time(email_id, time DESC);
+CREATE INDEX IF NOT EXISTS idx_email_read_time_dedup ON email_read_time(session_id, email_id, time);
@@ -0,0 +1 @@
+SELECT create_hypertable('email_read_time', 'time', if_not_exists => TRUE);
@@ -0,0 +1,9 @@
+-- The click limiter keeps a token bucket per key instead of a last-click
+-- time. The state is disposable, so the table is replaced rather than
+-- migrated.
+DROP TABLE IF EXISTS click_rate_limits;
+CREATE UNLOGGED TABLE click_rate_limits (
+	key TEXT PRIMARY KEY,
+	tokens DOUBLE PRECISION NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL
+);
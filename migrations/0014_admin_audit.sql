@@ -0,0 +1,12 @@
+-- Mutating admin API calls, for review at GET /admin/audit.
+CREATE TABLE IF NOT EXISTS admin_audit (
+	id BIGSERIAL PRIMARY KEY,
+	at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	key_id TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	params JSONB NOT NULL DEFAULT '{}',
+	status INT NOT NULL,
+	request_id TEXT
+);
+CREATE INDEX IF NOT EXISTS admin_audit_key_id_idx ON admin_audit (key_id, id DESC);
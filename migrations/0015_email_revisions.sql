@@ -0,0 +1,14 @@
+-- Snapshots of each email's publishable content, one row per change.
+CREATE TABLE IF NOT EXISTS email_revisions (
+	email_id TEXT NOT NULL,
+	revision INT NOT NULL,
+	content_hash TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	excerpt TEXT,
+	html TEXT,
+	markdown TEXT,
+	source_updated_at TIMESTAMPTZ,
+	captured_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (email_id, revision)
+);
@@ -0,0 +1,11 @@
+-- One row per liking session and email; unliking deletes it. session_id is
+-- a stable hash of the _track session, unlike the rotating hashes stored
+-- with views and clicks.
+CREATE TABLE IF NOT EXISTS email_likes (
+	email_id TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	liked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (email_id, session_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_likes_liked_at ON email_likes(liked_at);
@@ -0,0 +1,10 @@
+-- Lifetime tracked stats per email, recomputed by the stats rollup for
+-- emails with new events; list endpoints read these instead of counting
+-- raw events.
+CREATE TABLE IF NOT EXISTS email_stats_totals (
+	email_id TEXT PRIMARY KEY,
+	views BIGINT NOT NULL,
+	clicks BIGINT NOT NULL,
+	median_read_seconds INTEGER,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,15 @@
+-- Queued background jobs (see jobs.go). A row is deleted once its job
+-- succeeds; failed_at marks one that ran out of attempts.
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	kind TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	locked_until TIMESTAMPTZ,
+	last_error TEXT,
+	failed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at) WHERE failed_at IS NULL;
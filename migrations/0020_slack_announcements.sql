@@ -0,0 +1,6 @@
+-- Emails announced on Slack, claimed before posting so each is announced
+-- once across replicas.
+CREATE TABLE IF NOT EXISTS slack_announcements (
+	email_id TEXT PRIMARY KEY,
+	announced_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
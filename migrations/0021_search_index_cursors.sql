@@ -0,0 +1,7 @@
+-- Where each search index sync left off in the change feed (an encoded
+-- /changes cursor), keyed by engine and index name.
+CREATE TABLE IF NOT EXISTS search_index_cursors (
+	index_name TEXT PRIMARY KEY,
+	cursor TEXT NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS mailing_list_branding (
+	mailing_list_id TEXT PRIMARY KEY,
+	logo_url TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
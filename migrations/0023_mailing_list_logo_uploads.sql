@@ -0,0 +1,4 @@
+-- Uploaded logos, served from /mailing_lists/{id}/logo; logo_url is '' for
+-- these.
+ALTER TABLE mailing_list_branding ADD COLUMN IF NOT EXISTS logo_type TEXT;
+ALTER TABLE mailing_list_branding ADD COLUMN IF NOT EXISTS logo_data BYTEA;
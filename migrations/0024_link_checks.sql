@@ -0,0 +1,14 @@
+-- Outbound links of published emails and their latest health check; see
+-- linkcheck.go. failures counts consecutive failed checks.
+CREATE TABLE IF NOT EXISTS link_checks (
+	url TEXT PRIMARY KEY,
+	email_ids TEXT[] NOT NULL,
+	seen_at TIMESTAMPTZ NOT NULL,
+	checked_at TIMESTAMPTZ,
+	status INTEGER,
+	error TEXT,
+	failures INTEGER NOT NULL DEFAULT 0,
+	failing_since TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS link_checks_checked_at_idx ON link_checks (checked_at NULLS FIRST);
+CREATE INDEX IF NOT EXISTS link_checks_failures_idx ON link_checks (failures) WHERE failures > 0;
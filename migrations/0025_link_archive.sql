@@ -0,0 +1,4 @@
+-- linked_at is when the first email linking the URL was sent; archive_url
+-- is its closest Wayback snapshot once broken ('' when there's none).
+ALTER TABLE link_checks ADD COLUMN IF NOT EXISTS linked_at TIMESTAMPTZ;
+ALTER TABLE link_checks ADD COLUMN IF NOT EXISTS archive_url TEXT;
@@ -0,0 +1,3 @@
+-- code is the link's /l/{code} short URL; see shortlinks.go.
+ALTER TABLE email_links ADD COLUMN IF NOT EXISTS code TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS email_links_code_idx ON email_links (code);
@@ -0,0 +1,8 @@
+-- Actual sizes of images in published emails, for width/height and srcset
+-- on rewritten HTML; see images.go. Zero means the image couldn't be read.
+CREATE TABLE IF NOT EXISTS image_sizes (
+	url TEXT PRIMARY KEY,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	fetched_at TIMESTAMPTZ NOT NULL
+);
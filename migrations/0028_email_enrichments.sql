@@ -0,0 +1,12 @@
+-- Summaries and key points an LLM wrote for published emails; see
+-- enrich.go. requested_hash is the content last queued for enrichment,
+-- content_hash the content the stored fields were written from.
+CREATE TABLE IF NOT EXISTS email_enrichments (
+	email_id TEXT PRIMARY KEY,
+	requested_hash TEXT NOT NULL,
+	content_hash TEXT,
+	summary TEXT,
+	key_points TEXT[],
+	model TEXT,
+	enriched_at TIMESTAMPTZ
+);
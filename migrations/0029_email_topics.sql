@@ -0,0 +1,3 @@
+-- Topics the enrichment worker classified each email into, as slugs from
+-- the taxonomy in topics.go.
+ALTER TABLE email_enrichments ADD COLUMN IF NOT EXISTS topics TEXT[];
@@ -0,0 +1,27 @@
+-- Embeddings of published emails for semantic search; see embeddings.go.
+-- They need the pgvector extension, which not every metrics DB has (or
+-- lets us create), so without it this does nothing and semantic search
+-- stays off. To turn it on later, install pgvector and run both blocks
+-- by hand. requested_hash and content_hash work as in email_enrichments.
+-- There's no approximate index: the corpus is small enough to search
+-- exactly, and the dimensions are up to the model.
+DO $$
+BEGIN
+	CREATE EXTENSION IF NOT EXISTS vector;
+EXCEPTION WHEN OTHERS THEN
+	RAISE NOTICE 'pgvector unavailable, semantic search disabled: %', SQLERRM;
+END $$;
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector') THEN
+		CREATE TABLE IF NOT EXISTS email_embeddings (
+			email_id TEXT PRIMARY KEY,
+			requested_hash TEXT NOT NULL,
+			content_hash TEXT,
+			model TEXT,
+			embedding vector,
+			embedded_at TIMESTAMPTZ
+		);
+	END IF;
+END $$;
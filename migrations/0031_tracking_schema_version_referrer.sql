@@ -0,0 +1,5 @@
+-- 0003 classified events recorded before schema_version existed by their
+-- device bucket alone, so views with a referrer but no device (recorded
+-- before device buckets were) were left at v1. backfillTrackingSchemaVersion
+-- in migrations.go reclassifies them, a day of event time per statement,
+-- so it needs no SQL here.
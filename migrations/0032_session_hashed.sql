@@ -0,0 +1,16 @@
+-- Marks tracking rows whose session_id is a salted hash (see
+-- sessionhash.go). Writers set it; rows recorded before session hashing,
+-- or by an instance still running older code during a deploy, are NULL
+-- until the sessions.hash_legacy job hashes them. The partial indexes keep
+-- its search for them cheap once there are none.
+ALTER TABLE email_views ADD COLUMN IF NOT EXISTS session_hashed BOOLEAN;
+ALTER TABLE page_views ADD COLUMN IF NOT EXISTS session_hashed BOOLEAN;
+ALTER TABLE email_link_clicks ADD COLUMN IF NOT EXISTS session_hashed BOOLEAN;
+ALTER TABLE email_scroll_depth ADD COLUMN IF NOT EXISTS session_hashed BOOLEAN;
+ALTER TABLE email_read_time ADD COLUMN IF NOT EXISTS session_hashed BOOLEAN;
+
+CREATE INDEX IF NOT EXISTS idx_email_views_unhashed ON email_views(time) WHERE session_hashed IS NULL;
+CREATE INDEX IF NOT EXISTS idx_page_views_unhashed ON page_views(time) WHERE session_hashed IS NULL;
+CREATE INDEX IF NOT EXISTS idx_email_link_clicks_unhashed ON email_link_clicks(time) WHERE session_hashed IS NULL;
+CREATE INDEX IF NOT EXISTS idx_email_scroll_depth_unhashed ON email_scroll_depth(time) WHERE session_hashed IS NULL;
+CREATE INDEX IF NOT EXISTS idx_email_read_time_unhashed ON email_read_time(time) WHERE session_hashed IS NULL;
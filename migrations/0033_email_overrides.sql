@@ -0,0 +1,18 @@
+-- Review decisions and restored revisions for warehouse campaigns, which
+-- we can't write to (see overrides.go). publishable NULL defers to the
+-- campaign's ai_publishable; revision NULL means no content was restored.
+CREATE TABLE IF NOT EXISTS email_overrides (
+	email_id TEXT PRIMARY KEY,
+	publishable BOOLEAN,
+	revision INTEGER,
+	subject TEXT,
+	excerpt TEXT,
+	html TEXT,
+	markdown TEXT,
+	restored_at TIMESTAMPTZ,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Campaign writes used to be copied to the secondary warehouse by
+-- warehouse.mirror jobs, which nothing handles any more.
+DELETE FROM jobs WHERE kind = 'warehouse.mirror';
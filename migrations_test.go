@@ -0,0 +1,34 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"one without a semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"several", "SELECT 1;\n\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";;SELECT 1;;", []string{"SELECT 1"}},
+		{"quoted semicolons", `SELECT ';', "a;b" FROM t; SELECT 2`, []string{`SELECT ';', "a;b" FROM t`, "SELECT 2"}},
+		{"line comments", "-- first; not a statement\nSELECT 1; -- trailing; comment\nSELECT 2", []string{"-- first; not a statement\nSELECT 1", "-- trailing; comment\nSELECT 2"}},
+		{"comment only", "-- nothing to run;\n-- here\n", nil},
+		{"comment only statement", "SELECT 1;\n-- done\n", []string{"SELECT 1"}},
+		{
+			"dollar quoted",
+			"DO $$ BEGIN PERFORM 1; END $$;\nCREATE FUNCTION f() RETURNS int AS $fn$ SELECT 1; $fn$ LANGUAGE sql;",
+			[]string{"DO $$ BEGIN PERFORM 1; END $$", "CREATE FUNCTION f() RETURNS int AS $fn$ SELECT 1; $fn$ LANGUAGE sql"},
+		},
+		{"positional parameters", "SELECT $1; SELECT $2", []string{"SELECT $1", "SELECT $2"}},
+	}
+	for _, tt := range tests {
+		if got := splitSQL(tt.sql); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: splitSQL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ---------- Mock Data ----------
+
+// MOCK_DATA=1 serves the lists and emails in fixtures/mock.json without any
+// database, for frontend development and hermetic handler tests. Send times
+// are relative to startup so the archive always looks current. Endpoints
+// that read the warehouse directly (series, search, upcoming, subscribe)
+// answer 501, and nothing is tracked.
+
+//go:embed fixtures/mock.json
+var mockFixtures []byte
+
+// errNoWarehouse is returned by warehouse-only queries in mock data mode.
+var errNoWarehouse = errors.New("not available with MOCK_DATA")
+
+type mockFixture struct {
+	MailingLists []struct {
+		ID              string `json:"id"`
+		Name            string `json:"name"`
+		Description     string `json:"description"`
+		Color           string `json:"color"`
+		IsPublic        bool   `json:"is_public"`
+		SubscriberCount int64  `json:"subscriber_count"`
+	} `json:"mailing_lists"`
+	Emails []struct {
+		ID            string  `json:"id"`
+		MailingListID string  `json:"mailing_list_id"`
+		Subject       string  `json:"subject"`
+		Excerpt       *string `json:"excerpt"`
+		DaysAgo       int     `json:"days_ago"`
+		Draft         bool    `json:"draft"`
+		Unpublished   bool    `json:"unpublished"`       // sent, then pulled from the archive
+		ScheduledIn   int     `json:"scheduled_in_days"` // unsent, like a draft
+		Clicks        int64   `json:"clicks"`
+		Opens         int64   `json:"opens"`
+		HTML          *string `json:"html"`
+		Markdown      *string `json:"markdown"`
+	} `json:"emails"`
+}
+
+// mockSource is a ContentSource over the embedded fixtures.
+type mockSource struct {
+	lists   []MailingList
+	emails  []SourceEmail // published, newest first
+	drafts  map[string]SourceEmail
+	changes []SourceChange // in feed order
+}
+
+func newMockSource(now time.Time) (*mockSource, error) {
+	var f mockFixture
+	if err := json.Unmarshal(mockFixtures, &f); err != nil {
+		return nil, fmt.Errorf("mock fixtures: %w", err)
+	}
+	ms := &mockSource{drafts: map[string]SourceEmail{}}
+	refs := map[string]ListRef{}
+	public := map[string]bool{}
+	for _, l := range f.MailingLists {
+		refs[l.ID] = ListRef{ID: l.ID, Name: l.Name, Description: l.Description, Color: l.Color}
+		public[l.ID] = l.IsPublic
+	}
+	for _, fe := range f.Emails {
+		ref, ok := refs[fe.MailingListID]
+		if !ok {
+			return nil, fmt.Errorf("mock fixtures: email %s: unknown mailing list %q", fe.ID, fe.MailingListID)
+		}
+		e := SourceEmail{
+			ID: fe.ID, Subject: fe.Subject, Excerpt: fe.Excerpt, MailingList: ref,
+			HTML: fe.HTML, Markdown: fe.Markdown, Clicks: fe.Clicks, Opens: fe.Opens,
+		}
+		if fe.Draft || fe.ScheduledIn > 0 {
+			ms.drafts[e.ID] = e
+			continue
+		}
+		sentAt := now.Add(-time.Duration(fe.DaysAgo) * 24 * time.Hour)
+		e.SentAt, e.UpdatedAt = &sentAt, &sentAt
+		// Like Loops, emails on private lists aren't published.
+		live := !fe.Unpublished && public[ref.ID]
+		ms.changes = append(ms.changes, SourceChange{
+			Kind: changeEmail, ID: e.ID, Name: e.Subject, MailingListID: ref.ID,
+			Live: live, ChangedAt: sentAt,
+		})
+		if !live {
+			ms.drafts[e.ID] = e
+			continue
+		}
+		ms.emails = append(ms.emails, e)
+	}
+	sort.SliceStable(ms.emails, func(i, j int) bool { return ms.emails[i].SentAt.After(*ms.emails[j].SentAt) })
+
+	for _, l := range f.MailingLists {
+		ms.changes = append(ms.changes, SourceChange{
+			Kind: changeMailingList, ID: l.ID, Name: l.Name, MailingListID: l.ID,
+			Live: l.IsPublic, ChangedAt: now,
+		})
+		ml := MailingList{
+			ID: l.ID, Name: l.Name, Description: l.Description, Color: l.Color,
+			IsPublic: l.IsPublic, SubscriberCount: l.SubscriberCount, LastUpdatedAt: &now,
+		}
+		for _, e := range ms.emails {
+			if e.MailingList.ID != l.ID {
+				continue
+			}
+			if ml.LastSentAt == nil {
+				ml.LastSentAt = e.SentAt
+			}
+			ml.SentEmailCount++
+		}
+		// Like Loops, only lists with published emails are listed.
+		if ml.SentEmailCount > 0 {
+			ms.lists = append(ms.lists, ml)
+		}
+	}
+	sort.SliceStable(ms.lists, func(i, j int) bool { return ms.lists[i].LastSentAt.After(*ms.lists[j].LastSentAt) })
+	sort.Slice(ms.changes, func(i, j int) bool {
+		a := ms.changes[i]
+		return ChangeCursor{ChangedAt: a.ChangedAt, Kind: a.Kind, ID: a.ID}.Precedes(&ms.changes[j])
+	})
+	return ms, nil
+}
+
+func (ms *mockSource) Name() string { return "mock" }
+
+func (ms *mockSource) ListMailingLists(ctx context.Context, limit, offset int) ([]MailingList, *int, error) {
+	return mockPage(ms.lists, limit, offset)
+}
+
+func (ms *mockSource) ListEmails(ctx context.Context, f EmailFilter, limit, offset int) ([]SourceEmail, *int, error) {
+	emails := []SourceEmail{}
+	for _, e := range ms.emails {
+		if f.matches(&e) {
+			emails = append(emails, e)
+		}
+	}
+	return mockPage(emails, limit, offset)
+}
+
+func (ms *mockSource) EachEmail(ctx context.Context, f EmailFilter, offset int, fn func(*SourceEmail) error) error {
+	for _, e := range ms.emails {
+		if !f.matches(&e) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if err := fn(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *mockSource) GetEmail(ctx context.Context, id string, includeUnpublished bool) (*SourceEmail, error) {
+	for _, e := range ms.emails {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	if e, ok := ms.drafts[id]; ok && includeUnpublished {
+		return &e, nil
+	}
+	return nil, errNotFound
+}
+
+func (ms *mockSource) ListChanges(ctx context.Context, after ChangeCursor, limit int) ([]SourceChange, error) {
+	out := []SourceChange{}
+	for i := range ms.changes {
+		if len(out) == limit {
+			break
+		}
+		if after.Precedes(&ms.changes[i]) {
+			out = append(out, ms.changes[i])
+		}
+	}
+	return out, nil
+}
+
+func (f EmailFilter) matches(e *SourceEmail) bool {
+	if f.MailingListID != "" && e.MailingList.ID != f.MailingListID {
+		return false
+	}
+	if f.IDs != nil && !slices.Contains(f.IDs, e.ID) {
+		return false
+	}
+	return f.UpdatedSince == nil || (e.UpdatedAt != nil && e.UpdatedAt.After(*f.UpdatedSince))
+}
+
+// mockPage slices items like LIMIT/OFFSET, with the same next-offset rule as
+// the SQL sources.
+func mockPage[T any](items []T, limit, offset int) ([]T, *int, error) {
+	out := []T{}
+	if offset < len(items) {
+		out = append(out, items[offset:min(offset+limit, len(items))]...)
+	}
+	var next *int
+	if len(out) == limit {
+		n := offset + limit
+		next = &n
+	}
+	return out, next, nil
+}
+
+// newMockStore returns a Store with no database pools, backed by mockSource.
+func newMockStore() (*Store, error) {
+	source, err := newMockSource(time.Now().UTC().Truncate(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{health: NewHealthTracker(), source: source}
+	store.senders.Store(&senderNames{byScope: map[string]string{}, fallback: env("SENDER_DEFAULT_NAME", "Hack Club")})
+	return store, nil
+}
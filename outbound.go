@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ---------- Outbound Requests ----------
+
+// The link checker and image size probe fetch URLs from email content,
+// which anyone who can write a campaign controls. publicHTTPClient only
+// connects to public addresses: the check runs on the address actually
+// dialed, after DNS, so a hostname that resolves (or later re-resolves)
+// to an internal address is refused too, and every redirect is dialed,
+// and so checked, afresh. It never uses HTTP_PROXY, which would hide the
+// address from the check.
+
+// errNonPublicAddress is returned when a request would reach an internal
+// address.
+var errNonPublicAddress = errors.New("refusing to connect to a non-public address")
+
+// sharedAddressSpace is carrier-grade NAT (RFC 6598), which isn't in
+// netip's private ranges but isn't reachable from the internet either.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddress reports whether ip is routable on the public internet.
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsValid() &&
+		!ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// dialPublicOnly is a net.Dialer Control hook refusing non-public
+// addresses.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errNonPublicAddress, address)
+	}
+	if !publicAddress(ap.Addr()) {
+		return fmt.Errorf("%w: %s", errNonPublicAddress, ap.Addr())
+	}
+	return nil
+}
+
+// publicHTTPClient returns a client for fetching untrusted URLs; see
+// above.
+func publicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
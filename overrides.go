@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ---------- Email Overrides ----------
+
+// The warehouse is read-only to us, so review decisions (approvals.go) and
+// restored revisions (revisions.go) are kept in email_overrides in the
+// metrics DB and applied as content is read: decisions inside the
+// warehouse queries, which get the overrides as array arguments (see
+// join), and restored content to the rows they return. Each instance keeps
+// the table in memory, reloading it after its own writes and every minute
+// for other replicas'. A restore holds until the campaign is edited
+// upstream again; that edit is captured as a revision like any other, and
+// can be reverted the same way.
+
+var errNoOverrideStore = &statusError{status: http.StatusNotImplemented, code: codeNotImplemented, message: "review decisions and restores need METRICS_DATABASE_URL"}
+
+// emailOverride is what was decided or restored here for one campaign.
+type emailOverride struct {
+	publishable *bool          // nil defers to ai_publishable
+	restored    *EmailRevision // content restored over the campaign's, if any
+	restoredAt  time.Time
+}
+
+type emailOverrides struct {
+	byID map[string]*emailOverride
+	// The query arguments for join, one element per override.
+	ids         []string
+	publishable []*bool
+	updatedAt   []time.Time
+}
+
+var noEmailOverrides = &emailOverrides{byID: map[string]*emailOverride{}, ids: []string{}, publishable: []*bool{}, updatedAt: []time.Time{}}
+
+// emailOverrides returns the loaded overrides, or none before the first load.
+func (s *Store) emailOverrides() *emailOverrides {
+	if eo := s.overrides.Load(); eo != nil {
+		return eo
+	}
+	return noEmailOverrides
+}
+
+// join appends the overrides to a campaign query's args and returns a
+// LEFT JOIN exposing them as o(email_id, publishable, updated_at), which
+// campaignPublishable and campaignUpdatedAt read.
+func (eo *emailOverrides) join(args []any) (string, []any) {
+	n := len(args)
+	return fmt.Sprintf("LEFT JOIN unnest($%d::text[], $%d::boolean[], $%d::timestamptz[]) AS o(email_id, publishable, updated_at) ON o.email_id = c.id", n+1, n+2, n+3),
+		append(args, eo.ids, eo.publishable, eo.updatedAt)
+}
+
+// restoredRevision returns the revision restored over campaign id, unless
+// the campaign was edited upstream (at upstreamUpdatedAt, its raw
+// updated_at) after the restore.
+func (eo *emailOverrides) restoredRevision(id string, upstreamUpdatedAt *time.Time) *EmailRevision {
+	o := eo.byID[id]
+	if o == nil || o.restored == nil || (upstreamUpdatedAt != nil && upstreamUpdatedAt.After(o.restoredAt)) {
+		return nil
+	}
+	return o.restored
+}
+
+// restore replaces e's content with the revision restored over it, if any.
+// The slug is left alone so links keep working.
+func (eo *emailOverrides) restore(e *SourceEmail, upstreamUpdatedAt *time.Time) {
+	if rev := eo.restoredRevision(e.ID, upstreamUpdatedAt); rev != nil {
+		e.Subject, e.Excerpt, e.HTML, e.Markdown = rev.Subject, rev.Excerpt, rev.HTML, rev.Markdown
+	}
+}
+
+// LoadEmailOverrides replaces the in-memory overrides from the metrics DB.
+func (s *Store) LoadEmailOverrides(ctx context.Context) error {
+	eo := &emailOverrides{byID: map[string]*emailOverride{}, ids: []string{}, publishable: []*bool{}, updatedAt: []time.Time{}}
+	if s.metricsPool != nil {
+		rows, err := s.metricsPool.Query(ctx, `
+			SELECT email_id, publishable, revision, subject, excerpt, html, markdown, restored_at, updated_at
+			FROM email_overrides
+		`)
+		if err := s.observe(depMetrics, err); err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var o emailOverride
+			var revision *int
+			var subject *string
+			var restoredAt *time.Time
+			var updatedAt time.Time
+			rev := EmailRevision{}
+			if err := rows.Scan(&rev.EmailID, &o.publishable, &revision, &subject, &rev.Excerpt, &rev.HTML, &rev.Markdown,
+				&restoredAt, &updatedAt); err != nil {
+				return err
+			}
+			if revision != nil && restoredAt != nil {
+				rev.Revision, rev.Subject = *revision, deref(subject)
+				o.restored, o.restoredAt = &rev, *restoredAt
+			}
+			eo.byID[rev.EmailID] = &o
+			eo.ids = append(eo.ids, rev.EmailID)
+			eo.publishable = append(eo.publishable, o.publishable)
+			eo.updatedAt = append(eo.updatedAt, updatedAt)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.overrides.Store(eo)
+	return nil
+}
+
+// StartEmailOverrideRefresh loads the overrides now and every minute, so
+// decisions and restores made through another replica apply here too.
+func (s *Store) StartEmailOverrideRefresh(ctx context.Context) {
+	if err := s.LoadEmailOverrides(ctx); err != nil {
+		log.Printf("email overrides load error: %v", err)
+	}
+	if s.metricsPool == nil {
+		return
+	}
+	s.jobs.Every("refresh.email_overrides", time.Minute, s.LoadEmailOverrides)
+}
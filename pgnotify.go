@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Postgres Notification Bus ----------
+
+const pgNotifyChannel = "news_view_changes"
+
+// PGNotifier shares view/click change signals between replicas over
+// Postgres LISTEN/NOTIFY on the metrics DB. Changes tracked locally reach the
+// local ViewNotifier immediately and are published for other instances;
+// changes published by other instances are replayed into the local
+// ViewNotifier. Payloads are "<instance>|<id>,<id>,...".
+type PGNotifier struct {
+	store    *Store
+	local    *ViewNotifier
+	instance string
+	pending  chan string
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+const (
+	pgNotifyFlushInterval = 250 * time.Millisecond
+	pgNotifyMaxPayload    = 7000 // NOTIFY payloads must stay under 8000 bytes
+)
+
+func NewPGNotifier(store *Store, local *ViewNotifier) *PGNotifier {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	pn := &PGNotifier{
+		store:    store,
+		local:    local,
+		instance: hex.EncodeToString(b),
+		pending:  make(chan string, 1000),
+		stop:     make(chan struct{}),
+	}
+	pn.wg.Add(2)
+	go pn.publishLoop()
+	go pn.listenLoop()
+	return pn
+}
+
+func (pn *PGNotifier) Notify(emailID string) {
+	pn.local.Notify(emailID)
+	select {
+	case pn.pending <- emailID:
+	default:
+		// Remote SSE clients miss this update; the next change catches them up.
+	}
+}
+
+// Close stops publishing and listening. Pending changes are dropped.
+func (pn *PGNotifier) Close() {
+	close(pn.stop)
+	pn.wg.Wait()
+}
+
+func (pn *PGNotifier) publishLoop() {
+	defer pn.wg.Done()
+	ticker := time.NewTicker(pgNotifyFlushInterval)
+	defer ticker.Stop()
+
+	batch := make(map[string]bool)
+	for {
+		select {
+		case id := <-pn.pending:
+			batch[id] = true
+		case <-ticker.C:
+			if len(batch) > 0 {
+				pn.publish(batch)
+				clear(batch)
+			}
+		case <-pn.stop:
+			return
+		}
+	}
+}
+
+func (pn *PGNotifier) publish(ids map[string]bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	prefix := pn.instance + "|"
+	var b strings.Builder
+	send := func() {
+		if b.Len() == 0 {
+			return
+		}
+		_, err := pn.store.metricsPool.Exec(ctx, `SELECT pg_notify($1, $2)`, pgNotifyChannel, prefix+b.String())
+		if err := pn.store.observe(depMetrics, err); err != nil {
+			log.Printf("pg notify publish error: %v", err)
+		}
+		b.Reset()
+	}
+	for id := range ids {
+		if b.Len()+len(id)+1 > pgNotifyMaxPayload-len(prefix) {
+			send()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(id)
+	}
+	send()
+}
+
+// listenLoop holds a dedicated connection in LISTEN mode, reconnecting with
+// backoff when it drops.
+func (pn *PGNotifier) listenLoop() {
+	defer pn.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-pn.stop
+		cancel()
+	}()
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := pn.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("pg notify listener: %v (reconnecting in %s)", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func (pn *PGNotifier) listen(ctx context.Context) error {
+	conn, err := pgx.ConnectConfig(ctx, pn.store.metricsPool.Config().ConnConfig.Copy())
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgNotifyChannel); err != nil {
+		return err
+	}
+	log.Printf("pg notify listener: listening on %s", pgNotifyChannel)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		from, ids, ok := strings.Cut(n.Payload, "|")
+		if !ok || from == pn.instance {
+			continue
+		}
+		for _, id := range strings.Split(ids, ",") {
+			if id != "" {
+				pn.local.Notify(id)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Print Variant ----------
+
+// /emails/{id}/html?format=print is the email laid out for paper or PDF.
+// Campaign HTML is built for inboxes: colored backgrounds that waste ink, and
+// tables fixed at 600px that print cramped or cut off. The print variant
+// drops background colors and images and fixed widths, links straight to
+// destinations instead of through the click tracker (no one clicks paper,
+// and a printed URL should be the real one), and adds a print stylesheet
+// that prints each link's URL after it.
+
+// printStylesheet overrides whatever the campaign's own styles set.
+const printStylesheet = `<style>
+@page { margin: 2cm; }
+*, *::before, *::after { background: transparent !important; box-shadow: none !important; text-shadow: none !important; }
+html, body { color: #000 !important; margin: 0; }
+body { font: 11pt/1.5 Georgia, "Times New Roman", serif; }
+table, td, th, div, center { width: auto !important; max-width: 100% !important; }
+img { max-width: 100% !important; height: auto !important; page-break-inside: avoid; break-inside: avoid; }
+h1, h2, h3, h4, h5, h6 { page-break-after: avoid; break-after: avoid; }
+a { color: #000 !important; text-decoration: underline; }
+a[href^="http"]:not(:has(img))::after { content: " (" attr(href) ")"; font-size: 9pt; word-break: break-all; }
+</style>`
+
+// printDropsProp is whether a CSS property is one the print variant drops.
+func printDropsProp(prop string) bool {
+	switch prop {
+	case "width", "min-width", "background", "background-color", "background-image":
+		return true
+	}
+	return false
+}
+
+// stripStyleProps removes the declarations of an inline style for which drop
+// is true. Fragments without a property (from a ';' inside a value, such as
+// a data: URL) go with the declaration before them.
+func stripStyleProps(style string, drop func(prop string) bool) string {
+	var kept []string
+	dropping := false
+	for _, decl := range strings.Split(style, ";") {
+		prop, _, ok := strings.Cut(decl, ":")
+		if !ok || strings.ContainsAny(strings.TrimSpace(prop), " (,") {
+			if !dropping && strings.TrimSpace(decl) != "" {
+				kept = append(kept, decl)
+			}
+			continue
+		}
+		dropping = drop(strings.ToLower(strings.TrimSpace(prop)))
+		if !dropping {
+			kept = append(kept, decl)
+		}
+	}
+	return strings.TrimSpace(strings.Join(kept, ";"))
+}
+
+// printEmailDoc strips backgrounds and fixed widths from doc, unwraps
+// tracking redirects in its links, and adds the print stylesheet.
+func printEmailDoc(doc *goquery.Document) {
+	doc.Find("[bgcolor], [background]").RemoveAttr("bgcolor").RemoveAttr("background")
+	doc.Find("table, td, th, div, center").RemoveAttr("width")
+	doc.Find("[style]").Each(func(_ int, sel *goquery.Selection) {
+		if style := stripStyleProps(sel.AttrOr("style", ""), printDropsProp); style != "" {
+			sel.SetAttr("style", style)
+		} else {
+			sel.RemoveAttr("style")
+		}
+	})
+	doc.Find("a[href]").Each(func(_ int, sel *goquery.Selection) {
+		sel.SetAttr("href", unwrapTrackingURL(sel.AttrOr("href", "")))
+	})
+	doc.Find("head").AppendHtml(printStylesheet)
+}
+
+// GetPrintEmail is GetEmail for the print variant: published, with links
+// left pointing at their destinations.
+func (s *Store) GetPrintEmail(ctx context.Context, r *http.Request, id string) (*Email, error) {
+	src, err := s.source.GetEmail(ctx, id, false)
+	if err != nil {
+		return nil, err
+	}
+	e := s.buildEmail(ctx, r, src, false, nil)
+	return &e, nil
+}
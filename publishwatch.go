@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// ---------- Unpublish Propagation ----------
+
+// Content pulled upstream would otherwise keep being served until cache
+// entries expire, and by CDNs for longer. The publish watcher snapshots the
+// IDs of live emails and lists every PUBLISH_WATCH_INTERVAL (default 1m,
+// "0" disables it) and, for anything that dropped out since the last
+// snapshot, purges affected cache entries and sends a webhook, which is
+// also the hook for purging a CDN. Each instance watches for its own cache;
+// with several replicas, the webhook for a change is queued in the shared
+// outbox by whichever notices it first (see webhooks.go). Emails in a list
+// made private drop out of the feed with it (see content.go). Emails that
+// newly appear are announced on Slack (see slack.go).
+
+const (
+	defaultPublishWatchInterval = time.Minute
+	publishWatchPageSize        = 1000
+)
+
+// liveSnapshot walks the change feed from the start, keeping live entries
+// by kind and ID.
+func (s *Store) liveSnapshot(ctx context.Context) (map[string]Change, error) {
+	live := map[string]Change{}
+	var cursor ChangeCursor
+	for {
+		page, err := s.ListChanges(ctx, cursor, publishWatchPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			if c.Action == "upsert" {
+				live[c.Kind+" "+c.ID] = c
+			} else {
+				delete(live, c.Kind+" "+c.ID)
+			}
+		}
+		if len(page) < publishWatchPageSize {
+			return live, nil
+		}
+		last := page[len(page)-1]
+		cursor = ChangeCursor{ChangedAt: last.ChangedAt, Kind: last.Kind, ID: last.ID}
+	}
+}
+
+// StartPublishWatcher schedules the watcher. The first snapshot is only a
+// baseline.
+func (s *Server) StartPublishWatcher() {
+	interval := envDuration("PUBLISH_WATCH_INTERVAL", defaultPublishWatchInterval)
+	if interval <= 0 {
+		return
+	}
+	var prev map[string]Change
+	s.store.jobs.Every("watch.unpublished", interval, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		live, err := s.store.liveSnapshot(ctx)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			s.propagateUnpublished(prev, live)
+			s.slack.Announce(newlyPublished(prev, live))
+		}
+		prev = live
+		return nil
+	})
+}
+
+// newlyPublished returns the emails in live but not prev.
+func newlyPublished(prev, live map[string]Change) []Change {
+	var out []Change
+	for key, c := range live {
+		if _, ok := prev[key]; !ok && c.Kind == changeEmail {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// propagateUnpublished purges and announces what's in prev but not live.
+// Emails in a list that stopped being public go with it.
+func (s *Server) propagateUnpublished(prev, live map[string]Change) {
+	var removed []Change
+	lists := map[string]bool{}
+	for key, c := range prev {
+		if _, ok := live[key]; !ok {
+			removed = append(removed, c)
+			if c.Kind == changeMailingList {
+				lists[c.ID] = true
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	// Per-email paths (/emails/{id or slug}/...) of removed emails go, and so
+	// does everything else, since any collection may have listed them.
+	gone := map[string]bool{}
+	for key, c := range prev {
+		if c.Kind != changeEmail {
+			continue
+		}
+		if _, ok := live[key]; !ok || lists[c.MailingListID] {
+			gone[c.ID], gone[c.Slug] = true, true
+		}
+	}
+	n := s.cache.Purge(func(key string) bool {
+		rest, ok := strings.CutPrefix(cacheKeyPath(key), "/emails/")
+		if !ok {
+			return true
+		}
+		ref, _, _ := strings.Cut(rest, "/")
+		return gone[ref]
+	})
+	log.Printf("publish watcher: %d unpublished, purged %d cache entries", len(removed), n)
+
+	for _, c := range removed {
+		s.webhooks.Send(c.Kind+".unpublished", c.ID+" "+c.ChangedAt.Format(time.RFC3339Nano), c)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode/qr"
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- QR Codes ----------
+
+// /emails/{id}/qr.png is a QR code for the email's archive page, for
+// posters, printouts and event slides. boombuler/barcode encodes it, in
+// byte mode at the smallest version that fits; we draw it with the
+// standard quiet zone at a whole number of pixels per module, so it stays
+// sharp at any size.
+
+var qrLevels = map[string]qr.ErrorCorrectionLevel{"L": qr.L, "M": qr.M, "Q": qr.Q, "H": qr.H}
+
+const (
+	qrQuietZone    = 4 // modules of margin the standard asks for
+	qrDefaultScale = 8
+	qrMaxScale     = 40
+)
+
+// qrPNG encodes content at level and renders it with scale pixels per
+// module.
+func qrPNG(content string, level qr.ErrorCorrectionLevel, scale int) ([]byte, error) {
+	code, err := qr.Encode(content, level, qr.Unicode)
+	if err != nil {
+		return nil, err
+	}
+	size := code.Bounds().Dx()
+	side := (size + 2*qrQuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := range size {
+		for x := range size {
+			if r, _, _, _ := code.At(x, y).RGBA(); r != 0 {
+				continue
+			}
+			x0, y0 := (x+qrQuietZone)*scale, (y+qrQuietZone)*scale
+			for py := y0; py < y0+scale; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := x0; px < x0+scale; px++ {
+					row[px] = 1
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// handleEmailQR serves a QR code linking to the email's archive page.
+// ?size= is pixels per module (default 8), ?ecc= the error correction
+// level, L, M (default), Q or H.
+func (s *Server) handleEmailQR(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	scale := qrDefaultScale
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > qrMaxScale {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "size must be 1-"+strconv.Itoa(qrMaxScale))
+			return
+		}
+		scale = n
+	}
+	level := qr.M
+	if v := r.URL.Query().Get("ecc"); v != "" {
+		l, ok := qrLevels[strings.ToUpper(v)]
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "ecc must be L, M, Q or H")
+			return
+		}
+		level = l
+	}
+
+	s.cached(w, r, "image/png", func() ([]byte, error) {
+		e, err := s.store.GetEmail(r.Context(), r, emailID, false)
+		if err != nil {
+			return nil, err
+		}
+		return qrPNG(s.archiveURL(e), level, scale)
+	})
+}
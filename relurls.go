@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Relative URL Resolution ----------
+
+// Campaign HTML is written for the sending domain, where a link to "/join"
+// or an image at "/img/logo.png" makes sense. Served from the archive, they
+// resolved against the API's host instead: links were tracked and then
+// redirected to paths that don't exist there, and images broke. Relative
+// URLs are resolved against LINK_RELATIVE_BASE (or a <base href> in the
+// HTML, itself resolved against it) before links are rewritten, so click
+// tracking, link maps and link checks see the real destinations.
+
+// relativeURLAttrs are the attributes holding a single URL.
+var relativeURLAttrs = []string{"href", "src", "background", "poster", "action"}
+
+// resolveURL resolves a relative ref against base. Absolute URLs (with any
+// scheme, including mailto:, tel: and data:), fragments and empty or
+// unparseable values are returned as they are.
+func resolveURL(base *url.URL, ref string) string {
+	trimmed := strings.TrimSpace(ref)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return ref
+	}
+	u, err := url.Parse(trimmed)
+	if err != nil || u.Scheme != "" {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// resolveSrcset resolves each candidate URL in a srcset.
+func resolveSrcset(base *url.URL, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = resolveURL(base, fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// resolveDocURLs resolves doc's relative URLs against base, or its <base
+// href>, which is removed: its URLs no longer need it, and left in HTML
+// that's embedded in another page it would apply to that page too.
+func resolveDocURLs(doc *goquery.Document, base *url.URL) {
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := url.Parse(resolveURL(base, href)); err == nil && u.Scheme != "" {
+			base = u
+		}
+	}
+	doc.Find("base").Remove()
+	for _, attr := range relativeURLAttrs {
+		doc.Find("[" + attr + "]").Each(func(_ int, sel *goquery.Selection) {
+			sel.SetAttr(attr, resolveURL(base, sel.AttrOr(attr, "")))
+		})
+	}
+	doc.Find("[srcset]").Each(func(_ int, sel *goquery.Selection) {
+		sel.SetAttr("srcset", resolveSrcset(base, sel.AttrOr("srcset", "")))
+	})
+}
+
+// linkBaseURL parses LINK_RELATIVE_BASE, or returns nil when it's unset or
+// not an absolute URL.
+func (s *Store) linkBaseURL() *url.URL {
+	if s.linkBase == "" {
+		return nil
+	}
+	u, err := url.Parse(s.linkBase)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil
+	}
+	return u
+}
+
+// resolveRelativeURLs is resolveDocURLs for an HTML document, against
+// LINK_RELATIVE_BASE.
+func (s *Store) resolveRelativeURLs(html string) (string, error) {
+	base := s.linkBaseURL()
+	if base == nil {
+		return html, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return html, err
+	}
+	resolveDocURLs(doc, base)
+	return doc.Html()
+}
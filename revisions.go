@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Email Revisions ----------
+
+// Published posts can still be edited upstream, sometimes by accident. Each
+// time an email is served with content that differs from its last snapshot,
+// the publishable fields (subject, slug, excerpt, HTML, markdown) are saved
+// as a new revision in email_revisions in the metrics DB. Operators can
+// list and diff revisions and restore one, which serves its content in
+// place of the warehouse's (see overrides.go).
+
+// EmailRevision is a snapshot of an email's publishable content.
+type EmailRevision struct {
+	EmailID         string     `json:"email_id"`
+	Revision        int        `json:"revision"`
+	ContentHash     string     `json:"content_hash"`
+	Subject         string     `json:"subject"`
+	Slug            string     `json:"slug"`
+	Excerpt         *string    `json:"excerpt,omitempty"`
+	HTML            *string    `json:"html,omitempty"`
+	Markdown        *string    `json:"markdown,omitempty"`
+	SourceUpdatedAt *time.Time `json:"source_updated_at,omitempty"` // the provider's updated_at when captured
+	CapturedAt      time.Time  `json:"captured_at"`
+}
+
+// revisionHash identifies an email's publishable content.
+func revisionHash(src *SourceEmail) string {
+	h := sha1.New()
+	for _, v := range []*string{&src.Subject, &src.Slug, src.Excerpt, src.HTML, src.Markdown} {
+		if v == nil {
+			fmt.Fprint(h, "-\n")
+		} else {
+			fmt.Fprintf(h, "%d:%s\n", len(*v), *v)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SaveRevision snapshots src in the background if its content differs from
+// the last snapshot this instance saw; the insert itself also skips content
+// matching the latest stored revision, so replicas don't duplicate it.
+func (s *Store) SaveRevision(src *SourceEmail) {
+	if s.metricsPool == nil {
+		return
+	}
+	sum := revisionHash(src)
+	if prev, ok := s.revisions.Swap(src.ID, sum); ok && prev == sum {
+		return
+	}
+	snap := *src
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := s.saveRevision(ctx, &snap, sum); err != nil {
+			s.revisions.Delete(snap.ID)
+			log.Printf("revision %s: %v", snap.ID, err)
+		}
+	}()
+}
+
+func (s *Store) saveRevision(ctx context.Context, src *SourceEmail, sum string) error {
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO email_revisions (email_id, revision, content_hash, subject, slug, excerpt, html, markdown, source_updated_at)
+		SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, $3, $4, $5, $6, $7, $8
+		FROM email_revisions
+		WHERE email_id = $1
+		HAVING COALESCE((
+			SELECT content_hash FROM email_revisions WHERE email_id = $1 ORDER BY revision DESC LIMIT 1
+		), '') <> $2
+		ON CONFLICT (email_id, revision) DO NOTHING
+	`, src.ID, sum, src.Subject, src.Slug, src.Excerpt, src.HTML, src.Markdown, src.UpdatedAt)
+	return err
+}
+
+// ListRevisions returns an email's revisions newest first, without content.
+func (s *Store) ListRevisions(ctx context.Context, emailID string) ([]EmailRevision, error) {
+	out := []EmailRevision{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT revision, content_hash, subject, slug, excerpt, source_updated_at, captured_at
+		FROM email_revisions
+		WHERE email_id = $1
+		ORDER BY revision DESC
+	`, emailID)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rev := EmailRevision{EmailID: emailID}
+		if err := rows.Scan(&rev.Revision, &rev.ContentHash, &rev.Subject, &rev.Slug, &rev.Excerpt,
+			&rev.SourceUpdatedAt, &rev.CapturedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, rev)
+	}
+	return out, rows.Err()
+}
+
+// GetRevision returns one revision with content; revision 0 is the latest.
+func (s *Store) GetRevision(ctx context.Context, emailID string, revision int) (*EmailRevision, error) {
+	if s.metricsPool == nil {
+		return nil, errNotFound
+	}
+	rev := EmailRevision{EmailID: emailID}
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT revision, content_hash, subject, slug, excerpt, html, markdown, source_updated_at, captured_at
+		FROM email_revisions
+		WHERE email_id = $1 AND ($2 = 0 OR revision = $2)
+		ORDER BY revision DESC
+		LIMIT 1
+	`, emailID, revision).Scan(&rev.Revision, &rev.ContentHash, &rev.Subject, &rev.Slug, &rev.Excerpt,
+		&rev.HTML, &rev.Markdown, &rev.SourceUpdatedAt, &rev.CapturedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// RestoreRevision records a revision's HTML, markdown, title, and excerpt
+// to be served over the campaign's, and reloads the overrides so it
+// applies here at once. The slug is left alone so links keep working.
+func (s *Store) RestoreRevision(ctx context.Context, rev *EmailRevision) error {
+	if s.pool == nil {
+		return errNoWarehouse
+	}
+	if s.metricsPool == nil {
+		return errNoOverrideStore
+	}
+	if _, err := s.source.GetEmail(ctx, rev.EmailID, true); err != nil {
+		return err
+	}
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO email_overrides (email_id, revision, subject, excerpt, html, markdown, restored_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (email_id) DO UPDATE
+		SET revision = EXCLUDED.revision, subject = EXCLUDED.subject, excerpt = EXCLUDED.excerpt,
+		    html = EXCLUDED.html, markdown = EXCLUDED.markdown, restored_at = NOW(), updated_at = NOW()
+	`, rev.EmailID, rev.Revision, rev.Subject, rev.Excerpt, rev.HTML, rev.Markdown)
+	if err := s.observe(depMetrics, err); err != nil {
+		return err
+	}
+	return s.LoadEmailOverrides(ctx)
+}
+
+// RevisionDiff compares two revisions of an email.
+type RevisionDiff struct {
+	EmailID        string `json:"email_id"`
+	From           int    `json:"from"`
+	To             int    `json:"to"`
+	SubjectChanged bool   `json:"subject_changed"`
+	ExcerptChanged bool   `json:"excerpt_changed"`
+	HTMLChanged    bool   `json:"html_changed"`
+	Field          string `json:"field"` // markdown, or html when either side has no markdown
+	Diff           string `json:"diff"`  // unified diff of field
+}
+
+const revisionDiffContext = 3
+
+func diffRevisions(a, b *EmailRevision) RevisionDiff {
+	d := RevisionDiff{
+		EmailID:        a.EmailID,
+		From:           a.Revision,
+		To:             b.Revision,
+		SubjectChanged: a.Subject != b.Subject,
+		ExcerptChanged: deref(a.Excerpt) != deref(b.Excerpt),
+		HTMLChanged:    deref(a.HTML) != deref(b.HTML),
+		Field:          "markdown",
+	}
+	from, to := deref(a.Markdown), deref(b.Markdown)
+	if from == "" || to == "" {
+		d.Field, from, to = "html", deref(a.HTML), deref(b.HTML)
+	}
+	d.Diff = unifiedDiff(fmt.Sprintf("revision %d", a.Revision), fmt.Sprintf("revision %d", b.Revision),
+		splitLines(from), splitLines(to), revisionDiffContext)
+	return d
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// maxDiffCells bounds the LCS table. Edits past it (after trimming the
+// common prefix and suffix) are shown as one replacement.
+const maxDiffCells = 4 << 20
+
+// unifiedDiff renders a line diff of a and b with ctx lines of context, or
+// "" if they're equal.
+func unifiedDiff(nameA, nameB string, a, b []string, ctx int) string {
+	// Ops: ' ' keep, '-' delete from a, '+' insert from b.
+	type op struct {
+		kind byte
+		line string
+	}
+	var ops []op
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	if pre == len(a) && pre == len(b) {
+		return ""
+	}
+	for _, l := range a[:pre] {
+		ops = append(ops, op{' ', l})
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for _, l := range ma {
+			ops = append(ops, op{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, op{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of ma[i:] and mb[j:].
+		w := len(mb) + 1
+		lcs := make([]int32, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else {
+					lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, op{' ', ma[i]})
+				i, j = i+1, j+1
+			case i < len(ma) && (j == len(mb) || lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+				ops = append(ops, op{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, op{'+', mb[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, op{' ', l})
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+	// Walk hunks: runs of changes with up to ctx context lines around them,
+	// merged when their context would overlap.
+	for start := 0; start < len(ops); {
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		end := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				end = k + 1
+			} else if k-end >= 2*ctx {
+				break
+			}
+		}
+		lo, hi := max(first-ctx, 0), min(end+ctx, len(ops))
+
+		// Line numbers (1-based) of the hunk's start in a and b.
+		la, lb := 1, 1
+		for _, o := range ops[:lo] {
+			if o.kind != '+' {
+				la++
+			}
+			if o.kind != '-' {
+				lb++
+			}
+		}
+		na, nb := 0, 0
+		for _, o := range ops[lo:hi] {
+			if o.kind != '+' {
+				na++
+			}
+			if o.kind != '-' {
+				nb++
+			}
+		}
+		// An empty range is numbered by the line before it.
+		if na == 0 {
+			la--
+		}
+		if nb == 0 {
+			lb--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", la, na, lb, nb)
+		for _, o := range ops[lo:hi] {
+			sb.WriteByte(o.kind)
+			sb.WriteString(o.line)
+			sb.WriteByte('\n')
+		}
+		start = hi
+	}
+	return sb.String()
+}
+
+// Revisions keep whatever later edits removed, which may be why it was
+// removed, so the revision endpoints are admin-only.
+
+func (s *Server) handleAdminEmailRevisions(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	items, err := s.store.ListRevisions(r.Context(), emailID)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]any{"email_id": emailID, "items": items})
+}
+
+// parseRevision reads a revision number from the URL, or 0 (latest) when
+// missing.
+func parseRevision(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, badRequest("revision must be a positive integer")
+	}
+	return n, nil
+}
+
+func (s *Server) handleAdminEmailRevision(w http.ResponseWriter, r *http.Request) {
+	n, err := parseRevision(chi.URLParam(r, "revision"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	rev, err := s.store.GetRevision(r.Context(), chi.URLParam(r, "id"), n)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, rev)
+}
+
+// handleAdminEmailRevisionDiff diffs ?from= against ?to= (default: the
+// latest revision against the one before it).
+func (s *Server) handleAdminEmailRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	from, err := parseRevision(r.URL.Query().Get("from"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	to, err := parseRevision(r.URL.Query().Get("to"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	d, err := s.store.DiffRevisions(r.Context(), chi.URLParam(r, "id"), from, to)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, d)
+}
+
+// DiffRevisions diffs revision from of an email against to. to 0 is the
+// latest revision, and from 0 the one before to.
+func (s *Store) DiffRevisions(ctx context.Context, emailID string, from, to int) (*RevisionDiff, error) {
+	b, err := s.GetRevision(ctx, emailID, to)
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		from = b.Revision - 1
+		if from == 0 {
+			return nil, badRequest("the email has only one revision")
+		}
+	}
+	a, err := s.GetRevision(ctx, emailID, from)
+	if err != nil {
+		return nil, err
+	}
+	d := diffRevisions(a, b)
+	return &d, nil
+}
+
+// handleAdminRestoreRevision serves an earlier revision in place of the
+// upstream content. The restored content is captured as a new revision the
+// next time it's served.
+func (s *Server) handleAdminRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	n, err := parseRevision(chi.URLParam(r, "revision"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	rev, err := s.store.GetRevision(r.Context(), emailID, n)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if err := s.store.RestoreRevision(r.Context(), rev); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	purged := s.cache.Purge(func(string) bool { return true })
+	log.Printf("admin: %s restored %s to revision %d, purged %d cache entries", adminKeyID(r), emailID, rev.Revision, purged)
+	writeJSON(w, http.StatusOK, map[string]any{"email_id": emailID, "restored": rev.Revision})
+}
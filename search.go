@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Search ----------
+
+// Search runs Postgres full-text search over published emails. The warehouse
+// is read-only to us (no pg_trgm, no indexes), so when full text finds
+// nothing, a typo-tolerant trigram match over subjects and list names runs
+// in-process instead: "hackclb arcade" still finds "Hack Club Arcade".
+
+const fuzzyMinScore = 0.6
+
+type SearchResult struct {
+	ID             string     `json:"id"`
+	Slug           string     `json:"slug"`
+	Subject        string     `json:"subject"`
+	Excerpt        *string    `json:"excerpt,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	MailingListRef ListRef    `json:"mailing_list"`
+	Score          float64    `json:"score"`
+	Match          string     `json:"match"` // fulltext, fuzzy or semantic
+}
+
+const searchSelect = `
+SELECT c.id, COALESCE(c.ai_publishable_slug, ''), COALESCE(c.ai_publishable_response_json->>'title', ''),
+       c.ai_publishable_response_json->>'excerpt', c.sent_at,
+       c.mailing_list_id, COALESCE(ml.friendly_name, ''), COALESCE(ml.description, ''), COALESCE(ml.color_scheme, '#000000'),
+       c.updated_at`
+
+const searchDocument = `to_tsvector('english',
+	COALESCE(c.ai_publishable_response_json->>'title', '') || ' ' ||
+	COALESCE(ml.friendly_name, '') || ' ' ||
+	COALESCE(c.ai_publishable_content_markdown, ''))`
+
+// SearchEmails returns up to limit published emails matching q, best first.
+func (s *Store) SearchEmails(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	if s.pool == nil {
+		return nil, errNoWarehouse
+	}
+	eo := s.emailOverrides()
+	overrides, args := eo.join([]any{q, limit})
+	rows, err := s.content().Query(ctx, searchSelect+`,
+		       ts_rank(`+searchDocument+`, websearch_to_tsquery('english', $1))::float8 AS score
+		FROM loops.campaigns c
+		JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		`+overrides+`
+		`+publishedEmailsWhere+` AND `+searchDocument+` @@ websearch_to_tsquery('english', $1)
+		ORDER BY score DESC, c.sent_at DESC NULLS LAST
+		LIMIT $2
+	`, args...)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return nil, err
+	}
+	out, err := s.scanSearchResults(rows, eo, "fulltext")
+	if err != nil || len(out) > 0 {
+		return out, err
+	}
+	return s.fuzzySearchEmails(ctx, q, limit)
+}
+
+func (s *Store) fuzzySearchEmails(ctx context.Context, q string, limit int) ([]SearchResult, error) {
+	eo := s.emailOverrides()
+	overrides, args := eo.join(nil)
+	rows, err := s.content().Query(ctx, searchSelect+`, 0::float8
+		FROM loops.campaigns c
+		JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		`+overrides+`
+		`+publishedEmailsWhere, args...)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return nil, err
+	}
+	candidates, err := s.scanSearchResults(rows, eo, "fuzzy")
+	if err != nil {
+		return nil, err
+	}
+
+	query := trigrams(q)
+	out := []SearchResult{}
+	for _, c := range candidates {
+		c.Score = trigramContainment(query, trigrams(c.Subject+" "+c.MailingListRef.Name))
+		if c.Score >= fuzzyMinScore {
+			out = append(out, c)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		a, b := out[i].SentAt, out[j].SentAt
+		return a != nil && (b == nil || a.After(*b))
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// scanSearchResults reads searchSelect rows, showing restored revisions'
+// subjects and excerpts (eo).
+func (s *Store) scanSearchResults(rows pgx.Rows, eo *emailOverrides, match string) ([]SearchResult, error) {
+	defer rows.Close()
+	out := []SearchResult{}
+	for rows.Next() {
+		var sr SearchResult
+		var upstreamUpdatedAt *time.Time
+		if err := rows.Scan(&sr.ID, &sr.Slug, &sr.Subject, &sr.Excerpt, &sr.SentAt,
+			&sr.MailingListRef.ID, &sr.MailingListRef.Name, &sr.MailingListRef.Description, &sr.MailingListRef.Color,
+			&upstreamUpdatedAt, &sr.Score); err != nil {
+			return nil, err
+		}
+		if rev := eo.restoredRevision(sr.ID, upstreamUpdatedAt); rev != nil {
+			sr.Subject, sr.Excerpt = rev.Subject, rev.Excerpt
+		}
+		if sr.Slug == "" {
+			sr.Slug = slugify(sr.Subject)
+		}
+		sr.MailingListRef.Slug = slugify(sr.MailingListRef.Name)
+		sr.MailingListRef.LogoURL = s.ListLogoURL(sr.MailingListRef.ID)
+		sr.Match = match
+		out = append(out, sr)
+	}
+	return out, rows.Err()
+}
+
+// trigrams returns the trigram set of s with whitespace and punctuation
+// removed, so split or merged words ("hack club" / "hackclub") still share
+// most trigrams. Unlike pg_trgm there's no padding: a match anywhere in the
+// subject counts the same.
+func trigrams(s string) map[string]bool {
+	var runes []rune
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			runes = append(runes, r)
+		}
+	}
+	set := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
+
+// trigramContainment is the fraction of query trigrams found in target, so a
+// short query isn't penalized for matching a long subject.
+func trigramContainment(query, target map[string]bool) float64 {
+	if len(query) == 0 {
+		return 0
+	}
+	n := 0
+	for t := range query {
+		if target[t] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(query))
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(q)) < 3 || len(q) > 200 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "q must be 3-200 characters")
+		return
+	}
+	search := s.store.SearchEmails
+	switch r.URL.Query().Get("mode") {
+	case "", "keyword":
+	case "semantic":
+		search = s.store.SemanticSearchEmails
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "mode must be keyword or semantic")
+		return
+	}
+	limit, _ := parseLimitOffset(r, 20)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := search(r.Context(), q, limit)
+		if err != nil {
+			return nil, err
+		}
+		return Paginated[SearchResult]{Items: items, Meta: s.store.responseMeta()}, nil
+	})
+}
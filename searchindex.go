@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Search Index Sync ----------
+
+// /search ranks with Postgres full text, which is fine for the API but not
+// for search-as-you-type. With SEARCH_INDEX_PROVIDER set to "meilisearch"
+// or "algolia", a worker follows the change feed every
+// SEARCH_INDEX_INTERVAL (default 1m) and pushes published emails into that
+// engine's SEARCH_INDEX_NAME index (default "emails"), so frontends query
+// it directly and get its ranking, typo tolerance and highlighting.
+//
+// Each document carries the email's title, excerpt, plaintext body, tags
+// (its list's slug, plus its series' slug when it's part of one), slug,
+// list and archive URL; mailing_list.id and tags are filterable. Emails
+// pulled from the archive, or in a list that stopped being public, are
+// deleted. The feed position is kept in search_index_cursors in the
+// metrics DB, so a restart resumes where it left off; without one, each
+// start reindexes everything. Pushes are idempotent, so replicas racing
+// over the same changes is harmless.
+
+const (
+	defaultSearchIndexInterval = time.Minute
+	searchIndexPageSize        = 100
+	searchIndexMaxPlaintext    = 8000 // bytes, within Algolia's 10KB record limit
+)
+
+// SearchDocument is an email as pushed to the search index.
+type SearchDocument struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Excerpt     string        `json:"excerpt,omitempty"`
+	Plaintext   string        `json:"plaintext"`
+	Tags        []string      `json:"tags"`
+	Slug        string        `json:"slug"`
+	MailingList SearchListRef `json:"mailing_list"`
+	URL         string        `json:"url"`
+	SentAt      int64         `json:"sent_at,omitempty"` // Unix seconds, for sorting
+}
+
+type SearchListRef struct {
+	ID   string `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// searchIndex is a hosted search engine's index.
+type searchIndex interface {
+	Name() string
+	// Configure sets which attributes are searchable and filterable.
+	Configure(ctx context.Context) error
+	Upsert(ctx context.Context, docs []SearchDocument) error
+	Delete(ctx context.Context, ids []string) error
+	// DeleteList deletes every document in a mailing list.
+	DeleteList(ctx context.Context, listID string) error
+}
+
+// newSearchIndexFromEnv returns the SEARCH_INDEX_PROVIDER engine's index,
+// or nil when unset.
+func newSearchIndexFromEnv() (searchIndex, error) {
+	name := env("SEARCH_INDEX_NAME", "emails")
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch provider := os.Getenv("SEARCH_INDEX_PROVIDER"); provider {
+	case "":
+		return nil, nil
+	case "meilisearch":
+		base := strings.TrimRight(os.Getenv("MEILISEARCH_URL"), "/")
+		if base == "" {
+			return nil, errors.New("SEARCH_INDEX_PROVIDER=meilisearch needs MEILISEARCH_URL")
+		}
+		return &meilisearchIndex{base: base, key: os.Getenv("MEILISEARCH_API_KEY"), index: name, client: client}, nil
+	case "algolia":
+		app, key := os.Getenv("ALGOLIA_APP_ID"), os.Getenv("ALGOLIA_API_KEY")
+		if app == "" || key == "" {
+			return nil, errors.New("SEARCH_INDEX_PROVIDER=algolia needs ALGOLIA_APP_ID and ALGOLIA_API_KEY")
+		}
+		return &algoliaIndex{app: app, key: key, index: name, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown SEARCH_INDEX_PROVIDER %q (want meilisearch or algolia)", provider)
+	}
+}
+
+// StartSearchIndexSync schedules the sync.
+func (s *Server) StartSearchIndexSync() {
+	interval := envDuration("SEARCH_INDEX_INTERVAL", defaultSearchIndexInterval)
+	if s.searchIndex == nil || interval <= 0 {
+		return
+	}
+	var cursor *ChangeCursor
+	s.store.jobs.Every("sync.search_index", interval, func(ctx context.Context) error {
+		if cursor == nil {
+			if err := s.searchIndex.Configure(ctx); err != nil {
+				return fmt.Errorf("configure: %w", err)
+			}
+			saved, err := s.store.SearchIndexCursor(ctx, s.searchIndex.Name())
+			if err != nil {
+				return err
+			}
+			cursor = &saved
+		}
+		n, err := s.syncSearchIndex(ctx, cursor)
+		if n > 0 {
+			log.Printf("search index: synced %d changes to %s", n, s.searchIndex.Name())
+		}
+		return err
+	})
+}
+
+// syncSearchIndex pushes everything after cursor, a page at a time,
+// advancing and saving it after each, and returns how many changes it
+// applied.
+func (s *Server) syncSearchIndex(ctx context.Context, cursor *ChangeCursor) (int, error) {
+	total := 0
+	for {
+		page, err := s.store.ListChanges(ctx, *cursor, searchIndexPageSize)
+		if err != nil {
+			return total, err
+		}
+		if len(page) == 0 {
+			return total, nil
+		}
+		if err := s.applySearchChanges(ctx, page); err != nil {
+			return total, err
+		}
+		total += len(page)
+		last := page[len(page)-1]
+		*cursor = ChangeCursor{ChangedAt: last.ChangedAt, Kind: last.Kind, ID: last.ID}
+		if err := s.store.SaveSearchIndexCursor(ctx, s.searchIndex.Name(), *cursor); err != nil {
+			return total, err
+		}
+		if len(page) < searchIndexPageSize {
+			return total, nil
+		}
+	}
+}
+
+// applySearchChanges pushes one page of changes. Emails are looked up
+// rather than trusted from the feed, since a live email in a private list
+// isn't published; a list becoming public (or renamed) re-pushes its
+// emails.
+func (s *Server) applySearchChanges(ctx context.Context, changes []Change) error {
+	var docs []SearchDocument
+	var deleted []string
+	for _, c := range changes {
+		switch {
+		case c.Kind == changeMailingList && c.Action == "delete":
+			if err := s.searchIndex.DeleteList(ctx, c.ID); err != nil {
+				return err
+			}
+		case c.Kind == changeMailingList:
+			err := s.store.source.EachEmail(ctx, EmailFilter{MailingListID: c.ID}, 0, func(src *SourceEmail) error {
+				docs = append(docs, s.searchDocument(src))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		case c.Action == "delete":
+			deleted = append(deleted, c.ID)
+		default:
+			src, err := s.store.source.GetEmail(ctx, c.ID, false)
+			if errors.Is(err, errNotFound) {
+				deleted = append(deleted, c.ID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			docs = append(docs, s.searchDocument(src))
+		}
+	}
+	if len(docs) > 0 {
+		if err := s.searchIndex.Upsert(ctx, docs); err != nil {
+			return err
+		}
+	}
+	if len(deleted) > 0 {
+		return s.searchIndex.Delete(ctx, deleted)
+	}
+	return nil
+}
+
+// searchDocument builds src's search document. The plaintext is the
+// markdown body when there is one, else the HTML's text.
+func (s *Server) searchDocument(src *SourceEmail) SearchDocument {
+	listSlug := slugify(src.MailingList.Name)
+	doc := SearchDocument{
+		ID:          src.ID,
+		Title:       src.Subject,
+		Tags:        []string{listSlug},
+		Slug:        emailSlug(src.Slug, src.Subject, src.ID),
+		MailingList: SearchListRef{ID: src.MailingList.ID, Slug: listSlug, Name: src.MailingList.Name},
+		URL:         s.sourceArchiveURL(src),
+	}
+	if src.Excerpt != nil {
+		doc.Excerpt = *src.Excerpt
+	}
+	if src.SentAt != nil {
+		doc.SentAt = src.SentAt.Unix()
+	}
+	if series := detectSeries(src.Subject); series != nil {
+		doc.Tags = append(doc.Tags, series.Slug)
+	}
+	doc.Plaintext = truncateUTF8(sourcePlaintext(src), searchIndexMaxPlaintext)
+	return doc
+}
+
+// SearchIndexCursor returns where the sync into index left off, or the
+// start of the feed.
+func (s *Store) SearchIndexCursor(ctx context.Context, index string) (ChangeCursor, error) {
+	if s.metricsPool == nil {
+		return ChangeCursor{}, nil
+	}
+	var raw string
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT cursor FROM search_index_cursors WHERE index_name = $1
+	`, index).Scan(&raw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ChangeCursor{}, nil
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return ChangeCursor{}, err
+	}
+	return decodeChangeCursor(raw)
+}
+
+func (s *Store) SaveSearchIndexCursor(ctx context.Context, index string, c ChangeCursor) error {
+	if s.metricsPool == nil {
+		return nil
+	}
+	_, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO search_index_cursors (index_name, cursor, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (index_name) DO UPDATE SET cursor = EXCLUDED.cursor, updated_at = EXCLUDED.updated_at
+	`, index, encodeChangeCursor(c))
+	return s.observe(depMetrics, err)
+}
+
+// searchRequest sends a JSON request to a search engine, failing on any
+// non-2xx response.
+func searchRequest(ctx context.Context, client *http.Client, method, u string, header http.Header, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: status %d: %s", method, req.URL.Path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// meilisearchIndex writes through Meilisearch's documents API. Writes are
+// queued as Meilisearch tasks; a failed task shows in its dashboard, not
+// here.
+type meilisearchIndex struct {
+	base, key, index string
+	client           *http.Client
+}
+
+func (m *meilisearchIndex) Name() string { return "meilisearch " + m.index }
+
+func (m *meilisearchIndex) do(ctx context.Context, method, path string, body any) error {
+	header := http.Header{}
+	if m.key != "" {
+		header.Set("Authorization", "Bearer "+m.key)
+	}
+	return searchRequest(ctx, m.client, method, m.base+"/indexes/"+url.PathEscape(m.index)+path, header, body)
+}
+
+func (m *meilisearchIndex) Configure(ctx context.Context) error {
+	return m.do(ctx, http.MethodPatch, "/settings", map[string]any{
+		"searchableAttributes": []string{"title", "excerpt", "tags", "mailing_list.name", "plaintext"},
+		"filterableAttributes": []string{"mailing_list.id", "tags"},
+		"sortableAttributes":   []string{"sent_at"},
+	})
+}
+
+func (m *meilisearchIndex) Upsert(ctx context.Context, docs []SearchDocument) error {
+	return m.do(ctx, http.MethodPost, "/documents?primaryKey=id", docs)
+}
+
+func (m *meilisearchIndex) Delete(ctx context.Context, ids []string) error {
+	return m.do(ctx, http.MethodPost, "/documents/delete-batch", ids)
+}
+
+func (m *meilisearchIndex) DeleteList(ctx context.Context, listID string) error {
+	filter, _ := json.Marshal(listID) // a quoted, escaped string literal
+	return m.do(ctx, http.MethodPost, "/documents/delete", map[string]string{"filter": "mailing_list.id = " + string(filter)})
+}
+
+// algoliaIndex writes through Algolia's REST API with an admin API key.
+type algoliaIndex struct {
+	app, key, index string
+	client          *http.Client
+}
+
+func (a *algoliaIndex) Name() string { return "algolia " + a.index }
+
+func (a *algoliaIndex) do(ctx context.Context, method, path string, body any) error {
+	header := http.Header{}
+	header.Set("X-Algolia-Application-Id", a.app)
+	header.Set("X-Algolia-API-Key", a.key)
+	u := "https://" + a.app + ".algolia.net/1/indexes/" + url.PathEscape(a.index) + path
+	return searchRequest(ctx, a.client, method, u, header, body)
+}
+
+func (a *algoliaIndex) Configure(ctx context.Context) error {
+	return a.do(ctx, http.MethodPut, "/settings", map[string]any{
+		"searchableAttributes":  []string{"title", "excerpt", "tags", "mailing_list.name", "unordered(plaintext)"},
+		"attributesForFaceting": []string{"filterOnly(mailing_list.id)", "tags"},
+		"customRanking":         []string{"desc(sent_at)"},
+	})
+}
+
+// algoliaRecord is a document with the objectID Algolia keys records by.
+type algoliaRecord struct {
+	SearchDocument
+	ObjectID string `json:"objectID"`
+}
+
+func (a *algoliaIndex) Upsert(ctx context.Context, docs []SearchDocument) error {
+	requests := make([]map[string]any, len(docs))
+	for i, d := range docs {
+		requests[i] = map[string]any{"action": "updateObject", "body": algoliaRecord{d, d.ID}}
+	}
+	return a.do(ctx, http.MethodPost, "/batch", map[string]any{"requests": requests})
+}
+
+func (a *algoliaIndex) Delete(ctx context.Context, ids []string) error {
+	requests := make([]map[string]any, len(ids))
+	for i, id := range ids {
+		requests[i] = map[string]any{"action": "deleteObject", "body": map[string]string{"objectID": id}}
+	}
+	return a.do(ctx, http.MethodPost, "/batch", map[string]any{"requests": requests})
+}
+
+func (a *algoliaIndex) DeleteList(ctx context.Context, listID string) error {
+	filter, _ := json.Marshal(listID)
+	params := url.Values{"filters": {"mailing_list.id:" + string(filter)}}
+	return a.do(ctx, http.MethodPost, "/deleteByQuery", map[string]string{"params": params.Encode()})
+}
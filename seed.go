@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Seed ----------
+
+// loopsSchema is the subset of the Loops warehouse sync this service reads.
+// The real tables have many more columns; these are the ones our queries use.
+const loopsSchema = `
+CREATE SCHEMA IF NOT EXISTS loops;
+
+CREATE TABLE IF NOT EXISTS loops.mailing_lists (
+  id TEXT PRIMARY KEY,
+  friendly_name TEXT NOT NULL,
+  description TEXT,
+  is_public BOOLEAN,
+  color_scheme TEXT,
+  last_updated_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS loops.campaigns (
+  id TEXT PRIMARY KEY,
+  status TEXT NOT NULL,
+  mailing_list_id TEXT,
+  ai_publishable BOOLEAN,
+  ai_publishable_response_json JSONB,
+  ai_publishable_content_html TEXT,
+  ai_publishable_content_markdown TEXT,
+  ai_publishable_slug TEXT,
+  clicks BIGINT,
+  opens BIGINT,
+  sent_at TIMESTAMPTZ,
+  scheduled_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ
+);
+
+-- Databases seeded before updated_at was read.
+ALTER TABLE loops.campaigns ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS loops.audience_mailing_lists (
+  audience_id TEXT NOT NULL,
+  mailing_list_id TEXT,
+  PRIMARY KEY (audience_id, mailing_list_id)
+);
+`
+
+// runSeed creates the loops.* tables in DATABASE_URL and loads the same
+// lists and emails MOCK_DATA serves, so the full stack runs locally without
+// the production warehouse. Rerunning refreshes the demo rows in place.
+func runSeed(args []string) {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	configPath := fs.String("config", "", "TOML config file (overrides CONFIG_FILE)")
+	force := fs.Bool("force", false, "seed a database that isn't on this machine")
+	_ = fs.Parse(args)
+	loadEnv(*configPath)
+
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		log.Fatal("DATABASE_URL is required")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cfg, err := pgx.ParseConfig(dbURL)
+	if err != nil {
+		log.Fatalf("DATABASE_URL: %v", err)
+	}
+	if !*force && !isLocalDBHost(cfg.Host) {
+		log.Fatalf("refusing to seed demo data into %s; pass -force if that's really a development database", cfg.Host)
+	}
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		log.Fatalf("db connect: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	lists, emails, err := seedDemoData(ctx, conn, time.Now().UTC().Truncate(time.Hour))
+	if err != nil {
+		log.Fatalf("seed: %v", err)
+	}
+	log.Printf("seeded %d mailing lists and %d campaigns", lists, emails)
+}
+
+// isLocalDBHost reports whether host is a unix socket or loopback address.
+func isLocalDBHost(host string) bool {
+	if host == "" || host == "localhost" || host[0] == '/' {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func seedDemoData(ctx context.Context, conn *pgx.Conn, now time.Time) (lists, emails int, err error) {
+	var f mockFixture
+	if err := json.Unmarshal(mockFixtures, &f); err != nil {
+		return 0, 0, fmt.Errorf("fixtures: %w", err)
+	}
+	if _, err := conn.Exec(ctx, loopsSchema); err != nil {
+		return 0, 0, fmt.Errorf("create loops schema: %w", err)
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, l := range f.MailingLists {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO loops.mailing_lists (id, friendly_name, description, is_public, color_scheme, last_updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (id) DO UPDATE SET friendly_name = EXCLUDED.friendly_name, description = EXCLUDED.description,
+				is_public = EXCLUDED.is_public, color_scheme = EXCLUDED.color_scheme, last_updated_at = EXCLUDED.last_updated_at
+		`, l.ID, l.Name, l.Description, l.IsPublic, l.Color, now); err != nil {
+			return 0, 0, fmt.Errorf("mailing list %s: %w", l.ID, err)
+		}
+		// Subscriber counts come from membership rows; generate anonymous ones.
+		if _, err := tx.Exec(ctx, `DELETE FROM loops.audience_mailing_lists WHERE mailing_list_id = $1 AND audience_id LIKE 'demo-%'`, l.ID); err != nil {
+			return 0, 0, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO loops.audience_mailing_lists (audience_id, mailing_list_id)
+			SELECT 'demo-' || g, $1 FROM generate_series(1, $2::int) g
+		`, l.ID, l.SubscriberCount); err != nil {
+			return 0, 0, fmt.Errorf("mailing list %s subscribers: %w", l.ID, err)
+		}
+	}
+
+	for _, e := range f.Emails {
+		status, published := "Sent", true
+		var sentAt, scheduledAt *time.Time
+		switch {
+		case e.Draft:
+			status, published = "Draft", false
+		case e.ScheduledIn > 0:
+			status = "Scheduled"
+			t := now.Add(time.Duration(e.ScheduledIn) * 24 * time.Hour)
+			scheduledAt = &t
+		default:
+			t := now.Add(-time.Duration(e.DaysAgo) * 24 * time.Hour)
+			sentAt, published = &t, !e.Unpublished
+		}
+		meta := map[string]any{"title": e.Subject}
+		if e.Excerpt != nil {
+			meta["excerpt"] = *e.Excerpt
+		}
+		createdAt := now.Add(-time.Duration(e.DaysAgo+1) * 24 * time.Hour)
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO loops.campaigns (id, status, mailing_list_id, ai_publishable, ai_publishable_response_json,
+				ai_publishable_content_html, ai_publishable_content_markdown, clicks, opens, sent_at, scheduled_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT (id) DO UPDATE SET status = EXCLUDED.status, mailing_list_id = EXCLUDED.mailing_list_id,
+				ai_publishable = EXCLUDED.ai_publishable, ai_publishable_response_json = EXCLUDED.ai_publishable_response_json,
+				ai_publishable_content_html = EXCLUDED.ai_publishable_content_html,
+				ai_publishable_content_markdown = EXCLUDED.ai_publishable_content_markdown,
+				clicks = EXCLUDED.clicks, opens = EXCLUDED.opens, sent_at = EXCLUDED.sent_at,
+				scheduled_at = EXCLUDED.scheduled_at, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at
+		`, e.ID, status, e.MailingListID, published, meta, e.HTML, e.Markdown,
+			e.Clicks, e.Opens, sentAt, scheduledAt, createdAt, sentAt); err != nil {
+			return 0, 0, fmt.Errorf("campaign %s: %w", e.ID, err)
+		}
+	}
+	return len(f.MailingLists), len(f.Emails), tx.Commit(ctx)
+}
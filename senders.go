@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------- Sender Display Names ----------
+
+// Bylines come from a curated mapping in the metrics DB, never from the
+// campaign's from_email. A scope is "email:<campaign id>" or
+// "list:<mailing list id>"; the most specific match wins, falling back to
+// SENDER_DEFAULT_NAME.
+
+type senderNames struct {
+	byScope  map[string]string
+	fallback string
+}
+
+func (sn *senderNames) lookup(emailID, listID string) string {
+	if sn == nil {
+		return ""
+	}
+	if name, ok := sn.byScope["email:"+emailID]; ok {
+		return name
+	}
+	if name, ok := sn.byScope["list:"+listID]; ok {
+		return name
+	}
+	return sn.fallback
+}
+
+// SenderName is the display byline for an email (listID may be its list, or
+// emailID empty for a list itself).
+func (s *Store) SenderName(emailID, listID string) string {
+	return s.senders.Load().lookup(emailID, listID)
+}
+
+// LoadSenderNames replaces the in-memory mapping from the metrics DB.
+func (s *Store) LoadSenderNames(ctx context.Context) error {
+	sn := &senderNames{byScope: map[string]string{}, fallback: env("SENDER_DEFAULT_NAME", "Hack Club")}
+	if s.metricsPool != nil {
+		rows, err := s.metricsPool.Query(ctx, `SELECT scope, display_name FROM sender_display_names`)
+		if err := s.observe(depMetrics, err); err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var scope, name string
+			if err := rows.Scan(&scope, &name); err != nil {
+				return err
+			}
+			sn.byScope[scope] = name
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	s.senders.Store(sn)
+	return nil
+}
+
+// StartSenderNameRefresh loads the mapping now and every 5 minutes, so edits
+// made through another replica show up here too.
+func (s *Store) StartSenderNameRefresh(ctx context.Context) {
+	if err := s.LoadSenderNames(ctx); err != nil {
+		log.Printf("sender names load error: %v", err)
+	}
+	if s.metricsPool == nil {
+		return
+	}
+	s.jobs.Every("refresh.sender_names", 5*time.Minute, s.LoadSenderNames)
+}
+
+// SetSenderName upserts a mapping, or deletes it when name is empty.
+func (s *Store) SetSenderName(ctx context.Context, scope, name string) error {
+	var err error
+	if name == "" {
+		_, err = s.metricsPool.Exec(ctx, `DELETE FROM sender_display_names WHERE scope = $1`, scope)
+	} else {
+		_, err = s.metricsPool.Exec(ctx, `
+			INSERT INTO sender_display_names (scope, display_name, updated_at) VALUES ($1, $2, NOW())
+			ON CONFLICT (scope) DO UPDATE SET display_name = EXCLUDED.display_name, updated_at = NOW()
+		`, scope, name)
+	}
+	if err != nil {
+		return err
+	}
+	return s.LoadSenderNames(ctx)
+}
+
+func (s *Server) handleAdminSenderNames(w http.ResponseWriter, r *http.Request) {
+	sn := s.store.senders.Load()
+	writeJSON(w, http.StatusOK, map[string]any{"default": sn.fallback, "names": sn.byScope})
+}
+
+func (s *Server) handleAdminSetSenderName(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scope       string `json:"scope"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil ||
+		!(strings.HasPrefix(req.Scope, "email:") || strings.HasPrefix(req.Scope, "list:")) || len(req.DisplayName) > 100 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "expected {\"scope\": \"email:<id>\"|\"list:<id>\", \"display_name\": \"...\"} (empty name deletes)")
+		return
+	}
+	if s.store.metricsPool == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "METRICS_DATABASE_URL not configured")
+		return
+	}
+	name := strings.TrimSpace(req.DisplayName)
+	if err := s.store.SetSenderName(r.Context(), req.Scope, name); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	n := s.cache.Purge(func(string) bool { return true })
+	log.Printf("admin: sender name for %s set to %q, purged %d cache entries", req.Scope, name, n)
+	s.handleAdminSenderNames(w, r)
+}
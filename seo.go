@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Crawlers and Structured Data ----------
+
+// defaultRobotsTxt lets crawlers index docs and content reads but keeps them
+// off click redirects (which would record fake clicks), tracking beacons,
+// previews, and operator endpoints. ROBOTS_TXT_PATH replaces it.
+const defaultRobotsTxt = `User-agent: *
+Allow: /docs
+Allow: /emails
+Allow: /mailing_lists
+Allow: /series
+Disallow: /emails/*/click/
+Disallow: /emails/*/view
+Disallow: /emails/*/stats/stream
+Disallow: /stats/stream
+Disallow: /pages/view
+Disallow: /rum
+Disallow: /*preview_token=
+Disallow: /admin/
+`
+
+func (s *Server) handleRobots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write(s.robotsTxt)
+}
+
+// emailJSONLD is schema.org NewsArticle markup for an email's archive page,
+// ready to paste into <script type="application/ld+json">.
+func (s *Server) emailJSONLD(e *Email) map[string]any {
+	url := s.archiveURL(e)
+	doc := map[string]any{
+		"@context":         "https://schema.org",
+		"@type":            "NewsArticle",
+		"headline":         e.Subject,
+		"url":              url,
+		"mainEntityOfPage": map[string]any{"@type": "WebPage", "@id": url},
+		"author":           map[string]any{"@type": "Organization", "name": e.Sender},
+		"publisher": map[string]any{
+			"@type": "Organization",
+			"name":  s.publisherName,
+			"logo":  map[string]any{"@type": "ImageObject", "url": s.publisherLogo},
+		},
+		"isPartOf": map[string]any{"@type": "Periodical", "name": e.MailingListRef.Name},
+	}
+	if e.SentAt != nil {
+		doc["datePublished"] = e.SentAt.UTC().Format(time.RFC3339)
+	}
+	if e.Excerpt != nil && *e.Excerpt != "" {
+		doc["description"] = *e.Excerpt
+	}
+	if e.CoverImage != nil {
+		doc["image"] = []string{e.CoverImage.Src}
+	}
+	return doc
+}
+
+func (s *Server) handleEmailJSONLD(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.cached(w, r, "application/ld+json; charset=utf-8", func() ([]byte, error) {
+		e, err := s.store.GetEmail(r.Context(), r, emailID, false)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(s.emailJSONLD(e))
+	})
+}
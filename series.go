@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Series ----------
+
+// SeriesRef places an email in a multi-part series, e.g. "Arcade Week 2".
+type SeriesRef struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+	Part int    `json:"part"`
+}
+
+// seriesSubjectRegex matches subjects of the form "<name> <marker> <n>", where
+// the marker is Week/Part/Day/Vol/Episode/Issue/#: "Arcade Week 2: Ships",
+// "Guide to Jams (Part 1/3)", "HCB Newsletter #12".
+var seriesSubjectRegex = regexp.MustCompile(`(?i)^\s*(.*?[\p{L}\p{N}])[\s:(\[|–—-]*\b(?:week|part|day|vol\.?|volume|episode|ep\.?|issue|no\.)\s*(\d{1,3})\b|^\s*(.*?[\p{L}\p{N}])\s*#(\d{1,3})\b`)
+
+// detectSeries derives a series from an email subject, or nil.
+func detectSeries(subject string) *SeriesRef {
+	m := seriesSubjectRegex.FindStringSubmatch(subject)
+	if m == nil {
+		return nil
+	}
+	name, num := m[1], m[2]
+	if name == "" {
+		name, num = m[3], m[4]
+	}
+	part, err := strconv.Atoi(num)
+	if err != nil || part == 0 {
+		return nil
+	}
+	name = strings.TrimSpace(name)
+	return &SeriesRef{Slug: slugify(name), Name: name, Part: part}
+}
+
+type SeriesEmail struct {
+	ID     string     `json:"id"`
+	Slug   string     `json:"slug"`
+	Title  string     `json:"subject"`
+	Part   int        `json:"part"`
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+type Series struct {
+	Slug          string        `json:"slug"`
+	Name          string        `json:"name"`
+	MailingListID string        `json:"mailing_list_id"`
+	Count         int           `json:"count"`
+	LastSentAt    *time.Time    `json:"last_sent_at,omitempty"`
+	Emails        []SeriesEmail `json:"emails"` // ordered by part, then send time
+}
+
+// ListSeries groups published emails into series. Only series with at least
+// two published parts are returned, most recently updated first.
+func (s *Store) ListSeries(ctx context.Context, mailingListID string) ([]Series, error) {
+	if s.pool == nil {
+		return nil, errNoWarehouse
+	}
+	eo := s.emailOverrides()
+	overrides, args := eo.join([]any{mailingListID})
+	rows, err := s.content().Query(ctx, `
+		SELECT c.id, COALESCE(c.ai_publishable_response_json->>'title', ''),
+		       COALESCE(c.ai_publishable_slug, ''), c.sent_at, c.mailing_list_id, c.updated_at
+		FROM loops.campaigns c
+		JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		`+overrides+`
+		`+publishedEmailsWhere+` AND ($1 = '' OR c.mailing_list_id = $1)
+	`, args...)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bySlug := map[string]*Series{}
+	for rows.Next() {
+		var e SeriesEmail
+		var listID string
+		var upstreamUpdatedAt *time.Time
+		if err := rows.Scan(&e.ID, &e.Title, &e.Slug, &e.SentAt, &listID, &upstreamUpdatedAt); err != nil {
+			return nil, err
+		}
+		if rev := eo.restoredRevision(e.ID, upstreamUpdatedAt); rev != nil {
+			e.Title = rev.Subject
+		}
+		ref := detectSeries(e.Title)
+		if ref == nil {
+			continue
+		}
+		if e.Slug == "" {
+			e.Slug = slugify(e.Title)
+		}
+		e.Part = ref.Part
+		// Series never span lists; the same name on two lists is two series.
+		key := listID + "/" + ref.Slug
+		sr, ok := bySlug[key]
+		if !ok {
+			sr = &Series{Slug: ref.Slug, Name: ref.Name, MailingListID: listID}
+			bySlug[key] = sr
+		}
+		sr.Emails = append(sr.Emails, e)
+		if e.SentAt != nil && (sr.LastSentAt == nil || e.SentAt.After(*sr.LastSentAt)) {
+			sr.LastSentAt = e.SentAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := []Series{}
+	for _, sr := range bySlug {
+		if len(sr.Emails) < 2 {
+			continue
+		}
+		sort.Slice(sr.Emails, func(i, j int) bool {
+			a, b := sr.Emails[i], sr.Emails[j]
+			if a.Part != b.Part {
+				return a.Part < b.Part
+			}
+			return a.SentAt != nil && b.SentAt != nil && a.SentAt.Before(*b.SentAt)
+		})
+		sr.Count = len(sr.Emails)
+		out = append(out, *sr)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].LastSentAt, out[j].LastSentAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+	return out, nil
+}
+
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	mailingListID := r.URL.Query().Get("mailing_list_id")
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.ListSeries(r.Context(), mailingListID)
+		if err != nil {
+			return nil, err
+		}
+		return Paginated[Series]{Items: items, Meta: s.store.responseMeta()}, nil
+	})
+}
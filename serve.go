@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ---------- Listeners ----------
+
+// listen opens the server's listener. LISTEN=unix:/run/news.sock serves on a
+// unix domain socket (for a proxy on the same host, without a TCP port);
+// otherwise it's TCP on addr (HOST:PORT).
+func listen(addr string) (net.Listener, string, error) {
+	path, ok := strings.CutPrefix(os.Getenv("LISTEN"), "unix:")
+	if !ok {
+		if v := os.Getenv("LISTEN"); v != "" {
+			addr = strings.TrimPrefix(v, "tcp:")
+		}
+		ln, err := net.Listen("tcp", addr)
+		return ln, addr, err
+	}
+
+	// A socket left behind by a crashed process blocks Listen; remove it,
+	// but never anything that isn't a socket.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, "", fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, "", err
+		}
+	}
+	mode, err := strconv.ParseUint(env("LISTEN_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		return nil, "", fmt.Errorf("LISTEN_SOCKET_MODE: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, "", err
+	}
+	// Closing the listener (on shutdown) unlinks the socket.
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	return ln, "unix:" + path, nil
+}
+
+// tlsSettings is native TLS for deployments without a terminating proxy:
+// either a static certificate (TLS_CERT_FILE + TLS_KEY_FILE) or Let's
+// Encrypt certificates for AUTOCERT_DOMAINS.
+type tlsSettings struct {
+	certFile, keyFile string
+	manager           *autocert.Manager
+}
+
+// tlsFromEnv returns nil when TLS isn't configured.
+func tlsFromEnv() (*tlsSettings, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	domains := os.Getenv("AUTOCERT_DOMAINS")
+	switch {
+	case domains != "" && (certFile != "" || keyFile != ""):
+		return nil, errors.New("set either TLS_CERT_FILE/TLS_KEY_FILE or AUTOCERT_DOMAINS, not both")
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		// Fail at startup rather than on the first handshake.
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, err
+		}
+		return &tlsSettings{certFile: certFile, keyFile: keyFile}, nil
+	case domains != "":
+		var hosts []string
+		for _, d := range strings.Split(domains, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				hosts = append(hosts, d)
+			}
+		}
+		return &tlsSettings{manager: &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(hosts...),
+			Cache:      autocert.DirCache(env("AUTOCERT_CACHE_DIR", "/var/lib/news/autocert")),
+			Email:      os.Getenv("AUTOCERT_EMAIL"),
+		}}, nil
+	}
+	return nil, nil
+}
+
+func (t *tlsSettings) mode() string {
+	switch {
+	case t == nil:
+		return "off"
+	case t.manager != nil:
+		return "autocert"
+	}
+	return "static"
+}
+
+// configure sets up srv for TLS; serveTLS then needs no file arguments.
+func (t *tlsSettings) configure(srv *http.Server) {
+	if t.manager != nil {
+		srv.TLSConfig = t.manager.TLSConfig()
+		return
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+}
+
+func (t *tlsSettings) serve(srv *http.Server, ln net.Listener) error {
+	if t.manager != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.ServeTLS(ln, t.certFile, t.keyFile)
+}
+
+// startHTTPRedirect serves plain HTTP on addr, redirecting everything to
+// HTTPS (and answering ACME http-01 challenges under autocert), until ctx is
+// done.
+func (t *tlsSettings) startHTTPRedirect(ctx context.Context, addr string) {
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	if t.manager != nil {
+		h = t.manager.HTTPHandler(h)
+	}
+	redirect := &http.Server{Addr: addr, Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = redirect.Close()
+	}()
+	go func() {
+		log.Printf("redirecting http on %s to https", addr)
+		if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("http redirect listener: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ---------- Session Hashing ----------
+
+// The _track cookie never reaches the metrics DB. Tracking rows store
+// HMAC(session, salt), where the salt is derived from SESSION_HASH_SECRET
+// and the period the event falls in (SESSION_HASH_ROTATION, default 30
+// days). A leaked metrics DB can't be matched against cookies, and one
+// browser's rows only link up within a period. The cost is that a reader
+// active across a rotation counts as two sessions, and deduplication
+// windows don't span the boundary.
+//
+// Rows recorded before hashing hold the raw cookie value, as do rows an
+// instance still running older code writes during a deploy. Writers mark
+// hashed rows (session_hashed), and the sessions.hash_legacy job hashes
+// the rest in place, a day of event time per statement, with the salt of
+// each event's period, so they count and deduplicate like new rows.
+
+const defaultSessionHashRotation = 30 * 24 * time.Hour
+
+type SessionHasher struct {
+	secret   []byte
+	rotation time.Duration
+}
+
+// NewSessionHasherFromEnv reads SESSION_HASH_SECRET and
+// SESSION_HASH_ROTATION. The secret is required when metrics are recorded:
+// one made up per process would split sessions across replicas and
+// restarts, inflating unique counts and defeating deduplication. Without a
+// metrics DB nothing is stored, so a per-process secret does.
+func NewSessionHasherFromEnv(metrics bool) *SessionHasher {
+	secret := []byte(os.Getenv("SESSION_HASH_SECRET"))
+	if len(secret) == 0 {
+		if metrics {
+			log.Fatal("SESSION_HASH_SECRET is required with METRICS_DATABASE_URL; set it to the same random value on every instance")
+		}
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("session hash secret: %v", err)
+		}
+	}
+	rotation := envDuration("SESSION_HASH_ROTATION", defaultSessionHashRotation)
+	if rotation < time.Hour {
+		log.Printf("SESSION_HASH_ROTATION %s is under an hour; using 1h", rotation)
+		rotation = time.Hour
+	}
+	return &SessionHasher{secret: secret, rotation: rotation}
+}
+
+// Hash pseudonymizes sessionID for an event at t.
+func (h *SessionHasher) Hash(sessionID string, t time.Time) string {
+	return h.hashPeriod(sessionID, t.Unix()/h.rotationSeconds())
+}
+
+func (h *SessionHasher) rotationSeconds() int64 {
+	return int64(h.rotation / time.Second)
+}
+
+// hashPeriod is Hash for an event in the given rotation period.
+func (h *SessionHasher) hashPeriod(sessionID string, period int64) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte("session-salt:" + strconv.FormatInt(period, 10)))
+	salt := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, salt)
+	mac.Write([]byte(sessionID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Stable pseudonymizes sessionID without rotation, for state a session
+// must find again later (likes). It uses its own salt, so it can't be
+// matched against rotating hashes.
+func (h *SessionHasher) Stable(sessionID string) string {
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte("session-stable:" + sessionID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// hashSession is what tracking inserts store for sessionID. A Store without
+// a hasher (MOCK_DATA, which tracks nothing) keeps it as is.
+func (s *Store) hashSession(sessionID string, t time.Time) string {
+	if s.sessions == nil {
+		return sessionID
+	}
+	return s.sessions.Hash(sessionID, t)
+}
+
+// stableSession is hashSession for Stable hashes.
+func (s *Store) stableSession(sessionID string) string {
+	if s.sessions == nil {
+		return sessionID
+	}
+	return s.sessions.Stable(sessionID)
+}
+
+// sessionTables are the tables whose session_id is a Hash.
+var sessionTables = []string{"email_views", "page_views", "email_link_clicks", "email_scroll_depth", "email_read_time"}
+
+// sessionBackfillBatch is the span of event time hashed per UPDATE.
+const sessionBackfillBatch = 24 * time.Hour
+
+// StartSessionHashBackfill schedules hashing of raw session IDs: right
+// away, for rows from before hashing, then hourly for any written since by
+// instances that hadn't been upgraded yet.
+func (s *Store) StartSessionHashBackfill() {
+	if s.metricsPool == nil || s.sessions == nil {
+		return
+	}
+	s.jobs.Every("sessions.hash_legacy", time.Hour, s.hashLegacySessions)
+}
+
+// hashLegacySessions hashes every unmarked row's session ID. Replicas may
+// run it at once: a row is only rewritten while still unmarked.
+func (s *Store) hashLegacySessions(ctx context.Context) error {
+	for _, table := range sessionTables {
+		var from, to *time.Time
+		err := s.metricsPool.QueryRow(ctx, fmt.Sprintf(`
+			SELECT MIN(time), MAX(time) FROM %s WHERE session_hashed IS NULL
+		`, table)).Scan(&from, &to)
+		if err := s.observe(depMetrics, err); err != nil {
+			return err
+		}
+		if from == nil {
+			continue
+		}
+		hashed := int64(0)
+		for start := *from; !start.After(*to); start = start.Add(sessionBackfillBatch) {
+			n, err := s.hashSessionsBetween(ctx, table, start, start.Add(sessionBackfillBatch))
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			hashed += n
+		}
+		log.Printf("%s: hashed the session IDs of %d earlier events", table, hashed)
+	}
+	return nil
+}
+
+// hashSessionsBetween hashes the unmarked rows of table with event times
+// in [from, to), returning how many it rewrote.
+func (s *Store) hashSessionsBetween(ctx context.Context, table string, from, to time.Time) (int64, error) {
+	rotation := s.sessions.rotationSeconds()
+	rows, err := s.metricsPool.Query(ctx, fmt.Sprintf(`
+		SELECT DISTINCT session_id, floor(extract(epoch FROM time) / $3)::bigint
+		FROM %s
+		WHERE time >= $1 AND time < $2 AND session_hashed IS NULL
+	`, table), from, to, rotation)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	var raw, hashes []string
+	var periods []int64
+	for rows.Next() {
+		var id string
+		var period int64
+		if err := rows.Scan(&id, &period); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		raw = append(raw, id)
+		periods = append(periods, period)
+		hashes = append(hashes, s.sessions.hashPeriod(id, period))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(raw) == 0 {
+		return 0, err
+	}
+
+	tag, err := s.metricsPool.Exec(ctx, fmt.Sprintf(`
+		UPDATE %s t SET session_id = m.hash, session_hashed = true
+		FROM unnest($3::text[], $4::bigint[], $5::text[]) AS m(raw, period, hash)
+		WHERE t.time >= $1 AND t.time < $2 AND t.session_hashed IS NULL
+		  AND t.session_id = m.raw
+		  AND floor(extract(epoch FROM t.time) / $6)::bigint = m.period
+	`, table), from, to, raw, periods, hashes, rotation)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Short Links ----------
+
+// Every tracked link also gets a short code, derived from the email, link
+// index and URL so rebuilding an email gives the same codes, and saved with
+// the link map (see links.go). /l/{code} records the click exactly like
+// /emails/{id}/click/{index} and redirects. With LINK_SHORTLINKS=1,
+// rewritten HTML links to /l/{code}, which is much shorter than the URL
+// escaped into a query string and can be shared on its own; the long form
+// keeps working for HTML served before. Codes this instance generated are
+// also kept in memory, so a link resolves before its map is saved and
+// without a metrics DB.
+
+// shortlinkCodeBytes is how much of the hash a code keeps: 72 bits, 12
+// characters.
+const shortlinkCodeBytes = 9
+
+// shortlink is where a code leads.
+type shortlink struct {
+	EmailID string
+	Index   int
+	URL     string
+}
+
+// shortlinkCode returns the code for the link at index in emailID.
+func shortlinkCode(emailID string, index int, u string) string {
+	sum := sha256.Sum256([]byte(emailID + "\x00" + strconv.Itoa(index) + "\x00" + u))
+	return base64.RawURLEncoding.EncodeToString(sum[:shortlinkCodeBytes])
+}
+
+// linkSlot identifies a link by its email and index.
+type linkSlot struct {
+	emailID string
+	index   int
+}
+
+// rememberShortlinks keeps the codes and slots of an email's links in
+// memory.
+func (s *Store) rememberShortlinks(emailID string, links []EmailLink) {
+	for _, l := range links {
+		sl := shortlink{EmailID: emailID, Index: l.Index, URL: l.URL}
+		s.linkSlots.Store(linkSlot{emailID, l.Index}, sl)
+		if l.Code != "" {
+			s.shortCodes.Store(l.Code, sl)
+		}
+	}
+}
+
+// EmailLink returns the link at index in emailID's link map, or
+// errNotFound, for clicks reported by index rather than through a link.
+func (s *Store) EmailLink(ctx context.Context, emailID string, index int) (*shortlink, error) {
+	if v, ok := s.linkSlots.Load(linkSlot{emailID, index}); ok {
+		l := v.(shortlink)
+		return &l, nil
+	}
+	if s.metricsPool == nil {
+		return nil, errNotFound
+	}
+	l := shortlink{EmailID: emailID, Index: index}
+	err := s.metricsPool.QueryRow(ctx, `SELECT url FROM email_links WHERE email_id = $1 AND link_index = $2`, emailID, index).
+		Scan(&l.URL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ResolveShortlink returns the link with code, or errNotFound.
+func (s *Store) ResolveShortlink(ctx context.Context, code string) (*shortlink, error) {
+	if v, ok := s.shortCodes.Load(code); ok {
+		l := v.(shortlink)
+		return &l, nil
+	}
+	if s.metricsPool == nil || len(code) != base64.RawURLEncoding.EncodedLen(shortlinkCodeBytes) {
+		return nil, errNotFound
+	}
+	var l shortlink
+	err := s.metricsPool.QueryRow(ctx, `SELECT email_id, link_index, url FROM email_links WHERE code = $1`, code).
+		Scan(&l.EmailID, &l.Index, &l.URL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (s *Server) handleShortlink(w http.ResponseWriter, r *http.Request) {
+	l, err := s.store.ResolveShortlink(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	s.clickThrough(w, r, l.EmailID, l.Index, l.URL)
+}
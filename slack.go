@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ---------- Slack Announcements ----------
+
+// With SLACK_WEBHOOK_URL set (a Slack incoming webhook), each email that
+// becomes publishable is announced in its channel: subject linked to the
+// archive page, list, and excerpt. The publish watcher notices new emails
+// (see publishwatch.go), so an announcement follows publication or an
+// approval within PUBLISH_WATCH_INTERVAL; emails published while no
+// instance was running aren't announced. Each email is announced at most
+// once: replicas claim it in slack_announcements first, and posting is a
+// queued job, retried like webhooks.
+
+const (
+	slackJobKind     = "slack.announce"
+	slackMaxAttempts = 5
+)
+
+type Slack struct {
+	url    string
+	client *http.Client
+	srv    *Server
+}
+
+// NewSlackFromEnv reads SLACK_WEBHOOK_URL and registers posting with the
+// server's jobs.
+func NewSlackFromEnv(srv *Server) *Slack {
+	u := os.Getenv("SLACK_WEBHOOK_URL")
+	if u == "" {
+		return nil
+	}
+	sl := &Slack{url: u, client: &http.Client{Timeout: 10 * time.Second}, srv: srv}
+	srv.store.jobs.Handle(slackJobKind, slackMaxAttempts, sl.post)
+	return sl
+}
+
+// Announce queues announcements of emails not announced before.
+func (sl *Slack) Announce(emails []Change) {
+	if sl == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, c := range emails {
+		first, err := sl.srv.store.ClaimAnnouncement(ctx, c.ID)
+		if err != nil {
+			log.Printf("slack: claim %s: %v", c.ID, err)
+			continue
+		}
+		if !first {
+			continue
+		}
+		if err := sl.srv.store.jobs.Enqueue(ctx, slackJobKind, map[string]string{"email_id": c.ID}); err != nil {
+			log.Printf("slack: %s: %v", c.ID, err)
+		}
+	}
+}
+
+// ClaimAnnouncement records that emailID is being announced, reporting
+// false if it already was. Without a metrics DB there's nothing to share
+// with, so every claim succeeds.
+func (s *Store) ClaimAnnouncement(ctx context.Context, emailID string) (bool, error) {
+	if s.metricsPool == nil {
+		return true, nil
+	}
+	tag, err := s.metricsPool.Exec(ctx, `
+		INSERT INTO slack_announcements (email_id) VALUES ($1) ON CONFLICT DO NOTHING
+	`, emailID)
+	if err := s.observe(depMetrics, err); err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// slackEscape escapes text for Slack mrkdwn.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackMessage is the announcement of src, linking to url.
+func slackMessage(src *SourceEmail, url string) map[string]any {
+	subject := slackEscape.Replace(src.Subject)
+	list := slackEscape.Replace(src.MailingList.Name)
+	text := fmt.Sprintf("*<%s|%s>*\n_%s_", url, subject, list)
+	if src.Excerpt != nil && *src.Excerpt != "" {
+		text += "\n" + slackEscape.Replace(*src.Excerpt)
+	}
+	return map[string]any{
+		"text": fmt.Sprintf("New from %s: %s %s", list, subject, url), // notifications
+		"blocks": []any{
+			map[string]any{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}},
+		},
+	}
+}
+
+// post is the job handler. An email unpublished again before its turn is
+// dropped.
+func (sl *Slack) post(ctx context.Context, payload json.RawMessage) error {
+	var job struct {
+		EmailID string `json:"email_id"`
+	}
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	src, err := sl.srv.store.source.GetEmail(ctx, job.EmailID, false)
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(slackMessage(src, sl.srv.sourceArchiveURL(src)))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sl.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sl.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Social Snippets ----------
+
+// /emails/{id}/social drafts a post announcing an email for each network,
+// so the comms team can share every newsletter without writing copy. Each
+// is the subject as the hook, the summary (see enrich.go) or else the
+// excerpt as the body, and the archive page's URL; LinkedIn's longer post
+// adds the key points and the email's topics as hashtags. Bodies are cut
+// at a word to fit, counting links the way each network does.
+
+// socialNetwork is a network's post length limit. urlLength is what any
+// link counts as, or 0 if links count their actual length.
+type socialNetwork struct {
+	name      string
+	limit     int
+	urlLength int
+	long      bool // room for key points and hashtags
+}
+
+var socialNetworks = []socialNetwork{
+	{name: "x", limit: 280, urlLength: 23},
+	{name: "bluesky", limit: 300},
+	{name: "mastodon", limit: 500, urlLength: 23},
+	{name: "linkedin", limit: 3000, long: true},
+}
+
+// SocialSnippet is a ready-to-post text for one network.
+type SocialSnippet struct {
+	Network string `json:"network"`
+	Text    string `json:"text"`
+	Length  int    `json:"length"` // as the network counts it
+	Limit   int    `json:"limit"`
+}
+
+// socialLength is text's length as n counts it, with url in it.
+func (n socialNetwork) socialLength(text, url string) int {
+	length := utf8.RuneCountInString(text)
+	if n.urlLength > 0 && strings.Contains(text, url) {
+		length += n.urlLength - utf8.RuneCountInString(url)
+	}
+	return length
+}
+
+// truncateWords cuts s to at most max characters at a word boundary,
+// marking the cut with an ellipsis.
+func truncateWords(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	if max < 1 {
+		return ""
+	}
+	cut := max - 1
+	for i := cut; i > cut/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…"
+}
+
+// hashtag turns a topic's name into a hashtag: "Fiscal sponsorship" is
+// #FiscalSponsorship.
+func hashtag(name string) string {
+	var b strings.Builder
+	b.WriteByte('#')
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		r, size := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteString(word[size:])
+	}
+	return b.String()
+}
+
+// socialSnippets drafts e's posts; url is its archive page.
+func socialSnippets(e *Email, url string) []SocialSnippet {
+	body := ""
+	switch {
+	case e.Summary != nil && *e.Summary != "":
+		body = *e.Summary
+	case e.Excerpt != nil && *e.Excerpt != "":
+		body = *e.Excerpt
+	case e.PreviewText != nil:
+		body = *e.PreviewText
+	}
+	body = strings.Join(strings.Fields(body), " ")
+
+	var extra []string
+	if len(e.KeyPoints) > 0 {
+		points := make([]string, len(e.KeyPoints))
+		for i, p := range e.KeyPoints {
+			points[i] = "• " + p
+		}
+		extra = append(extra, strings.Join(points, "\n"))
+	}
+	var tags []string
+	for _, slug := range e.Topics {
+		for _, t := range topicTaxonomy {
+			if t.Slug == slug {
+				tags = append(tags, hashtag(t.Name))
+			}
+		}
+	}
+
+	out := make([]SocialSnippet, 0, len(socialNetworks))
+	for _, n := range socialNetworks {
+		compose := func(body string) string {
+			parts := []string{e.Subject}
+			if body != "" {
+				parts = append(parts, body)
+			}
+			if n.long {
+				parts = append(parts, extra...)
+				parts = append(parts, "Read it: "+url)
+				if len(tags) > 0 {
+					parts = append(parts, strings.Join(tags, " "))
+				}
+			} else {
+				parts = append(parts, url)
+			}
+			return strings.Join(parts, "\n\n")
+		}
+		text := compose(body)
+		if over := n.socialLength(text, url) - n.limit; over > 0 {
+			text = compose(truncateWords(body, utf8.RuneCountInString(body)-over))
+		}
+		if n.socialLength(text, url) > n.limit {
+			// The subject is too long even alone; drop the body and shorten it.
+			rest := strings.TrimPrefix(compose(""), e.Subject)
+			text = truncateWords(e.Subject, n.limit-n.socialLength(rest, url)) + rest
+		}
+		out = append(out, SocialSnippet{Network: n.name, Text: text, Length: n.socialLength(text, url), Limit: n.limit})
+	}
+	return out
+}
+
+func (s *Server) handleEmailSocial(w http.ResponseWriter, r *http.Request) {
+	emailID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		e, err := s.store.GetEmail(r.Context(), r, emailID, false)
+		if err != nil {
+			return nil, err
+		}
+		url := s.archiveURL(e)
+		return map[string]any{"email_id": e.ID, "url": url, "snippets": socialSnippets(e, url)}, nil
+	})
+}
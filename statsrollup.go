@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// ---------- Stats Rollup ----------
+
+// Lists show stats for dozens of emails per page, and counting each from
+// raw events is O(events) per email. Every STATS_ROLLUP_INTERVAL (default
+// 5m, "0" disables it) this worker refreshes the hourly view aggregate (on
+// timescale; plain Postgres keeps its hourly rollup) and recomputes
+// email_stats_totals for every email with events since its last pass, the
+// first pass covering all of them. List endpoints and exports then read an
+// email's tracked views, clicks and median read time from one row, with
+// likes counted for the whole page in the same query; single emails, stats
+// streams and emails not rolled up yet still count live. Totals lag by up
+// to the interval, likes don't.
+
+const defaultStatsRollupInterval = 5 * time.Minute
+
+// statsRollupOverlap reaches back before the previous pass, for events
+// that sat in the metrics writer's buffer while it ran.
+const statsRollupOverlap = 2 * time.Minute
+
+// StatsTotal is one email's rolled up tracked stats, and its likes.
+type StatsTotal struct {
+	Views             int64
+	Clicks            int64
+	MedianReadSeconds *int
+	Likes             int64
+}
+
+// StartStatsRollup schedules the rollup.
+func (s *Store) StartStatsRollup() {
+	interval := envDuration("STATS_ROLLUP_INTERVAL", defaultStatsRollupInterval)
+	if s.metricsPool == nil || interval <= 0 {
+		return
+	}
+	var since time.Time
+	s.jobs.Every("rollup.stats_totals", interval, func(ctx context.Context) error {
+		start := time.Now()
+		n, err := s.RollupStats(ctx, since)
+		if err != nil {
+			return err
+		}
+		if since.IsZero() {
+			log.Printf("stats rollup: totals for %d emails in %s", n, time.Since(start).Round(time.Millisecond))
+		}
+		since = start.Add(-statsRollupOverlap)
+		s.totalsReady.Store(true)
+		return nil
+	})
+}
+
+// RollupStats refreshes aggregates and recomputes the totals of emails
+// with events since then (all emails when since is zero), returning how
+// many it wrote.
+func (s *Store) RollupStats(ctx context.Context, since time.Time) (int64, error) {
+	if s.timescale {
+		if _, err := s.metricsPool.Exec(ctx, `CALL refresh_continuous_aggregate('email_view_counts', NOW() - INTERVAL '1 day', NULL)`); err != nil {
+			return 0, err
+		}
+	}
+	tag, err := s.metricsPool.Exec(ctx, `
+		WITH touched AS (
+			SELECT DISTINCT email_id FROM email_views WHERE time >= $1
+			UNION
+			SELECT DISTINCT email_id FROM email_link_clicks WHERE time >= $1
+			UNION
+			SELECT DISTINCT email_id FROM email_read_time WHERE time >= $1
+		)
+		INSERT INTO email_stats_totals (email_id, views, clicks, median_read_seconds, updated_at)
+		SELECT t.email_id,
+		       (SELECT COUNT(DISTINCT session_id) FROM email_views v WHERE v.email_id = t.email_id),
+		       (SELECT COUNT(DISTINCT (session_id, link_index)) FROM email_link_clicks c WHERE c.email_id = t.email_id),
+		       (SELECT percentile_disc(0.5) WITHIN GROUP (ORDER BY seconds)
+		        FROM (SELECT MAX(seconds) AS seconds FROM email_read_time r WHERE r.email_id = t.email_id GROUP BY session_id) sessions),
+		       NOW()
+		FROM touched t
+		ON CONFLICT (email_id) DO UPDATE SET
+			views = EXCLUDED.views,
+			clicks = EXCLUDED.clicks,
+			median_read_seconds = EXCLUDED.median_read_seconds,
+			updated_at = EXCLUDED.updated_at
+	`, since)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// StatsTotals returns the rolled up stats of ids (of every email when ids
+// is nil), or nil until this instance has completed a rollup, so callers
+// count live instead.
+func (s *Store) StatsTotals(ctx context.Context, ids []string) (map[string]StatsTotal, error) {
+	if s.metricsPool == nil || !s.totalsReady.Load() {
+		return nil, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		WITH likes AS (
+			SELECT email_id, COUNT(*) AS likes
+			FROM email_likes
+			WHERE $1::text[] IS NULL OR email_id = ANY($1)
+			GROUP BY email_id
+		)
+		SELECT t.email_id, t.views, t.clicks, t.median_read_seconds, COALESCE(l.likes, 0)
+		FROM email_stats_totals t
+		LEFT JOIN likes l ON l.email_id = t.email_id
+		WHERE $1::text[] IS NULL OR t.email_id = ANY($1)
+	`, ids)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]StatsTotal{}
+	for rows.Next() {
+		var id string
+		var t StatsTotal
+		if err := rows.Scan(&id, &t.Views, &t.Clicks, &t.MedianReadSeconds, &t.Likes); err != nil {
+			return nil, err
+		}
+		out[id] = t
+	}
+	return out, rows.Err()
+}
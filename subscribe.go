@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Subscribe Proxy ----------
+
+// Signups are forwarded to Loops server-side so browsers never see the API
+// key. Addresses are never stored or logged here.
+
+var loopsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+const loopsAPIBase = "https://app.loops.so/api/v1"
+
+// IsPublicMailingList reports whether id names a list readers may join.
+func (s *Store) IsPublicMailingList(ctx context.Context, id string) (bool, error) {
+	if s.pool == nil {
+		return false, errNoWarehouse
+	}
+	var public bool
+	err := s.content().QueryRow(ctx, `SELECT COALESCE(is_public, false) FROM loops.mailing_lists WHERE id = $1`, id).Scan(&public)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return public, s.observe(depWarehouse, err)
+}
+
+// loopsSubscribe adds email to a Loops mailing list, creating the contact if
+// needed. Existing contacts keep their other subscriptions.
+func loopsSubscribe(ctx context.Context, apiKey, email, listID string) error {
+	body := map[string]any{
+		"email":        email,
+		"source":       "news archive",
+		"mailingLists": map[string]bool{listID: true},
+	}
+	status, err := loopsRequest(ctx, apiKey, http.MethodPost, "/contacts/create", body)
+	if err == nil && status == http.StatusConflict {
+		delete(body, "source")
+		status, err = loopsRequest(ctx, apiKey, http.MethodPut, "/contacts/update", body)
+	}
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("loops: unexpected status %d", status)
+	}
+	return nil
+}
+
+func loopsRequest(ctx context.Context, apiKey, method, path string, body any) (int, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, loopsAPIBase+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := loopsHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// captchaVerifyURLs are the siteverify endpoints of supported providers.
+var captchaVerifyURLs = map[string]string{
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+}
+
+func verifyCaptcha(ctx context.Context, provider, secret, token, remoteIP string) (bool, error) {
+	form := url.Values{"secret": {secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, captchaVerifyURLs[provider], strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := loopsHTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Success, nil
+}
+
+// handleSubscribe accepts {"email", "captcha_token"} as JSON or a form post.
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	listID := chi.URLParam(r, "id")
+
+	var req struct {
+		Email        string `json:"email"`
+		CaptchaToken string `json:"captcha_token"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid JSON body")
+			return
+		}
+	} else if err := r.ParseForm(); err == nil {
+		req.Email = r.PostForm.Get("email")
+		req.CaptchaToken = r.PostForm.Get("captcha_token")
+		if req.CaptchaToken == "" {
+			// Widget defaults, so plain HTML forms work unmodified.
+			req.CaptchaToken = r.PostForm.Get("cf-turnstile-response") + r.PostForm.Get("h-captcha-response")
+		}
+	}
+
+	addr, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil || addr.Name != "" || len(addr.Address) > 254 {
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "invalid email address")
+		return
+	}
+
+	if s.captchaSecret != "" {
+		ip := ""
+		if parsed := remoteIP(r); parsed != nil {
+			ip = parsed.String()
+		}
+		ok, err := verifyCaptcha(r.Context(), s.captchaProvider, s.captchaSecret, req.CaptchaToken, ip)
+		if err != nil {
+			log.Printf("captcha verify error: %v", err)
+			writeError(w, r, http.StatusBadGateway, codeUpstreamError, "could not verify captcha")
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusForbidden, codeForbidden, "captcha verification failed")
+			return
+		}
+	}
+
+	public, err := s.store.IsPublicMailingList(r.Context(), listID)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if !public {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "mailing list not found")
+		return
+	}
+
+	if err := loopsSubscribe(r.Context(), s.loopsAPIKey, addr.Address, listID); err != nil {
+		log.Printf("subscribe to list %s failed: %v", listID, err)
+		writeError(w, r, http.StatusBadGateway, codeUpstreamError, "subscription failed, try again later")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "subscribed", "mailing_list_id": listID})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- Subscriber History ----------
+
+// The warehouse only knows current audience sizes, so a daily snapshot of
+// each list's subscriber count is kept in the metrics DB for growth charts.
+// Snapshots are upserts keyed by UTC day: every replica may take them, and
+// the last one of the day wins.
+
+const subscriberSnapshotInterval = 1 * time.Hour
+
+// SnapshotSubscriberCounts records today's subscriber count for every list.
+func (s *Store) SnapshotSubscriberCounts(ctx context.Context) (int, error) {
+	rows, err := s.content().Query(ctx, `
+		SELECT mailing_list_id, COUNT(*)::bigint
+		FROM loops.audience_mailing_lists
+		WHERE mailing_list_id IS NOT NULL
+		GROUP BY mailing_list_id
+	`)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return 0, err
+	}
+	var ids []string
+	var counts []int64
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		counts = append(counts, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	_, err = s.metricsPool.Exec(ctx, `
+		INSERT INTO list_subscriber_counts (day, mailing_list_id, subscriber_count, updated_at)
+		SELECT (NOW() AT TIME ZONE 'UTC')::date, id, n, NOW()
+		FROM unnest($1::text[], $2::bigint[]) AS t(id, n)
+		ON CONFLICT (mailing_list_id, day) DO UPDATE
+		SET subscriber_count = EXCLUDED.subscriber_count, updated_at = NOW()
+	`, ids, counts)
+	if err := s.observe(depMetrics, err); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// StartSubscriberSnapshots snapshots now and hourly, so a day is covered even
+// if the process restarts or the warehouse is briefly unavailable.
+func (s *Store) StartSubscriberSnapshots() {
+	if s.metricsPool == nil {
+		return
+	}
+	s.jobs.Every("snapshot.subscriber_counts", subscriberSnapshotInterval, func(ctx context.Context) error {
+		_, err := s.SnapshotSubscriberCounts(ctx)
+		return err
+	})
+}
+
+type SubscriberCountPoint struct {
+	Day             string `json:"day"` // YYYY-MM-DD, UTC
+	SubscriberCount int64  `json:"subscriber_count"`
+}
+
+// GetSubscriberTimeseries returns one point per snapshotted day since since,
+// oldest first. Days without a snapshot are absent rather than zero.
+func (s *Store) GetSubscriberTimeseries(ctx context.Context, mailingListID string, since time.Time) ([]SubscriberCountPoint, error) {
+	out := []SubscriberCountPoint{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT to_char(day, 'YYYY-MM-DD'), subscriber_count
+		FROM list_subscriber_counts
+		WHERE mailing_list_id = $1 AND day >= $2::date
+		ORDER BY day
+	`, mailingListID, since.UTC().Format(time.DateOnly))
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p SubscriberCountPoint
+		if err := rows.Scan(&p.Day, &p.SubscriberCount); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleSubscriberTimeseries(w http.ResponseWriter, r *http.Request) {
+	mailingListID := chi.URLParam(r, "id")
+	days := 365
+	if v := r.URL.Query().Get("days"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 3650 {
+			days = n
+		}
+	}
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.GetSubscriberTimeseries(r.Context(), mailingListID, since)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"mailing_list_id": mailingListID, "since": since.Format(time.DateOnly), "items": items}, nil
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ---------- List Themes ----------
+
+// Consumers render list badges from the list's color, and each used to pick
+// its own text color and accents. /mailing_lists/{id}/theme derives them
+// once: the color (normalized, or defaultListColor when unusable), whichever
+// of black and white text reads better on it by WCAG contrast ratio, a
+// gradient, and the list's logo when it has one (see logos.go).
+
+const defaultListColor = "#ec3750"
+
+type ListTheme struct {
+	MailingListID string        `json:"mailing_list_id"`
+	Primary       string        `json:"primary"`
+	Contrast      string        `json:"contrast"`       // text color on primary
+	ContrastRatio float64       `json:"contrast_ratio"` // WCAG, 1-21
+	Accessible    bool          `json:"accessible"`     // ratio >= 4.5 (AA for body text)
+	Gradient      ThemeGradient `json:"gradient"`
+	LogoURL       *string       `json:"logo_url,omitempty"`
+}
+
+type ThemeGradient struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	CSS  string `json:"css"`
+}
+
+type rgb struct{ r, g, b float64 } // 0-255
+
+// parseHexColor reads #rgb, #rrggbb, or either with alpha (ignored).
+func parseHexColor(s string) (rgb, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	switch len(s) {
+	case 3, 4:
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	case 6, 8:
+		s = s[:6]
+	default:
+		return rgb{}, false
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return rgb{}, false
+	}
+	return rgb{float64(n >> 16 & 0xff), float64(n >> 8 & 0xff), float64(n & 0xff)}, true
+}
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round(c.r)), int(math.Round(c.g)), int(math.Round(c.b)))
+}
+
+// mix moves c toward o by t (0-1).
+func (c rgb) mix(o rgb, t float64) rgb {
+	return rgb{c.r + (o.r-c.r)*t, c.g + (o.g-c.g)*t, c.b + (o.b-c.b)*t}
+}
+
+// luminance is WCAG relative luminance.
+func (c rgb) luminance() float64 {
+	channel := func(v float64) float64 {
+		v /= 255
+		if v <= 0.03928 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.r) + 0.7152*channel(c.g) + 0.0722*channel(c.b)
+}
+
+func contrastRatio(a, b rgb) float64 {
+	la, lb := a.luminance(), b.luminance()
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+var (
+	themeWhite = rgb{255, 255, 255}
+	themeBlack = rgb{0, 0, 0}
+)
+
+// listTheme derives the theme for a list colored color.
+func listTheme(listID, color string) ListTheme {
+	primary, ok := parseHexColor(color)
+	if !ok {
+		primary, _ = parseHexColor(defaultListColor)
+	}
+	t := ListTheme{MailingListID: listID, Primary: primary.hex()}
+
+	contrast := themeWhite
+	ratio := contrastRatio(primary, themeWhite)
+	if r := contrastRatio(primary, themeBlack); r > ratio {
+		contrast, ratio = themeBlack, r
+	}
+	t.Contrast = contrast.hex()
+	t.ContrastRatio = math.Round(ratio*100) / 100
+	t.Accessible = ratio >= 4.5
+
+	// A lighter tint into the color itself, or into a shade for colors too
+	// light to tint visibly.
+	from, to := primary.mix(themeWhite, 0.25), primary
+	if primary.luminance() > 0.7 {
+		from, to = primary, primary.mix(themeBlack, 0.2)
+	}
+	t.Gradient = ThemeGradient{From: from.hex(), To: to.hex()}
+	t.Gradient.CSS = "linear-gradient(135deg, " + t.Gradient.From + ", " + t.Gradient.To + ")"
+	return t
+}
+
+// GetMailingList returns the list with id among ListMailingLists' lists,
+// or errNotFound. There are only ever a few dozen lists, so it pages
+// through them rather than needing its own query in every ContentSource.
+func (s *Store) GetMailingList(ctx context.Context, id string) (*MailingList, error) {
+	const page = 200
+	for offset := 0; ; offset += page {
+		lists, next, err := s.ListMailingLists(ctx, page, offset)
+		if err != nil {
+			return nil, err
+		}
+		for i := range lists {
+			if lists[i].ID == id {
+				return &lists[i], nil
+			}
+		}
+		if next == nil {
+			return nil, errNotFound
+		}
+	}
+}
+
+func (s *Server) handleMailingListTheme(w http.ResponseWriter, r *http.Request) {
+	listID := chi.URLParam(r, "id")
+	s.jsonCached(w, r, func() (any, error) {
+		ml, err := s.store.GetMailingList(r.Context(), listID)
+		if err != nil {
+			return nil, err
+		}
+		t := listTheme(ml.ID, ml.Color)
+		t.LogoURL = ml.LogoURL
+		return t, nil
+	})
+}
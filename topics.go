@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ---------- Topics ----------
+
+// Lists and series say who an email is from and what run it's part of, not
+// what it's about. The enrichment worker (see enrich.go) also has the model
+// classify each email into one to three topics from the fixed taxonomy
+// below, returned as its topics; /emails?topic= filters on them and
+// /topics lists them with how many emails each has. Topics come from an
+// email's latest enrichment, so an edited email keeps its topics until it's
+// been enriched again. Renaming or removing a slug here needs enrichVersion
+// bumped; adding one just doesn't apply to emails until they're enriched.
+
+// Topic is one topic in the taxonomy.
+type Topic struct {
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Count       int    `json:"count"` // published emails with it
+}
+
+// topicTaxonomy is the topics emails are classified into.
+var topicTaxonomy = []Topic{
+	{Slug: "events", Name: "Events", Description: "Meetups, calls, livestreams and in-person gatherings"},
+	{Slug: "hackathons", Name: "Hackathons", Description: "Hackathons to attend or organize, and their results"},
+	{Slug: "programs", Name: "Programs", Description: "Hack Club programs and You Ship, We Ship challenges, such as Arcade and High Seas"},
+	{Slug: "fiscal-sponsorship", Name: "Fiscal sponsorship", Description: "HCB, fundraising and managing money for projects and events"},
+	{Slug: "grants", Name: "Grants", Description: "Grants, prizes and funding given to teens and clubs"},
+	{Slug: "hardware", Name: "Hardware", Description: "Electronics, PCBs, 3D printing and physical builds"},
+	{Slug: "clubs", Name: "Clubs", Description: "Starting and running school coding clubs"},
+	{Slug: "open-source", Name: "Open source", Description: "Open source projects and contributing to them"},
+	{Slug: "community", Name: "Community", Description: "Slack, community stories, member projects and shoutouts"},
+	{Slug: "opportunities", Name: "Opportunities", Description: "Jobs, internships, scholarships and other opportunities"},
+	{Slug: "announcements", Name: "Announcements", Description: "News about Hack Club itself, launches and policy changes"},
+}
+
+// isTopic reports whether slug is in the taxonomy.
+func isTopic(slug string) bool {
+	return slices.ContainsFunc(topicTaxonomy, func(t Topic) bool { return t.Slug == slug })
+}
+
+// topicPrompt describes the taxonomy for the enrichment prompt.
+func topicPrompt() string {
+	var b strings.Builder
+	for _, t := range topicTaxonomy {
+		fmt.Fprintf(&b, "  - %s: %s\n", t.Slug, t.Description)
+	}
+	return b.String()
+}
+
+// topicEmailIDs returns the emails whose latest enrichment has topic.
+func (s *Store) topicEmailIDs(topic string) []string {
+	ids := []string{}
+	s.enrichments.Range(func(k, v any) bool {
+		if slices.Contains(v.(enrichment).Topics, topic) {
+			ids = append(ids, k.(string))
+		}
+		return true
+	})
+	return ids
+}
+
+// ListTopics returns the taxonomy with each topic's count of published
+// emails.
+func (s *Store) ListTopics(r *http.Request) ([]Topic, error) {
+	counts := map[string]int{}
+	err := s.source.EachEmail(r.Context(), EmailFilter{}, 0, func(src *SourceEmail) error {
+		if v, ok := s.enrichments.Load(src.ID); ok {
+			for _, t := range v.(enrichment).Topics {
+				counts[t]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	topics := slices.Clone(topicTaxonomy)
+	for i := range topics {
+		topics[i].Count = counts[topics[i].Slug]
+	}
+	return topics, nil
+}
+
+func (s *Server) handleTopics(w http.ResponseWriter, r *http.Request) {
+	s.jsonCached(w, r, func() (any, error) {
+		topics, err := s.store.ListTopics(r)
+		if err != nil {
+			return nil, err
+		}
+		return Paginated[Topic]{Items: topics, Meta: s.store.responseMeta()}, nil
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ---------- Upstream Tracker Stripping ----------
+
+// Campaign HTML arrives with the sending platform's open-tracking pixel,
+// and sometimes a newsletter tool's analytics, still in it. Served from
+// the archive, those would report every reader's IP, user agent and
+// visit to a third party, and count archive visits as inbox opens. The
+// archive has its own first-party analytics, so before HTML is served:
+//
+//   - images that are tracking pixels (declared 2x2 or smaller, hidden
+//     with display:none or visibility:hidden, or on a known open tracker)
+//     are removed, unless they're ours (PUBLIC_BASE_URL);
+//   - scripts are removed, along with noscript blocks, where beacons put
+//     their image fallbacks. Inboxes never run scripts, so a script in
+//     campaign HTML is only ever a beacon or tag manager.
+//
+// Inline (data:) images never leave the page and are kept. Relative images
+// are resolved first (see relurls.go), so they're judged by where they load
+// from.
+
+// openTracker is an open-tracking endpoint: host (and its subdomains) and
+// a path prefix, or any path if empty.
+type openTracker struct {
+	host       string
+	pathPrefix string
+}
+
+// openTrackers are the pixel endpoints we recognise regardless of size.
+// Loops sends through Amazon SES, which tracks opens at /I0/ on the same
+// host that tracks clicks at /CL0/ (see trackingWrappers).
+var openTrackers = []openTracker{
+	{host: "c.loops.so", pathPrefix: "/I0/"},
+	{host: "awstrack.me", pathPrefix: "/I0/"},
+	{host: "list-manage.com", pathPrefix: "/track/open.php"},
+	{host: "sendgrid.net", pathPrefix: "/wf/open"},
+	{host: "google-analytics.com"},
+	{host: "pixel.wp.com"},
+}
+
+// isOpenTracker reports whether u is on a known open tracker.
+func isOpenTracker(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, t := range openTrackers {
+		if hostMatches(host, []string{t.host}) && strings.HasPrefix(u.Path, t.pathPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredSize is an element's declared width or height, from its style
+// or else its attribute, and whether one was declared at all.
+func declaredSize(sel *goquery.Selection, style map[string]string, prop string) (int, bool) {
+	v, ok := style[prop]
+	if !ok {
+		v, ok = sel.Attr(prop)
+	}
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px"))
+	return n, err == nil
+}
+
+// isTrackingPixel reports whether img is a third-party tracking pixel.
+// origin is our own, whose pixels are kept.
+func isTrackingPixel(img *goquery.Selection, origin string) bool {
+	src := strings.TrimSpace(img.AttrOr("src", ""))
+	if strings.HasPrefix(src, "//") {
+		src = "https:" + src
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return false
+	}
+	if origin != "" && strings.HasPrefix(src, strings.TrimSuffix(origin, "/")+"/") {
+		return false
+	}
+	if isOpenTracker(u) {
+		return true
+	}
+
+	style := map[string]string{}
+	for _, d := range parseCSSDecls(img.AttrOr("style", "")) {
+		style[d.prop] = strings.ToLower(d.value)
+	}
+	if style["display"] == "none" || style["visibility"] == "hidden" {
+		return true
+	}
+	width, hasWidth := declaredSize(img, style, "width")
+	height, hasHeight := declaredSize(img, style, "height")
+	return hasWidth && hasHeight && width <= 2 && height <= 2
+}
+
+// stripTrackers removes third-party tracking pixels and scripts from html,
+// as described above.
+func stripTrackers(html, origin string) (string, error) {
+	lower := strings.ToLower(html)
+	if !strings.Contains(lower, "<img") && !strings.Contains(lower, "<script") && !strings.Contains(lower, "<noscript") {
+		return html, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return html, err
+	}
+	doc.Find("script, noscript").Remove()
+	doc.Find("img[src]").FilterFunction(func(_ int, sel *goquery.Selection) bool {
+		return isTrackingPixel(sel, origin)
+	}).Remove()
+	return doc.Html()
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestUnwrapTrackingURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://hackclub.com/arcade", "https://hackclub.com/arcade"},
+		{"https://c.loops.so/CL0/https:%2F%2Fhackclub.com%2Farcade%3Fref=email/1/0100018f/abc=", "https://hackclub.com/arcade?ref=email"},
+		{"https://abc.r.us-east-1.awstrack.me/L0/https:%2F%2Fhackclub.com%2F/1/0100/xyz=", "https://hackclub.com/"},
+		{"https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fhackclub.com%2Fbank&data=05", "https://hackclub.com/bank"},
+		{"https://www.google.com/url?q=https://hackclub.com/&sa=D", "https://hackclub.com/"},
+		{"https://l.facebook.com/l.php?u=https%3A%2F%2Fhackclub.com%2F&h=AT0", "https://hackclub.com/"},
+		// Nested wrappers, up to maxUnwrapDepth.
+		{"https://www.google.com/url?q=https%3A%2F%2Fc.loops.so%2FCL0%2Fhttps%3A%252F%252Fhackclub.com%252F%2F1%2Fabc", "https://hackclub.com/"},
+		// Known trackers without a usable destination are left alone.
+		{"https://c.loops.so/CL0/javascript:alert(1)/1/abc", "https://c.loops.so/CL0/javascript:alert(1)/1/abc"},
+		{"https://c.loops.so/other/path", "https://c.loops.so/other/path"},
+		{"https://www.google.com/search?q=https://hackclub.com/", "https://www.google.com/search?q=https://hackclub.com/"},
+		{"https://www.google.com/url?q=/relative", "https://www.google.com/url?q=/relative"},
+		{"https://evilgoogle.com/url?q=https://evil.example/", "https://evilgoogle.com/url?q=https://evil.example/"},
+		{"mailto:team@hackclub.com", "mailto:team@hackclub.com"},
+	}
+	for _, tt := range tests {
+		if got := unwrapTrackingURL(tt.raw); got != tt.want {
+			t.Errorf("unwrapTrackingURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
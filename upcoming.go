@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ---------- Upcoming Campaigns ----------
+
+// UpcomingEmail is a teaser for a scheduled campaign. It deliberately carries
+// no content, slug, or exact send time: only what a "coming soon" strip shows.
+type UpcomingEmail struct {
+	Subject        string  `json:"subject"`
+	MailingListRef ListRef `json:"mailing_list"`
+	ScheduledFor   string  `json:"scheduled_for"` // YYYY-MM-DD, UTC
+}
+
+// ListUpcomingEmails returns publishable campaigns scheduled to send in the
+// next 30 days, soonest first.
+func (s *Store) ListUpcomingEmails(ctx context.Context, limit int) ([]UpcomingEmail, error) {
+	if s.pool == nil {
+		return nil, errNoWarehouse
+	}
+	rows, err := s.content().Query(ctx, `
+		SELECT c.ai_publishable_response_json->>'title', c.scheduled_at,
+		       ml.id, ml.friendly_name, COALESCE(ml.description, ''), COALESCE(ml.color_scheme, '#000000')
+		FROM loops.campaigns c
+		JOIN loops.mailing_lists ml ON ml.id = c.mailing_list_id
+		WHERE c.status = 'Scheduled' AND c.ai_publishable = true AND COALESCE(ml.is_public, false)
+		  AND c.scheduled_at > NOW() AND c.scheduled_at < NOW() + INTERVAL '30 days'
+		  AND COALESCE(c.ai_publishable_response_json->>'title', '') <> ''
+		ORDER BY c.scheduled_at
+		LIMIT $1
+	`, limit)
+	if err := s.observe(depWarehouse, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []UpcomingEmail{}
+	for rows.Next() {
+		var u UpcomingEmail
+		var at time.Time
+		if err := rows.Scan(&u.Subject, &at, &u.MailingListRef.ID, &u.MailingListRef.Name,
+			&u.MailingListRef.Description, &u.MailingListRef.Color); err != nil {
+			return nil, err
+		}
+		u.ScheduledFor = at.UTC().Format(time.DateOnly)
+		u.MailingListRef.Slug = slugify(u.MailingListRef.Name)
+		u.MailingListRef.LogoURL = s.ListLogoURL(u.MailingListRef.ID)
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *Server) handleUpcoming(w http.ResponseWriter, r *http.Request) {
+	limit, _ := parseLimitOffset(r, 10)
+	s.jsonCached(w, r, func() (any, error) {
+		items, err := s.store.ListUpcomingEmails(r.Context(), limit)
+		if err != nil {
+			return nil, err
+		}
+		return Paginated[UpcomingEmail]{Items: items, Meta: s.store.responseMeta()}, nil
+	})
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ---------- Webhooks ----------
+
+// Webhooks tell downstream systems (static site builds, CDN purgers,
+// mirrors) about content events as they're noticed, so they needn't poll
+// /changes. Each event is POSTed as JSON to every WEBHOOK_URLS entry.
+//
+// Every delivery is recorded in the webhook_deliveries outbox in the
+// metrics DB, in the same transaction as the queued job (see jobs.go) that
+// sends it, so a failed delivery
+// is retried with exponential backoff, across restarts, up to
+// webhookMaxAttempts times. /admin/webhooks lists deliveries and retries
+// failed ones. Without a metrics DB deliveries are still retried, in
+// memory.
+//
+// With WEBHOOK_SECRET set, each attempt is signed: X-Webhook-Timestamp is
+// the Unix time it was sent and X-Webhook-Signature is "sha256=" and the
+// hex HMAC-SHA256 of "<timestamp>.<body>". Receivers should check both and
+// reject old timestamps (say, over 5 minutes) to stop replays.
+// X-Webhook-ID stays the same across retries, for deduplication.
+
+const (
+	webhookJobKind     = "webhook.deliver"
+	webhookMaxAttempts = 10 // about 5 hours of backoff
+	webhookKeep        = 7 * 24 * time.Hour
+)
+
+// WebhookEvent is the body of a webhook delivery.
+type WebhookEvent struct {
+	Event string    `json:"event"` // e.g. email.unpublished
+	At    time.Time `json:"at"`
+	Data  any       `json:"data"`
+}
+
+// Webhooks delivers events. A nil *Webhooks discards them, so callers
+// needn't check configuration.
+type Webhooks struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+	store  *Store
+}
+
+// webhookJob is the queued job payload for one delivery.
+type webhookJob struct {
+	ID   string          `json:"id"`
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+// NewWebhooksFromEnv reads WEBHOOK_URLS (comma-separated) and
+// WEBHOOK_SECRET, and registers delivery with store's jobs.
+func NewWebhooksFromEnv(store *Store) *Webhooks {
+	var urls []string
+	for _, u := range strings.Split(os.Getenv("WEBHOOK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	wh := &Webhooks{
+		urls:   urls,
+		secret: []byte(os.Getenv("WEBHOOK_SECRET")),
+		client: &http.Client{Timeout: 10 * time.Second},
+		store:  store,
+	}
+	store.jobs.Handle(webhookJobKind, webhookMaxAttempts, wh.deliver)
+	if store.metricsPool != nil {
+		store.jobs.Every("webhooks.cleanup", 24*time.Hour, store.deleteOldDeliveries)
+	}
+	log.Printf("webhooks: delivering to %d URLs (signed: %t)", len(urls), len(wh.secret) > 0)
+	return wh
+}
+
+// Send queues event with data for every URL. key identifies the occurrence
+// (say, an email ID and when it changed): deliveries are keyed by event,
+// key and URL in the outbox, so replicas that each notice the same change
+// queue it once between them.
+func (wh *Webhooks) Send(event, key string, data any) {
+	if wh == nil {
+		return
+	}
+	body, err := json.Marshal(WebhookEvent{Event: event, At: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("webhooks: %s: %v", event, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, u := range wh.urls {
+		sum := sha256.Sum256([]byte(event + "\x00" + key + "\x00" + u))
+		job := webhookJob{ID: "whd_" + hex.EncodeToString(sum[:12]), URL: u, Body: body}
+		if err := wh.store.QueueDelivery(ctx, job, event); err != nil {
+			log.Printf("webhooks: %s to %s: %v", event, u, err)
+		}
+	}
+}
+
+// deliver is the job handler: one attempt at one delivery.
+func (wh *Webhooks) deliver(ctx context.Context, payload json.RawMessage) error {
+	var job webhookJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	status, err := wh.post(ctx, job)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return err // shutting down; the job is released, not failed
+	}
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if rerr := wh.store.RecordDeliveryAttempt(recordCtx, job.ID, status, err, false); rerr != nil {
+		log.Printf("webhooks: record attempt on %s: %v", job.ID, rerr)
+	}
+	return err
+}
+
+// sign returns the signature headers' values for body sent at t.
+func (wh *Webhooks) sign(t time.Time, body []byte) (timestamp, signature string) {
+	timestamp = strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, wh.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return timestamp, "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *Webhooks) post(ctx context.Context, job webhookJob) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.URL, bytes.NewReader(job.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "news-webhooks/1")
+	req.Header.Set("X-Webhook-ID", job.ID)
+	if len(wh.secret) > 0 {
+		ts, sig := wh.sign(time.Now(), job.Body)
+		req.Header.Set("X-Webhook-Timestamp", ts)
+		req.Header.Set("X-Webhook-Signature", sig)
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookDelivery is one outbox row.
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Event       string          `json:"event"`
+	URL         string          `json:"url"`
+	Status      string          `json:"status"` // pending, delivered or failed
+	Attempts    int             `json:"attempts"`
+	LastStatus  *int            `json:"last_status_code,omitempty"`
+	LastError   *string         `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"` // single delivery only
+}
+
+// QueueDelivery adds job to the outbox and queues it in one transaction,
+// so no delivery is recorded without a job to send it. A delivery already
+// in the outbox was queued by whoever added it, and is left alone. Without
+// a metrics DB there's no outbox, and the job is only queued.
+func (s *Store) QueueDelivery(ctx context.Context, job webhookJob, event string) error {
+	if s.metricsPool == nil {
+		return s.jobs.Enqueue(ctx, webhookJobKind, job)
+	}
+	err := pgx.BeginFunc(ctx, s.metricsPool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO webhook_deliveries (id, event, url, body) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO NOTHING
+		`, job.ID, event, job.URL, string(job.Body))
+		if err != nil || tag.RowsAffected() == 0 {
+			return err
+		}
+		return s.jobs.EnqueueTx(ctx, tx, webhookJobKind, job)
+	})
+	return s.observe(depMetrics, err)
+}
+
+// RecordDeliveryAttempt counts an attempt on delivery id: delivered when
+// err is nil, else failed if giveUp is set or it was the last attempt.
+func (s *Store) RecordDeliveryAttempt(ctx context.Context, id string, status int, err error, giveUp bool) error {
+	if s.metricsPool == nil {
+		return nil
+	}
+	var msg *string
+	if err != nil {
+		m := err.Error()
+		msg = &m
+	}
+	_, qerr := s.metricsPool.Exec(ctx, `
+		UPDATE webhook_deliveries SET
+			attempts = attempts + CASE WHEN $5 THEN 0 ELSE 1 END,
+			last_status_code = NULLIF($2, 0),
+			last_error = $3,
+			status = CASE
+				WHEN $3::text IS NULL THEN 'delivered'
+				WHEN $5 OR attempts + 1 >= $4 THEN 'failed'
+				ELSE 'pending'
+			END,
+			delivered_at = CASE WHEN $3::text IS NULL THEN NOW() END,
+			updated_at = NOW()
+		WHERE id = $1
+	`, id, status, msg, webhookMaxAttempts, giveUp)
+	return s.observe(depMetrics, qerr)
+}
+
+// ListDeliveries returns outbox rows newest first, optionally only those
+// with status.
+func (s *Store) ListDeliveries(ctx context.Context, status string, limit, offset int) ([]WebhookDelivery, error) {
+	out := []WebhookDelivery{}
+	if s.metricsPool == nil {
+		return out, nil
+	}
+	rows, err := s.metricsPool.Query(ctx, `
+		SELECT id, event, url, status, attempts, last_status_code, last_error, created_at, updated_at, delivered_at
+		FROM webhook_deliveries
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC, id
+		LIMIT $2 OFFSET $3
+	`, status, limit, offset)
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.URL, &d.Status, &d.Attempts, &d.LastStatus, &d.LastError,
+			&d.CreatedAt, &d.UpdatedAt, &d.DeliveredAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) GetDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	if s.metricsPool == nil {
+		return nil, errNotFound
+	}
+	var d WebhookDelivery
+	var body []byte
+	err := s.metricsPool.QueryRow(ctx, `
+		SELECT id, event, url, status, attempts, last_status_code, last_error, created_at, updated_at, delivered_at, body
+		FROM webhook_deliveries
+		WHERE id = $1
+	`, id).Scan(&d.ID, &d.Event, &d.URL, &d.Status, &d.Attempts, &d.LastStatus, &d.LastError,
+		&d.CreatedAt, &d.UpdatedAt, &d.DeliveredAt, &body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errNotFound
+	}
+	if err := s.observe(depMetrics, err); err != nil {
+		return nil, err
+	}
+	d.Body = body
+	return &d, nil
+}
+
+// RetryDelivery makes a delivery pending again with no attempts made, and
+// queues it, in one transaction.
+func (s *Store) RetryDelivery(ctx context.Context, d *WebhookDelivery) error {
+	err := pgx.BeginFunc(ctx, s.metricsPool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `
+			UPDATE webhook_deliveries SET status = 'pending', attempts = 0, updated_at = NOW() WHERE id = $1
+		`, d.ID); err != nil {
+			return err
+		}
+		return s.jobs.EnqueueTx(ctx, tx, webhookJobKind, webhookJob{ID: d.ID, URL: d.URL, Body: d.Body})
+	})
+	return s.observe(depMetrics, err)
+}
+
+// deleteOldDeliveries drops outbox rows settled over webhookKeep ago.
+func (s *Store) deleteOldDeliveries(ctx context.Context) error {
+	_, err := s.metricsPool.Exec(ctx, `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND updated_at < NOW() - $1 * INTERVAL '1 second'
+	`, webhookKeep.Seconds())
+	return s.observe(depMetrics, err)
+}
+
+// handleAdminWebhooks lists deliveries, filtered by ?status=.
+func (s *Server) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	limit, offset := parseLimitOffset(r, 50)
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", "pending", "delivered", "failed":
+	default:
+		writeError(w, r, http.StatusBadRequest, codeBadRequest, "status must be pending, delivered or failed")
+		return
+	}
+	// One extra row tells us whether there's another page.
+	items, err := s.store.ListDeliveries(r.Context(), status, limit+1, offset)
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	page := Paginated[WebhookDelivery]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		next := offset + limit
+		page.Next = &next
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) handleAdminWebhook(w http.ResponseWriter, r *http.Request) {
+	d, err := s.store.GetDelivery(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, d)
+}
+
+// handleAdminRetryWebhook queues a failed delivery again, with a fresh set
+// of attempts.
+func (s *Server) handleAdminRetryWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		writeError(w, r, http.StatusNotImplemented, codeNotImplemented, "WEBHOOK_URLS not configured")
+		return
+	}
+	d, err := s.store.GetDelivery(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err)
+		return
+	}
+	if d.Status != "failed" {
+		writeError(w, r, http.StatusConflict, codeConflict, "only failed deliveries can be retried")
+		return
+	}
+	if err := s.store.RetryDelivery(r.Context(), d); err != nil {
+		httpError(w, r, err)
+		return
+	}
+	d.Status, d.Attempts, d.Body = "pending", 0, nil
+	writeJSON(w, http.StatusAccepted, d)
+}